@@ -17,6 +17,9 @@ HLS_BASE_DIR="${HLS_DIR:-/tmp/streamforge/hls}"
 LOG_FILE="/var/log/streamforge-cleanup.log"
 DEFAULT_RETENTION_HOURS=24
 SEGMENT_RETENTION_MINUTES=30
+# Expired streams keep their .stream_ended marker this much longer, so the HLS
+# server still answers 410 Gone for them after a restart (its goneMemory)
+GONE_RETENTION_HOURS=168
 DRY_RUN="${DRY_RUN:-false}"
 
 # Logging function
@@ -63,6 +66,25 @@ is_stream_active() {
     return 1  # Stream is not active
 }
 
+# Get the segment retention for a stream in minutes, extended to cover its DVR
+# window from the .stream_settings file written by the transcoder
+get_segment_retention_minutes() {
+    local stream_dir="$1"
+    local settings_file="$stream_dir/.stream_settings"
+    local retention=$SEGMENT_RETENTION_MINUTES
+    
+    if [ -f "$settings_file" ]; then
+        local dvr_seconds=$(grep -o '"dvr_window_seconds": *[0-9]*' "$settings_file" | grep -o '[0-9]*$' 2>/dev/null || echo "0")
+        local dvr_minutes=$(( (${dvr_seconds:-0} + 59) / 60 ))
+        
+        if [ $dvr_minutes -gt $retention ]; then
+            retention=$dvr_minutes
+        fi
+    fi
+    
+    echo "$retention"
+}
+
 # Clean up old HLS segments
 cleanup_old_segments() {
     local stream_dir="$1"
@@ -77,11 +99,13 @@ cleanup_old_segments() {
     fi
     
     local cleaned_count=0
+    local retention_minutes=$(get_segment_retention_minutes "$stream_dir")
     
-    # Clean up .ts files older than retention period
-    for quality_dir in "$stream_dir"/{720p,480p,360p}; do
+    # Clean up .ts files older than retention period, never inside the DVR window
+    for quality_dir in "$stream_dir"/*/; do
+        quality_dir="${quality_dir%/}"
         if [ -d "$quality_dir" ]; then
-            local old_segments=$(find "$quality_dir" -name "*.ts" -mmin +$SEGMENT_RETENTION_MINUTES 2>/dev/null || true)
+            local old_segments=$(find "$quality_dir" -name "*.ts" -mmin +$retention_minutes 2>/dev/null || true)
             
             if [ -n "$old_segments" ]; then
                 if [ "$DRY_RUN" = "true" ]; then
@@ -99,6 +123,19 @@ cleanup_old_segments() {
     fi
 }
 
+# Remove the files of an expired stream, leaving only its .stream_ended marker
+# as a tombstone
+bury_stream() {
+    local stream_dir="$1"
+    find "$stream_dir" -mindepth 1 -maxdepth 1 ! -name .stream_ended -exec rm -rf {} +
+}
+
+# Check if only the .stream_ended marker of a stream is left
+is_tombstone() {
+    local stream_dir="$1"
+    [ -z "$(find "$stream_dir" -mindepth 1 -maxdepth 1 ! -name .stream_ended -print -quit)" ]
+}
+
 # Clean up ended streams
 cleanup_ended_streams() {
     log "Checking for ended streams to clean up..."
@@ -134,14 +171,27 @@ cleanup_ended_streams() {
                 local current_timestamp=$(date +%s)
                 local age_hours=$(( (current_timestamp - ended_timestamp) / 3600 ))
                 
-                if [ $age_hours -ge $retention_hours ]; then
-                    log "Stream $stream_name ended $age_hours hours ago (retention: ${retention_hours}h), cleaning up..."
+                if [ $age_hours -ge $((retention_hours + GONE_RETENTION_HOURS)) ]; then
+                    log "Stream $stream_name ended $age_hours hours ago, removing its tombstone..."
                     
                     if [ "$DRY_RUN" = "true" ]; then
                         log "DRY RUN: Would remove stream directory: $stream_dir"
                     else
                         rm -rf "$stream_dir"
                         cleaned_streams=$((cleaned_streams + 1))
+                        success "Removed tombstone of stream: $stream_name"
+                    fi
+                elif [ $age_hours -ge $retention_hours ]; then
+                    if is_tombstone "$stream_dir"; then
+                        continue
+                    fi
+                    log "Stream $stream_name ended $age_hours hours ago (retention: ${retention_hours}h), cleaning up..."
+                    
+                    if [ "$DRY_RUN" = "true" ]; then
+                        log "DRY RUN: Would remove stream files, keeping the marker: $stream_dir"
+                    else
+                        bury_stream "$stream_dir"
+                        cleaned_streams=$((cleaned_streams + 1))
                         success "Cleaned up ended stream: $stream_name"
                     fi
                 else
@@ -0,0 +1,183 @@
+// Package access decides which viewers may play a stream by their IP address
+// and the country it is in. Rules are kept per stream in a sidecar in the
+// stream's directory, so edge servers pick up changes without a restart, and
+// countries are resolved with a local MaxMind-format database.
+package access
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RulesFile is the sidecar in a stream's directory that holds its access rules
+const RulesFile = ".access_rules"
+
+// ErrAccessDenied is returned to viewers the access rules of a stream turn away
+var ErrAccessDenied = errors.New("playback is not permitted from your location")
+
+// Reasons a request is denied
+const (
+	ReasonDeniedNetwork   = "denied_network"
+	ReasonDeniedCountry   = "denied_country"
+	ReasonNotAllowed      = "not_allowed"
+	ReasonInvalidIP       = "invalid_ip"
+	ReasonRulesUnreadable = "rules_unreadable"
+)
+
+// Rules are the access rules of a stream. Deny rules win over allow rules, and
+// a stream with allow rules is only played where one of them matches.
+type Rules struct {
+	AllowCIDRs     []string  `json:"allow_cidrs"`
+	DenyCIDRs      []string  `json:"deny_cidrs"`
+	AllowCountries []string  `json:"allow_countries"`
+	DenyCountries  []string  `json:"deny_countries"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Empty reports whether the rules let every viewer play the stream
+func (r *Rules) Empty() bool {
+	return len(r.AllowCIDRs) == 0 && len(r.DenyCIDRs) == 0 &&
+		len(r.AllowCountries) == 0 && len(r.DenyCountries) == 0
+}
+
+// Normalize validates the rules and rewrites them in canonical form: bare
+// addresses become single-address prefixes and country codes upper case
+func (r *Rules) Normalize() error {
+	for _, list := range []*[]string{&r.AllowCIDRs, &r.DenyCIDRs} {
+		normalized := []string{}
+		for _, value := range *list {
+			prefix, err := parsePrefix(value)
+			if err != nil {
+				return err
+			}
+			normalized = append(normalized, prefix.String())
+		}
+		*list = normalized
+	}
+	for _, list := range []*[]string{&r.AllowCountries, &r.DenyCountries} {
+		normalized := []string{}
+		for _, value := range *list {
+			code := strings.ToUpper(strings.TrimSpace(value))
+			if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+				return fmt.Errorf("invalid country %q (expected an ISO 3166-1 alpha-2 code)", value)
+			}
+			normalized = append(normalized, code)
+		}
+		*list = normalized
+	}
+	return nil
+}
+
+// parsePrefix parses a CIDR prefix or a single address
+func parsePrefix(value string) (netip.Prefix, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", value)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", value)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// ReadRules reads the rules of a stream directory. A stream without a sidecar has no rules.
+func ReadRules(streamDir string) (*Rules, error) {
+	data, err := os.ReadFile(filepath.Join(streamDir, RulesFile))
+	if os.IsNotExist(err) {
+		return &Rules{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rules Rules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid access rules: %w", err)
+	}
+	return &rules, nil
+}
+
+// Decision is the outcome of checking a request against the rules of a stream
+type Decision struct {
+	Allowed bool
+	Reason  string // why the request was denied
+	Country string // the viewer's country, if the rules needed it
+}
+
+// Policy is a compiled set of rules
+type Policy struct {
+	allowNetworks  []netip.Prefix
+	denyNetworks   []netip.Prefix
+	allowCountries map[string]bool
+	denyCountries  map[string]bool
+}
+
+// Compile compiles normalized rules
+func Compile(rules *Rules) (*Policy, error) {
+	policy := &Policy{
+		allowCountries: map[string]bool{},
+		denyCountries:  map[string]bool{},
+	}
+	for _, list := range []struct {
+		values []string
+		dst    *[]netip.Prefix
+	}{{rules.AllowCIDRs, &policy.allowNetworks}, {rules.DenyCIDRs, &policy.denyNetworks}} {
+		for _, value := range list.values {
+			prefix, err := parsePrefix(value)
+			if err != nil {
+				return nil, err
+			}
+			*list.dst = append(*list.dst, prefix)
+		}
+	}
+	for _, code := range rules.AllowCountries {
+		policy.allowCountries[strings.ToUpper(code)] = true
+	}
+	for _, code := range rules.DenyCountries {
+		policy.denyCountries[strings.ToUpper(code)] = true
+	}
+	return policy, nil
+}
+
+// usesCountries reports whether the policy needs the viewer's country
+func (p *Policy) usesCountries() bool {
+	return len(p.allowCountries) > 0 || len(p.denyCountries) > 0
+}
+
+// Check decides whether a viewer may play the stream. Deny rules are checked
+// first; with allow rules the viewer must match one of them. A viewer whose
+// country is unknown matches no country rule, so allow lists fail closed.
+func (p *Policy) Check(addr netip.Addr, country string) Decision {
+	addr = addr.Unmap()
+	for _, prefix := range p.denyNetworks {
+		if prefix.Contains(addr) {
+			return Decision{Reason: ReasonDeniedNetwork, Country: country}
+		}
+	}
+	if country != "" && p.denyCountries[country] {
+		return Decision{Reason: ReasonDeniedCountry, Country: country}
+	}
+
+	if len(p.allowNetworks) == 0 && len(p.allowCountries) == 0 {
+		return Decision{Allowed: true, Country: country}
+	}
+	for _, prefix := range p.allowNetworks {
+		if prefix.Contains(addr) {
+			return Decision{Allowed: true, Country: country}
+		}
+	}
+	if country != "" && p.allowCountries[country] {
+		return Decision{Allowed: true, Country: country}
+	}
+	return Decision{Reason: ReasonNotAllowed, Country: country}
+}
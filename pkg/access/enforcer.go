@@ -0,0 +1,281 @@
+package access
+
+import (
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// refreshInterval is how often the rules of a stream and the geo database are
+// checked for changes
+const refreshInterval = time.Second
+
+// GeoIP resolves addresses to countries with a MaxMind-format database such as
+// GeoLite2-Country, reloading the file when it is replaced
+type GeoIP struct {
+	path string
+
+	mutex     sync.Mutex // serializes reloads
+	db        atomic.Pointer[mmdb]
+	modTime   time.Time
+	checkedAt atomic.Int64 // unix nanoseconds of the last modification check
+}
+
+// NewGeoIP opens a MaxMind-format database, or returns nil when the path is empty
+func NewGeoIP(path string) (*GeoIP, error) {
+	if path == "" {
+		return nil, nil
+	}
+	geo := &GeoIP{path: path}
+	if err := geo.reload(); err != nil {
+		return nil, err
+	}
+	return geo, nil
+}
+
+// Country returns the ISO code of the country an address is in, or an empty
+// string when it is unknown
+func (g *GeoIP) Country(addr netip.Addr) string {
+	if g == nil {
+		return ""
+	}
+	g.refresh()
+	country, err := g.db.Load().country(addr)
+	if err != nil {
+		log.Printf("⚠️  GeoIP lookup of %s failed: %v", addr, err)
+	}
+	return country
+}
+
+// refresh reloads the database when the file changed. A broken file keeps the
+// previous database in use.
+func (g *GeoIP) refresh() {
+	now := time.Now().UnixNano()
+	checkedAt := g.checkedAt.Load()
+	if now-checkedAt < int64(refreshInterval) || !g.checkedAt.CompareAndSwap(checkedAt, now) {
+		return
+	}
+	info, err := os.Stat(g.path)
+	if err != nil {
+		return
+	}
+	g.mutex.Lock()
+	changed := !info.ModTime().Equal(g.modTime)
+	g.mutex.Unlock()
+	if !changed {
+		return
+	}
+	if err := g.reload(); err != nil {
+		log.Printf("⚠️  Keeping the previous GeoIP database: %v", err)
+	}
+}
+
+// reload reads the database file
+func (g *GeoIP) reload() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	info, err := os.Stat(g.path)
+	if err != nil {
+		return err
+	}
+	db, err := openMMDB(g.path)
+	if err != nil {
+		return err
+	}
+	g.db.Store(db)
+	g.modTime = info.ModTime()
+	return nil
+}
+
+// Enforcer checks requests against the access rules of the streams in an HLS
+// directory and keeps audit counters of its decisions. Rules are reloaded when
+// their sidecar changes; the hot path takes no locks.
+type Enforcer struct {
+	hlsDir string
+	geo    *GeoIP
+
+	policies sync.Map // stream directory -> *cachedPolicy
+	counters sync.Map // stream directory -> *Counters
+}
+
+// cachedPolicy is the compiled rules of a stream as of a sidecar modification time
+type cachedPolicy struct {
+	policy    *Policy // nil when the stream has no rules
+	broken    bool    // the sidecar could not be read and there is no earlier policy
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewEnforcer returns an enforcer for the streams of an HLS directory. Country
+// rules match nothing without a geo database.
+func NewEnforcer(hlsDir string, geo *GeoIP) *Enforcer {
+	return &Enforcer{hlsDir: hlsDir, geo: geo}
+}
+
+// GeoIPEnabled reports whether country rules can be enforced
+func (e *Enforcer) GeoIPEnabled() bool {
+	return e.geo != nil
+}
+
+// Check decides whether a client may be served the files of a stream directory
+// and counts the decision
+func (e *Enforcer) Check(directory, clientIP string) Decision {
+	decision := e.decide(directory, clientIP)
+	e.countersOf(directory).record(decision)
+	return decision
+}
+
+// decide checks a client against the current rules of a stream directory
+func (e *Enforcer) decide(directory, clientIP string) Decision {
+	cached := e.policyOf(directory)
+	if cached.broken {
+		return Decision{Reason: ReasonRulesUnreadable}
+	}
+	if cached.policy == nil {
+		return Decision{Allowed: true}
+	}
+
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return Decision{Reason: ReasonInvalidIP}
+	}
+	country := ""
+	if cached.policy.usesCountries() {
+		country = e.geo.Country(addr)
+	}
+	return cached.policy.Check(addr, country)
+}
+
+// policyOf returns the compiled rules of a stream directory, re-reading the
+// sidecar at most once per refresh interval
+func (e *Enforcer) policyOf(directory string) *cachedPolicy {
+	now := time.Now()
+	previous, _ := e.policies.Load(directory)
+	if previous != nil && now.Sub(previous.(*cachedPolicy).checkedAt) < refreshInterval {
+		return previous.(*cachedPolicy)
+	}
+
+	next := &cachedPolicy{checkedAt: now}
+	streamDir := filepath.Join(e.hlsDir, directory)
+	if info, err := os.Stat(filepath.Join(streamDir, RulesFile)); err == nil {
+		next.modTime = info.ModTime()
+	}
+	if previous != nil && next.modTime.Equal(previous.(*cachedPolicy).modTime) {
+		next.policy = previous.(*cachedPolicy).policy
+		next.broken = previous.(*cachedPolicy).broken
+		e.policies.Store(directory, next)
+		return next
+	}
+
+	rules, err := ReadRules(streamDir)
+	var policy *Policy
+	if err == nil && !rules.Empty() {
+		policy, err = Compile(rules)
+	}
+	switch {
+	case err == nil:
+		next.policy = policy
+	case previous != nil && !previous.(*cachedPolicy).broken:
+		// Keep enforcing the last rules that could be read
+		log.Printf("⚠️  Keeping the previous access rules of %s: %v", directory, err)
+		next.policy = previous.(*cachedPolicy).policy
+	default:
+		log.Printf("⚠️  Denying access to %s, its access rules are unreadable: %v", directory, err)
+		next.broken = true
+	}
+	e.policies.Store(directory, next)
+	return next
+}
+
+// Rules returns the rules of a stream directory as enforced
+func (e *Enforcer) Rules(directory string) (*Rules, error) {
+	return ReadRules(filepath.Join(e.hlsDir, directory))
+}
+
+// Counters are the audit counters of a stream's access decisions
+type Counters struct {
+	allowed   atomic.Uint64
+	denied    atomic.Uint64
+	reasons   sync.Map // reason -> *atomic.Uint64
+	countries sync.Map // country of denied requests -> *atomic.Uint64
+}
+
+// CounterSnapshot is a copy of a stream's audit counters
+type CounterSnapshot struct {
+	Allowed         uint64            `json:"allowed"`
+	Denied          uint64            `json:"denied"`
+	DeniedByReason  map[string]uint64 `json:"denied_by_reason"`
+	DeniedByCountry map[string]uint64 `json:"denied_by_country"`
+}
+
+// countersOf returns the audit counters of a stream directory
+func (e *Enforcer) countersOf(directory string) *Counters {
+	if counters, ok := e.counters.Load(directory); ok {
+		return counters.(*Counters)
+	}
+	counters, _ := e.counters.LoadOrStore(directory, &Counters{})
+	return counters.(*Counters)
+}
+
+// Counters returns a snapshot of the audit counters of a stream directory
+func (e *Enforcer) Counters(directory string) CounterSnapshot {
+	return e.countersOf(directory).snapshot()
+}
+
+// Directories returns the stream directories with audit counters, sorted
+func (e *Enforcer) Directories() []string {
+	var directories []string
+	e.counters.Range(func(key, _ any) bool {
+		directories = append(directories, key.(string))
+		return true
+	})
+	sort.Strings(directories)
+	return directories
+}
+
+// record counts a decision
+func (c *Counters) record(decision Decision) {
+	if decision.Allowed {
+		c.allowed.Add(1)
+		return
+	}
+	c.denied.Add(1)
+	increment(&c.reasons, decision.Reason)
+	if decision.Country != "" {
+		increment(&c.countries, decision.Country)
+	}
+}
+
+// increment adds one to the counter of a key
+func increment(counters *sync.Map, key string) {
+	counter, ok := counters.Load(key)
+	if !ok {
+		counter, _ = counters.LoadOrStore(key, new(atomic.Uint64))
+	}
+	counter.(*atomic.Uint64).Add(1)
+}
+
+// snapshot copies the counters
+func (c *Counters) snapshot() CounterSnapshot {
+	snapshot := CounterSnapshot{
+		Allowed:         c.allowed.Load(),
+		Denied:          c.denied.Load(),
+		DeniedByReason:  map[string]uint64{},
+		DeniedByCountry: map[string]uint64{},
+	}
+	for _, copy := range []struct {
+		from *sync.Map
+		to   map[string]uint64
+	}{{&c.reasons, snapshot.DeniedByReason}, {&c.countries, snapshot.DeniedByCountry}} {
+		copy.from.Range(func(key, value any) bool {
+			copy.to[key.(string)] = value.(*atomic.Uint64).Load()
+			return true
+		})
+	}
+	return snapshot
+}
@@ -0,0 +1,377 @@
+package access
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+)
+
+// metadataMarker precedes the metadata section at the end of a MaxMind DB file
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+const (
+	// metadataSearchWindow is how far from the end of the file the metadata may start
+	metadataSearchWindow = 128 * 1024
+	// dataSectionSeparator is the number of zero bytes between the search tree and the data section
+	dataSectionSeparator = 16
+)
+
+// MaxMind DB data field types
+const (
+	mmdbExtended = 0
+	mmdbPointer  = 1
+	mmdbString   = 2
+	mmdbDouble   = 3
+	mmdbBytes    = 4
+	mmdbUint16   = 5
+	mmdbUint32   = 6
+	mmdbMap      = 7
+	mmdbInt32    = 8
+	mmdbUint64   = 9
+	mmdbUint128  = 10
+	mmdbArray    = 11
+	mmdbBool     = 14
+	mmdbFloat    = 15
+)
+
+// errMMDBCorrupt is returned for files that do not follow the MaxMind DB format
+var errMMDBCorrupt = errors.New("corrupt MaxMind database")
+
+// mmdb is a MaxMind DB file read into memory. Only what country lookups need
+// is decoded: lookups walk the search tree and then follow the country path of
+// the record without decoding the rest of it.
+type mmdb struct {
+	data       []byte // the whole file
+	nodeCount  uint32
+	recordSize uint32 // bits per record, 24, 28 or 32
+	ipVersion  uint32
+	dataStart  int    // offset of the data section
+	ipv4Start  uint32 // node IPv4 addresses start at in an IPv6 tree
+}
+
+// openMMDB reads a MaxMind DB file
+func openMMDB(path string) (*mmdb, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	searchFrom := len(data) - metadataSearchWindow
+	if searchFrom < 0 {
+		searchFrom = 0
+	}
+	marker := bytes.LastIndex(data[searchFrom:], metadataMarker)
+	if marker < 0 {
+		return nil, fmt.Errorf("%w: no metadata section", errMMDBCorrupt)
+	}
+	metadataStart := searchFrom + marker + len(metadataMarker)
+
+	db := &mmdb{data: data}
+	metadata := &mmdbDecoder{data: data[metadataStart:]}
+	for key, dst := range map[string]*uint32{
+		"node_count":  &db.nodeCount,
+		"record_size": &db.recordSize,
+		"ip_version":  &db.ipVersion,
+	} {
+		value, ok, err := metadata.uintAt(0, key)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: metadata has no %s", errMMDBCorrupt, key)
+		}
+		*dst = uint32(value)
+	}
+
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%w: unsupported record size %d", errMMDBCorrupt, db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("%w: unsupported IP version %d", errMMDBCorrupt, db.ipVersion)
+	}
+	treeSize := int(db.nodeCount) * int(db.recordSize) / 4
+	db.dataStart = treeSize + dataSectionSeparator
+	if db.dataStart > metadataStart-len(metadataMarker) {
+		return nil, fmt.Errorf("%w: search tree overlaps the metadata", errMMDBCorrupt)
+	}
+
+	// IPv4 addresses live under ::/96 of IPv6 trees
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a search tree node
+func (db *mmdb) record(node uint32, bit uint8) uint32 {
+	switch db.recordSize {
+	case 24:
+		b := db.data[node*6+uint32(bit)*3:]
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 28:
+		b := db.data[node*7:]
+		if bit == 0 {
+			return uint32(b[3]&0xF0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0F)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	default:
+		return binary.BigEndian.Uint32(db.data[node*8+uint32(bit)*4:])
+	}
+}
+
+// lookup returns the data section offset of the record for an address, and
+// false when the database has none
+func (db *mmdb) lookup(addr netip.Addr) (int, bool) {
+	addr = addr.Unmap()
+	var ip []byte
+	node := uint32(0)
+	if addr.Is4() {
+		ip = addr.AsSlice()
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else {
+		if db.ipVersion == 4 {
+			return 0, false
+		}
+		ip = addr.AsSlice()
+	}
+
+	for i := 0; i < len(ip)*8 && node < db.nodeCount; i++ {
+		bit := ip[i/8] >> (7 - uint(i%8)) & 1
+		node = db.record(node, bit)
+	}
+	if node <= db.nodeCount {
+		return 0, false
+	}
+	offset := int(node-db.nodeCount) - dataSectionSeparator
+	if offset < 0 || db.dataStart+offset >= len(db.data) {
+		return 0, false
+	}
+	return offset, true
+}
+
+// country returns the ISO code of the country an address is in, falling back
+// to the country it is registered to. It is empty when the database does not
+// know the address.
+func (db *mmdb) country(addr netip.Addr) (string, error) {
+	offset, ok := db.lookup(addr)
+	if !ok {
+		return "", nil
+	}
+	decoder := &mmdbDecoder{data: db.data[db.dataStart:]}
+	for _, field := range []string{"country", "registered_country"} {
+		code, ok, err := decoder.stringAt(offset, field, "iso_code")
+		if err != nil || ok {
+			return code, err
+		}
+	}
+	return "", nil
+}
+
+// mmdbDecoder reads fields of the MaxMind DB data section. Offsets, including
+// those of pointers, are relative to the start of the section.
+type mmdbDecoder struct {
+	data []byte
+}
+
+// field is the control information of a data field
+type field struct {
+	kind    int
+	size    int // payload size, or the value of booleans and the entry count of maps and arrays
+	payload int // offset of the payload
+	next    int // offset after the field, or after the pointer that led to it
+}
+
+// decodeControl reads the control byte of the field at an offset and follows pointers
+func (d *mmdbDecoder) decodeControl(offset int) (field, error) {
+	f, err := d.control(offset)
+	if err != nil || f.kind != mmdbPointer {
+		return f, err
+	}
+	target, err := d.control(f.size)
+	if err != nil {
+		return field{}, err
+	}
+	if target.kind == mmdbPointer {
+		return field{}, fmt.Errorf("%w: pointer to a pointer", errMMDBCorrupt)
+	}
+	target.next = f.next
+	return target, nil
+}
+
+// control reads the control byte of the field at an offset. For pointers the
+// size is the offset they point to.
+func (d *mmdbDecoder) control(offset int) (field, error) {
+	read := func(n int) ([]byte, error) {
+		if offset < 0 || offset+n > len(d.data) {
+			return nil, fmt.Errorf("%w: field beyond the end of the data", errMMDBCorrupt)
+		}
+		b := d.data[offset : offset+n]
+		offset += n
+		return b, nil
+	}
+
+	b, err := read(1)
+	if err != nil {
+		return field{}, err
+	}
+	ctrl := b[0]
+	f := field{kind: int(ctrl >> 5)}
+
+	if f.kind == mmdbPointer {
+		extra := int(ctrl>>3) & 0x3
+		b, err := read(extra + 1)
+		if err != nil {
+			return field{}, err
+		}
+		value := int(ctrl & 0x7)
+		if extra == 3 {
+			value = 0
+		}
+		for _, c := range b {
+			value = value<<8 | int(c)
+		}
+		switch extra {
+		case 1:
+			value += 2048
+		case 2:
+			value += 526336
+		}
+		f.size = value
+		f.next = offset
+		return f, nil
+	}
+
+	if f.kind == mmdbExtended {
+		b, err := read(1)
+		if err != nil {
+			return field{}, err
+		}
+		f.kind = 7 + int(b[0])
+	}
+
+	f.size = int(ctrl & 0x1f)
+	if f.size >= 29 {
+		n := f.size - 28
+		b, err := read(n)
+		if err != nil {
+			return field{}, err
+		}
+		value := 0
+		for _, c := range b {
+			value = value<<8 | int(c)
+		}
+		f.size = []int{29, 285, 65821}[n-1] + value
+	}
+	f.payload = offset
+
+	switch f.kind {
+	case mmdbMap, mmdbArray, mmdbBool:
+		f.next = offset
+	default:
+		if offset+f.size > len(d.data) {
+			return field{}, fmt.Errorf("%w: field beyond the end of the data", errMMDBCorrupt)
+		}
+		f.next = offset + f.size
+	}
+	return f, nil
+}
+
+// skip returns the offset after the field at an offset, including the entries of maps and arrays
+func (d *mmdbDecoder) skip(offset int) (int, error) {
+	f, err := d.decodeControl(offset)
+	if err != nil {
+		return 0, err
+	}
+	// A pointer ends after itself, wherever the field it points to ends
+	if f.next != f.payload || (f.kind != mmdbMap && f.kind != mmdbArray) {
+		return f.next, nil
+	}
+	entries := f.size
+	if f.kind == mmdbMap {
+		entries *= 2
+	}
+	next := f.payload
+	for i := 0; i < entries; i++ {
+		if next, err = d.skip(next); err != nil {
+			return 0, err
+		}
+	}
+	return next, nil
+}
+
+// find follows a path of map keys from the field at an offset
+func (d *mmdbDecoder) find(offset int, path ...string) (field, bool, error) {
+	f, err := d.decodeControl(offset)
+	if err != nil {
+		return field{}, false, err
+	}
+	for _, key := range path {
+		if f.kind != mmdbMap {
+			return field{}, false, nil
+		}
+		found := false
+		entry := f.payload
+		for i := 0; i < f.size; i++ {
+			k, err := d.decodeControl(entry)
+			if err != nil {
+				return field{}, false, err
+			}
+			if k.kind != mmdbString {
+				return field{}, false, fmt.Errorf("%w: map key is not a string", errMMDBCorrupt)
+			}
+			if string(d.data[k.payload:k.payload+k.size]) == key {
+				if f, err = d.decodeControl(k.next); err != nil {
+					return field{}, false, err
+				}
+				found = true
+				break
+			}
+			if entry, err = d.skip(k.next); err != nil {
+				return field{}, false, err
+			}
+		}
+		if !found {
+			return field{}, false, nil
+		}
+	}
+	return f, true, nil
+}
+
+// stringAt returns the string at a path of map keys
+func (d *mmdbDecoder) stringAt(offset int, path ...string) (string, bool, error) {
+	f, ok, err := d.find(offset, path...)
+	if err != nil || !ok || f.kind != mmdbString {
+		return "", false, err
+	}
+	return string(d.data[f.payload : f.payload+f.size]), true, nil
+}
+
+// uintAt returns the unsigned integer at a path of map keys
+func (d *mmdbDecoder) uintAt(offset int, path ...string) (uint64, bool, error) {
+	f, ok, err := d.find(offset, path...)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	switch f.kind {
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+	default:
+		return 0, false, nil
+	}
+	if f.size > 8 {
+		return 0, false, fmt.Errorf("%w: integer of %d bytes", errMMDBCorrupt, f.size)
+	}
+	var value uint64
+	for _, c := range d.data[f.payload : f.payload+f.size] {
+		value = value<<8 | uint64(c)
+	}
+	return value, true, nil
+}
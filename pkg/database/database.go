@@ -5,10 +5,12 @@ import (
 	"os"
 	"time"
 
+	"github.com/google/uuid"
 	"gorm.io/driver/sqlite"
 	"github.com/streamforge/platform/pkg/config"
 	"github.com/streamforge/platform/pkg/logger"
 	"github.com/streamforge/platform/pkg/models"
+	"github.com/streamforge/platform/pkg/playback"
 	"gorm.io/gorm"
 	glogger "gorm.io/gorm/logger"
 )
@@ -76,10 +78,20 @@ func (d *Database) AutoMigrate() error {
 	err := d.db.AutoMigrate(
 		&models.User{},
 		&models.Stream{},
+		&models.PlaybackID{},
 		&models.StreamSession{},
 		&models.Viewer{},
 		&models.StreamAnalytics{},
 		&models.Notification{},
+		&models.StreamSettings{},
+		&models.Recording{},
+		&models.Clip{},
+		&models.RestreamTarget{},
+		&models.StreamOverlay{},
+		&models.AdCue{},
+		&models.EncryptionKey{},
+		&models.StreamAccessRules{},
+		&models.PlaybackEvent{},
 	)
 
 	if err != nil {
@@ -134,6 +146,7 @@ func (d *Database) Seed() error {
 	// Create sample users
 	users := []models.User{
 		{
+			ID:        uuid.New(),
 			Username:  "demo_user",
 			Email:     "demo@streamforge.com",
 			Password:  "$2a$10$example.hash.here", // In real app, use proper bcrypt
@@ -142,6 +155,7 @@ func (d *Database) Seed() error {
 			IsActive:  true,
 		},
 		{
+			ID:        uuid.New(),
 			Username:  "streamer1",
 			Email:     "streamer1@streamforge.com",
 			Password:  "$2a$10$example.hash.here",
@@ -150,6 +164,7 @@ func (d *Database) Seed() error {
 			IsActive:  true,
 		},
 		{
+			ID:        uuid.New(),
 			Username:  "viewer1",
 			Email:     "viewer1@streamforge.com",
 			Password:  "$2a$10$example.hash.here",
@@ -172,6 +187,7 @@ func (d *Database) Seed() error {
 	}
 
 	demoStream := models.Stream{
+		ID:          uuid.New(),
 		UserID:      demoUser.ID,
 		Title:       "Demo Live Stream",
 		Description: "A demonstration live stream for testing purposes",
@@ -183,6 +199,21 @@ func (d *Database) Seed() error {
 		return fmt.Errorf("failed to create demo stream: %w", err)
 	}
 
+	playbackID, err := playback.NewID()
+	if err != nil {
+		return err
+	}
+	demoPlaybackID := models.PlaybackID{
+		ID:         uuid.New(),
+		StreamID:   demoStream.ID,
+		PlaybackID: playbackID,
+		Policy:     models.PlaybackPolicyPublic,
+	}
+
+	if err := d.db.Create(&demoPlaybackID).Error; err != nil {
+		return fmt.Errorf("failed to create demo playback ID: %w", err)
+	}
+
 	logger.Info("Database seeded successfully")
 	return nil
 }
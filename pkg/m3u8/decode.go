@@ -0,0 +1,435 @@
+package m3u8
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseMaster parses a master playlist
+func ParseMaster(data []byte) (*MasterPlaylist, error) {
+	lines, err := playlistLines(data)
+	if err != nil {
+		return nil, err
+	}
+
+	playlist := &MasterPlaylist{}
+	var pending *Variant
+	for _, l := range lines {
+		tag, value, _ := strings.Cut(l.text, ":")
+		switch {
+		case !strings.HasPrefix(l.text, "#"):
+			if pending == nil {
+				return nil, l.errorf("URI without EXT-X-STREAM-INF")
+			}
+			pending.URI = l.text
+			playlist.Variants = append(playlist.Variants, *pending)
+			pending = nil
+		case tag == "#EXT-X-VERSION":
+			if playlist.Version, err = strconv.Atoi(value); err != nil {
+				return nil, l.errorf("invalid version %q", value)
+			}
+		case tag == "#EXT-X-INDEPENDENT-SEGMENTS":
+			playlist.IndependentSegments = true
+		case tag == "#EXT-X-START":
+			if playlist.Start, err = parseStart(value); err != nil {
+				return nil, l.wrap(err)
+			}
+		case tag == "#EXT-X-MEDIA":
+			media, err := parseMedia(value)
+			if err != nil {
+				return nil, l.wrap(err)
+			}
+			playlist.Media = append(playlist.Media, media)
+		case tag == "#EXT-X-STREAM-INF":
+			variant, err := parseVariant(value)
+			if err != nil {
+				return nil, l.wrap(err)
+			}
+			pending = &variant
+		case tag == "#EXT-X-I-FRAME-STREAM-INF":
+			variant, err := parseVariant(value)
+			if err != nil {
+				return nil, l.wrap(err)
+			}
+			variant.URI = unquote(attributeValue(parseAttributes(value), "URI"))
+			playlist.IFrameVariants = append(playlist.IFrameVariants, variant)
+		case tag == "#EXTINF" || tag == "#EXT-X-TARGETDURATION":
+			return nil, ErrMediaPlaylist
+		default:
+			playlist.Tags = append(playlist.Tags, l.text)
+		}
+	}
+	return playlist, nil
+}
+
+// ParseMedia parses a media playlist
+func ParseMedia(data []byte) (*MediaPlaylist, error) {
+	lines, err := playlistLines(data)
+	if err != nil {
+		return nil, err
+	}
+
+	playlist := &MediaPlaylist{}
+	var current Segment
+	started := false // whether a segment tag was seen, after which unknown tags belong to segments
+	hasDuration := false
+	for _, l := range lines {
+		tag, value, _ := strings.Cut(l.text, ":")
+		switch {
+		case !strings.HasPrefix(l.text, "#"):
+			if !hasDuration {
+				return nil, l.errorf("segment %q without EXTINF", l.text)
+			}
+			current.URI = l.text
+			playlist.Segments = append(playlist.Segments, current)
+			current = Segment{}
+			hasDuration = false
+		case tag == "#EXT-X-VERSION":
+			if playlist.Version, err = strconv.Atoi(value); err != nil {
+				return nil, l.errorf("invalid version %q", value)
+			}
+		case tag == "#EXT-X-TARGETDURATION":
+			if playlist.TargetDuration, err = strconv.Atoi(value); err != nil {
+				return nil, l.errorf("invalid target duration %q", value)
+			}
+		case tag == "#EXT-X-MEDIA-SEQUENCE":
+			if playlist.MediaSequence, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return nil, l.errorf("invalid media sequence %q", value)
+			}
+		case tag == "#EXT-X-DISCONTINUITY-SEQUENCE":
+			if playlist.DiscontinuitySequence, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return nil, l.errorf("invalid discontinuity sequence %q", value)
+			}
+		case tag == "#EXT-X-PLAYLIST-TYPE":
+			playlist.PlaylistType = value
+		case tag == "#EXT-X-INDEPENDENT-SEGMENTS":
+			playlist.IndependentSegments = true
+		case tag == "#EXT-X-START":
+			if playlist.Start, err = parseStart(value); err != nil {
+				return nil, l.wrap(err)
+			}
+		case tag == "#EXT-X-SERVER-CONTROL":
+			if playlist.ServerControl, err = parseServerControl(value); err != nil {
+				return nil, l.wrap(err)
+			}
+		case tag == "#EXT-X-PART-INF":
+			if playlist.PartTarget, err = parseFloatAttribute(parseAttributes(value), "PART-TARGET"); err != nil {
+				return nil, l.wrap(err)
+			}
+		case tag == "#EXT-X-ENDLIST":
+			playlist.Ended = true
+		case tag == "#EXT-X-STREAM-INF" || tag == "#EXT-X-MEDIA":
+			return nil, ErrMasterPlaylist
+
+		case tag == "#EXTINF":
+			duration, title, _ := strings.Cut(value, ",")
+			if current.Duration, err = strconv.ParseFloat(duration, 64); err != nil {
+				return nil, l.errorf("invalid segment duration %q", duration)
+			}
+			current.Title = title
+			hasDuration, started = true, true
+		case tag == "#EXT-X-BYTERANGE":
+			current.ByteRange = value
+		case tag == "#EXT-X-DISCONTINUITY":
+			current.Discontinuity, started = true, true
+		case tag == "#EXT-X-GAP":
+			current.Gap, started = true, true
+		case tag == "#EXT-X-PROGRAM-DATE-TIME":
+			if current.ProgramDateTime, err = parseTime(value); err != nil {
+				return nil, l.wrap(err)
+			}
+			started = true
+		case tag == "#EXT-X-KEY":
+			current.Key, started = parseKey(value), true
+		case tag == "#EXT-X-MAP":
+			attributes := parseAttributes(value)
+			current.Map = &Map{
+				URI:       unquote(attributeValue(attributes, "URI")),
+				ByteRange: unquote(attributeValue(attributes, "BYTERANGE")),
+			}
+			started = true
+		case tag == "#EXT-X-DATERANGE":
+			dateRange, err := parseDateRange(value)
+			if err != nil {
+				return nil, l.wrap(err)
+			}
+			current.DateRanges = append(current.DateRanges, dateRange)
+			started = true
+		case tag == "#EXT-X-PART":
+			part, err := parsePart(value)
+			if err != nil {
+				return nil, l.wrap(err)
+			}
+			current.Parts = append(current.Parts, part)
+			started = true
+		case tag == "#EXT-X-PRELOAD-HINT":
+			attributes := parseAttributes(value)
+			playlist.PreloadHint = &PreloadHint{
+				Type: attributeValue(attributes, "TYPE"),
+				URI:  unquote(attributeValue(attributes, "URI")),
+			}
+			if raw := attributeValue(attributes, "BYTERANGE-START"); raw != "" {
+				if playlist.PreloadHint.ByteRangeStart, err = strconv.ParseInt(raw, 10, 64); err != nil {
+					return nil, l.errorf("invalid BYTERANGE-START %q", raw)
+				}
+			}
+		default:
+			if started {
+				current.Tags = append(current.Tags, l.text)
+			} else {
+				playlist.Tags = append(playlist.Tags, l.text)
+			}
+		}
+	}
+
+	// What follows the last segment belongs to the one that is still being written
+	playlist.PendingParts = current.Parts
+	playlist.Trailer = current.Tags
+	return playlist, nil
+}
+
+// line is a non-empty line of a playlist with its line number
+type line struct {
+	number int
+	text   string
+}
+
+func (l line) errorf(format string, args ...any) error {
+	return fmt.Errorf("m3u8: line %d: %s", l.number, fmt.Sprintf(format, args...))
+}
+
+func (l line) wrap(err error) error {
+	return fmt.Errorf("m3u8: line %d: %w", l.number, err)
+}
+
+// playlistLines returns the non-empty lines after the #EXTM3U header
+func playlistLines(data []byte) ([]line, error) {
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	var lines []line
+	for i, raw := range strings.Split(text, "\n") {
+		if trimmed := strings.TrimSpace(raw); trimmed != "" {
+			lines = append(lines, line{number: i + 1, text: trimmed})
+		}
+	}
+	if len(lines) == 0 || lines[0].text != "#EXTM3U" {
+		return nil, ErrNotPlaylist
+	}
+	return lines[1:], nil
+}
+
+// parseTime parses a date-time value. FFmpeg writes the zone offset without a
+// colon, which time.RFC3339 does not accept.
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999-0700"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date-time %q", value)
+}
+
+// parseAttributes splits an attribute list into its attributes, keeping the
+// quotes of quoted values so commas inside them survive
+func parseAttributes(list string) []Attribute {
+	var attributes []Attribute
+	for len(list) > 0 {
+		name, rest, found := strings.Cut(list, "=")
+		if !found {
+			break
+		}
+		end := 0
+		if strings.HasPrefix(rest, `"`) {
+			if closing := strings.Index(rest[1:], `"`); closing >= 0 {
+				end = closing + 2
+			} else {
+				end = len(rest)
+			}
+		} else if comma := strings.Index(rest, ","); comma >= 0 {
+			end = comma
+		} else {
+			end = len(rest)
+		}
+		attributes = append(attributes, Attribute{Name: strings.TrimSpace(name), Value: rest[:end]})
+		list = strings.TrimPrefix(rest[end:], ",")
+	}
+	return attributes
+}
+
+// attributeValue returns the value of an attribute as written, or ""
+func attributeValue(attributes []Attribute, name string) string {
+	for _, attribute := range attributes {
+		if attribute.Name == name {
+			return attribute.Value
+		}
+	}
+	return ""
+}
+
+// unquote removes the quotes around a quoted-string value
+func unquote(value string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(value, `"`), `"`)
+}
+
+// parseFloatAttribute returns a decimal attribute, or 0 when it is missing
+func parseFloatAttribute(attributes []Attribute, name string) (float64, error) {
+	raw := attributeValue(attributes, name)
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, raw)
+	}
+	return value, nil
+}
+
+// parseIntAttribute returns an integer attribute, or 0 when it is missing
+func parseIntAttribute(attributes []Attribute, name string) (int, error) {
+	raw := attributeValue(attributes, name)
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, raw)
+	}
+	return value, nil
+}
+
+func parseVariant(value string) (Variant, error) {
+	attributes := parseAttributes(value)
+	variant := Variant{
+		Codecs:         unquote(attributeValue(attributes, "CODECS")),
+		Resolution:     attributeValue(attributes, "RESOLUTION"),
+		Audio:          unquote(attributeValue(attributes, "AUDIO")),
+		Video:          unquote(attributeValue(attributes, "VIDEO")),
+		Subtitles:      unquote(attributeValue(attributes, "SUBTITLES")),
+		ClosedCaptions: unquote(attributeValue(attributes, "CLOSED-CAPTIONS")),
+	}
+	var err error
+	if variant.Bandwidth, err = parseIntAttribute(attributes, "BANDWIDTH"); err != nil {
+		return variant, err
+	}
+	if variant.AverageBandwidth, err = parseIntAttribute(attributes, "AVERAGE-BANDWIDTH"); err != nil {
+		return variant, err
+	}
+	if variant.FrameRate, err = parseFloatAttribute(attributes, "FRAME-RATE"); err != nil {
+		return variant, err
+	}
+	return variant, nil
+}
+
+func parseMedia(value string) (Media, error) {
+	attributes := parseAttributes(value)
+	media := Media{
+		Type:            attributeValue(attributes, "TYPE"),
+		GroupID:         unquote(attributeValue(attributes, "GROUP-ID")),
+		Name:            unquote(attributeValue(attributes, "NAME")),
+		Language:        unquote(attributeValue(attributes, "LANGUAGE")),
+		URI:             unquote(attributeValue(attributes, "URI")),
+		Default:         attributeValue(attributes, "DEFAULT") == "YES",
+		AutoSelect:      attributeValue(attributes, "AUTOSELECT") == "YES",
+		Forced:          attributeValue(attributes, "FORCED") == "YES",
+		InstreamID:      unquote(attributeValue(attributes, "INSTREAM-ID")),
+		Characteristics: unquote(attributeValue(attributes, "CHARACTERISTICS")),
+		Channels:        unquote(attributeValue(attributes, "CHANNELS")),
+	}
+	if media.Type == "" || media.GroupID == "" || media.Name == "" {
+		return media, fmt.Errorf("EXT-X-MEDIA needs TYPE, GROUP-ID and NAME")
+	}
+	return media, nil
+}
+
+func parseStart(value string) (*Start, error) {
+	attributes := parseAttributes(value)
+	offset, err := parseFloatAttribute(attributes, "TIME-OFFSET")
+	if err != nil {
+		return nil, err
+	}
+	return &Start{TimeOffset: offset, Precise: attributeValue(attributes, "PRECISE") == "YES"}, nil
+}
+
+func parseServerControl(value string) (*ServerControl, error) {
+	attributes := parseAttributes(value)
+	control := &ServerControl{CanBlockReload: attributeValue(attributes, "CAN-BLOCK-RELOAD") == "YES"}
+	var err error
+	if control.CanSkipUntil, err = parseFloatAttribute(attributes, "CAN-SKIP-UNTIL"); err != nil {
+		return nil, err
+	}
+	if control.HoldBack, err = parseFloatAttribute(attributes, "HOLD-BACK"); err != nil {
+		return nil, err
+	}
+	if control.PartHoldBack, err = parseFloatAttribute(attributes, "PART-HOLD-BACK"); err != nil {
+		return nil, err
+	}
+	return control, nil
+}
+
+func parseKey(value string) *Key {
+	attributes := parseAttributes(value)
+	return &Key{
+		Method:            attributeValue(attributes, "METHOD"),
+		URI:               unquote(attributeValue(attributes, "URI")),
+		IV:                attributeValue(attributes, "IV"),
+		KeyFormat:         unquote(attributeValue(attributes, "KEYFORMAT")),
+		KeyFormatVersions: unquote(attributeValue(attributes, "KEYFORMATVERSIONS")),
+	}
+}
+
+func parsePart(value string) (Part, error) {
+	attributes := parseAttributes(value)
+	part := Part{
+		URI:         unquote(attributeValue(attributes, "URI")),
+		Independent: attributeValue(attributes, "INDEPENDENT") == "YES",
+		ByteRange:   unquote(attributeValue(attributes, "BYTERANGE")),
+		Gap:         attributeValue(attributes, "GAP") == "YES",
+	}
+	var err error
+	part.Duration, err = parseFloatAttribute(attributes, "DURATION")
+	return part, err
+}
+
+func parseDateRange(value string) (DateRange, error) {
+	var dateRange DateRange
+	for _, attribute := range parseAttributes(value) {
+		var err error
+		switch attribute.Name {
+		case "ID":
+			dateRange.ID = unquote(attribute.Value)
+		case "CLASS":
+			dateRange.Class = unquote(attribute.Value)
+		case "START-DATE":
+			dateRange.StartDate, err = parseTime(unquote(attribute.Value))
+		case "END-DATE":
+			dateRange.EndDate, err = parseTime(unquote(attribute.Value))
+		case "DURATION", "PLANNED-DURATION":
+			var seconds float64
+			if seconds, err = strconv.ParseFloat(attribute.Value, 64); err != nil {
+				err = fmt.Errorf("invalid %s %q", attribute.Name, attribute.Value)
+			} else if attribute.Name == "DURATION" {
+				dateRange.Duration = &seconds
+			} else {
+				dateRange.PlannedDuration = &seconds
+			}
+		case "SCTE35-CMD":
+			dateRange.SCTE35Cmd = attribute.Value
+		case "SCTE35-OUT":
+			dateRange.SCTE35Out = attribute.Value
+		case "SCTE35-IN":
+			dateRange.SCTE35In = attribute.Value
+		case "END-ON-NEXT":
+			dateRange.EndOnNext = attribute.Value == "YES"
+		default:
+			dateRange.ClientAttributes = append(dateRange.ClientAttributes, attribute)
+		}
+		if err != nil {
+			return dateRange, err
+		}
+	}
+	if dateRange.ID == "" {
+		return dateRange, fmt.Errorf("EXT-X-DATERANGE needs an ID")
+	}
+	return dateRange, nil
+}
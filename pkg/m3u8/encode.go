@@ -0,0 +1,258 @@
+package m3u8
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Encode writes the master playlist
+func (p *MasterPlaylist) Encode() []byte {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	if p.Version > 0 {
+		fmt.Fprintf(&b, "#EXT-X-VERSION:%d\n", p.Version)
+	}
+	if p.IndependentSegments {
+		b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	}
+	if p.Start != nil {
+		writeStart(&b, p.Start)
+	}
+	writeTags(&b, p.Tags)
+
+	for _, media := range p.Media {
+		attributes := attributeWriter{}
+		attributes.enum("TYPE", media.Type)
+		attributes.quoted("GROUP-ID", media.GroupID)
+		attributes.quoted("NAME", media.Name)
+		attributes.quoted("LANGUAGE", media.Language)
+		attributes.flag("DEFAULT", media.Default)
+		attributes.flag("AUTOSELECT", media.AutoSelect)
+		attributes.flag("FORCED", media.Forced)
+		attributes.quoted("INSTREAM-ID", media.InstreamID)
+		attributes.quoted("CHARACTERISTICS", media.Characteristics)
+		attributes.quoted("CHANNELS", media.Channels)
+		attributes.quoted("URI", media.URI)
+		fmt.Fprintf(&b, "#EXT-X-MEDIA:%s\n", attributes.String())
+	}
+	for _, variant := range p.Variants {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:%s\n%s\n", variantAttributes(variant).String(), variant.URI)
+	}
+	for _, variant := range p.IFrameVariants {
+		attributes := variantAttributes(variant)
+		attributes.quoted("URI", variant.URI)
+		fmt.Fprintf(&b, "#EXT-X-I-FRAME-STREAM-INF:%s\n", attributes.String())
+	}
+	return []byte(b.String())
+}
+
+// Encode writes the media playlist
+func (p *MediaPlaylist) Encode() []byte {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	if p.Version > 0 {
+		fmt.Fprintf(&b, "#EXT-X-VERSION:%d\n", p.Version)
+	}
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", p.TargetDuration)
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", p.MediaSequence)
+	if p.DiscontinuitySequence > 0 {
+		fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", p.DiscontinuitySequence)
+	}
+	if p.PlaylistType != "" {
+		fmt.Fprintf(&b, "#EXT-X-PLAYLIST-TYPE:%s\n", p.PlaylistType)
+	}
+	if p.IndependentSegments {
+		b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	}
+	if p.Start != nil {
+		writeStart(&b, p.Start)
+	}
+	if control := p.ServerControl; control != nil {
+		attributes := attributeWriter{}
+		attributes.flag("CAN-BLOCK-RELOAD", control.CanBlockReload)
+		attributes.decimal("CAN-SKIP-UNTIL", control.CanSkipUntil)
+		attributes.decimal("HOLD-BACK", control.HoldBack)
+		attributes.decimal("PART-HOLD-BACK", control.PartHoldBack)
+		fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:%s\n", attributes.String())
+	}
+	if p.PartTarget > 0 {
+		fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%s\n", formatDecimal(p.PartTarget))
+	}
+	writeTags(&b, p.Tags)
+
+	for _, segment := range p.Segments {
+		writeSegment(&b, &segment)
+	}
+	writeParts(&b, p.PendingParts)
+	if hint := p.PreloadHint; hint != nil {
+		attributes := attributeWriter{}
+		attributes.enum("TYPE", hint.Type)
+		attributes.quoted("URI", hint.URI)
+		if hint.ByteRangeStart > 0 {
+			attributes.enum("BYTERANGE-START", strconv.FormatInt(hint.ByteRangeStart, 10))
+		}
+		fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:%s\n", attributes.String())
+	}
+	writeTags(&b, p.Trailer)
+	if p.Ended {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return []byte(b.String())
+}
+
+// writeSegment writes a segment with the tags in front of it
+func writeSegment(b *strings.Builder, segment *Segment) {
+	if segment.Discontinuity {
+		b.WriteString("#EXT-X-DISCONTINUITY\n")
+	}
+	if key := segment.Key; key != nil {
+		attributes := attributeWriter{}
+		attributes.enum("METHOD", key.Method)
+		attributes.quoted("URI", key.URI)
+		attributes.enum("IV", key.IV)
+		attributes.quoted("KEYFORMAT", key.KeyFormat)
+		attributes.quoted("KEYFORMATVERSIONS", key.KeyFormatVersions)
+		fmt.Fprintf(b, "#EXT-X-KEY:%s\n", attributes.String())
+	}
+	if segment.Map != nil {
+		attributes := attributeWriter{}
+		attributes.quoted("URI", segment.Map.URI)
+		attributes.quoted("BYTERANGE", segment.Map.ByteRange)
+		fmt.Fprintf(b, "#EXT-X-MAP:%s\n", attributes.String())
+	}
+	if !segment.ProgramDateTime.IsZero() {
+		fmt.Fprintf(b, "#EXT-X-PROGRAM-DATE-TIME:%s\n", segment.ProgramDateTime.Format(TimeFormat))
+	}
+	for _, dateRange := range segment.DateRanges {
+		fmt.Fprintf(b, "#EXT-X-DATERANGE:%s\n", dateRangeAttributes(dateRange).String())
+	}
+	writeTags(b, segment.Tags)
+	if segment.Gap {
+		b.WriteString("#EXT-X-GAP\n")
+	}
+	writeParts(b, segment.Parts)
+
+	// Six decimals is what FFmpeg writes, so its playlists come back unchanged
+	fmt.Fprintf(b, "#EXTINF:%s,%s\n", strconv.FormatFloat(segment.Duration, 'f', 6, 64), segment.Title)
+	if segment.ByteRange != "" {
+		fmt.Fprintf(b, "#EXT-X-BYTERANGE:%s\n", segment.ByteRange)
+	}
+	b.WriteString(segment.URI)
+	b.WriteString("\n")
+}
+
+func writeParts(b *strings.Builder, parts []Part) {
+	for _, part := range parts {
+		attributes := attributeWriter{}
+		attributes.decimal("DURATION", part.Duration)
+		attributes.quoted("URI", part.URI)
+		attributes.flag("INDEPENDENT", part.Independent)
+		attributes.quoted("BYTERANGE", part.ByteRange)
+		attributes.flag("GAP", part.Gap)
+		fmt.Fprintf(b, "#EXT-X-PART:%s\n", attributes.String())
+	}
+}
+
+func writeStart(b *strings.Builder, start *Start) {
+	attributes := attributeWriter{}
+	attributes.enum("TIME-OFFSET", formatDecimal(start.TimeOffset))
+	attributes.flag("PRECISE", start.Precise)
+	fmt.Fprintf(b, "#EXT-X-START:%s\n", attributes.String())
+}
+
+func writeTags(b *strings.Builder, tags []string) {
+	for _, tag := range tags {
+		b.WriteString(tag)
+		b.WriteString("\n")
+	}
+}
+
+func variantAttributes(variant Variant) *attributeWriter {
+	attributes := &attributeWriter{}
+	attributes.enum("BANDWIDTH", strconv.Itoa(variant.Bandwidth))
+	if variant.AverageBandwidth > 0 {
+		attributes.enum("AVERAGE-BANDWIDTH", strconv.Itoa(variant.AverageBandwidth))
+	}
+	attributes.enum("RESOLUTION", variant.Resolution)
+	attributes.quoted("CODECS", variant.Codecs)
+	attributes.decimal("FRAME-RATE", variant.FrameRate)
+	attributes.quoted("AUDIO", variant.Audio)
+	attributes.quoted("VIDEO", variant.Video)
+	attributes.quoted("SUBTITLES", variant.Subtitles)
+	if variant.ClosedCaptions == "NONE" {
+		attributes.enum("CLOSED-CAPTIONS", variant.ClosedCaptions)
+	} else {
+		attributes.quoted("CLOSED-CAPTIONS", variant.ClosedCaptions)
+	}
+	return attributes
+}
+
+func dateRangeAttributes(dateRange DateRange) *attributeWriter {
+	attributes := &attributeWriter{}
+	attributes.quoted("ID", dateRange.ID)
+	attributes.quoted("CLASS", dateRange.Class)
+	attributes.date("START-DATE", dateRange.StartDate)
+	attributes.date("END-DATE", dateRange.EndDate)
+	if dateRange.Duration != nil {
+		attributes.enum("DURATION", formatDecimal(*dateRange.Duration))
+	}
+	if dateRange.PlannedDuration != nil {
+		attributes.enum("PLANNED-DURATION", formatDecimal(*dateRange.PlannedDuration))
+	}
+	attributes.enum("SCTE35-CMD", dateRange.SCTE35Cmd)
+	attributes.enum("SCTE35-OUT", dateRange.SCTE35Out)
+	attributes.enum("SCTE35-IN", dateRange.SCTE35In)
+	attributes.flag("END-ON-NEXT", dateRange.EndOnNext)
+	for _, attribute := range dateRange.ClientAttributes {
+		attributes.enum(attribute.Name, attribute.Value)
+	}
+	return attributes
+}
+
+// attributeWriter builds an attribute list, leaving out empty values
+type attributeWriter struct {
+	strings.Builder
+}
+
+func (w *attributeWriter) enum(name, value string) {
+	if value == "" {
+		return
+	}
+	if w.Len() > 0 {
+		w.WriteString(",")
+	}
+	w.WriteString(name)
+	w.WriteString("=")
+	w.WriteString(value)
+}
+
+func (w *attributeWriter) quoted(name, value string) {
+	if value != "" {
+		w.enum(name, `"`+value+`"`)
+	}
+}
+
+func (w *attributeWriter) flag(name string, value bool) {
+	if value {
+		w.enum(name, "YES")
+	}
+}
+
+func (w *attributeWriter) decimal(name string, value float64) {
+	if value != 0 {
+		w.enum(name, formatDecimal(value))
+	}
+}
+
+func (w *attributeWriter) date(name string, value time.Time) {
+	if !value.IsZero() {
+		w.quoted(name, value.Format(TimeFormat))
+	}
+}
+
+// formatDecimal writes a decimal with millisecond precision
+func formatDecimal(value float64) string {
+	return strconv.FormatFloat(value, 'f', 3, 64)
+}
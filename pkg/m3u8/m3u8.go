@@ -0,0 +1,357 @@
+// Package m3u8 reads and writes HLS playlists (RFC 8216), including the
+// low-latency extensions. Playlists are parsed into plain structs that the
+// services change and encode again, so no service has to edit playlist text.
+// Tags the package does not model are kept in order and written back as they were.
+package m3u8
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// TimeFormat is how EXT-X-PROGRAM-DATE-TIME and EXT-X-DATERANGE dates are written
+const TimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// Values of EXT-X-PLAYLIST-TYPE
+const (
+	PlaylistTypeEvent = "EVENT"
+	PlaylistTypeVOD   = "VOD"
+)
+
+// Values of the TYPE attribute of EXT-X-MEDIA
+const (
+	MediaTypeAudio          = "AUDIO"
+	MediaTypeVideo          = "VIDEO"
+	MediaTypeSubtitles      = "SUBTITLES"
+	MediaTypeClosedCaptions = "CLOSED-CAPTIONS"
+)
+
+var (
+	// ErrNotPlaylist is returned for data that does not start with #EXTM3U
+	ErrNotPlaylist = errors.New("m3u8: missing #EXTM3U header")
+	// ErrMasterPlaylist is returned when a master playlist is parsed as a media playlist
+	ErrMasterPlaylist = errors.New("m3u8: not a media playlist")
+	// ErrMediaPlaylist is returned when a media playlist is parsed as a master playlist
+	ErrMediaPlaylist = errors.New("m3u8: not a master playlist")
+)
+
+// MasterPlaylist lists the renditions of a stream
+type MasterPlaylist struct {
+	Version             int
+	IndependentSegments bool
+	Start               *Start
+	Media               []Media
+	Variants            []Variant
+	IFrameVariants      []Variant
+	Tags                []string
+}
+
+// Variant is an EXT-X-STREAM-INF, or an EXT-X-I-FRAME-STREAM-INF in IFrameVariants
+type Variant struct {
+	URI              string
+	Bandwidth        int
+	AverageBandwidth int
+	Codecs           string
+	Resolution       string
+	FrameRate        float64
+	Audio            string
+	Video            string
+	Subtitles        string
+	ClosedCaptions   string // a group ID, or NONE
+}
+
+// Media is an EXT-X-MEDIA rendition of a media group
+type Media struct {
+	Type            string
+	GroupID         string
+	Name            string
+	Language        string
+	URI             string
+	Default         bool
+	AutoSelect      bool
+	Forced          bool
+	InstreamID      string
+	Characteristics string
+	Channels        string
+}
+
+// MediaPlaylist is the list of segments of one rendition
+type MediaPlaylist struct {
+	Version               int
+	TargetDuration        int
+	MediaSequence         int64
+	DiscontinuitySequence int64
+	PlaylistType          string
+	IndependentSegments   bool
+	Start                 *Start
+	ServerControl         *ServerControl
+	PartTarget            float64 // EXT-X-PART-INF, zero without partial segments
+	Tags                  []string
+	Segments              []Segment
+	// PendingParts are the parts of the segment that is still being written
+	PendingParts []Part
+	PreloadHint  *PreloadHint
+	// Trailer holds the tags after the last segment, such as EXT-X-RENDITION-REPORT
+	Trailer []string
+	Ended   bool
+}
+
+// Segment is a media segment with the tags written in front of it. Key and Map
+// are only set on the segment they appear before; they apply to the segments
+// that follow until the next one.
+type Segment struct {
+	URI             string
+	Duration        float64
+	Title           string
+	ByteRange       string
+	Discontinuity   bool
+	Gap             bool
+	ProgramDateTime time.Time
+	Key             *Key
+	Map             *Map
+	DateRanges      []DateRange
+	Parts           []Part
+	Tags            []string
+}
+
+// Key is an EXT-X-KEY
+type Key struct {
+	Method            string
+	URI               string
+	IV                string
+	KeyFormat         string
+	KeyFormatVersions string
+}
+
+// Map is an EXT-X-MAP
+type Map struct {
+	URI       string
+	ByteRange string
+}
+
+// DateRange is an EXT-X-DATERANGE. Zero times and nil durations are left out.
+type DateRange struct {
+	ID              string
+	Class           string
+	StartDate       time.Time
+	EndDate         time.Time
+	Duration        *float64
+	PlannedDuration *float64
+	SCTE35Cmd       string
+	SCTE35Out       string
+	SCTE35In        string
+	EndOnNext       bool
+	// ClientAttributes are the X- attributes with their values as written, quotes included
+	ClientAttributes []Attribute
+}
+
+// Attribute is a name and its value as written in an attribute list
+type Attribute struct {
+	Name  string
+	Value string
+}
+
+// Part is an EXT-X-PART of a low-latency playlist
+type Part struct {
+	URI         string
+	Duration    float64
+	Independent bool
+	ByteRange   string
+	Gap         bool
+}
+
+// PreloadHint is an EXT-X-PRELOAD-HINT
+type PreloadHint struct {
+	Type           string
+	URI            string
+	ByteRangeStart int64
+}
+
+// ServerControl is an EXT-X-SERVER-CONTROL
+type ServerControl struct {
+	CanBlockReload bool
+	CanSkipUntil   float64
+	HoldBack       float64
+	PartHoldBack   float64
+}
+
+// Start is an EXT-X-START
+type Start struct {
+	TimeOffset float64
+	Precise    bool
+}
+
+// ReadMasterFile parses a master playlist from disk
+func ReadMasterFile(path string) (*MasterPlaylist, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMaster(data)
+}
+
+// ReadMediaFile parses a media playlist from disk
+func ReadMediaFile(path string) (*MediaPlaylist, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMedia(data)
+}
+
+// ProgramDateTimes returns the wall-clock time of every segment. Segments
+// without their own EXT-X-PROGRAM-DATE-TIME continue from the one before;
+// segments before the first date-time get the zero time.
+func (p *MediaPlaylist) ProgramDateTimes() []time.Time {
+	times := make([]time.Time, len(p.Segments))
+	var next time.Time
+	for i, segment := range p.Segments {
+		if !segment.ProgramDateTime.IsZero() {
+			next = segment.ProgramDateTime
+		}
+		times[i] = next
+		if !next.IsZero() {
+			next = next.Add(time.Duration(segment.Duration * float64(time.Second)))
+		}
+	}
+	return times
+}
+
+// TrimFront removes the first n segments the way a live playlist slides,
+// advancing the media and discontinuity sequence numbers to match. The key and
+// initialization section in effect move to the new first segment.
+func (p *MediaPlaylist) TrimFront(n int) {
+	n = min(max(n, 0), len(p.Segments))
+	var key *Key
+	var initSection *Map
+	for _, segment := range p.Segments[:n] {
+		if segment.Discontinuity {
+			p.DiscontinuitySequence++
+		}
+		if segment.Key != nil {
+			key = segment.Key
+		}
+		if segment.Map != nil {
+			initSection = segment.Map
+		}
+	}
+	p.MediaSequence += int64(n)
+	p.Segments = p.Segments[n:]
+	if len(p.Segments) > 0 {
+		if p.Segments[0].Key == nil {
+			p.Segments[0].Key = key
+		}
+		if p.Segments[0].Map == nil {
+			p.Segments[0].Map = initSection
+		}
+	}
+}
+
+// AppendQuery adds a query string to the URI of every rendition, for example to
+// pass an access token on to the playlists a player loads next
+func (p *MasterPlaylist) AppendQuery(rawQuery string) {
+	for i := range p.Variants {
+		p.Variants[i].URI = appendQuery(p.Variants[i].URI, rawQuery)
+	}
+	for i := range p.IFrameVariants {
+		p.IFrameVariants[i].URI = appendQuery(p.IFrameVariants[i].URI, rawQuery)
+	}
+	for i := range p.Media {
+		p.Media[i].URI = appendQuery(p.Media[i].URI, rawQuery)
+	}
+}
+
+// AppendQuery adds a query string to the URI of every segment, part, key,
+// initialization section and preload hint
+func (p *MediaPlaylist) AppendQuery(rawQuery string) {
+	for i := range p.Segments {
+		segment := &p.Segments[i]
+		segment.URI = appendQuery(segment.URI, rawQuery)
+		if segment.Key != nil {
+			key := *segment.Key
+			key.URI = appendQuery(key.URI, rawQuery)
+			segment.Key = &key
+		}
+		if segment.Map != nil {
+			initSection := *segment.Map
+			initSection.URI = appendQuery(initSection.URI, rawQuery)
+			segment.Map = &initSection
+		}
+		appendPartsQuery(segment.Parts, rawQuery)
+	}
+	appendPartsQuery(p.PendingParts, rawQuery)
+	if p.PreloadHint != nil {
+		hint := *p.PreloadHint
+		hint.URI = appendQuery(hint.URI, rawQuery)
+		p.PreloadHint = &hint
+	}
+}
+
+// RewriteURIs replaces the URI of every variant, I-frame playlist and rendition
+func (p *MasterPlaylist) RewriteURIs(rewrite func(uri string) string) {
+	for i := range p.Variants {
+		p.Variants[i].URI = rewrite(p.Variants[i].URI)
+	}
+	for i := range p.IFrameVariants {
+		p.IFrameVariants[i].URI = rewrite(p.IFrameVariants[i].URI)
+	}
+	for i := range p.Media {
+		if p.Media[i].URI != "" {
+			p.Media[i].URI = rewrite(p.Media[i].URI)
+		}
+	}
+}
+
+// RewriteURIs replaces the URI of every segment, part, initialization section
+// and preload hint. Key URIs are left alone, as keys are not served with the media.
+func (p *MediaPlaylist) RewriteURIs(rewrite func(uri string) string) {
+	for i := range p.Segments {
+		segment := &p.Segments[i]
+		segment.URI = rewrite(segment.URI)
+		if segment.Map != nil {
+			initSection := *segment.Map
+			initSection.URI = rewrite(initSection.URI)
+			segment.Map = &initSection
+		}
+		for j := range segment.Parts {
+			segment.Parts[j].URI = rewrite(segment.Parts[j].URI)
+		}
+	}
+	for i := range p.PendingParts {
+		p.PendingParts[i].URI = rewrite(p.PendingParts[i].URI)
+	}
+	if p.PreloadHint != nil {
+		hint := *p.PreloadHint
+		hint.URI = rewrite(hint.URI)
+		p.PreloadHint = &hint
+	}
+}
+
+func appendPartsQuery(parts []Part, rawQuery string) {
+	for i := range parts {
+		parts[i].URI = appendQuery(parts[i].URI, rawQuery)
+	}
+}
+
+// appendQuery adds a query string to a URI. Empty URIs and inline data URIs are left alone.
+func appendQuery(uri, rawQuery string) string {
+	switch {
+	case rawQuery == "" || uri == "" || strings.HasPrefix(uri, "data:"):
+		return uri
+	case strings.Contains(uri, "?"):
+		return uri + "&" + rawQuery
+	default:
+		return uri + "?" + rawQuery
+	}
+}
+
+// Duration returns the total duration of the segments in seconds
+func (p *MediaPlaylist) Duration() float64 {
+	total := 0.0
+	for _, segment := range p.Segments {
+		total += segment.Duration
+	}
+	return total
+}
@@ -0,0 +1,235 @@
+package m3u8
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The playlists in testdata are written the way Encode writes them, so parsing
+// and encoding one must give back the same bytes
+var goldenFiles = []struct {
+	name   string
+	master bool
+}{
+	{"master.m3u8", true},
+	{"vod.m3u8", false},
+	{"dvr.m3u8", false},
+	{"llhls.m3u8", false},
+}
+
+func readGolden(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, golden := range goldenFiles {
+		t.Run(golden.name, func(t *testing.T) {
+			data := readGolden(t, golden.name)
+
+			var encoded []byte
+			if golden.master {
+				playlist, err := ParseMaster(data)
+				if err != nil {
+					t.Fatalf("ParseMaster: %v", err)
+				}
+				encoded = playlist.Encode()
+			} else {
+				playlist, err := ParseMedia(data)
+				if err != nil {
+					t.Fatalf("ParseMedia: %v", err)
+				}
+				encoded = playlist.Encode()
+			}
+
+			if !bytes.Equal(encoded, data) {
+				t.Errorf("round trip changed the playlist\n--- got\n%s\n--- want\n%s", encoded, data)
+			}
+		})
+	}
+}
+
+func TestParseMasterGolden(t *testing.T) {
+	playlist, err := ParseMaster(readGolden(t, "master.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if playlist.Version != 6 || !playlist.IndependentSegments {
+		t.Errorf("version %d, independent segments %v", playlist.Version, playlist.IndependentSegments)
+	}
+	if playlist.Start == nil || playlist.Start.TimeOffset != -12 || !playlist.Start.Precise {
+		t.Errorf("start = %+v", playlist.Start)
+	}
+	if len(playlist.Tags) != 1 {
+		t.Errorf("unknown tags = %q, want the session data", playlist.Tags)
+	}
+	if len(playlist.Media) != 3 || playlist.Media[0].Type != MediaTypeAudio || playlist.Media[2].InstreamID != "CC1" {
+		t.Errorf("media = %+v", playlist.Media)
+	}
+	if len(playlist.Variants) != 2 {
+		t.Fatalf("got %d variants, want 2", len(playlist.Variants))
+	}
+	top := playlist.Variants[0]
+	if top.URI != "1080p/playlist.m3u8" || top.Bandwidth != 5500000 || top.AverageBandwidth != 5000000 ||
+		top.Resolution != "1920x1080" || top.FrameRate != 30 || top.Audio != "aac" || top.ClosedCaptions != "cc" {
+		t.Errorf("top variant = %+v", top)
+	}
+	if playlist.Variants[1].ClosedCaptions != "NONE" {
+		t.Errorf("closed captions = %q, want NONE", playlist.Variants[1].ClosedCaptions)
+	}
+	if len(playlist.IFrameVariants) != 1 || playlist.IFrameVariants[0].URI != "1080p/iframes.m3u8" {
+		t.Errorf("I-frame variants = %+v", playlist.IFrameVariants)
+	}
+}
+
+func TestParseVODGolden(t *testing.T) {
+	playlist, err := ParseMedia(readGolden(t, "vod.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if playlist.PlaylistType != PlaylistTypeVOD || !playlist.Ended {
+		t.Errorf("playlist type %q, ended %v", playlist.PlaylistType, playlist.Ended)
+	}
+	if len(playlist.Segments) != 4 {
+		t.Fatalf("got %d segments, want 4", len(playlist.Segments))
+	}
+	if playlist.Segments[1].Title != "Intro" {
+		t.Errorf("title = %q", playlist.Segments[1].Title)
+	}
+
+	split := playlist.Segments[2]
+	if !split.Discontinuity || split.ByteRange != "1000@720" {
+		t.Errorf("discontinuity %v, byte range %q", split.Discontinuity, split.ByteRange)
+	}
+	if split.Key == nil || split.Key.Method != "AES-128" || split.Key.URI != "/api/keys/k1" {
+		t.Errorf("key = %+v", split.Key)
+	}
+	if split.Map == nil || split.Map.URI != "init-2.mp4" || split.Map.ByteRange != "720@0" {
+		t.Errorf("map = %+v", split.Map)
+	}
+	if !playlist.Segments[3].Gap || playlist.Segments[3].Key.Method != "NONE" {
+		t.Errorf("last segment = %+v", playlist.Segments[3])
+	}
+
+	// Segments continue from the last date-time they follow
+	times := playlist.ProgramDateTimes()
+	start := time.Date(2026, 1, 1, 20, 15, 0, 0, time.UTC)
+	if !times[1].Equal(start.Add(4 * time.Second)) {
+		t.Errorf("second segment at %v", times[1])
+	}
+	if !times[3].Equal(start.Add(5*time.Minute + 500*time.Millisecond + 3966667*time.Microsecond)) {
+		t.Errorf("last segment at %v", times[3])
+	}
+	if got := playlist.Duration(); got < 15.96 || got > 15.97 {
+		t.Errorf("duration = %v", got)
+	}
+}
+
+func TestParseDVRGolden(t *testing.T) {
+	playlist, err := ParseMedia(readGolden(t, "dvr.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if playlist.MediaSequence != 1042 || playlist.DiscontinuitySequence != 3 || playlist.Ended {
+		t.Errorf("media sequence %d, discontinuity sequence %d, ended %v",
+			playlist.MediaSequence, playlist.DiscontinuitySequence, playlist.Ended)
+	}
+	if len(playlist.Tags) != 1 || playlist.Tags[0] != "#EXT-X-ALLOW-CACHE:NO" {
+		t.Errorf("playlist tags = %q", playlist.Tags)
+	}
+
+	out := playlist.Segments[1]
+	if len(out.DateRanges) != 1 || len(out.Tags) != 1 || out.Tags[0] != "#EXT-X-CUE-OUT:30.000" {
+		t.Fatalf("ad break segment = %+v", out)
+	}
+	adBreak := out.DateRanges[0]
+	if adBreak.ID != "ad-1" || adBreak.Class != "com.streamforge.ad" || adBreak.SCTE35Out != "0xFC302000" ||
+		adBreak.PlannedDuration == nil || *adBreak.PlannedDuration != 30 || adBreak.Duration != nil {
+		t.Errorf("ad break = %+v", adBreak)
+	}
+	if len(adBreak.ClientAttributes) != 1 || adBreak.ClientAttributes[0] != (Attribute{Name: "X-AD-ID", Value: `"spot-7"`}) {
+		t.Errorf("client attributes = %+v", adBreak.ClientAttributes)
+	}
+
+	back := playlist.Segments[2]
+	if !back.Discontinuity || len(back.DateRanges) != 2 {
+		t.Fatalf("return segment = %+v", back)
+	}
+	if end := back.DateRanges[0]; end.Duration == nil || *end.Duration != 30 || end.SCTE35In != "0xFC302001" || end.EndDate.IsZero() {
+		t.Errorf("ad break end = %+v", end)
+	}
+	if !back.DateRanges[1].EndOnNext {
+		t.Errorf("chapter = %+v", back.DateRanges[1])
+	}
+
+	// Sliding past the discontinuity advances the discontinuity sequence
+	playlist.TrimFront(3)
+	if playlist.MediaSequence != 1045 || playlist.DiscontinuitySequence != 4 || len(playlist.Segments) != 0 {
+		t.Errorf("after trimming: media sequence %d, discontinuity sequence %d, %d segments",
+			playlist.MediaSequence, playlist.DiscontinuitySequence, len(playlist.Segments))
+	}
+}
+
+func TestParseLowLatencyGolden(t *testing.T) {
+	playlist, err := ParseMedia(readGolden(t, "llhls.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	control := playlist.ServerControl
+	if control == nil || !control.CanBlockReload || control.CanSkipUntil != 24 || control.PartHoldBack != 1.002 || control.HoldBack != 0 {
+		t.Errorf("server control = %+v", control)
+	}
+	if playlist.PartTarget != 0.334 {
+		t.Errorf("part target = %v", playlist.PartTarget)
+	}
+	if len(playlist.Segments) != 2 {
+		t.Fatalf("got %d segments, want 2", len(playlist.Segments))
+	}
+
+	parts := playlist.Segments[1].Parts
+	if len(parts) != 3 || !parts[0].Independent || parts[1].Independent || !parts[2].Gap {
+		t.Errorf("parts = %+v", parts)
+	}
+	if len(playlist.PendingParts) != 2 || playlist.PendingParts[1].ByteRange != "9000@0" {
+		t.Errorf("pending parts = %+v", playlist.PendingParts)
+	}
+	if hint := playlist.PreloadHint; hint == nil || hint.Type != "PART" || hint.URI != "segment268.m4s" || hint.ByteRangeStart != 9000 {
+		t.Errorf("preload hint = %+v", hint)
+	}
+	if len(playlist.Trailer) != 1 {
+		t.Errorf("trailer = %q, want the rendition report", playlist.Trailer)
+	}
+	if playlist.Ended {
+		t.Error("live playlist parsed as ended")
+	}
+
+	// Ending the playlist is a single field
+	playlist.Ended = true
+	if !bytes.HasSuffix(playlist.Encode(), []byte("\n#EXT-X-ENDLIST\n")) {
+		t.Error("ended playlist does not end with EXT-X-ENDLIST")
+	}
+}
+
+func TestParseWrongPlaylistType(t *testing.T) {
+	if _, err := ParseMedia(readGolden(t, "master.m3u8")); !errors.Is(err, ErrMasterPlaylist) {
+		t.Errorf("ParseMedia(master) error = %v, want %v", err, ErrMasterPlaylist)
+	}
+	if _, err := ParseMaster(readGolden(t, "vod.m3u8")); !errors.Is(err, ErrMediaPlaylist) {
+		t.Errorf("ParseMaster(media) error = %v, want %v", err, ErrMediaPlaylist)
+	}
+	if _, err := ParseMedia([]byte("#EXTINF:2.0,\nsegment.ts\n")); !errors.Is(err, ErrNotPlaylist) {
+		t.Errorf("ParseMedia(no header) error = %v, want %v", err, ErrNotPlaylist)
+	}
+}
@@ -8,7 +8,7 @@ import (
 
 // User represents a user in the system
 type User struct {
-	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
 	Username  string    `json:"username" gorm:"unique;not null"`
 	Email     string    `json:"email" gorm:"unique;not null"`
 	Password  string    `json:"-" gorm:"not null"`
@@ -21,7 +21,7 @@ type User struct {
 
 // Stream represents a live stream
 type Stream struct {
-	ID          uuid.UUID    `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ID          uuid.UUID    `json:"id" gorm:"type:uuid;primary_key"`
 	UserID      uuid.UUID    `json:"user_id" gorm:"type:uuid;not null"`
 	Title       string       `json:"title" gorm:"not null"`
 	Description string       `json:"description"`
@@ -35,12 +35,28 @@ type Stream struct {
 	UpdatedAt   time.Time    `json:"updated_at"`
 
 	// Relationships
-	User User `json:"user" gorm:"foreignKey:UserID"`
+	User        User         `json:"user" gorm:"foreignKey:UserID"`
+	PlaybackIDs []PlaybackID `json:"playback_ids,omitempty" gorm:"foreignKey:StreamID"`
+}
+
+// PlaybackID is a public identifier viewers play a stream by, so the secret
+// stream key never appears in playback URLs. A stream's output is published
+// under its oldest playback ID and every other one resolves to it.
+type PlaybackID struct {
+	ID         uuid.UUID      `json:"id" gorm:"type:uuid;primary_key"`
+	StreamID   uuid.UUID      `json:"stream_id" gorm:"type:uuid;index;not null"`
+	PlaybackID string         `json:"playback_id" gorm:"unique;not null"`
+	Policy     PlaybackPolicy `json:"policy" gorm:"default:'public'"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+
+	// Relationships
+	Stream *Stream `json:"-" gorm:"foreignKey:StreamID"`
 }
 
 // StreamSession represents a streaming session
 type StreamSession struct {
-	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
 	StreamID  uuid.UUID  `json:"stream_id" gorm:"type:uuid;not null"`
 	Quality   string     `json:"quality"`
 	Bitrate   int        `json:"bitrate"`
@@ -55,7 +71,7 @@ type StreamSession struct {
 
 // Viewer represents a viewer watching a stream
 type Viewer struct {
-	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
 	StreamID   uuid.UUID  `json:"stream_id" gorm:"type:uuid;not null"`
 	UserID     *uuid.UUID `json:"user_id" gorm:"type:uuid"` // nullable for anonymous viewers
 	IPAddress  string     `json:"ip_address"`
@@ -73,7 +89,7 @@ type Viewer struct {
 
 // StreamAnalytics represents analytics data for a stream
 type StreamAnalytics struct {
-	ID              uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ID              uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
 	StreamID        uuid.UUID `json:"stream_id" gorm:"type:uuid;not null"`
 	Date            time.Time `json:"date" gorm:"type:date;not null"`
 	TotalViewers    int       `json:"total_viewers"`
@@ -89,7 +105,7 @@ type StreamAnalytics struct {
 
 // Notification represents a notification
 type Notification struct {
-	ID        uuid.UUID        `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ID        uuid.UUID        `json:"id" gorm:"type:uuid;primary_key"`
 	UserID    uuid.UUID        `json:"user_id" gorm:"type:uuid;not null"`
 	Type      NotificationType `json:"type" gorm:"not null"`
 	Title     string           `json:"title" gorm:"not null"`
@@ -103,6 +119,169 @@ type Notification struct {
 	User User `json:"user" gorm:"foreignKey:UserID"`
 }
 
+// StreamSettings holds per-stream options keyed by stream key
+type StreamSettings struct {
+	ID              uuid.UUID        `json:"id" gorm:"type:uuid;primary_key"`
+	StreamKey       string           `json:"stream_key" gorm:"unique;not null"`
+	RecordingMode   RecordingMode    `json:"recording_mode" gorm:"default:'off'"`
+	DVRWindow       int              `json:"dvr_window_seconds" gorm:"default:0"`  // in seconds, 0 disables DVR
+	SlateGrace      int              `json:"slate_grace_seconds" gorm:"default:0"` // in seconds, 0 ends the stream when the publisher drops
+	SlateURL        string           `json:"slate_url"`                            // image or clip shown while the publisher is away, empty for the built-in slate
+	Encoder         EncoderOverrides `json:"encoder" gorm:"embedded;embeddedPrefix:encoder_"`
+	Encryption      EncryptionMethod `json:"encryption" gorm:"default:'none'"`
+	KeyRotation     int              `json:"key_rotation_segments" gorm:"default:0"` // segments per content key, 0 keeps one key per session
+	DeliveryTier    string           `json:"delivery_tier"`                          // rate limit tier of the HLS servers, empty for the default tier
+	PlaylistBaseURL string           `json:"playlist_base_url"`                      // base URL variant playlist URIs are rewritten onto, empty for the HLS server's own
+	SegmentBaseURL  string           `json:"segment_base_url"`                       // base URL segment URIs are rewritten onto, empty for the HLS server's own
+	PropagateQuery  []string         `json:"propagate_query" gorm:"serializer:json"` // query parameters of playlist requests carried over to segment URIs
+	CreatedAt       time.Time        `json:"created_at"`
+	UpdatedAt       time.Time        `json:"updated_at"`
+}
+
+// EncryptionKey is a content key of an encrypted stream. Its ID is part of the
+// key URI in the playlists; the key itself is stored sealed with the key
+// encryption secret and only released by the key server.
+type EncryptionKey struct {
+	ID        uuid.UUID        `json:"id" gorm:"type:uuid;primary_key"`
+	StreamKey string           `json:"-" gorm:"index;not null"`
+	Method    EncryptionMethod `json:"method" gorm:"not null"`
+	SealedKey []byte           `json:"-" gorm:"not null"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// StreamAccessRules restricts where a stream may be played, by the viewer's
+// network and country. Deny rules win over allow rules, and a stream with
+// allow rules is only played where one of them matches. The rules are
+// published to the stream's directory and enforced by the HLS servers.
+type StreamAccessRules struct {
+	ID             uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	StreamKey      string    `json:"stream_key" gorm:"unique;not null"`
+	AllowCIDRs     []string  `json:"allow_cidrs" gorm:"column:allow_cidrs;serializer:json"`
+	DenyCIDRs      []string  `json:"deny_cidrs" gorm:"column:deny_cidrs;serializer:json"`
+	AllowCountries []string  `json:"allow_countries" gorm:"serializer:json"` // ISO 3166-1 alpha-2 codes
+	DenyCountries  []string  `json:"deny_countries" gorm:"serializer:json"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// EncoderOverrides tunes the encoder of a stream. Empty fields keep the transcoder defaults.
+type EncoderOverrides struct {
+	Preset          string  `json:"preset,omitempty"`           // x264 preset, e.g. veryfast
+	Tune            string  `json:"tune,omitempty"`             // x264 tune, or "none" to disable the default zerolatency
+	GOPSeconds      float64 `json:"gop_seconds,omitempty"`      // keyframe interval, must divide the segment duration
+	BFrames         *int    `json:"b_frames,omitempty"`         // consecutive B-frames
+	SegmentDuration int     `json:"segment_duration,omitempty"` // target segment length in seconds
+	PlaylistSize    int     `json:"playlist_size,omitempty"`    // segments in the live playlist
+}
+
+// Recording represents an archived rendition of a live stream session
+type Recording struct {
+	ID              uuid.UUID       `json:"id" gorm:"type:uuid;primary_key"`
+	StreamKey       string          `json:"-" gorm:"index;not null"`                  // secret, never returned by the API
+	StreamSessionID *uuid.UUID      `json:"stream_session_id" gorm:"type:uuid;index"` // nullable for unregistered stream keys
+	Rendition       string          `json:"rendition" gorm:"not null"`
+	Status          RecordingStatus `json:"status" gorm:"default:'recording'"`
+	PlaylistPath    string          `json:"-"`
+	MP4Path         string          `json:"-"`
+	SegmentCount    int             `json:"segment_count"`
+	Duration        float64         `json:"duration"` // in seconds
+	SizeBytes       int64           `json:"size_bytes"`
+	Error           string          `json:"error,omitempty"`
+	StartedAt       time.Time       `json:"started_at"`
+	EndedAt         *time.Time      `json:"ended_at"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+
+	// Relationships
+	StreamSession *StreamSession `json:"stream_session,omitempty" gorm:"foreignKey:StreamSessionID"`
+}
+
+// Clip represents a short excerpt cut from the on-disk segments of a live stream
+type Clip struct {
+	ID              uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	StreamKey       string     `json:"-" gorm:"index;not null"`                  // secret, never returned by the API
+	StreamSessionID *uuid.UUID `json:"stream_session_id" gorm:"type:uuid;index"` // nullable when the stream is not live
+	Title           string     `json:"title" gorm:"not null"`
+	Rendition       string     `json:"rendition" gorm:"not null"`
+	Status          ClipStatus `json:"status" gorm:"default:'processing'"`
+	PlaylistPath    string     `json:"-"`
+	MP4Path         string     `json:"-"`
+	SegmentCount    int        `json:"segment_count"`
+	Duration        float64    `json:"duration"` // in seconds
+	SizeBytes       int64      `json:"size_bytes"`
+	Error           string     `json:"error,omitempty"`
+	StartTime       *time.Time `json:"start_time"` // program date-time of the first segment
+	EndTime         *time.Time `json:"end_time"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// RestreamTarget is an external RTMP/SRT destination a stream is relayed to while live
+type RestreamTarget struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	StreamKey string    `json:"stream_key" gorm:"index;not null"`
+	Name      string    `json:"name" gorm:"not null"`
+	URL       string    `json:"url" gorm:"not null"`
+	Key       string    `json:"-"` // the destination's stream key, never returned by the API
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// StreamOverlay is a logo, text or clock burned into selected renditions of a stream
+type StreamOverlay struct {
+	ID         uuid.UUID       `json:"id" gorm:"type:uuid;primary_key"`
+	StreamKey  string          `json:"stream_key" gorm:"index;not null"`
+	Name       string          `json:"name" gorm:"not null"`
+	Type       OverlayType     `json:"type" gorm:"not null"`
+	ImageURL   string          `json:"image_url,omitempty"` // file path or http(s) URL, for image overlays
+	Text       string          `json:"text,omitempty"`      // literal text, or strftime format for clocks
+	Position   OverlayPosition `json:"position"`
+	Margin     int             `json:"margin"`    // in pixels at 1080p
+	Opacity    float64         `json:"opacity"`   // 0 to 1
+	Scale      float64         `json:"scale"`     // image width as a fraction of the video width
+	FontSize   int             `json:"font_size"` // in pixels at 1080p
+	FontColor  string          `json:"font_color"`
+	Renditions string          `json:"renditions"` // comma-separated rendition names, empty for all
+	Enabled    bool            `json:"enabled"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// AdCue is an ad break signalled in the playlists of a stream. The cue-out is
+// placed on the first segment boundary at or after StartTime and the cue-in on
+// the first boundary after Duration has elapsed.
+type AdCue struct {
+	ID              uuid.UUID   `json:"id" gorm:"type:uuid;primary_key"`
+	StreamKey       string      `json:"stream_key" gorm:"index;not null"`
+	StreamSessionID *uuid.UUID  `json:"stream_session_id" gorm:"type:uuid;index"`
+	EventID         uint32      `json:"event_id"`         // SCTE-35 splice_event_id
+	StartTime       time.Time   `json:"start_time"`       // requested cue-out time
+	PlannedDuration float64     `json:"planned_duration"` // in seconds
+	Duration        float64     `json:"duration"`         // shorter than planned when ended early
+	Status          AdCueStatus `json:"status"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+}
+
+// PlaybackEvent is a quality-of-experience event reported by a viewer's player.
+// Events are grouped by the player's session and aggregated into QoE reports
+// per stream.
+type PlaybackEvent struct {
+	ID         uuid.UUID         `json:"id" gorm:"type:uuid;primary_key"`
+	StreamKey  string            `json:"-" gorm:"index:idx_playback_events_stream_time;not null"`
+	PlaybackID string            `json:"playback_id"`
+	SessionID  string            `json:"session_id" gorm:"index;not null"`
+	Type       PlaybackEventType `json:"type" gorm:"not null"`
+	Time       time.Time         `json:"time" gorm:"index:idx_playback_events_stream_time;not null"`
+	StartupMS  int               `json:"startup_ms,omitempty"` // time to first frame, for play events
+	Rendition  string            `json:"rendition,omitempty"`
+	Bitrate    int               `json:"bitrate,omitempty"` // bits per second of the rendition playing
+	Error      string            `json:"error,omitempty"`
+	Fatal      bool              `json:"fatal,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
 // StreamStatus represents the status of a stream
 type StreamStatus string
 
@@ -112,6 +291,96 @@ const (
 	StreamStatusPrivate StreamStatus = "private"
 )
 
+// PlaybackPolicy controls who may play a stream by one of its playback IDs
+type PlaybackPolicy string
+
+const (
+	PlaybackPolicyPublic PlaybackPolicy = "public"
+	PlaybackPolicySigned PlaybackPolicy = "signed"
+)
+
+// PlaybackEventType is what happened in a player
+type PlaybackEventType string
+
+const (
+	PlaybackEventPlay            PlaybackEventType = "play"
+	PlaybackEventStallStart      PlaybackEventType = "stall_start"
+	PlaybackEventStallEnd        PlaybackEventType = "stall_end"
+	PlaybackEventRenditionSwitch PlaybackEventType = "rendition_switch"
+	PlaybackEventError           PlaybackEventType = "error"
+	PlaybackEventHeartbeat       PlaybackEventType = "heartbeat"
+	PlaybackEventEnd             PlaybackEventType = "end"
+)
+
+// RecordingMode controls which renditions of a stream are archived
+type RecordingMode string
+
+const (
+	RecordingModeOff RecordingMode = "off"
+	RecordingModeTop RecordingMode = "top"
+	RecordingModeAll RecordingMode = "all"
+)
+
+// EncryptionMethod is how the segments of a stream are encrypted
+type EncryptionMethod string
+
+const (
+	EncryptionNone   EncryptionMethod = "none"
+	EncryptionAES128 EncryptionMethod = "AES-128"
+	// EncryptionSampleAES is recognised but not produced: the FFmpeg HLS muxer only
+	// encrypts whole segments
+	EncryptionSampleAES EncryptionMethod = "SAMPLE-AES"
+)
+
+// RecordingStatus represents the lifecycle state of a recording
+type RecordingStatus string
+
+const (
+	RecordingStatusRecording  RecordingStatus = "recording"
+	RecordingStatusProcessing RecordingStatus = "processing"
+	RecordingStatusReady      RecordingStatus = "ready"
+	RecordingStatusFailed     RecordingStatus = "failed"
+)
+
+// OverlayType represents the kind of a stream overlay
+type OverlayType string
+
+const (
+	OverlayTypeImage OverlayType = "image"
+	OverlayTypeText  OverlayType = "text"
+	OverlayTypeClock OverlayType = "clock"
+)
+
+// OverlayPosition represents where an overlay is anchored on the video
+type OverlayPosition string
+
+const (
+	OverlayPositionTopLeft     OverlayPosition = "top-left"
+	OverlayPositionTopRight    OverlayPosition = "top-right"
+	OverlayPositionBottomLeft  OverlayPosition = "bottom-left"
+	OverlayPositionBottomRight OverlayPosition = "bottom-right"
+	OverlayPositionCenter      OverlayPosition = "center"
+)
+
+// ClipStatus represents the lifecycle state of a clip
+type ClipStatus string
+
+const (
+	ClipStatusProcessing ClipStatus = "processing"
+	ClipStatusReady      ClipStatus = "ready"
+	ClipStatusFailed     ClipStatus = "failed"
+)
+
+// AdCueStatus represents the lifecycle state of an ad cue
+type AdCueStatus string
+
+const (
+	AdCueStatusScheduled AdCueStatus = "scheduled"
+	AdCueStatusActive    AdCueStatus = "active"
+	AdCueStatusCompleted AdCueStatus = "completed"
+	AdCueStatusCancelled AdCueStatus = "cancelled"
+)
+
 // NotificationType represents the type of notification
 type NotificationType string
 
@@ -138,6 +407,7 @@ const (
 	EventViewerJoined       = "viewer_joined"
 	EventViewerLeft         = "viewer_left"
 	EventViewerCountUpdated = "viewer_count_updated"
+	EventStreamStateChanged = "stream_state_changed"
 )
 
 // APIResponse represents a standard API response
@@ -0,0 +1,170 @@
+package playback
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// IndexFile is the file in the root of the HLS directory that maps playback
+	// IDs to the directories their streams are published in
+	IndexFile = ".playback_ids"
+	// idLength is the number of characters in a playback ID
+	idLength = 24
+	// idAlphabet is what playback IDs are made of, safe in URLs and file names
+	idAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	// indexRefreshInterval is how often resolvers check whether the index changed
+	indexRefreshInterval = time.Second
+)
+
+// NewID returns a random playback ID
+func NewID() (string, error) {
+	buf := make([]byte, idLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate playback ID: %w", err)
+	}
+	// 256 is not a multiple of the alphabet size; the slight bias does not
+	// make IDs guessable
+	for i, b := range buf {
+		buf[i] = idAlphabet[int(b)%len(idAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// Target is where a playback ID leads
+type Target struct {
+	// Directory is the stream's directory in the HLS directory
+	Directory string `json:"directory"`
+	// Signed is set when the playback ID is only served with a token
+	Signed bool `json:"signed"`
+}
+
+// Index maps playback IDs to their targets. It never contains stream keys, as
+// it is kept next to the files it describes.
+type Index map[string]Target
+
+// ReadIndex reads the playback ID index of an HLS directory. A missing index is empty.
+func ReadIndex(hlsDir string) (Index, error) {
+	data, err := os.ReadFile(filepath.Join(hlsDir, IndexFile))
+	if os.IsNotExist(err) {
+		return Index{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	index := Index{}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid playback ID index: %w", err)
+	}
+	return index, nil
+}
+
+// Resolver resolves the first path element of HLS requests using the playback
+// ID index, re-reading it when it changes. Changes are picked up within
+// indexRefreshInterval, so resolving does not stat the index on every request.
+type Resolver struct {
+	hlsDir string
+
+	mutex     sync.Mutex // serializes reloads
+	snapshot  atomic.Pointer[resolverSnapshot]
+	modTime   time.Time
+	checkedAt atomic.Int64 // unix nanoseconds of the last modification check
+}
+
+// resolverSnapshot is an index as read, never modified once published
+type resolverSnapshot struct {
+	index Index
+	dirs  map[string]bool // directories of indexed streams
+}
+
+// NewResolver returns a resolver for the index of an HLS directory
+func NewResolver(hlsDir string) *Resolver {
+	r := &Resolver{hlsDir: hlsDir}
+	r.snapshot.Store(&resolverSnapshot{index: Index{}, dirs: map[string]bool{}})
+	return r
+}
+
+// Resolve returns the target of a name from a request path. Playback IDs lead
+// to their stream's directory. Any other name is a directory of its own, as
+// written for unregistered streams, unless it belongs to an indexed stream:
+// those are only reachable by playback ID, so ok is false.
+func (r *Resolver) Resolve(name string) (target Target, ok bool) {
+	snapshot := r.current()
+	if target, found := snapshot.index[name]; found {
+		return target, true
+	}
+	if snapshot.dirs[name] {
+		return Target{}, false
+	}
+	return Target{Directory: name}, true
+}
+
+// Lookup returns the target of a playback ID, and false for names that are not one
+func (r *Resolver) Lookup(playbackID string) (Target, bool) {
+	target, found := r.current().index[playbackID]
+	return target, found
+}
+
+// PlaybackIDs returns the playback IDs that lead to a directory, sorted
+func (r *Resolver) PlaybackIDs(directory string) []string {
+	var ids []string
+	for id, target := range r.current().index {
+		if target.Directory == directory {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Invalidate makes the next resolve check the index, for the process that just
+// wrote it
+func (r *Resolver) Invalidate() {
+	r.checkedAt.Store(0)
+}
+
+// current returns the index, reloading it first if it is due for a check
+func (r *Resolver) current() *resolverSnapshot {
+	r.refresh()
+	return r.snapshot.Load()
+}
+
+// refresh reloads the index when its modification time changed. A broken index
+// keeps the previous one in use.
+func (r *Resolver) refresh() {
+	now := time.Now().UnixNano()
+	checkedAt := r.checkedAt.Load()
+	if now-checkedAt < int64(indexRefreshInterval) || !r.checkedAt.CompareAndSwap(checkedAt, now) {
+		return
+	}
+
+	info, err := os.Stat(filepath.Join(r.hlsDir, IndexFile))
+	var modTime time.Time
+	if err == nil {
+		modTime = info.ModTime()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if modTime.Equal(r.modTime) {
+		return
+	}
+
+	index, err := ReadIndex(r.hlsDir)
+	if err != nil {
+		return
+	}
+	dirs := make(map[string]bool, len(index))
+	for _, target := range index {
+		dirs[target.Directory] = true
+	}
+	r.modTime = modTime
+	r.snapshot.Store(&resolverSnapshot{index: index, dirs: dirs})
+}
@@ -0,0 +1,182 @@
+// Package playback issues and checks the signed tokens that gate HLS delivery,
+// and resolves the public playback IDs streams are played by.
+// A token is an HS256 JWT scoped to one playback ID, with an expiry and optionally
+// bound to the viewer's IP address. It travels in the token query parameter and
+// is appended to every URI of the playlists it unlocks.
+package playback
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/streamforge/platform/pkg/m3u8"
+)
+
+const (
+	// TokenParam is the query parameter that carries a playback token
+	TokenParam = "token"
+	// audience keeps playback tokens and user tokens from being mistaken for each other
+	audience = "streamforge-playback"
+)
+
+var (
+	// ErrTokenRequired is returned when a stream needs a token and the request has none
+	ErrTokenRequired = errors.New("playback token required")
+	// ErrTokensDisabled is returned when a stream needs a token but no signing secret is configured
+	ErrTokensDisabled = errors.New("playback tokens are not configured")
+	// ErrTokenInvalid is returned for tokens that are malformed or not signed with the secret
+	ErrTokenInvalid = errors.New("invalid playback token")
+	// ErrTokenExpired is returned for tokens past their expiry
+	ErrTokenExpired = errors.New("playback token expired")
+	// ErrTokenScope is returned for tokens issued for another stream
+	ErrTokenScope = errors.New("playback token is not valid for this stream")
+	// ErrTokenIP is returned for tokens bound to another IP address
+	ErrTokenIP = errors.New("playback token is bound to another IP address")
+)
+
+// Claims are the contents of a playback token. The subject is the playback ID,
+// or for unregistered streams the stream, it unlocks.
+type Claims struct {
+	IP string `json:"ip,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Signer issues and verifies playback tokens with a shared secret
+type Signer struct {
+	secret []byte
+}
+
+// NewSigner returns a signer for the secret, or nil when the secret is empty
+func NewSigner(secret string) *Signer {
+	if secret == "" {
+		return nil
+	}
+	return &Signer{secret: []byte(secret)}
+}
+
+// Issue signs a token for a stream that expires after ttl. A non-empty ip binds
+// the token to that client address.
+func (s *Signer) Issue(stream string, ttl time.Duration, ip string) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(ttl).Truncate(time.Second)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		IP: ip,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   stream,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+	})
+	signed, err := token.SignedString(s.secret)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign playback token: %w", err)
+	}
+	return signed, expires, nil
+}
+
+// Verify checks that a token is signed with the secret, has not expired, is
+// scoped to the stream and, when bound, presented from its IP address
+func (s *Signer) Verify(token, stream, clientIP string) (*Claims, error) {
+	claims, err := s.Parse(token, clientIP)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject != stream {
+		return nil, ErrTokenScope
+	}
+	return claims, nil
+}
+
+// Parse checks a token like Verify, whatever it is scoped to, and returns its
+// claims. Callers check the subject themselves.
+func (s *Signer) Parse(token, clientIP string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return s.secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithAudience(audience),
+	)
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return nil, ErrTokenExpired
+	case err != nil || claims.ExpiresAt == nil:
+		return nil, ErrTokenInvalid
+	case claims.IP != "" && claims.IP != clientIP:
+		return nil, ErrTokenIP
+	}
+	return claims, nil
+}
+
+// Authorize decides whether a request for a stream may be served. Requests
+// without a token pass unless the stream requires one; a token that is
+// presented must be valid. signer may be nil when tokens are not configured.
+func Authorize(signer *Signer, token, stream, clientIP string, required bool) error {
+	switch {
+	case token == "" && required:
+		return ErrTokenRequired
+	case token == "":
+		return nil
+	case signer == nil && required:
+		return ErrTokensDisabled
+	case signer == nil:
+		return nil
+	}
+	_, err := signer.Verify(token, stream, clientIP)
+	return err
+}
+
+// StatusCode returns the HTTP status for an error from Authorize
+func StatusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrTokenRequired):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrTokensDisabled):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusForbidden
+	}
+}
+
+// TokenQuery returns the query string that carries a token
+func TokenQuery(token string) string {
+	return url.Values{TokenParam: {token}}.Encode()
+}
+
+// TokenizePlaylist appends a token to every URI of a master or media playlist so
+// players request the child playlists and segments with it. Data that is not a
+// playlist is returned unchanged.
+func TokenizePlaylist(data []byte, token string) []byte {
+	if master, err := m3u8.ParseMaster(data); err == nil {
+		master.AppendQuery(TokenQuery(token))
+		return master.Encode()
+	}
+	if media, err := m3u8.ParseMedia(data); err == nil {
+		media.AppendQuery(TokenQuery(token))
+		return media.Encode()
+	}
+	return data
+}
+
+// TokenizeThumbnails appends a token to the sprite sheet URIs of a WebVTT
+// thumbnail track, placing it before the #xywh fragment
+func TokenizeThumbnails(data []byte, token string) []byte {
+	query := TokenQuery(token)
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		if fragment := strings.Index(line, "#xywh="); fragment > 0 {
+			separator := "?"
+			if strings.Contains(line[:fragment], "?") {
+				separator = "&"
+			}
+			lines[i] = line[:fragment] + separator + query + line[fragment:]
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
@@ -40,10 +40,11 @@ type StreamData struct {
 }
 
 type HLSStream struct {
-	Name       string `json:"name"`
-	Status     string `json:"status"`
-	FileCount  int    `json:"file_count"`
-	LastUpdate string `json:"last_update"`
+	Name        string   `json:"name"`
+	PlaybackIDs []string `json:"playback_ids,omitempty"`
+	Status      string   `json:"status"`
+	FileCount   int      `json:"file_count"`
+	LastUpdate  string   `json:"last_update"`
 }
 
 type SystemStats struct {
@@ -84,11 +85,22 @@ type FilesData struct {
 }
 
 // AdminAPI handles all admin API operations
-type AdminAPI struct{}
+type AdminAPI struct {
+	transcoderURL string
+	// Authenticates the admin API on the transcoder's internal routes
+	internalSecret string
+}
 
 // NewAdminAPI creates a new admin API instance
 func NewAdminAPI() *AdminAPI {
-	return &AdminAPI{}
+	transcoderURL := os.Getenv("TRANSCODER_URL")
+	if transcoderURL == "" {
+		transcoderURL = "http://localhost:8083"
+	}
+	return &AdminAPI{
+		transcoderURL:  transcoderURL,
+		internalSecret: os.Getenv("INTERNAL_API_SECRET"),
+	}
 }
 
 // SetupRoutes sets up all admin API routes
@@ -214,6 +226,7 @@ func (api *AdminAPI) GetStreams(c *gin.Context) {
 
 	// Check HLS files
 	hlsDir := "/tmp/hls_shared"
+	playbackIDs := playbackIDsByDirectory(hlsDir)
 	if entries, err := os.ReadDir(hlsDir); err == nil {
 		for _, entry := range entries {
 			if entry.IsDir() {
@@ -221,8 +234,9 @@ func (api *AdminAPI) GetStreams(c *gin.Context) {
 				streamPath := filepath.Join(hlsDir, streamName)
 				
 				stream := HLSStream{
-					Name:   streamName,
-					Status: "inactive",
+					Name:        streamName,
+					PlaybackIDs: playbackIDs[streamName],
+					Status:      "inactive",
 				}
 
 				if files, err := os.ReadDir(streamPath); err == nil {
@@ -469,10 +483,11 @@ func (api *AdminAPI) getDiskInfo(dir string) (string, error) {
 	return "", fmt.Errorf("no disk info found")
 }
 
+// controlStream acts on a stream given by stream key or playback ID
 func (api *AdminAPI) controlStream(streamName, action string) APIResponse {
 	switch action {
 	case "stop":
-		cmd := exec.Command("curl", "-s", fmt.Sprintf("http://localhost:8080/control/drop/publisher?app=live&name=%s", streamName))
+		cmd := exec.Command("curl", "-s", fmt.Sprintf("http://localhost:8080/control/drop/publisher?app=live&name=%s", api.resolveStreamKey(streamName)))
 		output, err := cmd.Output()
 		if err != nil {
 			return APIResponse{
@@ -498,7 +513,7 @@ func (api *AdminAPI) controlStream(streamName, action string) APIResponse {
 			Data: map[string]string{
 				"action":   action,
 				"stream":   streamName,
-				"rtmp_url": fmt.Sprintf("rtmp://localhost:1935/live/%s", streamName),
+				"rtmp_url": fmt.Sprintf("rtmp://localhost:1935/live/%s", api.resolveStreamKey(streamName)),
 			},
 		}
 
@@ -513,7 +528,7 @@ func (api *AdminAPI) controlStream(streamName, action string) APIResponse {
 			Data: map[string]string{
 				"action":   action,
 				"stream":   streamName,
-				"rtmp_url": fmt.Sprintf("rtmp://localhost:1935/live/%s", streamName),
+				"rtmp_url": fmt.Sprintf("rtmp://localhost:1935/live/%s", api.resolveStreamKey(streamName)),
 			},
 		}
 
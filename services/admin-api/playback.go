@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// playbackIndexFile maps the playback IDs viewers use to stream directories.
+// The transcoder keeps it in the root of the HLS directory.
+const playbackIndexFile = ".playback_ids"
+
+// playbackIDsByDirectory reads the playback ID index of an HLS directory and
+// returns the playback IDs of every stream directory, sorted
+func playbackIDsByDirectory(hlsDir string) map[string][]string {
+	byDirectory := map[string][]string{}
+	data, err := os.ReadFile(filepath.Join(hlsDir, playbackIndexFile))
+	if err != nil {
+		return byDirectory
+	}
+
+	var index map[string]struct {
+		Directory string `json:"directory"`
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return byDirectory
+	}
+	for playbackID, target := range index {
+		byDirectory[target.Directory] = append(byDirectory[target.Directory], playbackID)
+	}
+	for _, ids := range byDirectory {
+		sort.Strings(ids)
+	}
+	return byDirectory
+}
+
+// resolveStreamKey asks the transcoder which stream a playback ID belongs to and
+// returns its stream key. Names that are not playback IDs are returned unchanged.
+// The stream key is only handed out on the transcoder's internal route.
+func (api *AdminAPI) resolveStreamKey(name string) string {
+	req, err := http.NewRequest(http.MethodGet, api.transcoderURL+"/internal/playback/ids/"+url.PathEscape(name), nil)
+	if err != nil {
+		return name
+	}
+	req.Header.Set("Authorization", "Bearer "+api.internalSecret)
+
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return name
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Success bool `json:"success"`
+		Data    struct {
+			StreamKey string `json:"stream_key"`
+		} `json:"data"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&body) != nil || !body.Success {
+		return name
+	}
+	return body.Data.StreamKey
+}
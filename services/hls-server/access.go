@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/streamforge/platform/pkg/access"
+)
+
+// checkAccess enforces the access rules of a stream directory, writing a 403
+// response and returning false for clients the rules turn away
+func (s *HLSServer) checkAccess(c *gin.Context, directory string) bool {
+	decision := s.access.Check(directory, c.ClientIP())
+	if decision.Allowed {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": access.ErrAccessDenied.Error(), "reason": decision.Reason})
+	return false
+}
+
+// AccessStatus reports the access rules of a stream as enforced, along with
+// the audit counters of requests allowed and denied since the server started
+func (s *HLSServer) AccessStatus(c *gin.Context) {
+	streamName := c.Param("stream")
+	directory, ok := s.streamDirectory(streamName)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+		return
+	}
+
+	rules, err := s.access.Rules(directory)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data": gin.H{
+			"stream":        streamName,
+			"rules":         rules,
+			"geoip_enabled": s.access.GeoIPEnabled(),
+			"counters":      s.access.Counters(directory),
+			"timestamp":     time.Now().Format(time.RFC3339),
+		},
+	})
+}
+
+// AccessCounters reports the audit counters of every stream that was requested
+func (s *HLSServer) AccessCounters(c *gin.Context) {
+	streams := []gin.H{}
+	for _, directory := range s.access.Directories() {
+		streams = append(streams, gin.H{
+			"stream":   s.playbackName(directory),
+			"counters": s.access.Counters(directory),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":        "success",
+		"geoip_enabled": s.access.GeoIPEnabled(),
+		"streams":       streams,
+	})
+}
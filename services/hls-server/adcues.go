@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/streamforge/platform/pkg/m3u8"
+)
+
+const (
+	// adCuesFile is the ad break sidecar written by the transcoder
+	adCuesFile = ".ad_cues"
+	// adCueTolerance absorbs the millisecond rounding of program date-times
+	adCueTolerance = 50 * time.Millisecond
+)
+
+// AdCue mirrors an ad break the transcoder schedules for a stream
+type AdCue struct {
+	ID              string    `json:"id"`
+	Start           time.Time `json:"start"`
+	PlannedDuration float64   `json:"planned_duration"`
+	Duration        float64   `json:"duration"` // shorter than planned when the break was ended early
+	SCTE35Out       string    `json:"scte35_out"`
+	SCTE35In        string    `json:"scte35_in"`
+}
+
+// adCueAlignment is the segment boundaries an ad break was placed on. In is zero
+// until the segment that ends the break has been written.
+type adCueAlignment struct {
+	out time.Time
+	in  time.Time
+}
+
+// alignedAdCue is an ad break with the boundaries it is marked on
+type alignedAdCue struct {
+	AdCue
+	adCueAlignment
+}
+
+// loadAdCues reads the ad break sidecar of a stream, returning nil if there is none
+func (s *HLSServer) loadAdCues(streamName string) []AdCue {
+	data, err := s.files.ReadFile(filepath.Join(s.hlsDir, streamName, adCuesFile))
+	if err != nil {
+		return nil
+	}
+
+	var cues []AdCue
+	if err := json.Unmarshal(data, &cues); err != nil {
+		return nil
+	}
+	return cues
+}
+
+// alignAdCues places every ad break that has started on segment boundaries. The
+// cue-out goes on the first segment starting at or after the requested time and
+// the cue-in on the first segment starting once the duration has elapsed.
+// Alignments are remembered so every variant and every refresh reports the same
+// dates, even after the boundary slides out of the playlist.
+func (s *HLSServer) alignAdCues(cues []AdCue, playlist *dvrPlaylist) []alignedAdCue {
+	var aligned []alignedAdCue
+	for _, cue := range cues {
+		var alignment adCueAlignment
+		if cached, ok := s.adCueAlignments.Load(cue.ID); ok {
+			alignment = cached.(adCueAlignment)
+		} else {
+			index := playlist.segmentAt(cue.Start)
+			if index < 0 {
+				continue // the break has not started yet
+			}
+			alignment.out = playlist.times[index]
+			// The boundary slid out of the window before it was seen, e.g. after a restart
+			if index == 0 && playlist.times[index].Sub(cue.Start) > time.Duration(playlist.Segments[index].Duration*float64(time.Second)) {
+				alignment.out = cue.Start
+			}
+		}
+
+		if alignment.in.IsZero() {
+			if index := playlist.segmentAt(alignment.out.Add(time.Duration(cue.Duration * float64(time.Second)))); index >= 0 {
+				alignment.in = playlist.times[index]
+			}
+		}
+		s.adCueAlignments.Store(cue.ID, alignment)
+
+		aligned = append(aligned, alignedAdCue{AdCue: cue, adCueAlignment: alignment})
+	}
+	return aligned
+}
+
+// segmentAt returns the index of the first segment starting at or after t, or -1
+func (p *dvrPlaylist) segmentAt(t time.Time) int {
+	for i, start := range p.times {
+		if !start.IsZero() && !start.Before(t.Add(-adCueTolerance)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// markAdCues tags the segments from the given index with their ad breaks:
+// EXT-X-DATERANGE with SCTE35-OUT and EXT-X-CUE-OUT where a break starts,
+// EXT-X-CUE-OUT-CONT inside it and EXT-X-DATERANGE with SCTE35-IN and
+// EXT-X-CUE-IN where it ends
+func (p *dvrPlaylist) markAdCues(from int, cues []alignedAdCue) {
+	for _, cue := range cues {
+		for i := from; i < len(p.Segments); i++ {
+			segment := &p.Segments[i]
+			t := p.times[i]
+			if t.IsZero() {
+				continue
+			}
+
+			switch {
+			case sameInstant(t, cue.out):
+				segment.DateRanges = append(segment.DateRanges, cue.dateRangeOut())
+				segment.Tags = append(segment.Tags, fmt.Sprintf("#EXT-X-CUE-OUT:DURATION=%.3f", cue.PlannedDuration))
+			case t.After(cue.out) && (cue.in.IsZero() || t.Before(cue.in.Add(-adCueTolerance))):
+				// A playlist starting mid-break still declares the break it is in
+				if i == from {
+					segment.DateRanges = append(segment.DateRanges, cue.dateRangeOut())
+				}
+				segment.Tags = append(segment.Tags, fmt.Sprintf("#EXT-X-CUE-OUT-CONT:ElapsedTime=%.3f,Duration=%.3f", t.Sub(cue.out).Seconds(), cue.PlannedDuration))
+			case !cue.in.IsZero() && sameInstant(t, cue.in):
+				segment.DateRanges = append(segment.DateRanges, cue.dateRangeIn())
+				segment.Tags = append(segment.Tags, "#EXT-X-CUE-IN")
+			}
+		}
+	}
+}
+
+// dateRangeOut returns the EXT-X-DATERANGE that starts an ad break
+func (c alignedAdCue) dateRangeOut() m3u8.DateRange {
+	planned := c.PlannedDuration
+	return m3u8.DateRange{
+		ID:              c.ID,
+		StartDate:       c.out.UTC(),
+		PlannedDuration: &planned,
+		SCTE35Out:       c.SCTE35Out,
+	}
+}
+
+// dateRangeIn returns the EXT-X-DATERANGE that ends an ad break. It repeats the
+// ID and START-DATE of the cue-out so players merge both into one range.
+func (c alignedAdCue) dateRangeIn() m3u8.DateRange {
+	duration := c.in.Sub(c.out).Seconds()
+	return m3u8.DateRange{
+		ID:        c.ID,
+		StartDate: c.out.UTC(),
+		EndDate:   c.in.UTC(),
+		Duration:  &duration,
+		SCTE35In:  c.SCTE35In,
+	}
+}
+
+// sameInstant reports whether two program date-times refer to the same boundary
+func sameInstant(a, b time.Time) bool {
+	d := a.Sub(b)
+	return d > -adCueTolerance && d < adCueTolerance
+}
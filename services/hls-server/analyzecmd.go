@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// runAnalyzeCommand analyzes a stream from the command line:
+//
+//	hls-server analyze [-dir <hls dir>] [-segments <n>] [-json] <stream>
+//
+// It exits with 0 when the renditions are healthy, 2 when the analysis found
+// problems and 1 when the stream could not be analyzed.
+func runAnalyzeCommand(args []string, hlsDir string) int {
+	flags := flag.NewFlagSet("analyze", flag.ExitOnError)
+	dir := flags.String("dir", hlsDir, "HLS directory")
+	segments := flags.Int("segments", defaultAnalyzeSegments, "Newest segments to analyze per variant")
+	asJSON := flags.Bool("json", false, "Print the analysis as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: hls-server analyze [-dir <hls dir>] [-segments <n>] [-json] <stream>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 1
+	}
+	if *segments < 1 || *segments > maxAnalyzeSegments {
+		fmt.Fprintf(os.Stderr, "❌ -segments must be between 1 and %d\n", maxAnalyzeSegments)
+		return 1
+	}
+
+	streamName := flags.Arg(0)
+	analysis, err := NewHLSServer(*dir).analyzeStream(streamName, *segments)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "❌ Stream %s not found in %s\n", streamName, *dir)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to analyze %s: %v\n", streamName, err)
+		return 1
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(analysis)
+	} else {
+		printAnalysis(analysis)
+	}
+
+	if !analysis.Healthy {
+		return 2
+	}
+	return 0
+}
+
+// printAnalysis writes a human readable analysis report
+func printAnalysis(analysis *StreamAnalysis) {
+	fmt.Printf("📊 %s: %d variants\n\n", analysis.Stream, len(analysis.Variants))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VARIANT\tBANDWIDTH\tRESOLUTION\tSEGMENTS\tDURATION MIN/AVG/MAX\tTARGET\tGOP\tDRIFT\tNON-IDR\tPTS GAPS\tCC ERRORS")
+	for _, variant := range analysis.Variants {
+		if variant.Error != "" {
+			fmt.Fprintf(w, "%s\t%d\t%s\t⚠️  %s\n", variant.Name, variant.Bandwidth, variant.Resolution, variant.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%.3f/%.3f/%.3f\t%gs\t%.3fs\t%d\t%d\t%d\t%d\n",
+			variant.Name, variant.Bandwidth, variant.Resolution, len(variant.Segments),
+			variant.MinDuration, variant.AverageDuration, variant.MaxDuration, variant.SegmentDuration,
+			variant.AverageGOP, variant.DriftingSegments, variant.NonIDRStarts, variant.PTSGaps, variant.ContinuityErrors)
+	}
+	w.Flush()
+
+	for _, variant := range analysis.Variants {
+		for _, segment := range variant.Segments {
+			if segment.Error != "" {
+				fmt.Printf("⚠️  %s #%d: %s\n", variant.Name, segment.Sequence, segment.Error)
+			} else if segment.PTSGap != 0 {
+				fmt.Printf("⚠️  %s #%d: PTS gap of %+.3fs\n", variant.Name, segment.Sequence, segment.PTSGap)
+			}
+		}
+	}
+
+	fmt.Println()
+	alignment := analysis.Alignment
+	if alignment.Aligned {
+		fmt.Printf("✅ Renditions are keyframe-aligned with %s (%d segments compared)\n", alignment.Reference, alignment.Compared)
+	} else {
+		fmt.Printf("❌ %d alignment issues against %s (%d segments compared)\n", len(alignment.Mismatches), alignment.Reference, alignment.Compared)
+		for _, mismatch := range alignment.Mismatches {
+			fmt.Printf("   %s #%d: %s\n", mismatch.Variant, mismatch.Sequence, mismatch.Issue)
+		}
+	}
+}
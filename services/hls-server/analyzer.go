@@ -0,0 +1,463 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/streamforge/platform/pkg/m3u8"
+)
+
+const (
+	// defaultAnalyzeSegments is how many of the newest segments of each variant are analyzed
+	defaultAnalyzeSegments = 10
+	// maxAnalyzeSegments bounds the work of one analysis
+	maxAnalyzeSegments = 200
+	// alignmentTolerance is how far apart, in PTS ticks, the keyframes of two
+	// renditions may be and still count as aligned (10ms)
+	alignmentTolerance = ptsClock / 100
+	// driftTolerance is how far a segment may be from the target segment duration, in seconds
+	driftTolerance = 0.1
+)
+
+// StreamAnalysis is the segment timing and keyframe alignment of the renditions of a stream
+type StreamAnalysis struct {
+	Stream     string           `json:"stream"`
+	AnalyzedAt time.Time        `json:"analyzed_at"`
+	Healthy    bool             `json:"healthy"`
+	Variants   []*VariantReport `json:"variants"`
+	Alignment  AlignmentReport  `json:"alignment"`
+}
+
+// VariantReport summarises the newest segments of one rendition
+type VariantReport struct {
+	Name               string           `json:"name"`
+	URI                string           `json:"uri"`
+	Bandwidth          int              `json:"bandwidth"`
+	Resolution         string           `json:"resolution,omitempty"`
+	Codec              string           `json:"codec,omitempty"`
+	TargetDuration     int              `json:"target_duration"`
+	SegmentDuration    float64          `json:"segment_duration"`
+	MinDuration        float64          `json:"min_duration"`
+	MaxDuration        float64          `json:"max_duration"`
+	AverageDuration    float64          `json:"average_duration"`
+	AverageGOP         float64          `json:"average_gop"`
+	DriftingSegments   int              `json:"drifting_segments"`
+	OverTargetSegments int              `json:"over_target_segments"`
+	NonIDRStarts       int              `json:"non_idr_starts"`
+	PTSGaps            int              `json:"pts_gaps"`
+	ContinuityErrors   int              `json:"continuity_errors"`
+	Segments           []*SegmentReport `json:"segments"`
+	Error              string           `json:"error,omitempty"`
+}
+
+// SegmentReport is the timing of one media segment. Timestamps are in seconds;
+// IDR offsets are relative to the first frame of the segment.
+type SegmentReport struct {
+	Sequence         int64     `json:"sequence"`
+	URI              string    `json:"uri"`
+	Duration         float64   `json:"duration"`
+	MeasuredDuration float64   `json:"measured_duration"`
+	Discontinuity    bool      `json:"discontinuity,omitempty"`
+	StartPTS         float64   `json:"start_pts"`
+	Frames           int       `json:"frames"`
+	IDROffsets       []float64 `json:"idr_offsets"`
+	StartsWithIDR    bool      `json:"starts_with_idr"`
+	Drifting         bool      `json:"drifting,omitempty"`
+	PTSGap           float64   `json:"pts_gap,omitempty"`
+	ContinuityErrors int       `json:"continuity_errors,omitempty"`
+	Error            string    `json:"error,omitempty"`
+
+	startTicks    int64
+	measuredTicks int64
+	idrTicks      []int64
+}
+
+// AlignmentReport compares the segments every rendition has in common with the
+// top rendition. Players can only switch cleanly between renditions whose
+// segments start on the same keyframe.
+type AlignmentReport struct {
+	Reference  string              `json:"reference"`
+	Compared   int                 `json:"compared"`
+	Aligned    bool                `json:"aligned"`
+	Mismatches []AlignmentMismatch `json:"mismatches"`
+}
+
+// AlignmentMismatch is a segment of a rendition that breaks keyframe alignment
+type AlignmentMismatch struct {
+	Sequence int64   `json:"sequence"`
+	Variant  string  `json:"variant"`
+	Issue    string  `json:"issue"`
+	Offset   float64 `json:"offset,omitempty"`
+}
+
+// AnalyzeStream reports the segment timing and keyframe alignment of a stream's renditions
+func (s *HLSServer) AnalyzeStream(c *gin.Context) {
+	streamName := c.Param("stream")
+	if streamName != filepath.Base(streamName) || strings.HasPrefix(streamName, ".") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid stream name"})
+		return
+	}
+
+	count, err := parseSegmentCount(c.Query("segments"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	analysis, err := s.analyzeStream(streamName, count)
+	if errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"data":   analysis,
+	})
+}
+
+// parseSegmentCount reads how many segments per variant to analyze
+func parseSegmentCount(raw string) (int, error) {
+	if raw == "" {
+		return defaultAnalyzeSegments, nil
+	}
+	count, err := strconv.Atoi(raw)
+	if err != nil || count < 1 || count > maxAnalyzeSegments {
+		return 0, fmt.Errorf("invalid segments %q (expected 1 to %d)", raw, maxAnalyzeSegments)
+	}
+	return count, nil
+}
+
+// analyzeStream parses the newest segments of every variant in the master
+// playlist of a stream and compares their keyframes across variants
+func (s *HLSServer) analyzeStream(streamName string, count int) (*StreamAnalysis, error) {
+	directory, ok := s.streamDirectory(streamName)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	streamDir := filepath.Join(s.hlsDir, directory)
+	master, err := m3u8.ReadMasterFile(filepath.Join(streamDir, "master.m3u8"))
+	if err != nil {
+		return nil, err
+	}
+
+	var segmentDuration float64
+	if settings := s.loadStreamSettings(directory); settings != nil {
+		segmentDuration = float64(settings.SegmentDuration)
+	}
+
+	analysis := &StreamAnalysis{Stream: streamName, AnalyzedAt: time.Now().UTC()}
+	for _, variant := range master.Variants {
+		report := &VariantReport{
+			Name:       variantName(variant.URI),
+			URI:        variant.URI,
+			Bandwidth:  variant.Bandwidth,
+			Resolution: variant.Resolution,
+		}
+		if err := s.analyzeVariant(directory, report, segmentDuration, count); err != nil {
+			report.Error = err.Error()
+		}
+		analysis.Variants = append(analysis.Variants, report)
+	}
+	if len(analysis.Variants) == 0 {
+		return nil, fmt.Errorf("master playlist of %s lists no variants", streamName)
+	}
+
+	analysis.Alignment = compareVariants(analysis.Variants)
+	analysis.Healthy = analysis.Alignment.Aligned
+	for _, variant := range analysis.Variants {
+		if variant.Error != "" || variant.DriftingSegments > 0 || variant.OverTargetSegments > 0 ||
+			variant.NonIDRStarts > 0 || variant.PTSGaps > 0 || variant.ContinuityErrors > 0 {
+			analysis.Healthy = false
+		}
+	}
+	return analysis, nil
+}
+
+// analyzeVariant parses the newest segments of a variant playlist in a stream
+// directory. Segments are expected to last the stream's segment duration, or
+// the target duration when the stream has no settings.
+func (s *HLSServer) analyzeVariant(directory string, report *VariantReport, segmentDuration float64, count int) error {
+	playlistPath := filepath.Join(s.hlsDir, directory, filepath.FromSlash(stripQuery(report.URI)))
+	playlist, err := m3u8.ReadMediaFile(playlistPath)
+	if err != nil {
+		return fmt.Errorf("failed to read playlist: %w", err)
+	}
+
+	report.TargetDuration = playlist.TargetDuration
+	report.SegmentDuration = segmentDuration
+	if report.SegmentDuration <= 0 {
+		report.SegmentDuration = float64(report.TargetDuration)
+	}
+
+	restarts := map[int64]bool{}
+	if discontinuities := s.loadDiscontinuities(directory, report.Name); discontinuities != nil {
+		for _, sequence := range discontinuities.Sequences {
+			restarts[sequence] = true
+		}
+	}
+
+	from := max(len(playlist.Segments)-count, 0)
+	// The key of a segment is the last one written at or before it
+	var key *m3u8.Key
+	for _, segment := range playlist.Segments[:from] {
+		if segment.Key != nil {
+			key = segment.Key
+		}
+	}
+	var previous *SegmentReport
+	var lastIDR int64
+	hasLastIDR := false
+	var gopTicks, gops int64
+	for i, segment := range playlist.Segments[from:] {
+		sequence := playlist.MediaSequence + int64(from+i)
+		current := &SegmentReport{
+			Sequence:      sequence,
+			URI:           segment.URI,
+			Duration:      segment.Duration,
+			Discontinuity: segment.Discontinuity || restarts[sequence],
+			IDROffsets:    []float64{},
+		}
+		report.Segments = append(report.Segments, current)
+
+		if current.Discontinuity {
+			previous = nil
+			hasLastIDR = false
+		}
+		if segment.Key != nil {
+			key = segment.Key
+		}
+		if key != nil && key.Method != "NONE" {
+			// The HLS server never holds content keys
+			current.Error = fmt.Sprintf("segment is encrypted with %s", key.Method)
+			previous = nil
+			hasLastIDR = false
+			continue
+		}
+		segmentPath := filepath.Join(filepath.Dir(playlistPath), filepath.FromSlash(stripQuery(current.URI)))
+		if err := analyzeSegment(segmentPath, current, report); err != nil {
+			current.Error = err.Error()
+			previous = nil
+			hasLastIDR = false
+			continue
+		}
+
+		// The next segment should start where this one ends
+		if previous != nil {
+			gap := ptsDelta(previous.startTicks+previous.measuredTicks, current.startTicks)
+			if abs(gap) > previous.measuredTicks/int64(max(previous.Frames, 1))/2 {
+				current.PTSGap = round3(float64(gap) / ptsClock)
+				report.PTSGaps++
+			}
+		}
+		for _, idr := range current.idrTicks {
+			if hasLastIDR {
+				gopTicks += ptsDelta(lastIDR, idr)
+				gops++
+			}
+			lastIDR, hasLastIDR = idr, true
+		}
+		previous = current
+	}
+
+	var total float64
+	measured := 0
+	for i, segment := range report.Segments {
+		if segment.Error != "" {
+			continue
+		}
+		if measured == 0 || segment.Duration < report.MinDuration {
+			report.MinDuration = segment.Duration
+		}
+		report.MaxDuration = math.Max(report.MaxDuration, segment.Duration)
+		total += segment.Duration
+		measured++
+
+		if report.TargetDuration > 0 && int(math.Round(segment.Duration)) > report.TargetDuration {
+			report.OverTargetSegments++
+		}
+		// A segment cut short by an encoder restart or the end of the stream is not drift
+		cut := (i+1 < len(report.Segments) && report.Segments[i+1].Discontinuity) ||
+			(i+1 == len(report.Segments) && playlist.Ended)
+		if report.SegmentDuration > 0 && !cut && math.Abs(segment.Duration-report.SegmentDuration) > driftTolerance {
+			segment.Drifting = true
+			report.DriftingSegments++
+		}
+	}
+	if measured > 0 {
+		report.AverageDuration = round3(total / float64(measured))
+	}
+	if gops > 0 {
+		report.AverageGOP = round3(float64(gopTicks) / float64(gops) / ptsClock)
+	}
+	return nil
+}
+
+// analyzeSegment parses one MPEG-TS segment of a variant
+func analyzeSegment(segmentPath string, report *SegmentReport, variant *VariantReport) error {
+	data, err := os.ReadFile(segmentPath)
+	if err != nil {
+		return fmt.Errorf("failed to read segment: %w", err)
+	}
+	summary, err := parseTransportStream(data)
+	if err != nil {
+		return err
+	}
+	if len(summary.frames) == 0 {
+		return errors.New("segment has no video frames")
+	}
+
+	variant.Codec = summary.videoCodec
+	variant.ContinuityErrors += summary.continuityErrors
+	report.ContinuityErrors = summary.continuityErrors
+	report.Frames = len(summary.frames)
+	report.StartsWithIDR = summary.frames[0].keyframe
+	if !report.StartsWithIDR {
+		variant.NonIDRStarts++
+	}
+
+	// Frames are stored in decode order; B-frames make presentation order differ
+	first := summary.frames[0].pts
+	offsets := make([]int64, len(summary.frames))
+	for i, frame := range summary.frames {
+		offsets[i] = ptsDelta(first, frame.pts)
+	}
+	sorted := append([]int64(nil), offsets...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	report.startTicks = (first + sorted[0] + ptsWrap) % ptsWrap
+	report.measuredTicks = sorted[len(sorted)-1] - sorted[0] + frameDuration(sorted)
+	report.StartPTS = round3(float64(report.startTicks) / ptsClock)
+	report.MeasuredDuration = round3(float64(report.measuredTicks) / ptsClock)
+
+	for i, frame := range summary.frames {
+		if frame.keyframe {
+			report.idrTicks = append(report.idrTicks, frame.pts)
+			report.IDROffsets = append(report.IDROffsets, round3(float64(offsets[i]-sorted[0])/ptsClock))
+		}
+	}
+	return nil
+}
+
+// frameDuration returns the most common interval between presentation
+// timestamps, which is the frame duration of a constant frame rate stream
+func frameDuration(sorted []int64) int64 {
+	counts := map[int64]int{}
+	var best int64
+	for i := 1; i < len(sorted); i++ {
+		delta := sorted[i] - sorted[i-1]
+		if delta <= 0 {
+			continue
+		}
+		counts[delta]++
+		if counts[delta] > counts[best] || (counts[delta] == counts[best] && delta < best) {
+			best = delta
+		}
+	}
+	return best
+}
+
+// compareVariants checks that every rendition starts its segments on the same
+// keyframes as the top rendition
+func compareVariants(variants []*VariantReport) AlignmentReport {
+	alignment := AlignmentReport{Aligned: true, Mismatches: []AlignmentMismatch{}}
+	var reference *VariantReport
+	for _, variant := range variants {
+		if variant.Error == "" && len(variant.Segments) > 0 {
+			reference = variant
+			break
+		}
+	}
+	if reference == nil {
+		alignment.Aligned = false
+		return alignment
+	}
+	alignment.Reference = reference.Name
+
+	mismatch := func(sequence int64, variant, issue string, offset float64) {
+		alignment.Aligned = false
+		alignment.Mismatches = append(alignment.Mismatches, AlignmentMismatch{
+			Sequence: sequence, Variant: variant, Issue: issue, Offset: offset,
+		})
+	}
+
+	for _, variant := range variants {
+		segments := map[int64]*SegmentReport{}
+		for _, segment := range variant.Segments {
+			segments[segment.Sequence] = segment
+		}
+
+		for _, ref := range reference.Segments {
+			segment := segments[ref.Sequence]
+			if ref.Error != "" || segment == nil || segment.Error != "" {
+				continue
+			}
+			if !segment.StartsWithIDR {
+				mismatch(segment.Sequence, variant.Name, "does not start with an IDR frame", 0)
+			}
+			if variant == reference {
+				continue
+			}
+			alignment.Compared++
+
+			if offset := ptsDelta(ref.startTicks, segment.startTicks); abs(offset) > alignmentTolerance {
+				mismatch(segment.Sequence, variant.Name,
+					fmt.Sprintf("starts %+.3fs from %s", float64(offset)/ptsClock, reference.Name), round3(float64(offset)/ptsClock))
+				continue
+			}
+			if !sameKeyframes(ref.idrTicks, segment.idrTicks) {
+				mismatch(segment.Sequence, variant.Name,
+					fmt.Sprintf("has %d IDR frames at %v, %s has %d at %v", len(segment.IDROffsets), segment.IDROffsets,
+						reference.Name, len(ref.IDROffsets), ref.IDROffsets), 0)
+			}
+		}
+	}
+	return alignment
+}
+
+// sameKeyframes reports whether two segments have their keyframes at the same timestamps
+func sameKeyframes(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if abs(ptsDelta(a[i], b[i])) > alignmentTolerance {
+			return false
+		}
+	}
+	return true
+}
+
+// stripQuery removes the query string from a playlist URI
+func stripQuery(uri string) string {
+	if i := strings.Index(uri, "?"); i >= 0 {
+		return uri[:i]
+	}
+	return uri
+}
+
+// round3 rounds seconds to milliseconds for reporting
+func round3(seconds float64) float64 {
+	return math.Round(seconds*1000) / 1000
+}
+
+// abs returns the absolute value of a timestamp difference
+func abs(delta int64) int64 {
+	if delta < 0 {
+		return -delta
+	}
+	return delta
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// benchResult is the throughput of one benchmark run
+type benchResult struct {
+	mode     string
+	requests uint64
+	bytes    uint64
+	failures uint64
+	elapsed  time.Duration
+}
+
+// requestsPerSecond returns the request throughput of the run
+func (r benchResult) requestsPerSecond() float64 {
+	return float64(r.requests) / r.elapsed.Seconds()
+}
+
+// megabytesPerSecond returns the byte throughput of the run
+func (r benchResult) megabytesPerSecond() float64 {
+	return float64(r.bytes) / (1 << 20) / r.elapsed.Seconds()
+}
+
+// runBenchCommand measures how much the file cache speeds up serving a stream:
+//
+//	hls-server bench [-dir <hls dir>] [-duration <d>] [-clients <n>] [-cache-mb <n>] <stream>
+//
+// The stream's playlists and segments are requested over loopback by
+// concurrent clients, once from disk and once through the cache.
+func runBenchCommand(args []string, hlsDir string) int {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	dir := flags.String("dir", hlsDir, "HLS directory")
+	duration := flags.Duration("duration", 5*time.Second, "How long each run lasts")
+	clients := flags.Int("clients", 32, "Concurrent clients")
+	cacheMegabytes := flags.Int64("cache-mb", defaultCacheMegabytes, "Memory budget of the cache in megabytes")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: hls-server bench [-dir <hls dir>] [-duration <d>] [-clients <n>] [-cache-mb <n>] <stream>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 1
+	}
+	if *duration <= 0 || *clients < 1 || *cacheMegabytes < 1 {
+		fmt.Fprintln(os.Stderr, "❌ -duration, -clients and -cache-mb must be positive")
+		return 1
+	}
+
+	streamName := flags.Arg(0)
+	paths, err := benchPaths(*dir, streamName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to list %s: %v\n", streamName, err)
+		return 1
+	}
+	if len(paths) == 0 {
+		fmt.Fprintf(os.Stderr, "❌ Stream %s has no playlists or segments in %s\n", streamName, *dir)
+		return 1
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	fmt.Printf("🏁 %s: %d files, %d clients, %s per run\n\n", streamName, len(paths), *clients, *duration)
+
+	disk := NewHLSServer(*dir)
+	cached := NewHLSServer(*dir)
+	cached.files = NewFileCache(*cacheMegabytes << 20)
+	results := []benchResult{
+		benchServer("disk", disk, paths, *clients, *duration),
+		benchServer("cache", cached, paths, *clients, *duration),
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODE\tREQUESTS\tREQ/S\tMB/S\tFAILURES")
+	for _, result := range results {
+		fmt.Fprintf(w, "%s\t%d\t%.0f\t%.1f\t%d\n", result.mode, result.requests,
+			result.requestsPerSecond(), result.megabytesPerSecond(), result.failures)
+	}
+	w.Flush()
+
+	if results[0].requests > 0 {
+		fmt.Printf("\n⚡ The cache serves %.2fx the requests per second\n",
+			results[1].requestsPerSecond()/results[0].requestsPerSecond())
+	}
+	for _, result := range results {
+		if result.failures > 0 {
+			return 2
+		}
+	}
+	return 0
+}
+
+// benchPaths lists the request paths of a stream's playlists and segments
+func benchPaths(hlsDir, streamName string) ([]string, error) {
+	streamDir := filepath.Join(hlsDir, streamName)
+	var paths []string
+	err := filepath.WalkDir(streamDir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(entry.Name(), ".") && path != streamDir {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch filepath.Ext(path) {
+		case ".m3u8", ".ts", ".m4s", ".mp4", ".aac":
+			relative, err := filepath.Rel(hlsDir, path)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, "/hls/"+filepath.ToSlash(relative))
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// benchServer requests the paths from a server over loopback for a duration
+func benchServer(mode string, hlsServer *HLSServer, paths []string, clients int, duration time.Duration) benchResult {
+	r := gin.New()
+	r.GET("/hls/*filepath", hlsServer.ServeHLSFile)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: clients}}
+	defer client.CloseIdleConnections()
+
+	// Warm up, so the cached run measures hits rather than the first reads
+	for _, path := range paths {
+		if response, err := client.Get(server.URL + path); err == nil {
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+		}
+	}
+
+	result := benchResult{mode: mode}
+	var next atomic.Uint64
+	var wg sync.WaitGroup
+	start := time.Now()
+	deadline := start.Add(duration)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				path := paths[next.Add(1)%uint64(len(paths))]
+				response, err := client.Get(server.URL + path)
+				if err != nil {
+					atomic.AddUint64(&result.failures, 1)
+					continue
+				}
+				n, _ := io.Copy(io.Discard, response.Body)
+				response.Body.Close()
+				if response.StatusCode != http.StatusOK {
+					atomic.AddUint64(&result.failures, 1)
+					continue
+				}
+				atomic.AddUint64(&result.requests, 1)
+				atomic.AddUint64(&result.bytes, uint64(n))
+			}
+		}()
+	}
+	wg.Wait()
+	result.elapsed = time.Since(start)
+	return result
+}
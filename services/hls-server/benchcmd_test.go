@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// writeBenchStream writes a stream of two variants with a few segments each,
+// the size of two-second segments at 720p and 360p
+func writeBenchStream(b *testing.B, hlsDir, streamName string) {
+	b.Helper()
+	variants := map[string]int{"720p": 700 << 10, "360p": 200 << 10}
+
+	master := "#EXTM3U\n"
+	for name := range variants {
+		master += fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d\n%s/playlist.m3u8\n", variants[name]*4, name)
+	}
+	writeBenchFile(b, filepath.Join(hlsDir, streamName, "master.m3u8"), []byte(master))
+
+	for name, size := range variants {
+		var playlist strings.Builder
+		playlist.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:1\n")
+		for i := 1; i <= 6; i++ {
+			segment := fmt.Sprintf("segment%03d.ts", i)
+			fmt.Fprintf(&playlist, "#EXTINF:2.000,\n%s\n", segment)
+			writeBenchFile(b, filepath.Join(hlsDir, streamName, name, segment), bytes.Repeat([]byte{0x47}, size))
+		}
+		writeBenchFile(b, filepath.Join(hlsDir, streamName, name, "playlist.m3u8"), []byte(playlist.String()))
+	}
+}
+
+func writeBenchFile(b *testing.B, path string, data []byte) {
+	b.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		b.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkServeHLSFile serves the playlists and segments of a stream from
+// disk and through the file cache, as the bench command does over loopback
+func BenchmarkServeHLSFile(b *testing.B) {
+	gin.SetMode(gin.ReleaseMode)
+	hlsDir := b.TempDir()
+	writeBenchStream(b, hlsDir, "bench")
+	paths, err := benchPaths(hlsDir, "bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	var total int64
+	for _, path := range paths {
+		info, err := os.Stat(filepath.Join(hlsDir, strings.TrimPrefix(path, "/hls/")))
+		if err != nil {
+			b.Fatal(err)
+		}
+		total += info.Size()
+	}
+
+	for _, mode := range []string{"disk", "cache"} {
+		b.Run(mode, func(b *testing.B) {
+			hlsServer := NewHLSServer(hlsDir)
+			if mode == "cache" {
+				hlsServer.files = NewFileCache(defaultCacheMegabytes << 20)
+			}
+			r := gin.New()
+			r.GET("/hls/*filepath", hlsServer.ServeHLSFile)
+
+			// Warm up, so the cached run measures hits rather than the first reads
+			for _, path := range paths {
+				r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+			}
+
+			// Requests go round the paths, so each serves the average file
+			b.SetBytes(total / int64(len(paths)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					recorder := httptest.NewRecorder()
+					r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, paths[i%len(paths)], nil))
+					if recorder.Code != http.StatusOK {
+						b.Errorf("GET %s = %d", paths[i%len(paths)], recorder.Code)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultCacheMegabytes is the default memory budget of the file cache
+	defaultCacheMegabytes = 256
+	// maxCachedFileSize is the largest file kept in memory; larger ones are sent from disk
+	maxCachedFileSize = 16 << 20
+	// cacheRevalidateAfter is how long a cached file is trusted without checking
+	// its modification time, in case a change notification was missed
+	cacheRevalidateAfter = 5 * time.Second
+	// cacheEvictTarget is the share of the budget eviction frees the cache down to
+	cacheEvictTarget = 0.9
+)
+
+// cachedFile is what serving a file needs to know of it. Data is nil for files
+// that are not kept in memory.
+type cachedFile struct {
+	size    int64
+	modTime time.Time
+	etag    string
+	data    []byte
+
+	validatedAt atomic.Int64 // unix nanoseconds
+	lastUsed    atomic.Int64 // unix nanoseconds
+}
+
+// newCachedFile describes a file from its stat information
+func newCachedFile(info os.FileInfo) *cachedFile {
+	return &cachedFile{
+		size:    info.Size(),
+		modTime: info.ModTime(),
+		etag:    fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano()),
+	}
+}
+
+// matches reports whether stat information still describes the cached file
+func (f *cachedFile) matches(info os.FileInfo) bool {
+	return info.Size() == f.size && info.ModTime().Equal(f.modTime)
+}
+
+// FileCache keeps recently served segments, playlists and sidecars in memory.
+// Entries are dropped when fsnotify reports a change in their directory, and
+// revalidated by modification time every few seconds in case a notification
+// was missed, or on every request when notifications are unavailable. The
+// least recently used files are evicted when the cache outgrows its budget.
+type FileCache struct {
+	maxBytes int64
+
+	entries     sync.Map // path -> *cachedFile
+	size        atomic.Int64
+	evictMutex  sync.Mutex
+	watcher     *fsnotify.Watcher // nil when change notifications are unavailable
+	watched     sync.Map          // directory -> struct{}
+	generations sync.Map          // directory -> *atomic.Uint64, bumped on every change
+
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	invalidations atomic.Uint64
+	evictions     atomic.Uint64
+}
+
+// NewFileCache returns a cache with a memory budget in bytes, or nil when the budget is zero
+func NewFileCache(maxBytes int64) *FileCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	cache := &FileCache{maxBytes: maxBytes}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("⚠️  File change notifications unavailable, validating cached files by modification time: %v", err)
+		return cache
+	}
+	cache.watcher = watcher
+	go cache.watch()
+	return cache
+}
+
+// Open returns the cached description of a file, loading small files into
+// memory. Without a cache it only stats the file.
+func (fc *FileCache) Open(path string) (*cachedFile, error) {
+	if fc == nil {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		return newCachedFile(info), nil
+	}
+
+	now := time.Now().UnixNano()
+	if value, ok := fc.entries.Load(path); ok {
+		file := value.(*cachedFile)
+		if fc.watcher != nil && now-file.validatedAt.Load() < int64(cacheRevalidateAfter) {
+			file.lastUsed.Store(now)
+			fc.hits.Add(1)
+			return file, nil
+		}
+		if info, err := os.Stat(path); err == nil && file.matches(info) {
+			file.validatedAt.Store(now)
+			file.lastUsed.Store(now)
+			fc.hits.Add(1)
+			return file, nil
+		}
+		fc.invalidate(path)
+	}
+	fc.misses.Add(1)
+	return fc.load(path, now)
+}
+
+// load reads a file and caches it if it is small enough. Changes that arrive
+// while the file is read keep it out of the cache.
+func (fc *FileCache) load(path string, now int64) (*cachedFile, error) {
+	dir := filepath.Dir(path)
+	fc.watchDir(dir)
+	generation := fc.generation(dir).Load()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	file := newCachedFile(info)
+	if !info.Mode().IsRegular() || info.Size() > maxCachedFileSize {
+		return file, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != info.Size() {
+		// Written to while being read
+		return file, nil
+	}
+	file.data = data
+	file.validatedAt.Store(now)
+	file.lastUsed.Store(now)
+
+	if fc.generation(dir).Load() == generation {
+		if previous, loaded := fc.entries.Swap(path, file); loaded {
+			fc.size.Add(-previous.(*cachedFile).size)
+		}
+		if fc.size.Add(file.size) > fc.maxBytes {
+			fc.evict()
+		}
+	}
+	return file, nil
+}
+
+// ReadFile returns the contents of a file, from memory when it is cached. The
+// returned slice is shared and must not be modified.
+func (fc *FileCache) ReadFile(path string) ([]byte, error) {
+	if fc == nil {
+		return os.ReadFile(path)
+	}
+	file, err := fc.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if file.data != nil {
+		return file.data, nil
+	}
+	return os.ReadFile(path)
+}
+
+// generation returns the change counter of a directory
+func (fc *FileCache) generation(dir string) *atomic.Uint64 {
+	if counter, ok := fc.generations.Load(dir); ok {
+		return counter.(*atomic.Uint64)
+	}
+	counter, _ := fc.generations.LoadOrStore(dir, new(atomic.Uint64))
+	return counter.(*atomic.Uint64)
+}
+
+// watchDir subscribes to changes in a directory the first time a file in it is loaded
+func (fc *FileCache) watchDir(dir string) {
+	if fc.watcher == nil {
+		return
+	}
+	if _, watched := fc.watched.LoadOrStore(dir, struct{}{}); watched {
+		return
+	}
+	if err := fc.watcher.Add(dir); err != nil {
+		// Files of the directory are still revalidated by modification time
+		fc.watched.Delete(dir)
+		log.Printf("⚠️  Failed to watch %s for changes: %v", dir, err)
+	}
+}
+
+// watch drops cached files as their directories report changes
+func (fc *FileCache) watch() {
+	for {
+		select {
+		case event, ok := <-fc.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename|fsnotify.Chmod) == 0 {
+				continue
+			}
+			fc.generation(filepath.Dir(event.Name)).Add(1)
+			fc.invalidate(event.Name)
+			if _, watched := fc.watched.Load(event.Name); watched && (event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)) {
+				// A watched directory was removed or moved away with the files in it
+				fc.generation(event.Name).Add(1)
+				fc.watched.Delete(event.Name)
+				fc.watcher.Remove(event.Name)
+				fc.invalidateDir(event.Name)
+			}
+		case err, ok := <-fc.watcher.Errors:
+			if !ok {
+				return
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				// Changes were lost, so nothing cached can be trusted
+				fc.invalidateDir("")
+			}
+			log.Printf("⚠️  File change notifications: %v", err)
+		}
+	}
+}
+
+// invalidate drops a file from the cache
+func (fc *FileCache) invalidate(path string) {
+	if previous, loaded := fc.entries.LoadAndDelete(path); loaded {
+		fc.size.Add(-previous.(*cachedFile).size)
+		fc.invalidations.Add(1)
+	}
+}
+
+// invalidateDir drops every file under a directory, or every file for an empty path
+func (fc *FileCache) invalidateDir(dir string) {
+	prefix := dir + string(filepath.Separator)
+	fc.entries.Range(func(key, _ any) bool {
+		if dir == "" || strings.HasPrefix(key.(string), prefix) {
+			fc.invalidate(key.(string))
+		}
+		return true
+	})
+}
+
+// evict drops the least recently used files until the cache is back under
+// budget. Only one request evicts at a time; the others carry on.
+func (fc *FileCache) evict() {
+	if !fc.evictMutex.TryLock() {
+		return
+	}
+	defer fc.evictMutex.Unlock()
+
+	type candidate struct {
+		path     string
+		lastUsed int64
+	}
+	var candidates []candidate
+	fc.entries.Range(func(key, value any) bool {
+		candidates = append(candidates, candidate{key.(string), value.(*cachedFile).lastUsed.Load()})
+		return true
+	})
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].lastUsed < candidates[j].lastUsed })
+
+	target := int64(float64(fc.maxBytes) * cacheEvictTarget)
+	for _, candidate := range candidates {
+		if fc.size.Load() <= target {
+			break
+		}
+		if previous, loaded := fc.entries.LoadAndDelete(candidate.path); loaded {
+			fc.size.Add(-previous.(*cachedFile).size)
+			fc.evictions.Add(1)
+		}
+	}
+}
+
+// stats reports the size and effectiveness of the cache
+func (fc *FileCache) stats() gin.H {
+	files := 0
+	fc.entries.Range(func(_, _ any) bool {
+		files++
+		return true
+	})
+	return gin.H{
+		"files":         files,
+		"bytes":         fc.size.Load(),
+		"max_bytes":     fc.maxBytes,
+		"hits":          fc.hits.Load(),
+		"misses":        fc.misses.Load(),
+		"invalidations": fc.invalidations.Load(),
+		"evictions":     fc.evictions.Load(),
+		"notifications": fc.watcher != nil,
+	}
+}
+
+// serveFile sends a file with its ETag, honouring If-None-Match and Range.
+// Cached files are sent from memory; others are sent from disk, which uses
+// sendfile where the platform supports it.
+func (s *HLSServer) serveFile(c *gin.Context, fullPath string, file *cachedFile) {
+	c.Header("ETag", file.etag)
+	if file.data == nil {
+		c.File(fullPath)
+		return
+	}
+	http.ServeContent(c.Writer, c.Request, filepath.Base(fullPath), file.modTime, bytes.NewReader(file.data))
+}
+
+// CacheStats reports the state of the file cache
+func (s *HLSServer) CacheStats(c *gin.Context) {
+	if s.files == nil {
+		c.JSON(http.StatusOK, gin.H{"status": "success", "enabled": false})
+		return
+	}
+	stats := s.files.stats()
+	stats["status"] = "success"
+	stats["enabled"] = true
+	c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/streamforge/platform/pkg/playback"
+)
+
+// DeliveryURLs are where the URIs of a stream's playlists point. Relative URIs
+// are rebased onto a base URL in place of the HLS server's /hls path, so
+// playlists can be served from one domain and segments from a CDN. Without
+// base URLs playlists are passed through unchanged.
+type DeliveryURLs struct {
+	PlaylistBase string   // base of variant playlist URIs in master playlists
+	SegmentBase  string   // base of segment URIs in variant playlists
+	Propagate    []string // query parameters of playlist requests carried over to segment URIs
+}
+
+// parseBaseURL validates a base URL, which must be an http(s) URL without a query
+func parseBaseURL(value string) (string, error) {
+	value = strings.TrimRight(strings.TrimSpace(value), "/")
+	if value == "" {
+		return "", nil
+	}
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+		parsed.RawQuery != "" || parsed.Fragment != "" || parsed.ForceQuery {
+		return "", fmt.Errorf("invalid base URL %q (expected an http(s) URL without a query)", value)
+	}
+	return value, nil
+}
+
+// parseQueryNames splits a comma-separated list of query parameter names
+func parseQueryNames(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// deliveryURLsOf returns the delivery URLs of a stream: those of its settings,
+// falling back to the server's own for what they leave out
+func (s *HLSServer) deliveryURLsOf(settings *StreamSettings) DeliveryURLs {
+	urls := s.delivery
+	if settings == nil {
+		return urls
+	}
+	if settings.PlaylistBaseURL != "" {
+		urls.PlaylistBase = settings.PlaylistBaseURL
+	}
+	if settings.SegmentBaseURL != "" {
+		urls.SegmentBase = settings.SegmentBaseURL
+	}
+	if len(settings.PropagateQuery) > 0 {
+		urls.Propagate = settings.PropagateQuery
+	}
+	return urls
+}
+
+// propagatedQuery returns the carried-over parameters of a playlist request.
+// The playback token is left out, as it is always carried over.
+func (d DeliveryURLs) propagatedQuery(query url.Values) string {
+	propagated := url.Values{}
+	for _, name := range d.Propagate {
+		if name == playback.TokenParam {
+			continue
+		}
+		if values, ok := query[name]; ok {
+			propagated[name] = values
+		}
+	}
+	return propagated.Encode()
+}
+
+// rebase turns a URI relative to a playlist into one on a base URL. requestDir
+// is the directory of the playlist below /hls, as the viewer requested it.
+// Absolute URIs are returned unchanged.
+func rebase(base, requestDir, uri string) string {
+	if base == "" || uri == "" || strings.HasPrefix(uri, "/") || strings.HasPrefix(uri, "data:") || strings.Contains(uri, "://") {
+		return uri
+	}
+	uriPath, query, hasQuery := strings.Cut(uri, "?")
+	rebased := base + path.Join(requestDir, uriPath)
+	if hasQuery {
+		rebased += "?" + query
+	}
+	return rebased
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+)
+
+// discontinuitiesFile is the encoder restart sidecar written by the transcoder
+const discontinuitiesFile = ".discontinuities"
+
+// variantDiscontinuities mirrors the encoder restarts the transcoder records for
+// a variant playlist: the media sequence numbers of the segments that start with
+// EXT-X-DISCONTINUITY, and how many such segments already left the playlist
+type variantDiscontinuities struct {
+	Removed   int64   `json:"removed"`
+	Sequences []int64 `json:"sequences"`
+}
+
+// loadDiscontinuities reads the restarts of a variant playlist from the sidecar
+// of a stream, returning nil if there were none
+func (s *HLSServer) loadDiscontinuities(streamName, variant string) *variantDiscontinuities {
+	data, err := s.files.ReadFile(filepath.Join(s.hlsDir, streamName, discontinuitiesFile))
+	if err != nil {
+		return nil
+	}
+
+	var variants map[string]*variantDiscontinuities
+	if err := json.Unmarshal(data, &variants); err != nil {
+		return nil
+	}
+	return variants[variant]
+}
+
+// sequenceAt returns the EXT-X-DISCONTINUITY-SEQUENCE of a playlist starting at
+// the given media sequence number: the discontinuities before its first segment.
+// FFmpeg does not track it, so without this players lose count of the periods
+// once a discontinuity slides out of the live window.
+func (d *variantDiscontinuities) sequenceAt(mediaSequence int64) int64 {
+	count := d.Removed
+	for _, sequence := range d.Sequences {
+		if sequence < mediaSequence {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/streamforge/platform/pkg/m3u8"
+	"github.com/streamforge/platform/pkg/playback"
+)
+
+const (
+	// streamSettingsFile is the delivery settings sidecar written by the transcoder
+	streamSettingsFile = ".stream_settings"
+	// defaultLiveWindowSegments is the live playlist length when the sidecar does not say
+	defaultLiveWindowSegments = 12
+)
+
+// StreamSettings mirrors the delivery settings the transcoder writes per stream
+type StreamSettings struct {
+	SegmentDuration    int `json:"segment_duration"`
+	LiveWindowSegments int `json:"live_window_segments"`
+	DVRWindowSeconds   int `json:"dvr_window_seconds"`
+	// SignedPlayback is set for private streams, which are only served with a playback token
+	SignedPlayback bool `json:"signed_playback"`
+	// DeliveryTier selects the rate limits of the stream's viewers
+	DeliveryTier string `json:"delivery_tier"`
+	// Base URLs and query parameters playlist URIs are rewritten with, overriding the server's
+	PlaylistBaseURL string   `json:"playlist_base_url"`
+	SegmentBaseURL  string   `json:"segment_base_url"`
+	PropagateQuery  []string `json:"propagate_query"`
+}
+
+// dvrRequest describes how a viewer wants to see a stream's DVR window
+type dvrRequest struct {
+	mode        string // "live", "event" or "window"
+	startOffset float64
+	hasOffset   bool
+	start       time.Time
+}
+
+// dvrPlaylist is a variant playlist with the wall-clock time of every segment
+type dvrPlaylist struct {
+	*m3u8.MediaPlaylist
+	times []time.Time
+}
+
+// loadStreamSettings reads the settings sidecar of a stream, returning nil if there is none
+func (s *HLSServer) loadStreamSettings(streamName string) *StreamSettings {
+	data, err := s.files.ReadFile(filepath.Join(s.hlsDir, streamName, streamSettingsFile))
+	if err != nil {
+		return nil
+	}
+
+	var settings StreamSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil
+	}
+	if settings.LiveWindowSegments <= 0 {
+		settings.LiveWindowSegments = defaultLiveWindowSegments
+	}
+	return &settings
+}
+
+// dvrParams are the query parameters parseDVRRequest reads
+var dvrParams = []string{"dvr", "start_offset", "start"}
+
+// dvrQuery returns the DVR parameters of a request, to carry over onto variant playlists
+func dvrQuery(query url.Values) string {
+	carried := url.Values{}
+	for _, name := range dvrParams {
+		if values, ok := query[name]; ok {
+			carried[name] = values
+		}
+	}
+	return carried.Encode()
+}
+
+// parseDVRRequest reads the DVR query parameters:
+//
+//	dvr=live|event|window  live edge only (default), EVENT playlist of the whole window until it slides, or the whole window
+//	start_offset=<seconds> start this many seconds behind the live edge
+//	start=<RFC3339|unix>   start at the segment covering this wall-clock time
+func parseDVRRequest(query url.Values) (*dvrRequest, error) {
+	req := &dvrRequest{mode: "live"}
+
+	switch mode := query.Get("dvr"); mode {
+	case "", "live":
+	case "event", "window":
+		req.mode = mode
+	default:
+		return nil, fmt.Errorf("invalid dvr mode %q (expected live, event or window)", mode)
+	}
+
+	if raw := query.Get("start_offset"); raw != "" {
+		offset, err := strconv.ParseFloat(raw, 64)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid start_offset %q", raw)
+		}
+		req.startOffset = offset
+		req.hasOffset = true
+	}
+
+	if raw := query.Get("start"); raw != "" {
+		if t, err := time.Parse(time.RFC3339, raw); err == nil {
+			req.start = t
+		} else if unix, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			req.start = time.Unix(unix, 0)
+		} else {
+			return nil, fmt.Errorf("invalid start %q (expected RFC3339 or unix seconds)", raw)
+		}
+	}
+
+	if req.hasOffset && !req.start.IsZero() {
+		return nil, fmt.Errorf("start and start_offset cannot be combined")
+	}
+	return req, nil
+}
+
+// ServePlaylist serves a playlist of a stream, trimming variant playlists of DVR
+// streams to the window the viewer asked for, marking the ad breaks of the
+// current session, numbering the discontinuities left by encoder restarts,
+// ending the variant playlists of streams that stopped and rebasing URIs onto
+// the configured CDN base URLs. Other playlists are served unchanged.
+func (s *HLSServer) ServePlaylist(c *gin.Context, fullPath, cleanPath string) {
+	streamName := streamOf(cleanPath)
+	settings := s.loadStreamSettings(streamName)
+	dvr := settings != nil && settings.DVRWindowSeconds > 0
+	master := filepath.Base(fullPath) == "master.m3u8"
+
+	var cues []AdCue
+	var discontinuities *variantDiscontinuities
+	ended := false
+	if !master {
+		cues = s.loadAdCues(streamName)
+		discontinuities = s.loadDiscontinuities(streamName, filepath.Base(filepath.Dir(fullPath)))
+		// An encoder killed before it could end its playlists leaves them looking live
+		state, _ := s.endedState(streamName)
+		ended = state != nil
+	}
+	token := c.Query(playback.TokenParam)
+
+	// URIs are rebased as the viewer sees the playlist, under its playback ID
+	delivery := s.deliveryURLsOf(settings)
+	requestDir := path.Dir(path.Clean(c.Param("filepath")))
+	base := delivery.SegmentBase
+	if master {
+		base = delivery.PlaylistBase
+	}
+	propagated := delivery.propagatedQuery(c.Request.URL.Query())
+	rebaseURI := func(uri string) string { return rebase(base, requestDir, uri) }
+
+	if !dvr && len(cues) == 0 && discontinuities == nil && !ended && token == "" && base == "" && propagated == "" {
+		file, err := s.files.Open(fullPath)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		s.serveFile(c, fullPath, file)
+		return
+	}
+
+	req := &dvrRequest{mode: "window"}
+	if dvr {
+		var err error
+		if req, err = parseDVRRequest(c.Request.URL.Query()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	data, err := s.files.ReadFile(fullPath)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	var body []byte
+	if master {
+		playlist, err := m3u8.ParseMaster(data)
+		if err != nil {
+			c.Data(http.StatusOK, "application/vnd.apple.mpegurl", data)
+			return
+		}
+		if base != "" {
+			playlist.RewriteURIs(rebaseURI)
+		}
+		// Variant playlists are requested with the same DVR parameters, token
+		// and carried-over parameters; anything else the viewer added is dropped
+		if dvr {
+			playlist.AppendQuery(dvrQuery(c.Request.URL.Query()))
+		}
+		if token != "" {
+			playlist.AppendQuery(playback.TokenQuery(token))
+		}
+		if propagated != "" {
+			playlist.AppendQuery(propagated)
+		}
+		body = playlist.Encode()
+	} else {
+		parsed, err := m3u8.ParseMedia(data)
+		if err != nil {
+			c.Data(http.StatusOK, "application/vnd.apple.mpegurl", data)
+			return
+		}
+		if base != "" {
+			parsed.RewriteURIs(rebaseURI)
+		}
+		if token != "" {
+			parsed.AppendQuery(playback.TokenQuery(token))
+		}
+		if propagated != "" {
+			parsed.AppendQuery(propagated)
+		}
+		if ended {
+			parsed.Ended = true
+		}
+		playlist := newDVRPlaylist(parsed)
+		if discontinuities != nil {
+			playlist.DiscontinuitySequence = discontinuities.sequenceAt(playlist.MediaSequence)
+		}
+		from := 0
+		if dvr {
+			from = playlist.startIndex(req, settings)
+		}
+		if req.mode == "event" {
+			// EVENT playlists may only grow, so the start position is signalled
+			// instead of trimmed. FFmpeg deletes the oldest segments once the
+			// window is full, so from then on it is served without a type and
+			// the start position is counted back from the live edge.
+			event := !playlist.slid(settings)
+			startOffset := playlist.offsetOf(from)
+			if !event && from > 0 {
+				startOffset -= playlist.offsetOf(len(playlist.Segments))
+			}
+			playlist.markAdCues(0, s.alignAdCues(cues, playlist))
+			body = playlist.render(0, event, startOffset)
+		} else {
+			playlist.markAdCues(from, s.alignAdCues(cues, playlist))
+			body = playlist.render(from, false, 0)
+		}
+	}
+
+	c.Data(http.StatusOK, "application/vnd.apple.mpegurl", body)
+}
+
+// newDVRPlaylist places the segments of a variant playlist on the wall clock
+func newDVRPlaylist(playlist *m3u8.MediaPlaylist) *dvrPlaylist {
+	return &dvrPlaylist{MediaPlaylist: playlist, times: playlist.ProgramDateTimes()}
+}
+
+// startIndex returns the index of the first segment the viewer should receive
+func (p *dvrPlaylist) startIndex(req *dvrRequest, settings *StreamSettings) int {
+	switch {
+	case req.hasOffset:
+		// Walk back from the live edge until the offset is covered
+		elapsed := 0.0
+		for i := len(p.Segments) - 1; i >= 0; i-- {
+			elapsed += p.Segments[i].Duration
+			if elapsed >= req.startOffset {
+				return i
+			}
+		}
+		return 0
+	case !req.start.IsZero():
+		for i, segment := range p.Segments {
+			if p.times[i].IsZero() {
+				continue
+			}
+			end := p.times[i].Add(time.Duration(segment.Duration * float64(time.Second)))
+			if end.After(req.start) {
+				return i
+			}
+		}
+		// Past the live edge, start with the newest segment
+		if len(p.Segments) > 0 {
+			return len(p.Segments) - 1
+		}
+		return 0
+	case req.mode == "event" || req.mode == "window":
+		return 0
+	default:
+		if start := len(p.Segments) - settings.LiveWindowSegments; start > 0 {
+			return start
+		}
+		return 0
+	}
+}
+
+// slid reports whether the playlist may have lost segments from its start.
+// FFmpeg keeps as many segments as cover the DVR window, or the live window if
+// that is longer, and drops the oldest once the playlist holds that many.
+func (p *dvrPlaylist) slid(settings *StreamSettings) bool {
+	kept := settings.LiveWindowSegments
+	if settings.SegmentDuration > 0 {
+		window := (settings.DVRWindowSeconds + settings.SegmentDuration - 1) / settings.SegmentDuration
+		if window > kept {
+			kept = window
+		}
+	}
+	return len(p.Segments) >= kept
+}
+
+// offsetOf returns the playback time at which a segment starts, in seconds
+func (p *dvrPlaylist) offsetOf(index int) float64 {
+	offset := 0.0
+	for _, segment := range p.Segments[:index] {
+		offset += segment.Duration
+	}
+	return offset
+}
+
+// render writes the playlist starting at the given segment, adjusting the media
+// and discontinuity sequence numbers for the segments that were dropped. A
+// non-zero startOffset is written as EXT-X-START for players to seek to,
+// negative offsets counting back from the end of the playlist.
+func (p *dvrPlaylist) render(from int, event bool, startOffset float64) []byte {
+	// The first segment must carry its wall clock so players can map DVR positions
+	if from < len(p.Segments) && p.Segments[from].ProgramDateTime.IsZero() {
+		p.Segments[from].ProgramDateTime = p.times[from]
+	}
+	p.TrimFront(from)
+
+	p.PlaylistType = ""
+	if event {
+		p.PlaylistType = m3u8.PlaylistTypeEvent
+	}
+	if startOffset != 0 {
+		p.Start = &m3u8.Start{TimeOffset: startOffset, Precise: true}
+	}
+	return p.Encode()
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/streamforge/platform/pkg/access"
+	"github.com/streamforge/platform/pkg/playback"
+)
+
+const (
+	// originTimeout bounds a fetch from an origin, including the body
+	originTimeout = 10 * time.Second
+	// originCooldown is how long an origin that failed is tried only after the others
+	originCooldown = 10 * time.Second
+	// defaultEdgeRetention is how long an edge keeps files nobody asked for
+	defaultEdgeRetention = 10 * time.Minute
+	// edgeSweepInterval is how often an edge drops files past their retention
+	edgeSweepInterval = time.Minute
+)
+
+// errOriginUnavailable is returned when no origin could be reached for a file the edge does not have
+var errOriginUnavailable = errors.New("origin unavailable")
+
+// streamSidecars are the sidecars of a stream an edge keeps up to date, so
+// playback tokens, access rules, DVR windows and ad breaks work as on the origin
+var streamSidecars = []string{streamSettingsFile, access.RulesFile, adCuesFile, discontinuitiesFile, streamEndedFile}
+
+// EdgeTTLs are how long an edge serves each type of file before asking the
+// origin again. Segment names are never reused, so they can be kept long;
+// playlists change with every segment.
+type EdgeTTLs struct {
+	Playlist time.Duration `json:"playlist"`
+	Segment  time.Duration `json:"segment"`
+	Sidecar  time.Duration `json:"sidecar"`
+	Default  time.Duration `json:"default"`
+	Missing  time.Duration `json:"missing"` // files the origin does not have, and retries while it is unreachable
+}
+
+// defaultEdgeTTLs are the TTLs of files EDGE_TTLS does not override
+var defaultEdgeTTLs = EdgeTTLs{
+	Playlist: time.Second,
+	Segment:  24 * time.Hour,
+	Sidecar:  2 * time.Second,
+	Default:  10 * time.Second,
+	Missing:  time.Second,
+}
+
+// parseEdgeTTLs reads TTL overrides such as "playlist=2s,segment=1h"
+func parseEdgeTTLs(spec string) (EdgeTTLs, error) {
+	ttls := defaultEdgeTTLs
+	fields := map[string]*time.Duration{
+		"playlist": &ttls.Playlist,
+		"segment":  &ttls.Segment,
+		"sidecar":  &ttls.Sidecar,
+		"default":  &ttls.Default,
+		"missing":  &ttls.Missing,
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(entry, "=")
+		field, ok := fields[strings.TrimSpace(name)]
+		if !ok {
+			return ttls, fmt.Errorf("unknown file type %q (expected playlist, segment, sidecar, default or missing)", name)
+		}
+		ttl, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || ttl < 0 {
+			return ttls, fmt.Errorf("invalid TTL %q for %s", value, name)
+		}
+		*field = ttl
+	}
+	return ttls, nil
+}
+
+// ttlOf returns the TTL of a file by its type
+func (t EdgeTTLs) ttlOf(rel string) time.Duration {
+	name := path.Base(rel)
+	switch {
+	case strings.HasPrefix(name, "."):
+		return t.Sidecar
+	case strings.HasSuffix(name, ".m3u8"):
+		return t.Playlist
+	}
+	switch path.Ext(name) {
+	case ".ts", ".m4s", ".aac":
+		return t.Segment
+	}
+	return t.Default
+}
+
+// edgeOrigin is an origin an edge pulls from, and how it has been answering
+type edgeOrigin struct {
+	url       string
+	downUntil atomic.Int64 // unix nanoseconds until which the origin is tried last
+	failures  atomic.Uint64
+	lastError atomic.Pointer[string]
+}
+
+// edgeEntry is what an edge knows of a file it pulled
+type edgeEntry struct {
+	etag     string
+	missing  bool         // the origin does not have the file
+	failed   bool         // no origin could be reached and there is no copy
+	expires  atomic.Int64 // unix nanoseconds after which the origin is asked again
+	lastUsed atomic.Int64 // unix nanoseconds
+}
+
+// edgePull is a fetch in flight, which concurrent requests for the same file wait on
+type edgePull struct {
+	done chan struct{}
+	err  error
+}
+
+// Edge mirrors the HLS directory of one or more origins on demand. Files are
+// pulled into the local HLS directory the first time they are asked for, and
+// revalidated with their ETag once their TTL expires, so everything else serves
+// them as if the volume were shared. Concurrent misses for a file are collapsed
+// into one fetch, origins that fail are tried after the others, and a stale
+// copy is served while no origin can be reached.
+type Edge struct {
+	hlsDir    string
+	origins   []*edgeOrigin
+	secret    string
+	ttls      EdgeTTLs
+	retention time.Duration
+	client    *http.Client
+
+	entries sync.Map // slash-separated path relative to the HLS directory -> *edgeEntry
+	pulls   sync.Map // path -> *edgePull
+
+	hits          atomic.Uint64
+	fetches       atomic.Uint64
+	revalidations atomic.Uint64
+	collapsed     atomic.Uint64
+	notFound      atomic.Uint64
+	failovers     atomic.Uint64
+	stale         atomic.Uint64
+	unavailable   atomic.Uint64
+}
+
+// NewEdge returns an edge pulling from origins, given as base URLs of their HLS
+// servers, into an HLS directory
+func NewEdge(hlsDir string, origins []string, secret string, ttls EdgeTTLs, retention time.Duration) (*Edge, error) {
+	edge := &Edge{
+		hlsDir:    hlsDir,
+		secret:    secret,
+		ttls:      ttls,
+		retention: retention,
+		client:    &http.Client{Timeout: originTimeout},
+	}
+	for _, origin := range origins {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		parsed, err := url.Parse(origin)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid origin %q", origin)
+		}
+		edge.origins = append(edge.origins, &edgeOrigin{url: origin})
+	}
+	if len(edge.origins) == 0 {
+		return nil, errors.New("no origins")
+	}
+	go edge.sweep()
+	return edge, nil
+}
+
+// Pull brings a file up to date from the origins unless a fresh copy is
+// cached. It returns os.ErrNotExist when the origins do not have the file.
+func (e *Edge) Pull(rel string) error {
+	now := time.Now().UnixNano()
+	var previous *edgeEntry
+	if value, ok := e.entries.Load(rel); ok {
+		previous = value.(*edgeEntry)
+		previous.lastUsed.Store(now)
+		if now < previous.expires.Load() {
+			e.hits.Add(1)
+			switch {
+			case previous.missing:
+				return os.ErrNotExist
+			case previous.failed:
+				return errOriginUnavailable
+			}
+			return nil
+		}
+	}
+
+	pull := &edgePull{done: make(chan struct{})}
+	if inFlight, loaded := e.pulls.LoadOrStore(rel, pull); loaded {
+		e.collapsed.Add(1)
+		<-inFlight.(*edgePull).done
+		return inFlight.(*edgePull).err
+	}
+	pull.err = e.fetch(rel, previous)
+	e.pulls.Delete(rel)
+	close(pull.done)
+	return pull.err
+}
+
+// candidates returns the origins in the order to try them: as configured, with
+// those that failed recently last
+func (e *Edge) candidates() []*edgeOrigin {
+	now := time.Now().UnixNano()
+	candidates := make([]*edgeOrigin, 0, len(e.origins))
+	var down []*edgeOrigin
+	for _, origin := range e.origins {
+		if now < origin.downUntil.Load() {
+			down = append(down, origin)
+		} else {
+			candidates = append(candidates, origin)
+		}
+	}
+	return append(candidates, down...)
+}
+
+// fetch asks the origins for a file in turn until one answers
+func (e *Edge) fetch(rel string, previous *edgeEntry) error {
+	local := filepath.Join(e.hlsDir, filepath.FromSlash(rel))
+	etag := ""
+	if previous != nil && !previous.missing && !previous.failed {
+		if _, err := os.Stat(local); err == nil {
+			etag = previous.etag
+		}
+	}
+
+	var lastErr error
+	for i, origin := range e.candidates() {
+		if i > 0 {
+			e.failovers.Add(1)
+		}
+		status, newETag, err := e.fetchFrom(origin, rel, local, etag)
+		if err != nil {
+			message := err.Error()
+			origin.lastError.Store(&message)
+			origin.failures.Add(1)
+			origin.downUntil.Store(time.Now().Add(originCooldown).UnixNano())
+			log.Printf("⚠️  Origin %s failed for %s: %v", origin.url, rel, err)
+			lastErr = err
+			continue
+		}
+		origin.downUntil.Store(0)
+
+		now := time.Now()
+		switch status {
+		case http.StatusNotModified:
+			e.revalidations.Add(1)
+			previous.expires.Store(now.Add(e.ttls.ttlOf(rel)).UnixNano())
+			return nil
+		case http.StatusNotFound:
+			e.notFound.Add(1)
+			os.Remove(local)
+			e.store(rel, &edgeEntry{missing: true}, now, e.ttls.Missing)
+			return os.ErrNotExist
+		default:
+			e.fetches.Add(1)
+			e.store(rel, &edgeEntry{etag: newETag}, now, e.ttls.ttlOf(rel))
+			return nil
+		}
+	}
+
+	// Keep serving what we have until an origin is back
+	if _, err := os.Stat(local); err == nil {
+		e.stale.Add(1)
+		entry := &edgeEntry{}
+		if previous != nil {
+			entry.etag = previous.etag
+		}
+		e.store(rel, entry, time.Now(), e.ttls.Missing)
+		return nil
+	}
+	// Origins are not asked again for the file until it expires
+	e.unavailable.Add(1)
+	e.store(rel, &edgeEntry{failed: true}, time.Now(), e.ttls.Missing)
+	return fmt.Errorf("%w: %v", errOriginUnavailable, lastErr)
+}
+
+// store records what is known of a file until its TTL expires
+func (e *Edge) store(rel string, entry *edgeEntry, now time.Time, ttl time.Duration) {
+	entry.expires.Store(now.Add(ttl).UnixNano())
+	entry.lastUsed.Store(now.UnixNano())
+	e.entries.Store(rel, entry)
+}
+
+// fetchFrom asks one origin for a file, revalidating the local copy when there
+// is an ETag, and writes a new copy into place atomically. Errors are only
+// returned for answers another origin might do better on.
+func (e *Edge) fetchFrom(origin *edgeOrigin, rel, local, etag string) (int, string, error) {
+	request, err := http.NewRequest(http.MethodGet, origin.url+"/origin/"+(&url.URL{Path: rel}).EscapedPath(), nil)
+	if err != nil {
+		return 0, "", err
+	}
+	request.Header.Set("Authorization", "Bearer "+e.secret)
+	if etag != "" {
+		request.Header.Set("If-None-Match", etag)
+	}
+	response, err := e.client.Do(request)
+	if err != nil {
+		return 0, "", err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified, http.StatusNotFound:
+		return response.StatusCode, "", nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		// A misconfigured origin says nothing about whether the file exists
+		return 0, "", fmt.Errorf("origin refused the secret: %s", response.Status)
+	default:
+		return 0, "", fmt.Errorf("origin answered %s", response.Status)
+	}
+
+	dir := filepath.Dir(local)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, "", err
+	}
+	tmp, err := os.CreateTemp(dir, ".pull-*")
+	if err != nil {
+		return 0, "", err
+	}
+	_, err = io.Copy(tmp, response.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), local)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, "", err
+	}
+	return http.StatusOK, response.Header.Get("ETag"), nil
+}
+
+// sweep drops the files nobody asked for within the retention
+func (e *Edge) sweep() {
+	ticker := time.NewTicker(edgeSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cutoff := time.Now().Add(-e.retention).UnixNano()
+		e.entries.Range(func(key, value any) bool {
+			if value.(*edgeEntry).lastUsed.Load() < cutoff {
+				e.entries.Delete(key)
+				os.Remove(filepath.Join(e.hlsDir, filepath.FromSlash(key.(string))))
+			}
+			return true
+		})
+	}
+}
+
+// stats reports the origins and how the edge has been answering requests
+func (e *Edge) stats() gin.H {
+	files := 0
+	e.entries.Range(func(_, _ any) bool {
+		files++
+		return true
+	})
+	now := time.Now().UnixNano()
+	origins := []gin.H{}
+	for _, origin := range e.origins {
+		lastError := ""
+		if message := origin.lastError.Load(); message != nil {
+			lastError = *message
+		}
+		origins = append(origins, gin.H{
+			"url":        origin.url,
+			"healthy":    now >= origin.downUntil.Load(),
+			"failures":   origin.failures.Load(),
+			"last_error": lastError,
+		})
+	}
+	return gin.H{
+		"origins":       origins,
+		"ttls":          gin.H{"playlist": e.ttls.Playlist.String(), "segment": e.ttls.Segment.String(), "sidecar": e.ttls.Sidecar.String(), "default": e.ttls.Default.String(), "missing": e.ttls.Missing.String()},
+		"retention":     e.retention.String(),
+		"files":         files,
+		"hits":          e.hits.Load(),
+		"fetches":       e.fetches.Load(),
+		"revalidations": e.revalidations.Load(),
+		"collapsed":     e.collapsed.Load(),
+		"not_found":     e.notFound.Load(),
+		"failovers":     e.failovers.Load(),
+		"stale":         e.stale.Load(),
+		"unavailable":   e.unavailable.Load(),
+	}
+}
+
+// pullStream brings the playback ID index and the sidecars of the stream a
+// request is for up to date on edges. Sidecars the origins do not have are
+// removed, and ones that cannot be refreshed stay as they were.
+func (s *HLSServer) pullStream(name string) {
+	if s.edge == nil {
+		return
+	}
+	s.edge.Pull(playback.IndexFile)
+	directory, ok := s.streamDirectory(name)
+	if !ok {
+		return
+	}
+	for _, sidecar := range streamSidecars {
+		s.edge.Pull(path.Join(directory, sidecar))
+	}
+}
+
+// pullFile brings a file of a stream up to date on edges, writing a 404 or 502
+// response and returning false when it cannot be served
+func (s *HLSServer) pullFile(c *gin.Context, cleanPath string) bool {
+	if s.edge == nil {
+		return true
+	}
+	err := s.edge.Pull(strings.TrimPrefix(filepath.ToSlash(cleanPath), "/"))
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, os.ErrNotExist):
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+	default:
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Origin unavailable"})
+	}
+	return false
+}
+
+// ServeOriginFile serves the raw files of the HLS directory, sidecars
+// included, to edges presenting the origin secret. Edges without it are
+// refused with 401 or 403, never 404, so they do not take files for missing.
+func (s *HLSServer) ServeOriginFile(c *gin.Context) {
+	authorization := c.GetHeader("Authorization")
+	if authorization == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Origin secret required"})
+		return
+	}
+	if s.originSecret == "" || subtle.ConstantTimeCompare([]byte(authorization), []byte("Bearer "+s.originSecret)) != 1 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid origin secret"})
+		return
+	}
+
+	cleanPath := filepath.Clean(c.Param("filepath"))
+	if strings.Contains(cleanPath, "..") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file path"})
+		return
+	}
+	fullPath := filepath.Join(s.hlsDir, cleanPath)
+	if info, err := os.Stat(fullPath); err != nil || !info.Mode().IsRegular() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	file, err := s.files.Open(fullPath)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	s.serveFile(c, fullPath, file)
+}
+
+// EdgeStats reports the origins of an edge and how it has been answering requests
+func (s *HLSServer) EdgeStats(c *gin.Context) {
+	if s.edge == nil {
+		c.JSON(http.StatusOK, gin.H{"status": "success", "enabled": false})
+		return
+	}
+	stats := s.edge.stats()
+	stats["status"] = "success"
+	stats["enabled"] = true
+	c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testOriginSecret = "origin-secret"
+
+// testOrigin is an origin HLS server counting the requests edges make to it
+type testOrigin struct {
+	*httptest.Server
+	dir string
+
+	mutex       sync.Mutex
+	requests    map[string]int
+	notModified atomic.Int64
+	// gate holds requests until it is closed, when set
+	gate chan struct{}
+	// failing makes every request answer 500
+	failing atomic.Bool
+}
+
+func newTestOrigin(t *testing.T) *testOrigin {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	origin := &testOrigin{dir: t.TempDir(), requests: map[string]int{}}
+	server := NewHLSServer(origin.dir)
+	server.originSecret = testOriginSecret
+	router := gin.New()
+	router.GET("/origin/*filepath", server.ServeOriginFile)
+
+	origin.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin.mutex.Lock()
+		origin.requests[r.URL.Path]++
+		gate := origin.gate
+		origin.mutex.Unlock()
+		if gate != nil {
+			<-gate
+		}
+		if origin.failing.Load() {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		recorder := &statusRecorder{ResponseWriter: w}
+		router.ServeHTTP(recorder, r)
+		if recorder.status == http.StatusNotModified {
+			origin.notModified.Add(1)
+		}
+	}))
+	t.Cleanup(origin.Close)
+	return origin
+}
+
+// write puts a file into the HLS directory of the origin
+func (o *testOrigin) write(t *testing.T, rel, content string) {
+	t.Helper()
+	full := filepath.Join(o.dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// requestsFor returns how many times edges asked for a file
+func (o *testOrigin) requestsFor(rel string) int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.requests["/origin/"+rel]
+}
+
+// statusRecorder remembers the status a handler answered with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func newTestEdge(t *testing.T, ttls EdgeTTLs, origins ...string) *Edge {
+	t.Helper()
+	edge, err := NewEdge(t.TempDir(), origins, testOriginSecret, ttls, defaultEdgeRetention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return edge
+}
+
+// readEdgeFile returns the local copy of a file an edge pulled
+func readEdgeFile(t *testing.T, edge *Edge, rel string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(edge.hlsDir, filepath.FromSlash(rel)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestEdgeCollapsesConcurrentPulls(t *testing.T) {
+	origin := newTestOrigin(t)
+	origin.write(t, "s1/720p/segment1.ts", "segment one")
+	origin.gate = make(chan struct{})
+	edge := newTestEdge(t, defaultEdgeTTLs, origin.URL)
+
+	const viewers = 10
+	errs := make(chan error, viewers)
+	for i := 0; i < viewers; i++ {
+		go func() { errs <- edge.Pull("s1/720p/segment1.ts") }()
+	}
+
+	// Every pull but the first waits on the one in flight
+	deadline := time.Now().Add(5 * time.Second)
+	for edge.collapsed.Load() < viewers-1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d pulls collapsed", edge.collapsed.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(origin.gate)
+	for i := 0; i < viewers; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Pull: %v", err)
+		}
+	}
+
+	if got := origin.requestsFor("s1/720p/segment1.ts"); got != 1 {
+		t.Errorf("origin asked %d times, want 1", got)
+	}
+	if got := edge.fetches.Load(); got != 1 {
+		t.Errorf("fetches = %d, want 1", got)
+	}
+	if got := readEdgeFile(t, edge, "s1/720p/segment1.ts"); got != "segment one" {
+		t.Errorf("edge copy = %q", got)
+	}
+}
+
+func TestEdgeTTLsAndRevalidation(t *testing.T) {
+	origin := newTestOrigin(t)
+	origin.write(t, "s1/720p/playlist.m3u8", "#EXTM3U\n")
+	origin.write(t, "s1/720p/segment1.ts", "segment one")
+	ttls := defaultEdgeTTLs
+	ttls.Playlist = 50 * time.Millisecond
+	ttls.Segment = time.Hour
+	edge := newTestEdge(t, ttls, origin.URL)
+
+	for i := 0; i < 3; i++ {
+		for _, rel := range []string{"s1/720p/playlist.m3u8", "s1/720p/segment1.ts"} {
+			if err := edge.Pull(rel); err != nil {
+				t.Fatalf("Pull(%s): %v", rel, err)
+			}
+		}
+	}
+	if got := origin.requestsFor("s1/720p/playlist.m3u8"); got != 1 {
+		t.Errorf("playlist fetched %d times within its TTL, want 1", got)
+	}
+	if got := edge.hits.Load(); got != 4 {
+		t.Errorf("hits = %d, want 4", got)
+	}
+
+	// An unchanged playlist is revalidated with its ETag once the TTL expires
+	time.Sleep(2 * ttls.Playlist)
+	if err := edge.Pull("s1/720p/playlist.m3u8"); err != nil {
+		t.Fatal(err)
+	}
+	if got := origin.notModified.Load(); got != 1 {
+		t.Errorf("origin answered 304 %d times, want 1", got)
+	}
+	if got := edge.revalidations.Load(); got != 1 {
+		t.Errorf("revalidations = %d, want 1", got)
+	}
+
+	// A changed playlist is fetched again once the TTL expires
+	origin.write(t, "s1/720p/playlist.m3u8", "#EXTM3U\n#EXT-X-VERSION:3\n")
+	if err := edge.Pull("s1/720p/playlist.m3u8"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readEdgeFile(t, edge, "s1/720p/playlist.m3u8"); got != "#EXTM3U\n" {
+		t.Errorf("playlist refreshed within its TTL: %q", got)
+	}
+	time.Sleep(2 * ttls.Playlist)
+	if err := edge.Pull("s1/720p/playlist.m3u8"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readEdgeFile(t, edge, "s1/720p/playlist.m3u8"); got != "#EXTM3U\n#EXT-X-VERSION:3\n" {
+		t.Errorf("playlist not refreshed after its TTL: %q", got)
+	}
+
+	// Segments outlive the playlist TTL
+	if err := edge.Pull("s1/720p/segment1.ts"); err != nil {
+		t.Fatal(err)
+	}
+	if got := origin.requestsFor("s1/720p/segment1.ts"); got != 1 {
+		t.Errorf("segment fetched %d times, want 1", got)
+	}
+}
+
+func TestEdgeFailsOverBetweenOrigins(t *testing.T) {
+	broken := newTestOrigin(t)
+	broken.failing.Store(true)
+	healthy := newTestOrigin(t)
+	healthy.write(t, "s1/720p/segment1.ts", "segment one")
+	healthy.write(t, "s1/720p/segment2.ts", "segment two")
+	edge := newTestEdge(t, defaultEdgeTTLs, broken.URL, healthy.URL)
+
+	if err := edge.Pull("s1/720p/segment1.ts"); err != nil {
+		t.Fatalf("Pull: %v", err)
+	}
+	if got := edge.failovers.Load(); got != 1 {
+		t.Errorf("failovers = %d, want 1", got)
+	}
+	if got := edge.origins[0].failures.Load(); got != 1 {
+		t.Errorf("failures of the broken origin = %d, want 1", got)
+	}
+	if got := readEdgeFile(t, edge, "s1/720p/segment1.ts"); got != "segment one" {
+		t.Errorf("edge copy = %q", got)
+	}
+
+	// The broken origin is tried last while it cools down
+	if err := edge.Pull("s1/720p/segment2.ts"); err != nil {
+		t.Fatalf("Pull: %v", err)
+	}
+	if got := broken.requestsFor("s1/720p/segment2.ts"); got != 0 {
+		t.Errorf("broken origin asked %d times during its cooldown, want 0", got)
+	}
+	if got := edge.failovers.Load(); got != 1 {
+		t.Errorf("failovers = %d, want still 1", got)
+	}
+}
+
+func TestEdgeServesStaleWhileOriginsAreDown(t *testing.T) {
+	origin := newTestOrigin(t)
+	origin.write(t, "s1/720p/playlist.m3u8", "#EXTM3U\n")
+	ttls := defaultEdgeTTLs
+	ttls.Playlist = 10 * time.Millisecond
+	ttls.Missing = 10 * time.Millisecond
+	edge := newTestEdge(t, ttls, origin.URL)
+
+	if err := edge.Pull("s1/720p/playlist.m3u8"); err != nil {
+		t.Fatal(err)
+	}
+	origin.failing.Store(true)
+	time.Sleep(2 * ttls.Playlist)
+
+	if err := edge.Pull("s1/720p/playlist.m3u8"); err != nil {
+		t.Fatalf("Pull with a local copy: %v", err)
+	}
+	if got := edge.stale.Load(); got != 1 {
+		t.Errorf("stale = %d, want 1", got)
+	}
+	if got := readEdgeFile(t, edge, "s1/720p/playlist.m3u8"); got != "#EXTM3U\n" {
+		t.Errorf("stale copy = %q", got)
+	}
+
+	// Files never pulled cannot be served until an origin is back
+	if err := edge.Pull("s1/720p/segment1.ts"); !errors.Is(err, errOriginUnavailable) {
+		t.Errorf("Pull without a local copy = %v, want %v", err, errOriginUnavailable)
+	}
+	if err := edge.Pull("s1/720p/segment1.ts"); !errors.Is(err, errOriginUnavailable) {
+		t.Errorf("cached failure = %v, want %v", err, errOriginUnavailable)
+	}
+	if got := origin.requestsFor("s1/720p/segment1.ts"); got != 1 {
+		t.Errorf("origin asked %d times within the missing TTL, want 1", got)
+	}
+}
+
+func TestEdgeMissingFiles(t *testing.T) {
+	origin := newTestOrigin(t)
+	origin.write(t, "s1/720p/segment1.ts", "segment one")
+	edge := newTestEdge(t, defaultEdgeTTLs, origin.URL)
+
+	for i := 0; i < 2; i++ {
+		if err := edge.Pull("s1/720p/segment9.ts"); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Pull = %v, want %v", err, os.ErrNotExist)
+		}
+	}
+	if got := origin.requestsFor("s1/720p/segment9.ts"); got != 1 {
+		t.Errorf("origin asked %d times within the missing TTL, want 1", got)
+	}
+
+	// Origins refuse edges presenting the wrong secret, which must not pass for a missing file
+	stranger, err := NewEdge(t.TempDir(), []string{origin.URL}, "wrong", defaultEdgeTTLs, defaultEdgeRetention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stranger.Pull("s1/720p/segment1.ts"); !errors.Is(err, errOriginUnavailable) {
+		t.Errorf("Pull with the wrong secret = %v, want %v", err, errOriginUnavailable)
+	}
+	if got := stranger.notFound.Load(); got != 0 {
+		t.Errorf("not found = %d with the wrong secret, want 0", got)
+	}
+}
+
+func TestOriginRefusesEdgesWithoutTheSecret(t *testing.T) {
+	origin := newTestOrigin(t)
+	origin.write(t, "s1/720p/segment1.ts", "segment one")
+
+	for authorization, want := range map[string]int{
+		"":                           http.StatusUnauthorized,
+		"Bearer wrong":               http.StatusForbidden,
+		"Bearer " + testOriginSecret: http.StatusOK,
+	} {
+		request, err := http.NewRequest(http.MethodGet, origin.URL+"/origin/s1/720p/segment1.ts", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != want {
+			t.Errorf("Authorization %q = %d, want %d", authorization, response.StatusCode, want)
+		}
+	}
+}
+
+// newServer runs an HLS server with the routes main sets up for viewers and edges
+func newServer(t *testing.T, server *HLSServer) *httptest.Server {
+	t.Helper()
+	router := gin.New()
+	router.GET("/hls/*filepath", server.ServeHLSFile)
+	router.GET("/origin/*filepath", server.ServeOriginFile)
+	httpServer := httptest.NewServer(router)
+	t.Cleanup(httpServer.Close)
+	return httpServer
+}
+
+// getBody requests a URL and returns the status and body of the answer
+func getBody(t *testing.T, url string) (int, string) {
+	t.Helper()
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.StatusCode, string(body)
+}
+
+func TestEdgeServerPullsFromOriginServers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// The first origin was set up with another secret, the second one matches the edge's
+	misconfigured := NewHLSServer(t.TempDir())
+	misconfigured.originSecret = "another-secret"
+	origin := NewHLSServer(t.TempDir())
+	origin.originSecret = testOriginSecret
+	for _, server := range []*HLSServer{misconfigured, origin} {
+		for rel, content := range map[string]string{
+			"s1/720p/playlist.m3u8": "#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXTINF:2.000,\nsegment1.ts\n",
+			"s1/720p/segment1.ts":   "segment one",
+		} {
+			full := filepath.Join(server.hlsDir, filepath.FromSlash(rel))
+			if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	misconfiguredURL := newServer(t, misconfigured).URL
+	originURL := newServer(t, origin).URL
+
+	edgeDir := t.TempDir()
+	edge := NewHLSServer(edgeDir)
+	var err error
+	if edge.edge, err = NewEdge(edgeDir, []string{misconfiguredURL, originURL}, testOriginSecret, defaultEdgeTTLs, defaultEdgeRetention); err != nil {
+		t.Fatal(err)
+	}
+	edgeURL := newServer(t, edge).URL
+
+	// The origin refusing the secret is failed over, not taken to lack the file
+	if status, body := getBody(t, edgeURL+"/hls/s1/720p/segment1.ts"); status != http.StatusOK || body != "segment one" {
+		t.Errorf("GET segment through the edge = %d %q", status, body)
+	}
+	if got := edge.edge.failovers.Load(); got != 1 {
+		t.Errorf("failovers = %d, want 1", got)
+	}
+	if got := edge.edge.origins[0].failures.Load(); got < 1 {
+		t.Errorf("failures of the misconfigured origin = %d, want at least 1", got)
+	}
+	if status, _ := getBody(t, edgeURL+"/hls/s1/720p/playlist.m3u8"); status != http.StatusOK {
+		t.Errorf("GET playlist through the edge = %d", status)
+	}
+	if status, _ := getBody(t, edgeURL+"/hls/s1/720p/segment9.ts"); status != http.StatusNotFound {
+		t.Errorf("GET missing segment through the edge = %d, want %d", status, http.StatusNotFound)
+	}
+
+	// An edge every origin refuses answers 502 rather than caching a 404
+	strangerDir := t.TempDir()
+	stranger := NewHLSServer(strangerDir)
+	if stranger.edge, err = NewEdge(strangerDir, []string{misconfiguredURL, originURL}, "wrong", defaultEdgeTTLs, defaultEdgeRetention); err != nil {
+		t.Fatal(err)
+	}
+	strangerURL := newServer(t, stranger).URL
+	if status, _ := getBody(t, strangerURL+"/hls/s1/720p/segment1.ts"); status != http.StatusBadGateway {
+		t.Errorf("GET through an edge with the wrong secret = %d, want %d", status, http.StatusBadGateway)
+	}
+	if got := stranger.edge.notFound.Load(); got != 0 {
+		t.Errorf("not found = %d with the wrong secret, want 0", got)
+	}
+}
+
+func TestEdgeServesPulledFiles(t *testing.T) {
+	origin := newTestOrigin(t)
+	origin.write(t, "s1/720p/playlist.m3u8", "#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXTINF:2.000,\nsegment1.ts\n")
+	origin.write(t, "s1/720p/segment1.ts", "segment one")
+
+	server := NewHLSServer(t.TempDir())
+	server.edge = newTestEdge(t, defaultEdgeTTLs, origin.URL)
+	server.edge.hlsDir = server.hlsDir
+	router := gin.New()
+	router.GET("/hls/*filepath", server.ServeHLSFile)
+
+	for rel, want := range map[string]int{
+		"s1/720p/segment1.ts": http.StatusOK,
+		"s1/720p/segment9.ts": http.StatusNotFound,
+	} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/hls/"+rel, nil))
+		if recorder.Code != want {
+			t.Errorf("GET %s = %d, want %d", rel, recorder.Code, want)
+		}
+	}
+}
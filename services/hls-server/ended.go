@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/streamforge/platform/pkg/m3u8"
+)
+
+const (
+	// streamEndedFile marks a stream that stopped, written by the transcoder and on_publish_done.sh
+	streamEndedFile = ".stream_ended"
+	// defaultEndedRetention is how long an ended stream is kept when its marker does not say
+	defaultEndedRetention = 24 * time.Hour
+	// endedRefreshInterval is how often the ended state of a stream is re-read
+	endedRefreshInterval = time.Second
+	// goneMemory is how long a removed stream is still answered with 410 Gone.
+	// The cleanup job keeps the marker of expired streams as long, which
+	// carries this over restarts.
+	goneMemory = 7 * 24 * time.Hour
+)
+
+// StreamEnded mirrors the marker left in the directory of a stream that stopped
+type StreamEnded struct {
+	StreamName     string    `json:"stream_name"`
+	EndedAt        time.Time `json:"ended_at"`
+	Reason         string    `json:"reason,omitempty"`
+	RetentionHours *int      `json:"retention_hours"`
+}
+
+// ExpiresAt returns when the files of the stream stop being served
+func (e *StreamEnded) ExpiresAt() time.Time {
+	if e.RetentionHours == nil {
+		return e.EndedAt.Add(defaultEndedRetention)
+	}
+	return e.EndedAt.Add(time.Duration(*e.RetentionHours) * time.Hour)
+}
+
+// endedEntry caches the ended state of a stream directory. ended outlives the
+// marker once the directory is removed, so the stream can be answered as gone.
+type endedEntry struct {
+	ended     *StreamEnded
+	removed   bool
+	checkedAt time.Time
+}
+
+// loadStreamEnded reads the ended marker of a stream directory, returning nil if there is none
+func (s *HLSServer) loadStreamEnded(directory string) *StreamEnded {
+	data, err := s.files.ReadFile(filepath.Join(s.hlsDir, directory, streamEndedFile))
+	if err != nil {
+		return nil
+	}
+	var ended StreamEnded
+	if err := json.Unmarshal(data, &ended); err != nil || ended.EndedAt.IsZero() {
+		return nil
+	}
+	return &ended
+}
+
+// newestSegment returns when the newest segment listed by the variant
+// playlists of a stream directory was written, or the zero time if there is none
+func (s *HLSServer) newestSegment(directory string) time.Time {
+	streamDir := filepath.Join(s.hlsDir, directory)
+	data, err := s.files.ReadFile(filepath.Join(streamDir, "master.m3u8"))
+	if err != nil {
+		return time.Time{}
+	}
+	master, err := m3u8.ParseMaster(data)
+	if err != nil {
+		return time.Time{}
+	}
+
+	var newest time.Time
+	for _, variant := range master.Variants {
+		playlistPath := filepath.Join(streamDir, filepath.FromSlash(stripQuery(variant.URI)))
+		data, err := s.files.ReadFile(playlistPath)
+		if err != nil {
+			continue
+		}
+		playlist, err := m3u8.ParseMedia(data)
+		if err != nil || len(playlist.Segments) == 0 {
+			continue
+		}
+		last := playlist.Segments[len(playlist.Segments)-1]
+		info, err := os.Stat(filepath.Join(filepath.Dir(playlistPath), filepath.FromSlash(stripQuery(last.URI))))
+		if err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return newest
+}
+
+// endedState returns the ended state of a stream directory, and whether the
+// stream has expired or been removed after it ended. Streams that wrote
+// segments after their marker are live again and not ended.
+func (s *HLSServer) endedState(directory string) (*StreamEnded, bool) {
+	now := time.Now()
+	var previous *endedEntry
+	if value, ok := s.endedStreams.Load(directory); ok {
+		previous = value.(*endedEntry)
+		if now.Sub(previous.checkedAt) < endedRefreshInterval {
+			return previous.state()
+		}
+	}
+
+	entry := &endedEntry{ended: s.loadStreamEnded(directory), checkedAt: now}
+	if entry.ended != nil && s.newestSegment(directory).After(entry.ended.EndedAt.Add(time.Second)) {
+		// The stream was published again before its marker was removed
+		entry.ended = nil
+	}
+	if entry.ended == nil {
+		_, err := os.Stat(filepath.Join(s.hlsDir, directory))
+		switch {
+		case err == nil:
+			// Live, or live again
+		case previous != nil && previous.ended != nil && now.Before(previous.ended.ExpiresAt().Add(goneMemory)):
+			// The cleanup job removed the stream
+			entry.ended, entry.removed = previous.ended, true
+		default:
+			s.endedStreams.Delete(directory)
+			return nil, false
+		}
+	}
+	s.endedStreams.Store(directory, entry)
+	return entry.state()
+}
+
+// state returns the ended state of an entry and whether the stream is gone
+func (e *endedEntry) state() (*StreamEnded, bool) {
+	if e.ended == nil {
+		return nil, false
+	}
+	return e.ended, e.removed || time.Now().After(e.ended.ExpiresAt())
+}
+
+// checkGone writes a 410 response and returns false for streams that ended
+// longer ago than their retention period
+func (s *HLSServer) checkGone(c *gin.Context, directory string) bool {
+	ended, gone := s.endedState(directory)
+	if !gone {
+		return true
+	}
+	c.JSON(http.StatusGone, gin.H{
+		"error":      "Stream has ended",
+		"ended_at":   ended.EndedAt,
+		"expired_at": ended.ExpiresAt(),
+	})
+	return false
+}
@@ -6,21 +6,50 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/streamforge/platform/pkg/access"
+	"github.com/streamforge/platform/pkg/playback"
 )
 
 // HLSServer serves HLS files with optimized CORS and caching
 type HLSServer struct {
 	hlsDir string
+	// Segment boundaries of ad breaks by cue ID
+	adCueAlignments sync.Map
+	// Playback token verification, nil when no secret is configured
+	tokens        *playback.Signer
+	requireTokens bool
+	// Resolves playback IDs to stream directories
+	playbackIDs *playback.Resolver
+	// Enforces the access rules of streams by network and country
+	access *access.Enforcer
+	// Limits requests and bytes per client, nil when rate limiting is off
+	limiter *RateLimiter
+	// Keeps hot segments, playlists and sidecars in memory, nil when disabled
+	files *FileCache
+	// Pulls files from origins when running as an edge, nil otherwise
+	edge *Edge
+	// Secret edges present to fetch raw files, empty when edges are not served
+	originSecret string
+	// Base URLs playlist URIs are rewritten onto, for streams that set none
+	delivery DeliveryURLs
+	// Estimates the QoE of viewer sessions from their requests, nil when disabled
+	qoe *QoEEstimator
+	// Ended state of stream directories, kept after removal to answer 410 Gone
+	endedStreams sync.Map
 }
 
 // NewHLSServer creates a new HLS server instance
 func NewHLSServer(hlsDir string) *HLSServer {
 	return &HLSServer{
-		hlsDir: hlsDir,
+		hlsDir:      hlsDir,
+		playbackIDs: playback.NewResolver(hlsDir),
+		access:      access.NewEnforcer(hlsDir, nil),
 	}
 }
 
@@ -46,6 +75,14 @@ func OptimizedCORSMiddleware() gin.HandlerFunc {
 			c.Writer.Header().Set("Content-Type", "video/mp2t")
 			// Enable range requests for better seeking performance
 			c.Writer.Header().Set("Accept-Ranges", "bytes")
+		} else if strings.HasSuffix(path, ".jpg") {
+			// Posters and sprite sheets are refreshed by the transcoder every few seconds
+			c.Writer.Header().Set("Cache-Control", "max-age=10, public")
+			c.Writer.Header().Set("Content-Type", "image/jpeg")
+		} else if strings.HasSuffix(path, ".vtt") {
+			// Thumbnail tracks grow with the DVR window like a playlist
+			c.Writer.Header().Set("Cache-Control", "max-age=5, no-cache")
+			c.Writer.Header().Set("Content-Type", "text/vtt")
 		} else {
 			// No cache for other files
 			c.Writer.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
@@ -76,17 +113,68 @@ func (s *HLSServer) ServeHLSFile(c *gin.Context) {
 		return
 	}
 
+	// Sidecars and the playback ID index are not for viewers
+	if strings.Contains(filepath.ToSlash(cleanPath), "/.") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	// Clients are limited in how often they ask, whether or not the file exists
+	name := streamOf(cleanPath)
+	if !s.rateLimitRequest(c, name) {
+		return
+	}
+
+	// Streams are played by playback ID, and private ones only with a valid playback token
+	s.pullStream(name)
+	directory, ok := s.authorizePlayback(c, name)
+	if !ok {
+		return
+	}
+	// Licensed streams are only played from the networks and countries their rules allow
+	if !s.checkAccess(c, directory) {
+		return
+	}
+	// Streams that ended longer ago than their retention period are gone for good
+	if !s.checkGone(c, directory) {
+		return
+	}
+	cleanPath = filepath.Join("/", directory, strings.TrimPrefix(filepath.ToSlash(cleanPath), "/"+name))
+
 	// Construct full file path
 	fullPath := filepath.Join(s.hlsDir, cleanPath)
 
-	// Check if file exists
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+	// Edges fetch the file from an origin unless they have a fresh copy
+	if !s.pullFile(c, cleanPath) {
+		return
+	}
+
+	// Check if file exists; hot files are answered from memory without touching the disk
+	file, err := s.files.Open(fullPath)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
 
+	// Clients are limited in how much they download
+	if !s.rateLimitBytes(c, directory, file.size) {
+		return
+	}
+	s.observeQoE(c, directory, fullPath, file.size)
+
+	// Playlists may be trimmed to the requested DVR window
+	if strings.HasSuffix(fullPath, ".m3u8") {
+		s.ServePlaylist(c, fullPath, cleanPath)
+		return
+	}
+	// Sprite sheets need the token too
+	if token := c.Query(playback.TokenParam); token != "" && strings.HasSuffix(fullPath, ".vtt") {
+		s.serveThumbnails(c, fullPath, token)
+		return
+	}
+
 	// Serve the file
-	c.File(fullPath)
+	s.serveFile(c, fullPath, file)
 }
 
 // GetHLSDirectory lists available HLS streams
@@ -96,8 +184,9 @@ func (s *HLSServer) GetHLSDirectory(c *gin.Context) {
 	if entries, err := os.ReadDir(s.hlsDir); err == nil {
 		for _, entry := range entries {
 			if entry.IsDir() {
-				streamName := entry.Name()
-				streamPath := filepath.Join(s.hlsDir, streamName)
+				directory := entry.Name()
+				streamName := s.playbackName(directory)
+				streamPath := filepath.Join(s.hlsDir, directory)
 				
 				// Check if master playlist exists
 				masterPlaylist := filepath.Join(streamPath, "master.m3u8")
@@ -105,32 +194,76 @@ func (s *HLSServer) GetHLSDirectory(c *gin.Context) {
 				lastUpdate := ""
 				fileCount := 0
 
-				if _, err := os.Stat(masterPlaylist); err == nil {
-					if info, err := os.Stat(masterPlaylist); err == nil {
-						lastUpdate = info.ModTime().Format(time.RFC3339)
-						if time.Since(info.ModTime()) < 30*time.Second {
+				// Count files in stream directory
+				if files, err := os.ReadDir(streamPath); err == nil {
+					fileCount = len(files)
+				}
+
+				dvrWindow := 0
+				if settings := s.loadStreamSettings(directory); settings != nil {
+					dvrWindow = settings.DVRWindowSeconds
+				}
+
+				endpoints := gin.H{}
+				variants := []gin.H{}
+				var newest time.Time
+				if stats, err := s.variantStats(streamName); err == nil {
+					endpoints["master"] = fmt.Sprintf("/hls/%s/master.m3u8", streamName)
+
+					// A stream is live while any of its variants is still writing segments
+					for _, variant := range stats {
+						endpoints[variant.Name] = variant.Playlist
+						variants = append(variants, gin.H{
+							"name":       variant.Name,
+							"bandwidth":  variant.Bandwidth,
+							"resolution": variant.Resolution,
+							"playlist":   variant.Playlist,
+						})
+						if variant.Active {
 							status = "active"
 						}
+						if variant.lastUpdate.After(newest) {
+							newest = variant.lastUpdate
+						}
+					}
+					if newest.IsZero() {
+						if info, err := os.Stat(masterPlaylist); err == nil {
+							newest = info.ModTime()
+						}
+					}
+					if !newest.IsZero() {
+						lastUpdate = newest.Format(time.RFC3339)
 					}
 				}
 
-				// Count files in stream directory
-				if files, err := os.ReadDir(streamPath); err == nil {
-					fileCount = len(files)
+				// Thumbnails appear once the transcoder has captured the first frame
+				if _, err := os.Stat(filepath.Join(streamPath, "thumb.jpg")); err == nil {
+					endpoints["thumbnail"] = fmt.Sprintf("/hls/%s/thumb.jpg", streamName)
+				}
+				if _, err := os.Stat(filepath.Join(streamPath, "thumbs", "thumbnails.vtt")); err == nil {
+					endpoints["thumbnails"] = fmt.Sprintf("/hls/%s/thumbs/thumbnails.vtt", streamName)
 				}
 
-				streams = append(streams, gin.H{
-					"name":        streamName,
-					"status":      status,
-					"last_update": lastUpdate,
-					"file_count":  fileCount,
-					"endpoints": gin.H{
-						"master":   fmt.Sprintf("/hls/%s/master.m3u8", streamName),
-						"720p":     fmt.Sprintf("/hls/%s/720p/playlist.m3u8", streamName),
-						"480p":     fmt.Sprintf("/hls/%s/480p/playlist.m3u8", streamName),
-						"360p":     fmt.Sprintf("/hls/%s/360p/playlist.m3u8", streamName),
-					},
-				})
+				stream := gin.H{
+					"name":               streamName,
+					"playback_ids":       s.playbackIDs.PlaybackIDs(directory),
+					"status":             status,
+					"last_update":        lastUpdate,
+					"file_count":         fileCount,
+					"dvr_window_seconds": dvrWindow,
+					"variants":           variants,
+					"endpoints":          endpoints,
+				}
+				// Ended streams are served as recordings until their retention period is over
+				if ended, gone := s.endedState(directory); ended != nil {
+					stream["status"] = "ended"
+					if gone {
+						stream["status"] = "expired"
+					}
+					stream["ended_at"] = ended.EndedAt.Format(time.RFC3339)
+					stream["expires_at"] = ended.ExpiresAt().Format(time.RFC3339)
+				}
+				streams = append(streams, stream)
 			}
 		}
 	}
@@ -166,64 +299,45 @@ func (s *HLSServer) HealthCheck(c *gin.Context) {
 // StreamStats provides statistics about streams
 func (s *HLSServer) StreamStats(c *gin.Context) {
 	streamName := c.Param("stream")
-	streamPath := filepath.Join(s.hlsDir, streamName)
+	directory, ok := s.streamDirectory(streamName)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+		return
+	}
+	streamPath := filepath.Join(s.hlsDir, directory)
 
 	if _, err := os.Stat(streamPath); os.IsNotExist(err) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
 		return
 	}
 
-	stats := gin.H{
-		"stream":    streamName,
-		"variants":  gin.H{},
-		"total_size": int64(0),
+	variants, err := s.variantStats(streamName)
+	if err != nil {
+		variants = []*VariantStats{}
 	}
 
-	variants := []string{"720p", "480p", "360p"}
 	totalSize := int64(0)
-
 	for _, variant := range variants {
-		variantPath := filepath.Join(streamPath, variant)
-		variantStats := gin.H{
-			"name":         variant,
-			"segment_count": 0,
-			"size":         int64(0),
-			"last_update":  "",
-			"active":       false,
-		}
-
-		if files, err := os.ReadDir(variantPath); err == nil {
-			segmentCount := 0
-			variantSize := int64(0)
-			var lastMod time.Time
-
-			for _, file := range files {
-				if strings.HasSuffix(file.Name(), ".ts") {
-					segmentCount++
-					if info, err := file.Info(); err == nil {
-						variantSize += info.Size()
-						if info.ModTime().After(lastMod) {
-							lastMod = info.ModTime()
-						}
-					}
-				}
-			}
-
-			variantStats["segment_count"] = segmentCount
-			variantStats["size"] = variantSize
-			totalSize += variantSize
-
-			if !lastMod.IsZero() {
-				variantStats["last_update"] = lastMod.Format(time.RFC3339)
-				variantStats["active"] = time.Since(lastMod) < 30*time.Second
-			}
-		}
-
-		stats["variants"].(gin.H)[variant] = variantStats
+		totalSize += variant.Size
 	}
 
-	stats["total_size"] = totalSize
-	stats["timestamp"] = time.Now().Format(time.RFC3339)
+	stats := gin.H{
+		"stream":     streamName,
+		"variants":   variants,
+		"total_size": totalSize,
+		"timestamp":  time.Now().Format(time.RFC3339),
+	}
+	if ended, _ := s.endedState(directory); ended != nil {
+		stats["ended_at"] = ended.EndedAt.Format(time.RFC3339)
+		stats["expires_at"] = ended.ExpiresAt().Format(time.RFC3339)
+	}
+	if err != nil {
+		stats["error"] = "Master playlist not available"
+	}
+	// Viewer experience as estimated from the requests of their sessions
+	if s.qoe != nil {
+		stats["qoe"] = summarizeSessions(s.qoe.sessions(directory))
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"status": "success",
@@ -243,6 +357,15 @@ func main() {
 		hlsDir = envHLSDir
 	}
 
+	// "hls-server analyze <stream>" reports on a stream instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "analyze" {
+		os.Exit(runAnalyzeCommand(os.Args[2:], hlsDir))
+	}
+	// "hls-server bench <stream>" measures the throughput gain of the file cache
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(runBenchCommand(os.Args[2:], hlsDir))
+	}
+
 	// Create HLS directory if it doesn't exist
 	if err := os.MkdirAll(hlsDir, 0755); err != nil {
 		log.Fatalf("Failed to create HLS directory: %v", err)
@@ -254,8 +377,104 @@ func main() {
 	// Create HLS server
 	hlsServer := NewHLSServer(hlsDir)
 
+	// Private streams need playback tokens signed with the secret the transcoder issues them with
+	hlsServer.tokens = playback.NewSigner(os.Getenv("PLAYBACK_TOKEN_SECRET"))
+	hlsServer.requireTokens = os.Getenv("REQUIRE_PLAYBACK_TOKENS") == "true"
+	if hlsServer.tokens == nil {
+		log.Printf("⚠️  PLAYBACK_TOKEN_SECRET is not set, private streams cannot be played")
+	}
+
+	// Country access rules resolve viewers with a MaxMind-format database
+	geo, err := access.NewGeoIP(os.Getenv("GEOIP_DB"))
+	if err != nil {
+		log.Fatalf("Failed to open GeoIP database: %v", err)
+	}
+	if geo == nil {
+		log.Printf("⚠️  GEOIP_DB is not set, country access rules match no viewer")
+	}
+	hlsServer.access = access.NewEnforcer(hlsDir, geo)
+
+	// Hot files are served from memory
+	cacheMegabytes := int64(defaultCacheMegabytes)
+	if envCache := os.Getenv("HLS_CACHE_MB"); envCache != "" {
+		megabytes, err := strconv.ParseInt(envCache, 10, 64)
+		if err != nil || megabytes < 0 {
+			log.Fatalf("Invalid HLS_CACHE_MB %q", envCache)
+		}
+		cacheMegabytes = megabytes
+	}
+	hlsServer.files = NewFileCache(cacheMegabytes << 20)
+
+	// Rate limits are set per delivery tier; streams name their tier in their settings
+	if path := os.Getenv("RATE_LIMITS"); path != "" {
+		tiers, err := loadRateLimits(path)
+		if err != nil {
+			log.Fatalf("Failed to load rate limits: %v", err)
+		}
+		hlsServer.limiter = NewRateLimiter(tiers, hlsServer.loadStreamSettings)
+		log.Printf("🚦 Rate limiting %d delivery tiers", len(tiers))
+	}
+
+	// Playlists may point at CDN domains instead of this server
+	for _, base := range []struct {
+		env string
+		dst *string
+	}{{"PLAYLIST_BASE_URL", &hlsServer.delivery.PlaylistBase}, {"SEGMENT_BASE_URL", &hlsServer.delivery.SegmentBase}} {
+		value, err := parseBaseURL(os.Getenv(base.env))
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", base.env, err)
+		}
+		*base.dst = value
+	}
+	hlsServer.delivery.Propagate = parseQueryNames(os.Getenv("PROPAGATE_QUERY"))
+
+	// Origins serve raw files to edges that share their secret
+	hlsServer.originSecret = os.Getenv("ORIGIN_SECRET")
+
+	// Edges pull files from origins instead of sharing their HLS volume
+	if origins := os.Getenv("ORIGINS"); origins != "" {
+		ttls, err := parseEdgeTTLs(os.Getenv("EDGE_TTLS"))
+		if err != nil {
+			log.Fatalf("Invalid EDGE_TTLS: %v", err)
+		}
+		retention := defaultEdgeRetention
+		if envRetention := os.Getenv("EDGE_RETENTION"); envRetention != "" {
+			if retention, err = time.ParseDuration(envRetention); err != nil || retention <= 0 {
+				log.Fatalf("Invalid EDGE_RETENTION %q", envRetention)
+			}
+		}
+		if hlsServer.originSecret == "" {
+			log.Fatalf("ORIGIN_SECRET is required to pull from ORIGINS")
+		}
+		edge, err := NewEdge(hlsDir, strings.Split(origins, ","), hlsServer.originSecret, ttls, retention)
+		if err != nil {
+			log.Fatalf("Invalid ORIGINS: %v", err)
+		}
+		hlsServer.edge = edge
+		log.Printf("🌍 Edge mode, pulling from %d origins", len(edge.origins))
+	}
+
+	// The QoE of viewer sessions is estimated from their requests
+	qoeSessions := defaultQoESessions
+	if envSessions := os.Getenv("QOE_SESSIONS"); envSessions != "" {
+		if qoeSessions, err = strconv.Atoi(envSessions); err != nil || qoeSessions < 0 {
+			log.Fatalf("Invalid QOE_SESSIONS %q", envSessions)
+		}
+	}
+	if qoeSessions > 0 {
+		if hlsServer.qoe, err = NewQoEEstimator(qoeSessions, os.Getenv("QOE_LOG")); err != nil {
+			log.Fatalf("Failed to open QOE_LOG: %v", err)
+		}
+	}
+
 	// Setup router
 	r := gin.New()
+	// Client addresses are only taken from forwarding headers of trusted proxies
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		if err := r.SetTrustedProxies(strings.Split(strings.ReplaceAll(proxies, " ", ""), ",")); err != nil {
+			log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+		}
+	}
 	r.Use(gin.Recovery())
 	r.Use(OptimizedCORSMiddleware())
 
@@ -263,10 +482,18 @@ func main() {
 	r.GET("/health", hlsServer.HealthCheck)
 	r.GET("/streams", hlsServer.GetHLSDirectory)
 	r.GET("/stats/:stream", hlsServer.StreamStats)
+	r.GET("/analyze/:stream", hlsServer.AnalyzeStream)
+	r.GET("/access", hlsServer.AccessCounters)
+	r.GET("/access/:stream", hlsServer.AccessStatus)
+	r.GET("/ratelimits", hlsServer.RateLimitStats)
+	r.GET("/cache", hlsServer.CacheStats)
+	r.GET("/edge", hlsServer.EdgeStats)
+	r.GET("/qoe/:stream", hlsServer.QoESessions)
 
 	// HLS file serving routes
 	r.GET("/hls/*filepath", hlsServer.ServeHLSFile)
 	r.HEAD("/hls/*filepath", hlsServer.ServeHLSFile) // Support HEAD requests for range queries
+	r.GET("/origin/*filepath", hlsServer.ServeOriginFile)
 
 	// Root redirect to streams listing
 	r.GET("/", func(c *gin.Context) {
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// tsPacketSize is the size of an MPEG-TS packet
+	tsPacketSize = 188
+	// tsSyncByte starts every MPEG-TS packet
+	tsSyncByte = 0x47
+	// ptsClock is the frequency of PES timestamps
+	ptsClock = 90000
+	// ptsWrap is where the 33-bit PES timestamps wrap around
+	ptsWrap = int64(1) << 33
+)
+
+// Video stream types the analyzer understands
+const (
+	streamTypeH264 = 0x1b
+	streamTypeHEVC = 0x24
+)
+
+// tsFrame is a video access unit found in a transport stream
+type tsFrame struct {
+	pts      int64
+	keyframe bool // an IDR picture (or an IRAP picture for HEVC)
+}
+
+// tsSummary is what the analyzer needs to know about a segment
+type tsSummary struct {
+	videoCodec       string
+	frames           []tsFrame
+	continuityErrors int
+}
+
+// tsDemuxer follows the program tables of a transport stream and collects the
+// video frames of its first video stream
+type tsDemuxer struct {
+	summary    *tsSummary
+	pmtPID     int
+	videoPID   int
+	videoType  byte
+	continuity map[int]byte
+	pes        []byte
+}
+
+// parseTransportStream demuxes an MPEG-TS segment. Each video PES packet is
+// taken as one access unit, which is how FFmpeg muxes HLS segments.
+func parseTransportStream(data []byte) (*tsSummary, error) {
+	if len(data) < tsPacketSize || len(data)%tsPacketSize != 0 {
+		return nil, fmt.Errorf("segment size %d is not a whole number of transport stream packets", len(data))
+	}
+
+	d := &tsDemuxer{
+		summary:    &tsSummary{},
+		pmtPID:     -1,
+		videoPID:   -1,
+		continuity: make(map[int]byte),
+	}
+	for offset := 0; offset+tsPacketSize <= len(data); offset += tsPacketSize {
+		packet := data[offset : offset+tsPacketSize]
+		if packet[0] != tsSyncByte {
+			return nil, fmt.Errorf("lost sync at byte %d", offset)
+		}
+		d.packet(packet)
+	}
+	d.flushPES()
+
+	if d.videoPID < 0 {
+		return nil, errors.New("no H.264 or HEVC stream in the program map")
+	}
+	return d.summary, nil
+}
+
+// packet handles one transport stream packet
+func (d *tsDemuxer) packet(packet []byte) {
+	unitStart := packet[1]&0x40 != 0
+	pid := int(packet[1]&0x1f)<<8 | int(packet[2])
+	adaptation := (packet[3] >> 4) & 0x3
+	counter := packet[3] & 0x0f
+
+	payload := packet[4:]
+	if adaptation&0x2 != 0 {
+		length := int(packet[4])
+		if 5+length > tsPacketSize {
+			return
+		}
+		// A discontinuity indicator resets the continuity counter
+		if length > 0 && packet[5]&0x80 != 0 {
+			delete(d.continuity, pid)
+		}
+		payload = packet[5+length:]
+	}
+	if adaptation&0x1 == 0 {
+		return
+	}
+
+	// Repeated packets carry the same counter, anything else must count up
+	if last, ok := d.continuity[pid]; ok && counter != last && counter != (last+1)&0x0f {
+		d.summary.continuityErrors++
+	}
+	d.continuity[pid] = counter
+
+	switch pid {
+	case 0:
+		if unitStart {
+			d.parsePAT(psiSection(payload))
+		}
+	case d.pmtPID:
+		if unitStart {
+			d.parsePMT(psiSection(payload))
+		}
+	case d.videoPID:
+		if unitStart {
+			d.flushPES()
+		}
+		if unitStart || d.pes != nil {
+			d.pes = append(d.pes, payload...)
+		}
+	}
+}
+
+// psiSection skips the pointer field in front of a program specific information section
+func psiSection(payload []byte) []byte {
+	if len(payload) == 0 || int(payload[0])+1 > len(payload) {
+		return nil
+	}
+	return payload[1+int(payload[0]):]
+}
+
+// parsePAT finds the program map of the first program
+func (d *tsDemuxer) parsePAT(section []byte) {
+	if len(section) < 8 || section[0] != 0x00 {
+		return
+	}
+	end := min(3+(int(section[1]&0x0f)<<8|int(section[2]))-4, len(section))
+	for i := 8; i+4 <= end; i += 4 {
+		program := int(section[i])<<8 | int(section[i+1])
+		if program != 0 {
+			d.pmtPID = int(section[i+2]&0x1f)<<8 | int(section[i+3])
+			return
+		}
+	}
+}
+
+// parsePMT finds the first video stream of the program
+func (d *tsDemuxer) parsePMT(section []byte) {
+	if len(section) < 12 || section[0] != 0x02 {
+		return
+	}
+	end := min(3+(int(section[1]&0x0f)<<8|int(section[2]))-4, len(section))
+	for i := 12 + (int(section[10]&0x0f)<<8 | int(section[11])); i+5 <= end; {
+		streamType := section[i]
+		pid := int(section[i+1]&0x1f)<<8 | int(section[i+2])
+		if d.videoPID < 0 && (streamType == streamTypeH264 || streamType == streamTypeHEVC) {
+			d.videoPID = pid
+			d.videoType = streamType
+			d.summary.videoCodec = "h264"
+			if streamType == streamTypeHEVC {
+				d.summary.videoCodec = "hevc"
+			}
+		}
+		i += 5 + (int(section[i+3]&0x0f)<<8 | int(section[i+4]))
+	}
+}
+
+// flushPES turns the video PES packet collected so far into a frame
+func (d *tsDemuxer) flushPES() {
+	pes := d.pes
+	d.pes = nil
+	if len(pes) < 9 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 {
+		return
+	}
+
+	flags := pes[7] >> 6
+	headerEnd := 9 + int(pes[8])
+	if flags&0x2 == 0 || len(pes) < 14 || headerEnd > len(pes) {
+		return // frames without a timestamp cannot be placed
+	}
+	d.summary.frames = append(d.summary.frames, tsFrame{
+		pts:      parseTimestamp(pes[9:14]),
+		keyframe: containsKeyframe(pes[headerEnd:], d.videoType),
+	})
+}
+
+// parseTimestamp decodes a 33-bit PES timestamp
+func parseTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+// containsKeyframe scans the NAL units of an access unit for an IDR picture,
+// or an IRAP picture for HEVC
+func containsKeyframe(es []byte, streamType byte) bool {
+	for i := 0; i+3 < len(es); i++ {
+		if es[i] != 0 || es[i+1] != 0 || es[i+2] != 1 {
+			continue
+		}
+		header := es[i+3]
+		if streamType == streamTypeHEVC {
+			if nalType := (header >> 1) & 0x3f; nalType >= 16 && nalType <= 21 {
+				return true
+			}
+		} else if header&0x1f == 5 {
+			return true
+		}
+		i += 2
+	}
+	return false
+}
+
+// ptsDelta returns b - a for timestamps that may have wrapped around in between
+func ptsDelta(a, b int64) int64 {
+	delta := (b - a) % ptsWrap
+	if delta > ptsWrap/2 {
+		delta -= ptsWrap
+	} else if delta < -ptsWrap/2 {
+		delta += ptsWrap
+	}
+	return delta
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/streamforge/platform/pkg/playback"
+)
+
+// streamOf returns the stream a cleaned request path belongs to
+func streamOf(cleanPath string) string {
+	return strings.SplitN(strings.TrimPrefix(filepath.ToSlash(cleanPath), "/"), "/", 2)[0]
+}
+
+// streamDirectory resolves a playback ID, or the name of an unregistered
+// stream, to the directory its files are in
+func (s *HLSServer) streamDirectory(name string) (string, bool) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", false
+	}
+	target, ok := s.playbackIDs.Resolve(name)
+	return target.Directory, ok
+}
+
+// playbackName returns the name a stream directory is played by: its first
+// playback ID, or the directory itself for unregistered streams
+func (s *HLSServer) playbackName(directory string) string {
+	ids := s.playbackIDs.PlaybackIDs(directory)
+	for _, id := range ids {
+		if id == directory {
+			return id
+		}
+	}
+	if len(ids) > 0 {
+		return ids[0]
+	}
+	return directory
+}
+
+// authorizePlayback resolves the playback ID of a request and checks its
+// playback token, returning the directory of the stream's files. Signed
+// playback IDs, private streams, and every stream when tokens are required
+// globally, need a valid token; a token sent for any other stream must still
+// be valid. It writes the error response and returns false when the request
+// may not be served.
+func (s *HLSServer) authorizePlayback(c *gin.Context, name string) (string, bool) {
+	directory, ok := s.streamDirectory(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return "", false
+	}
+
+	target, _ := s.playbackIDs.Lookup(name)
+	required := s.requireTokens || target.Signed
+	if !required {
+		if settings := s.loadStreamSettings(directory); settings != nil {
+			required = settings.SignedPlayback
+		}
+	}
+
+	err := playback.Authorize(s.tokens, c.Query(playback.TokenParam), name, c.ClientIP(), required)
+	if err != nil {
+		c.JSON(playback.StatusCode(err), gin.H{"error": err.Error()})
+		return "", false
+	}
+	return directory, true
+}
+
+// serveThumbnails serves a WebVTT thumbnail track with the request's token on
+// every sprite sheet
+func (s *HLSServer) serveThumbnails(c *gin.Context, fullPath, token string) {
+	data, err := s.files.ReadFile(fullPath)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	c.Data(http.StatusOK, "text/vtt", playback.TokenizeThumbnails(data, token))
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/streamforge/platform/pkg/m3u8"
+)
+
+const (
+	// defaultQoESessions is how many finished sessions are kept per stream
+	defaultQoESessions = 500
+	// qoeSessionIdle is how long a session goes without requests before it counts as finished
+	qoeSessionIdle = 30 * time.Second
+	// qoeSweepInterval is how often idle sessions are finished
+	qoeSweepInterval = 10 * time.Second
+	// qoeStallTolerance is how late a segment may be fetched, in seconds, before
+	// the player is assumed to have run out of buffer
+	qoeStallTolerance = 0.5
+	// qoeDefaultSegmentSeconds is assumed for segments of playlists not seen yet
+	qoeDefaultSegmentSeconds = 2.0
+	// playlistRefreshInterval is how often the segment durations of a variant are re-read
+	playlistRefreshInterval = time.Second
+)
+
+// QoESession is what the requests of one viewer session reveal about its
+// playback. Players fetch segments as they need them, so a segment fetched
+// after the media before it would have run out means the player stalled.
+type QoESession struct {
+	ID        string    `json:"id"` // hash of the session, never the token or address it is derived from
+	Stream    string    `json:"stream"`
+	StartedAt time.Time `json:"started_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	Finished  bool      `json:"finished"`
+
+	// StartupMS is the time from the first request to the first segment
+	StartupMS         int64              `json:"startup_ms"`
+	AbandonedAtStart  bool               `json:"abandoned_at_startup"` // left without fetching a segment
+	Segments          int                `json:"segments"`
+	Bytes             int64              `json:"bytes"`
+	MediaSeconds      float64            `json:"media_seconds"` // duration of the segments fetched
+	WatchSeconds      float64            `json:"watch_seconds"`
+	Stalls            int                `json:"stalls"`
+	StallSeconds      float64            `json:"stall_seconds"`
+	Rendition         string             `json:"rendition"`
+	RenditionSwitches int                `json:"rendition_switches"`
+	RenditionSeconds  map[string]float64 `json:"rendition_seconds"`
+	SkippedSegments   int                `json:"skipped_segments"` // sequence numbers jumped over, e.g. to catch up with the live edge
+
+	mutex     sync.Mutex
+	playStart time.Time
+	lastSeq   int64
+}
+
+// QoEEstimator tracks the sessions of every stream from their requests
+type QoEEstimator struct {
+	keep     int
+	active   sync.Map // "<directory>|<session>" -> *QoESession
+	finished sync.Map // directory -> *finishedSessions
+	variants sync.Map // directory of a variant playlist -> *variantDurations
+	log      *json.Encoder
+	logMutex sync.Mutex
+}
+
+// finishedSessions is a ring of the last finished sessions of a stream
+type finishedSessions struct {
+	mutex    sync.Mutex
+	sessions []*QoESession
+	next     int
+}
+
+// variantDurations are the segment durations of a variant playlist
+type variantDurations struct {
+	checkedAt atomic.Int64
+	durations atomic.Pointer[map[string]float64]
+	target    atomic.Int64
+}
+
+// NewQoEEstimator returns an estimator keeping the last keep finished sessions
+// of each stream. Finished sessions are also appended to logPath as JSON lines
+// when it is set.
+func NewQoEEstimator(keep int, logPath string) (*QoEEstimator, error) {
+	estimator := &QoEEstimator{keep: keep}
+	if logPath != "" {
+		file, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		estimator.log = json.NewEncoder(file)
+	}
+	go estimator.sweep()
+	return estimator, nil
+}
+
+// qoeSessionOf identifies the viewer session of a request: its playback session,
+// or its address and user agent for anonymous players
+func qoeSessionOf(c *gin.Context) string {
+	if session := sessionOf(c); session != "" {
+		return session
+	}
+	return c.ClientIP() + "|" + c.Request.UserAgent()
+}
+
+// observe accounts for a request for a file of a stream directory
+func (e *QoEEstimator) observe(c *gin.Context, directory, fullPath string, size int64) {
+	ext := filepath.Ext(fullPath)
+	segment := ext == ".ts" || ext == ".m4s" || ext == ".aac" || ext == ".mp4"
+	if c.Request.Method != http.MethodGet || (ext != ".m3u8" && !segment) {
+		return
+	}
+	if length, ok := rangeLength(c.GetHeader("Range"), size); ok {
+		if length < size && !strings.HasPrefix(c.GetHeader("Range"), "bytes=0-") {
+			// Continuations of a partial download are not new fetches
+			return
+		}
+		size = length
+	}
+
+	now := time.Now()
+	session := e.sessionOf(directory, qoeSessionOf(c), now)
+
+	if segment {
+		rendition := filepath.Base(filepath.Dir(fullPath))
+		session.fetched(now, rendition, segmentSequence(fullPath), e.segmentDuration(fullPath), size)
+		return
+	}
+	if filepath.Base(fullPath) != "master.m3u8" {
+		e.refreshVariant(fullPath)
+	}
+	session.touch(now)
+}
+
+// sessionOf returns the active session of a viewer, starting one if needed
+func (e *QoEEstimator) sessionOf(directory, viewer string, now time.Time) *QoESession {
+	key := directory + "|" + viewer
+	if session, ok := e.active.Load(key); ok {
+		return session.(*QoESession)
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	session, _ := e.active.LoadOrStore(key, &QoESession{
+		ID:               fmt.Sprintf("%016x", hash.Sum64()),
+		Stream:           directory,
+		StartedAt:        now,
+		LastSeen:         now,
+		RenditionSeconds: map[string]float64{},
+		lastSeq:          -1,
+	})
+	return session.(*QoESession)
+}
+
+// touch records a request that fetched no media
+func (q *QoESession) touch(now time.Time) {
+	q.mutex.Lock()
+	q.LastSeen = now
+	q.mutex.Unlock()
+}
+
+// fetched records the fetch of a segment. The player is assumed to start
+// playing with the first segment; every later one must arrive before the
+// media fetched so far has been played, or the player stalled for the difference.
+func (q *QoESession) fetched(now time.Time, rendition string, seq int64, duration float64, size int64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.LastSeen = now
+	if q.Segments == 0 {
+		q.playStart = now
+		q.StartupMS = now.Sub(q.StartedAt).Milliseconds()
+	} else {
+		q.WatchSeconds = now.Sub(q.playStart).Seconds() - q.StallSeconds
+		if late := q.WatchSeconds - q.MediaSeconds; late > qoeStallTolerance {
+			q.Stalls++
+			q.StallSeconds += late
+			q.WatchSeconds = q.MediaSeconds
+		}
+	}
+
+	if q.Rendition != "" && rendition != q.Rendition {
+		q.RenditionSwitches++
+	}
+	if seq >= 0 && q.lastSeq >= 0 && rendition == q.Rendition && seq > q.lastSeq+1 {
+		q.SkippedSegments += int(seq - q.lastSeq - 1)
+	}
+	q.Rendition = rendition
+	if seq >= 0 {
+		q.lastSeq = seq
+	}
+
+	q.Segments++
+	q.Bytes += size
+	q.MediaSeconds += duration
+	q.RenditionSeconds[rendition] += duration
+}
+
+// finish closes a session that went idle
+func (q *QoESession) finish() {
+	q.mutex.Lock()
+	q.Finished = true
+	q.AbandonedAtStart = q.Segments == 0
+	q.mutex.Unlock()
+}
+
+// snapshot copies a session so it can be reported while it is updated
+func (q *QoESession) snapshot() *QoESession {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	copied := &QoESession{
+		ID:                q.ID,
+		Stream:            q.Stream,
+		StartedAt:         q.StartedAt,
+		LastSeen:          q.LastSeen,
+		Finished:          q.Finished,
+		StartupMS:         q.StartupMS,
+		AbandonedAtStart:  q.AbandonedAtStart,
+		Segments:          q.Segments,
+		Bytes:             q.Bytes,
+		MediaSeconds:      round3(q.MediaSeconds),
+		WatchSeconds:      round3(q.WatchSeconds),
+		Stalls:            q.Stalls,
+		StallSeconds:      round3(q.StallSeconds),
+		Rendition:         q.Rendition,
+		RenditionSwitches: q.RenditionSwitches,
+		RenditionSeconds:  make(map[string]float64, len(q.RenditionSeconds)),
+		SkippedSegments:   q.SkippedSegments,
+	}
+	for rendition, seconds := range q.RenditionSeconds {
+		copied.RenditionSeconds[rendition] = round3(seconds)
+	}
+	return copied
+}
+
+// segmentSequence returns the sequence number at the end of a segment's name, or -1
+func segmentSequence(fullPath string) int64 {
+	name := strings.TrimSuffix(filepath.Base(fullPath), filepath.Ext(fullPath))
+	digits := len(name)
+	for digits > 0 && name[digits-1] >= '0' && name[digits-1] <= '9' {
+		digits--
+	}
+	seq, err := strconv.ParseInt(name[digits:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return seq
+}
+
+// refreshVariant re-reads the segment durations of a variant playlist, at most
+// once a second
+func (e *QoEEstimator) refreshVariant(playlistPath string) {
+	value, ok := e.variants.Load(filepath.Dir(playlistPath))
+	if !ok {
+		value, _ = e.variants.LoadOrStore(filepath.Dir(playlistPath), &variantDurations{})
+	}
+	variant := value.(*variantDurations)
+
+	now := time.Now().UnixNano()
+	checkedAt := variant.checkedAt.Load()
+	if now-checkedAt < int64(playlistRefreshInterval) || !variant.checkedAt.CompareAndSwap(checkedAt, now) {
+		return
+	}
+	playlist, err := m3u8.ReadMediaFile(playlistPath)
+	if err != nil {
+		return
+	}
+	durations := make(map[string]float64, len(playlist.Segments))
+	for _, segment := range playlist.Segments {
+		durations[filepath.Base(stripQuery(segment.URI))] = segment.Duration
+	}
+	variant.durations.Store(&durations)
+	variant.target.Store(int64(playlist.TargetDuration))
+}
+
+// segmentDuration returns the duration of a segment from the playlists of its
+// variant, falling back to their target duration
+func (e *QoEEstimator) segmentDuration(segmentPath string) float64 {
+	value, ok := e.variants.Load(filepath.Dir(segmentPath))
+	if !ok {
+		return qoeDefaultSegmentSeconds
+	}
+	variant := value.(*variantDurations)
+	if durations := variant.durations.Load(); durations != nil {
+		if duration, ok := (*durations)[filepath.Base(segmentPath)]; ok {
+			return duration
+		}
+	}
+	if target := variant.target.Load(); target > 0 {
+		return float64(target)
+	}
+	return qoeDefaultSegmentSeconds
+}
+
+// sweep finishes the sessions that went idle
+func (e *QoEEstimator) sweep() {
+	ticker := time.NewTicker(qoeSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		idleSince := time.Now().Add(-qoeSessionIdle)
+		e.active.Range(func(key, value any) bool {
+			session := value.(*QoESession)
+			session.mutex.Lock()
+			idle := session.LastSeen.Before(idleSince)
+			session.mutex.Unlock()
+			if idle {
+				e.active.Delete(key)
+				session.finish()
+				e.store(session.snapshot())
+			}
+			return true
+		})
+	}
+}
+
+// store keeps a finished session with its stream and appends it to the log
+func (e *QoEEstimator) store(session *QoESession) {
+	value, ok := e.finished.Load(session.Stream)
+	if !ok {
+		value, _ = e.finished.LoadOrStore(session.Stream, &finishedSessions{})
+	}
+	ring := value.(*finishedSessions)
+	ring.mutex.Lock()
+	if len(ring.sessions) < e.keep {
+		ring.sessions = append(ring.sessions, session)
+	} else {
+		ring.sessions[ring.next] = session
+		ring.next = (ring.next + 1) % e.keep
+	}
+	ring.mutex.Unlock()
+
+	if e.log != nil {
+		e.logMutex.Lock()
+		if err := e.log.Encode(session); err != nil {
+			log.Printf("⚠️  Failed to log QoE session %s: %v", session.ID, err)
+		}
+		e.logMutex.Unlock()
+	}
+}
+
+// sessions returns the active and finished sessions of a stream directory, oldest first
+func (e *QoEEstimator) sessions(directory string) []*QoESession {
+	var sessions []*QoESession
+	if value, ok := e.finished.Load(directory); ok {
+		ring := value.(*finishedSessions)
+		ring.mutex.Lock()
+		sessions = append(sessions, ring.sessions...)
+		ring.mutex.Unlock()
+	}
+	prefix := directory + "|"
+	e.active.Range(func(key, value any) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			sessions = append(sessions, value.(*QoESession).snapshot())
+		}
+		return true
+	})
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].StartedAt.Before(sessions[j].StartedAt) })
+	return sessions
+}
+
+// summarizeSessions aggregates sessions into the QoE of their stream
+func summarizeSessions(sessions []*QoESession) gin.H {
+	var startups []int64
+	active, abandoned, stalls, switches, skipped := 0, 0, 0, 0, 0
+	var watch, stalled, media float64
+	var bytes int64
+	renditions := map[string]float64{}
+	for _, session := range sessions {
+		if !session.Finished {
+			active++
+		}
+		if session.AbandonedAtStart {
+			abandoned++
+		}
+		if session.Segments > 0 {
+			startups = append(startups, session.StartupMS)
+		}
+		stalls += session.Stalls
+		switches += session.RenditionSwitches
+		skipped += session.SkippedSegments
+		watch += session.WatchSeconds
+		stalled += session.StallSeconds
+		media += session.MediaSeconds
+		bytes += session.Bytes
+		for rendition, seconds := range session.RenditionSeconds {
+			renditions[rendition] = round3(renditions[rendition] + seconds)
+		}
+	}
+
+	summary := gin.H{
+		"sessions":             len(sessions),
+		"active_sessions":      active,
+		"abandoned_at_startup": abandoned,
+		"startup_p50_ms":       int64(0),
+		"startup_p95_ms":       int64(0),
+		"watch_seconds":        round3(watch),
+		"stall_seconds":        round3(stalled),
+		"stalls":               stalls,
+		"rebuffer_ratio":       0.0,
+		"average_bitrate":      0,
+		"rendition_switches":   switches,
+		"skipped_segments":     skipped,
+		"rendition_seconds":    renditions,
+	}
+	if len(startups) > 0 {
+		sort.Slice(startups, func(i, j int) bool { return startups[i] < startups[j] })
+		summary["startup_p50_ms"] = startups[(50*len(startups)+99)/100-1]
+		summary["startup_p95_ms"] = startups[(95*len(startups)+99)/100-1]
+	}
+	if watch+stalled > 0 {
+		summary["rebuffer_ratio"] = round3(stalled / (watch + stalled))
+	}
+	if media > 0 {
+		summary["average_bitrate"] = int(float64(bytes) * 8 / media)
+	}
+	return summary
+}
+
+// observeQoE accounts for a request in the QoE estimates of its stream
+func (s *HLSServer) observeQoE(c *gin.Context, directory, fullPath string, size int64) {
+	if s.qoe != nil {
+		s.qoe.observe(c, directory, fullPath, size)
+	}
+}
+
+// QoESessions reports the estimated QoE of a stream and each of its recent
+// viewer sessions
+func (s *HLSServer) QoESessions(c *gin.Context) {
+	if s.qoe == nil {
+		c.JSON(http.StatusOK, gin.H{"status": "success", "enabled": false})
+		return
+	}
+	streamName := c.Param("stream")
+	directory, ok := s.streamDirectory(streamName)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+		return
+	}
+	sessions := s.qoe.sessions(directory)
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"enabled":  true,
+		"stream":   streamName,
+		"summary":  summarizeSessions(sessions),
+		"sessions": sessions,
+	})
+}
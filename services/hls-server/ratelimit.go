@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/streamforge/platform/pkg/playback"
+)
+
+const (
+	// defaultTier is the tier of streams whose settings do not name one, or name an unknown one
+	defaultTier = "default"
+	// sessionHeader identifies a playback session; AVPlayer sends it with every request
+	sessionHeader = "X-Playback-Session-Id"
+	// tierRefreshInterval is how often the tier of a stream is re-read from its settings
+	tierRefreshInterval = 5 * time.Second
+	// bucketSweepInterval is how often buckets of idle clients are dropped
+	bucketSweepInterval = time.Minute
+)
+
+// Limit is a token bucket rate: requests or bytes per second, with bursts of up
+// to Burst. A zero rate is unlimited.
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst float64 `json:"burst"`
+}
+
+// ClientLimits are the limits applied to one client, an IP address or a session
+type ClientLimits struct {
+	Requests Limit `json:"requests"`
+	Bytes    Limit `json:"bytes"`
+}
+
+// TierLimits are the limits of a delivery tier
+type TierLimits struct {
+	IP      ClientLimits `json:"ip"`
+	Session ClientLimits `json:"session"`
+}
+
+// loadRateLimits reads the delivery tiers from a JSON file mapping tier names
+// to their limits. The default tier applies to streams without a known tier.
+func loadRateLimits(path string) (map[string]TierLimits, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tiers := map[string]TierLimits{}
+	if err := json.Unmarshal(data, &tiers); err != nil {
+		return nil, fmt.Errorf("invalid rate limits: %w", err)
+	}
+	if _, ok := tiers[defaultTier]; !ok {
+		return nil, fmt.Errorf("invalid rate limits: no %q tier", defaultTier)
+	}
+	for name, tier := range tiers {
+		for _, limit := range []Limit{tier.IP.Requests, tier.IP.Bytes, tier.Session.Requests, tier.Session.Bytes} {
+			if limit.Rate < 0 || limit.Burst < 0 || (limit.Rate > 0 && limit.Burst < 1) {
+				return nil, fmt.Errorf("invalid rate limits of tier %q: rates and bursts must be positive", name)
+			}
+		}
+	}
+	return tiers, nil
+}
+
+// retiredBucket is the arrival time of a bucket the sweep dropped, which takes
+// no more tokens
+const retiredBucket = math.MinInt64
+
+// bucket is a token bucket kept as the theoretical arrival time of the generic
+// cell rate algorithm, so taking tokens is a single compare-and-swap
+type bucket struct {
+	tat    atomic.Int64 // unix nanoseconds at which the bucket is full again
+	refill int64        // nanoseconds an empty bucket takes to fill up
+}
+
+// take takes cost tokens, returning how long to wait when there are too few.
+// Costs above the burst are let through when the bucket is full, leaving it in
+// debt, so a segment larger than the byte burst can still be served. live is
+// false when the sweep retired the bucket, and nothing was taken.
+func (b *bucket) take(limit Limit, cost float64, now int64) (wait time.Duration, ok, live bool) {
+	interval := float64(time.Second) / limit.Rate
+	tolerance := int64(limit.Burst * interval)
+	increment := int64(cost * interval)
+	admit := int64(math.Min(cost, limit.Burst) * interval)
+	for {
+		current := b.tat.Load()
+		if current == retiredBucket {
+			return 0, false, false
+		}
+		tat := current
+		if tat < now {
+			tat = now
+		}
+		if allowAt := tat + admit - tolerance; allowAt > now {
+			return time.Duration(allowAt - now), false, true
+		}
+		if b.tat.CompareAndSwap(current, tat+increment) {
+			return 0, true, true
+		}
+	}
+}
+
+// tierCounters count the requests of a tier that were served, and those throttled by each limit
+type tierCounters struct {
+	allowed         atomic.Uint64
+	ipRequests      atomic.Uint64
+	ipBytes         atomic.Uint64
+	sessionRequests atomic.Uint64
+	sessionBytes    atomic.Uint64
+}
+
+// tierEntry caches the tier of a stream directory
+type tierEntry struct {
+	name      string
+	checkedAt time.Time
+}
+
+// RateLimiter limits requests and bytes per IP address and per playback
+// session. Buckets live in sync.Maps and are updated with atomics, so the
+// request path takes no locks.
+type RateLimiter struct {
+	tiers    map[string]TierLimits
+	counters map[string]*tierCounters
+	buckets  sync.Map // "<tier>|<scope>|<client>" -> *bucket
+	streams  sync.Map // stream directory -> *tierEntry
+	settings func(directory string) *StreamSettings
+}
+
+// NewRateLimiter returns a limiter for the tiers. Stream settings name the
+// tier of each stream.
+func NewRateLimiter(tiers map[string]TierLimits, settings func(directory string) *StreamSettings) *RateLimiter {
+	limiter := &RateLimiter{
+		tiers:    tiers,
+		counters: map[string]*tierCounters{},
+		settings: settings,
+	}
+	for name := range tiers {
+		limiter.counters[name] = &tierCounters{}
+	}
+	go limiter.sweep()
+	return limiter
+}
+
+// tierOf returns the tier of a stream directory
+func (l *RateLimiter) tierOf(directory string) string {
+	now := time.Now()
+	if entry, ok := l.streams.Load(directory); ok && now.Sub(entry.(*tierEntry).checkedAt) < tierRefreshInterval {
+		return entry.(*tierEntry).name
+	}
+	name := defaultTier
+	if settings := l.settings(directory); settings != nil {
+		if _, ok := l.tiers[settings.DeliveryTier]; ok {
+			name = settings.DeliveryTier
+		}
+	}
+	l.streams.Store(directory, &tierEntry{name: name, checkedAt: now})
+	return name
+}
+
+// bucketOf returns the bucket of a client for a limit
+func (l *RateLimiter) bucketOf(key string, limit Limit) *bucket {
+	if b, ok := l.buckets.Load(key); ok {
+		return b.(*bucket)
+	}
+	b, _ := l.buckets.LoadOrStore(key, &bucket{refill: int64(limit.Burst / limit.Rate * float64(time.Second))})
+	return b.(*bucket)
+}
+
+// refund gives back tokens taken from the bucket
+func (b *bucket) refund(limit Limit, cost float64) {
+	amount := int64(cost * float64(time.Second) / limit.Rate)
+	for {
+		current := b.tat.Load()
+		if current == retiredBucket || b.tat.CompareAndSwap(current, current-amount) {
+			return
+		}
+	}
+}
+
+// charge is a limit a request is charged to
+type charge struct {
+	name   string
+	scope  string
+	client string
+	limit  Limit
+	cost   float64
+	count  *atomic.Uint64
+}
+
+// AllowRequest charges a request for a stream directory to the client's IP
+// address and session. It is charged before the file is looked up, so requests
+// for files that do not exist or may not be played are limited too. It returns
+// the limit that was exceeded and how long to wait before retrying, or an
+// empty limit when the request may go on.
+func (l *RateLimiter) AllowRequest(directory, clientIP, session string) (string, time.Duration) {
+	tier := l.tierOf(directory)
+	limits := l.tiers[tier]
+	counters := l.counters[tier]
+	return l.charge(tier, []charge{
+		{"ip_requests", "ip", clientIP, limits.IP.Requests, 1, &counters.ipRequests},
+		{"session_requests", "session", session, limits.Session.Requests, 1, &counters.sessionRequests},
+	})
+}
+
+// AllowBytes charges the size bytes a request is answered with to the
+// client's IP address and session, like AllowRequest
+func (l *RateLimiter) AllowBytes(directory, clientIP, session string, size int64) (string, time.Duration) {
+	tier := l.tierOf(directory)
+	limits := l.tiers[tier]
+	counters := l.counters[tier]
+	limit, wait := l.charge(tier, []charge{
+		{"ip_bytes", "ip-bytes", clientIP, limits.IP.Bytes, float64(size), &counters.ipBytes},
+		{"session_bytes", "session-bytes", session, limits.Session.Bytes, float64(size), &counters.sessionBytes},
+	})
+	if limit == "" {
+		counters.allowed.Add(1)
+	}
+	return limit, wait
+}
+
+// charge takes tokens for every limit, or none: when one limit is exceeded,
+// the tokens already taken for the others are given back
+func (l *RateLimiter) charge(tier string, charges []charge) (string, time.Duration) {
+	now := time.Now().UnixNano()
+	taken := make([]*bucket, len(charges))
+	for i, c := range charges {
+		if c.limit.Rate == 0 || c.cost == 0 || c.client == "" {
+			continue
+		}
+		key := tier + "|" + c.scope + "|" + c.client
+		b := l.bucketOf(key, c.limit)
+		wait, ok, live := b.take(c.limit, c.cost, now)
+		for !live {
+			// The sweep retired the bucket after it was looked up; charge its successor
+			l.buckets.CompareAndDelete(key, b)
+			b = l.bucketOf(key, c.limit)
+			wait, ok, live = b.take(c.limit, c.cost, now)
+		}
+		if ok {
+			taken[i] = b
+			continue
+		}
+		for j, earlier := range taken[:i] {
+			if earlier != nil {
+				earlier.refund(charges[j].limit, charges[j].cost)
+			}
+		}
+		c.count.Add(1)
+		return c.name, wait
+	}
+	return "", 0
+}
+
+// sweep drops the buckets of clients that have been idle for a full burst
+// period after their bucket refilled. A bucket is retired before it is
+// removed, so a request that looked it up just before moves on to a new one
+// rather than charging one that is gone.
+func (l *RateLimiter) sweep() {
+	ticker := time.NewTicker(bucketSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now().UnixNano()
+		l.buckets.Range(func(key, value any) bool {
+			b := value.(*bucket)
+			tat := b.tat.Load()
+			if tat != retiredBucket && tat+b.refill < now && b.tat.CompareAndSwap(tat, retiredBucket) {
+				l.buckets.CompareAndDelete(key, b)
+			}
+			return true
+		})
+	}
+}
+
+// stats reports the tiers and how often each of their limits throttled requests
+func (l *RateLimiter) stats() gin.H {
+	tracked := 0
+	l.buckets.Range(func(_, _ any) bool {
+		tracked++
+		return true
+	})
+
+	names := make([]string, 0, len(l.tiers))
+	for name := range l.tiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tiers := []gin.H{}
+	for _, name := range names {
+		counters := l.counters[name]
+		tiers = append(tiers, gin.H{
+			"tier":    name,
+			"limits":  l.tiers[name],
+			"allowed": counters.allowed.Load(),
+			"throttled": gin.H{
+				"ip_requests":      counters.ipRequests.Load(),
+				"ip_bytes":         counters.ipBytes.Load(),
+				"session_requests": counters.sessionRequests.Load(),
+				"session_bytes":    counters.sessionBytes.Load(),
+			},
+		})
+	}
+	return gin.H{"tiers": tiers, "tracked_buckets": tracked}
+}
+
+// sessionOf identifies the playback session of a request: its playback token,
+// or the session ID players send, or nothing for anonymous requests
+func sessionOf(c *gin.Context) string {
+	if token := c.Query(playback.TokenParam); token != "" {
+		return token
+	}
+	return c.GetHeader(sessionHeader)
+}
+
+// rateLimitRequest charges a request for a stream to its client, writing a
+// 429 response and returning false when a limit is exceeded. Names that are
+// not a stream are charged to the default tier.
+func (s *HLSServer) rateLimitRequest(c *gin.Context, name string) bool {
+	if s.limiter == nil {
+		return true
+	}
+	directory, _ := s.streamDirectory(name)
+	limit, wait := s.limiter.AllowRequest(directory, c.ClientIP(), sessionOf(c))
+	return s.throttled(c, limit, wait)
+}
+
+// rateLimitBytes charges the bytes of a file of a stream to the client of a
+// request, writing a 429 response and returning false when a limit is exceeded
+func (s *HLSServer) rateLimitBytes(c *gin.Context, directory string, size int64) bool {
+	if s.limiter == nil {
+		return true
+	}
+	if c.Request.Method == http.MethodHead {
+		size = 0
+	} else if length, ok := rangeLength(c.GetHeader("Range"), size); ok {
+		size = length
+	}
+	limit, wait := s.limiter.AllowBytes(directory, c.ClientIP(), sessionOf(c), size)
+	return s.throttled(c, limit, wait)
+}
+
+// throttled writes a 429 response and returns false when a limit was exceeded
+func (s *HLSServer) throttled(c *gin.Context, limit string, wait time.Duration) bool {
+	if limit == "" {
+		return true
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded", "limit": limit})
+	return false
+}
+
+// rangeLength returns the number of bytes a single-range Range header asks for
+func rangeLength(header string, size int64) (int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, false
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return min(suffix, size), true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start >= size {
+		return 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil {
+			return 0, false
+		}
+		end = min(end, size-1)
+	}
+	if end < start {
+		return 0, false
+	}
+	return end - start + 1, true
+}
+
+// RateLimitStats reports the rate limit tiers and throttling counters
+func (s *HLSServer) RateLimitStats(c *gin.Context) {
+	if s.limiter == nil {
+		c.JSON(http.StatusOK, gin.H{"status": "success", "enabled": false})
+		return
+	}
+	stats := s.limiter.stats()
+	stats["status"] = "success"
+	stats["enabled"] = true
+	c.JSON(http.StatusOK, stats)
+}
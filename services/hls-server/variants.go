@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/streamforge/platform/pkg/m3u8"
+)
+
+const (
+	// activeAfter is how recently a variant must have written a segment to count as live
+	activeAfter = 30 * time.Second
+	// cadenceTolerance is how much longer than the target duration segments may
+	// take to appear before the variant counts as falling behind, in seconds
+	cadenceTolerance = 0.5
+)
+
+// VariantStats describes a rendition listed in a stream's master playlist
+type VariantStats struct {
+	Name       string `json:"name"`
+	URI        string `json:"uri"`
+	Playlist   string `json:"playlist"`
+	Bandwidth  int    `json:"bandwidth"`
+	Resolution string `json:"resolution,omitempty"`
+	Codecs     string `json:"codecs,omitempty"`
+
+	SegmentCount int   `json:"segment_count"`
+	Size         int64 `json:"size"`
+	// NewestSegmentAge is how long ago the newest segment was written, in seconds
+	NewestSegmentAge *float64 `json:"newest_segment_age,omitempty"`
+	LastUpdate       string   `json:"last_update"`
+	Active           bool     `json:"active"`
+	Ended            bool     `json:"ended"`
+
+	// TargetDuration is what the playlist promises, AverageDuration what its
+	// segments last and Cadence how often they were actually written
+	TargetDuration  int     `json:"target_duration"`
+	AverageDuration float64 `json:"average_duration"`
+	Cadence         float64 `json:"cadence"`
+	FallingBehind   bool    `json:"falling_behind"`
+
+	Error string `json:"error,omitempty"`
+
+	lastUpdate time.Time
+}
+
+// variantStats reads the master playlist of a stream and reports every variant
+// it lists, however the renditions are named or laid out on disk
+func (s *HLSServer) variantStats(streamName string) ([]*VariantStats, error) {
+	directory, ok := s.streamDirectory(streamName)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	streamDir := filepath.Join(s.hlsDir, directory)
+	master, err := m3u8.ReadMasterFile(filepath.Join(streamDir, "master.m3u8"))
+	if err != nil {
+		return nil, err
+	}
+
+	variants := make([]*VariantStats, 0, len(master.Variants))
+	for _, variant := range master.Variants {
+		stats := &VariantStats{
+			Name:       variantName(variant.URI),
+			URI:        variant.URI,
+			Playlist:   "/hls/" + streamName + "/" + variant.URI,
+			Bandwidth:  variant.Bandwidth,
+			Resolution: variant.Resolution,
+			Codecs:     variant.Codecs,
+		}
+		if err := stats.read(filepath.Join(streamDir, filepath.FromSlash(stripQuery(variant.URI)))); err != nil {
+			stats.Error = err.Error()
+		}
+		variants = append(variants, stats)
+	}
+	return variants, nil
+}
+
+// read fills in the segment statistics of a variant from its playlist and the
+// segments it lists
+func (v *VariantStats) read(playlistPath string) error {
+	playlist, err := m3u8.ReadMediaFile(playlistPath)
+	if err != nil {
+		return err
+	}
+
+	v.TargetDuration = playlist.TargetDuration
+	v.SegmentCount = len(playlist.Segments)
+	v.Ended = playlist.Ended
+	if v.SegmentCount > 0 {
+		v.AverageDuration = round3(playlist.Duration() / float64(v.SegmentCount))
+	}
+
+	// Segments are renamed into place once complete, so their modification
+	// times tell when each one became available
+	var first, newest time.Time
+	written := 0
+	for _, segment := range playlist.Segments {
+		info, err := os.Stat(filepath.Join(filepath.Dir(playlistPath), filepath.FromSlash(stripQuery(segment.URI))))
+		if err != nil {
+			continue
+		}
+		v.Size += info.Size()
+		if written == 0 {
+			first = info.ModTime()
+		}
+		newest = info.ModTime()
+		written++
+	}
+	if written > 1 {
+		v.Cadence = round3(newest.Sub(first).Seconds() / float64(written-1))
+	}
+
+	if newest.IsZero() {
+		if info, err := os.Stat(playlistPath); err == nil {
+			v.lastUpdate = info.ModTime()
+		}
+	} else {
+		age := round3(time.Since(newest).Seconds())
+		v.NewestSegmentAge = &age
+		v.lastUpdate = newest
+	}
+	if !v.lastUpdate.IsZero() {
+		v.LastUpdate = v.lastUpdate.Format(time.RFC3339)
+	}
+
+	v.Active = !v.Ended && !newest.IsZero() && time.Since(newest) < activeAfter
+	v.FallingBehind = v.Active && v.TargetDuration > 0 && v.Cadence > float64(v.TargetDuration)+cadenceTolerance
+	return nil
+}
+
+// variantName names a variant after the directory of its playlist, or the
+// playlist itself when it sits next to the master playlist
+func variantName(uri string) string {
+	if dir := path.Dir(stripQuery(uri)); dir != "." {
+		return dir
+	}
+	return stripQuery(uri)
+}
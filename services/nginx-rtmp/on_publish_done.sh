@@ -39,17 +39,30 @@ log "INFO: Stream publish ended - Name: $STREAM_NAME, Client: $CLIENT_ADDR"
 log "INFO: Stopping transcoding processes for stream: $STREAM_NAME"
 pkill -f "transcode.*$STREAM_NAME" || true
 
-# Notify transcoder service about stream end
+# Notify transcoder service about the publisher leaving. Streams with a slate
+# grace period keep running on their slate, others wait for the publisher to
+# reconnect; the transcoder ends them when it does not.
 log "INFO: Notifying transcoder service about stream end: $STREAM_NAME"
-curl -X POST "${TRANSCODER_URL}/transcode/stop/${STREAM_NAME}" \
+RESPONSE=$(curl -s -X POST "${TRANSCODER_URL}/transcode/disconnect/${STREAM_NAME}" \
   --connect-timeout 5 \
-  --max-time 10 \
-  >> "$LOG_FILE" 2>&1 || {
+  --max-time 15) || {
     log "WARNING: Failed to notify transcoder service, but continuing..."
 }
+echo "$RESPONSE" >> "$LOG_FILE"
 
-# Stream cleanup based on retention policy
-STREAM_DIR="$HLS_BASE_DIR/$STREAM_NAME"
+if echo "$RESPONSE" | grep -q '"slate":true'; then
+    log "INFO: Showing slate for $STREAM_NAME until the publisher returns or the grace period ends"
+    exit 0
+fi
+if echo "$RESPONSE" | grep -q '"outcome":"reconnect"'; then
+    log "INFO: Keeping $STREAM_NAME open for the publisher to reconnect"
+    exit 0
+fi
+
+# Stream cleanup based on retention policy. The output directory is named after
+# the stream's playback ID, or the stream key for unregistered streams.
+PLAYBACK_ID=$(echo "$RESPONSE" | sed -n 's/.*"playback_id":"\([a-zA-Z0-9_-]*\)".*/\1/p')
+STREAM_DIR="$HLS_BASE_DIR/${PLAYBACK_ID:-$STREAM_NAME}"
 
 if [ "$CLEANUP_ENABLED" = "true" ] && [ -d "$STREAM_DIR" ]; then
     log "INFO: Applying retention policy for stream: $STREAM_NAME"
@@ -57,7 +70,7 @@ if [ "$CLEANUP_ENABLED" = "true" ] && [ -d "$STREAM_DIR" ]; then
     # Mark stream as ended by creating a metadata file
     cat > "$STREAM_DIR/.stream_ended" << EOF
 {
-    "stream_name": "$STREAM_NAME",
+    "stream_name": "${PLAYBACK_ID:-$STREAM_NAME}",
     "ended_at": "$(date -Iseconds)",
     "client_addr": "$CLIENT_ADDR",
     "retention_hours": $RETENTION_HOURS
@@ -66,8 +79,9 @@ EOF
 
     # Schedule cleanup based on retention policy
     if [ "$RETENTION_HOURS" -eq 0 ]; then
-        log "INFO: Immediate cleanup enabled, removing stream directory: $STREAM_NAME"
-        rm -rf "$STREAM_DIR"
+        # The marker is kept as a tombstone, so the stream is answered as gone
+        log "INFO: Immediate cleanup enabled, removing stream files: $STREAM_NAME"
+        find "$STREAM_DIR" -mindepth 1 -maxdepth 1 ! -name .stream_ended -exec rm -rf {} +
     else
         log "INFO: Stream will be cleaned up after $RETENTION_HOURS hours"
         # The cleanup will be handled by a separate cleanup service/cron job
@@ -76,15 +90,5 @@ else
     log "INFO: Cleanup disabled or stream directory not found"
 fi
 
-# Update master playlist to indicate stream ended
-if [ -d "$STREAM_DIR" ]; then
-    cat > "$STREAM_DIR/master.m3u8" << EOF
-#EXTM3U
-#EXT-X-VERSION:3
-#EXT-X-ENDLIST
-# Stream ended at $(date -Iseconds)
-EOF
-fi
-
 log "INFO: Stream cleanup completed for: $STREAM_NAME"
 exit 0
\ No newline at end of file
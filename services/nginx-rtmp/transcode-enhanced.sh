@@ -53,15 +53,8 @@ cleanup() {
     # Kill any remaining FFmpeg processes for this stream
     pkill -f "ffmpeg.*$STREAM_NAME" || true
 
-    # Update master playlist to indicate stream ended
-    if [ -d "$HLS_DIR" ]; then
-        cat > "$HLS_DIR/master.m3u8" << EOF
-#EXTM3U
-#EXT-X-VERSION:3
-#EXT-X-ENDLIST
-# Transcoding ended at $(date -Iseconds)
-EOF
-    fi
+    # FFmpeg ends the variant playlists itself, and the master playlist stays
+    # valid so players that join late still reach the recording
 
     log "INFO: Transcoding cleanup completed for $STREAM_NAME"
 }
@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"regexp"
@@ -54,11 +56,14 @@ type StreamManager struct {
 	mutex         sync.RWMutex
 	nginxStatURL  string
 	transcoderURL string
-	outputDir     string
+	// Authenticates the stream manager on the transcoder's internal routes
+	internalSecret string
+	outputDir      string
 }
 
 type StreamInfo struct {
 	Name          string    `json:"name"`
+	PlaybackID    string    `json:"playback_id,omitempty"` // public name of the stream's HLS output
 	Active        bool      `json:"active"`
 	Publishing    bool      `json:"publishing"`
 	Clients       int       `json:"clients"`
@@ -68,6 +73,18 @@ type StreamInfo struct {
 	BWOut         int       `json:"bw_out"`
 }
 
+// PlaybackStatus is the status of a stream looked up by playback ID, without
+// the stream key and anything else viewers should not see
+type PlaybackStatus struct {
+	PlaybackID string    `json:"playback_id"`
+	Active     bool      `json:"active"`
+	Publishing bool      `json:"publishing"`
+	Clients    int       `json:"clients"`
+	LastSeen   time.Time `json:"last_seen"`
+	BWIn       int       `json:"bw_in"`
+	BWOut      int       `json:"bw_out"`
+}
+
 func NewStreamManager() *StreamManager {
 	nginxURL := os.Getenv("NGINX_URL")
 	if nginxURL == "" {
@@ -79,11 +96,17 @@ func NewStreamManager() *StreamManager {
 		transcoderURL = "http://transcoder:8083"
 	}
 
+	internalSecret := os.Getenv("INTERNAL_API_SECRET")
+	if internalSecret == "" {
+		log.Println("⚠️  INTERNAL_API_SECRET is not set, streams cannot be looked up by playback ID")
+	}
+
 	return &StreamManager{
-		activeStreams: make(map[string]*StreamInfo),
-		nginxStatURL:  nginxURL + "/stat",
-		transcoderURL: transcoderURL,
-		outputDir:     "/tmp/hls_shared",
+		activeStreams:  make(map[string]*StreamInfo),
+		nginxStatURL:   nginxURL + "/stat",
+		transcoderURL:  transcoderURL,
+		internalSecret: internalSecret,
+		outputDir:      "/tmp/hls_shared",
 	}
 }
 
@@ -158,7 +181,10 @@ func (sm *StreamManager) checkStreams() {
 				log.Printf("🎬 New stream detected: %s", streamName)
 
 				// Start transcoder for new stream
-				go sm.startTranscoder(streamName)
+				go func(streamName string) {
+					sm.startTranscoder(streamName)
+					sm.updatePlaybackID(streamName)
+				}(streamName)
 			}
 		}
 	}
@@ -284,6 +310,105 @@ func (sm *StreamManager) stopTranscoder(streamName string) {
 	}
 }
 
+// transcoderResponse is the envelope of transcoder API responses
+type transcoderResponse struct {
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data"`
+	Error   string          `json:"error"`
+}
+
+// playbackIDInfo is what the transcoder reports about a playback ID
+type playbackIDInfo struct {
+	PlaybackID string `json:"playback_id"`
+	StreamKey  string `json:"stream_key"`
+	Policy     string `json:"policy"`
+}
+
+// getTranscoder decodes the data of a transcoder API response. It returns false
+// when the transcoder does not know the resource.
+func (sm *StreamManager) getTranscoder(path string, data interface{}) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, sm.transcoderURL+path, nil)
+	if err != nil {
+		return false, err
+	}
+	if sm.internalSecret != "" {
+		req.Header.Set("Authorization", "Bearer "+sm.internalSecret)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	var body transcoderResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return false, err
+	}
+	if !body.Success {
+		return false, fmt.Errorf("transcoder API returned status %d: %s", resp.StatusCode, body.Error)
+	}
+	return true, json.Unmarshal(body.Data, data)
+}
+
+// updatePlaybackID records the playback ID a stream is played by. Streams not
+// registered with the transcoder are played by their name.
+func (sm *StreamManager) updatePlaybackID(streamName string) {
+	var ids []playbackIDInfo
+	found, err := sm.getTranscoder("/playback/ids?stream_key="+url.QueryEscape(streamName), &ids)
+	if err != nil {
+		log.Printf("❌ Failed to look up playback ID of %s: %v", streamName, err)
+		return
+	}
+	playbackID := streamName
+	if found && len(ids) > 0 {
+		playbackID = ids[0].PlaybackID
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	if streamInfo, exists := sm.activeStreams[streamName]; exists {
+		streamInfo.PlaybackID = playbackID
+	}
+}
+
+// findStream returns a copy of a stream by name or playback ID, and whether it
+// was found by name. The transcoder is asked which stream playback IDs that are
+// not known yet belong to.
+func (sm *StreamManager) findStream(name string) (StreamInfo, bool, bool) {
+	sm.mutex.RLock()
+	if streamInfo, exists := sm.activeStreams[name]; exists {
+		defer sm.mutex.RUnlock()
+		return *streamInfo, true, true
+	}
+	for _, candidate := range sm.activeStreams {
+		if candidate.PlaybackID == name {
+			defer sm.mutex.RUnlock()
+			return *candidate, false, true
+		}
+	}
+	sm.mutex.RUnlock()
+
+	var id playbackIDInfo
+	found, err := sm.getTranscoder("/internal/playback/ids/"+url.PathEscape(name), &id)
+	if err != nil {
+		log.Printf("❌ Failed to resolve playback ID %s: %v", name, err)
+	}
+	if !found || err != nil {
+		return StreamInfo{}, false, false
+	}
+
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+	streamInfo, exists := sm.activeStreams[id.StreamKey]
+	if !exists {
+		return StreamInfo{}, false, false
+	}
+	return *streamInfo, false, true
+}
+
 // HTTP Handlers
 func (sm *StreamManager) getActiveStreams(c *gin.Context) {
 	sm.mutex.RLock()
@@ -301,17 +426,25 @@ func (sm *StreamManager) getActiveStreams(c *gin.Context) {
 }
 
 func (sm *StreamManager) getStreamStatus(c *gin.Context) {
-	streamName := c.Param("stream")
-
-	sm.mutex.RLock()
-	streamInfo, exists := sm.activeStreams[streamName]
-	sm.mutex.RUnlock()
-
+	streamInfo, byName, exists := sm.findStream(c.Param("stream"))
 	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
 		return
 	}
 
+	// Only someone who knows the stream key sees it
+	if !byName {
+		c.JSON(http.StatusOK, PlaybackStatus{
+			PlaybackID: streamInfo.PlaybackID,
+			Active:     streamInfo.Active,
+			Publishing: streamInfo.Publishing,
+			Clients:    streamInfo.Clients,
+			LastSeen:   streamInfo.LastSeen,
+			BWIn:       streamInfo.BWIn,
+			BWOut:      streamInfo.BWOut,
+		})
+		return
+	}
 	c.JSON(http.StatusOK, streamInfo)
 }
 
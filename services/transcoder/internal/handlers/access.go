@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetAccessRules handles requests to get the access rules of a stream
+func (h *Handler) GetAccessRules(c *gin.Context) {
+	rules, err := h.transcoderManager.AccessRules(c.Param("streamKey"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    rules,
+	})
+}
+
+// UpdateAccessRules handles requests to change the access rules of a stream.
+// Lists that are given replace the current ones. Changes are enforced by the
+// HLS servers from the next request, whether or not the stream is live.
+func (h *Handler) UpdateAccessRules(c *gin.Context) {
+	streamKey := c.Param("streamKey")
+
+	var req struct {
+		AllowCIDRs     *[]string `json:"allow_cidrs"`
+		DenyCIDRs      *[]string `json:"deny_cidrs"`
+		AllowCountries *[]string `json:"allow_countries"`
+		DenyCountries  *[]string `json:"deny_countries"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	rules, err := h.transcoderManager.AccessRules(streamKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	for _, list := range []struct {
+		value *[]string
+		dst   *[]string
+	}{
+		{req.AllowCIDRs, &rules.AllowCIDRs},
+		{req.DenyCIDRs, &rules.DenyCIDRs},
+		{req.AllowCountries, &rules.AllowCountries},
+		{req.DenyCountries, &rules.DenyCountries},
+	} {
+		if list.value != nil {
+			*list.dst = *list.value
+		}
+	}
+
+	rules, err = h.transcoderManager.SetAccessRules(rules)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Access rules updated",
+		"data":    rules,
+	})
+}
+
+// DeleteAccessRules handles requests to remove the access rules of a stream
+func (h *Handler) DeleteAccessRules(c *gin.Context) {
+	if err := h.transcoderManager.DeleteAccessRules(c.Param("streamKey")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Access rules removed",
+	})
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/streamforge/platform/pkg/models"
+	"github.com/streamforge/platform/services/transcoder/internal/transcoder"
+)
+
+// ScheduleAdCue handles requests to schedule an ad break on a live stream
+func (h *Handler) ScheduleAdCue(c *gin.Context) {
+	var req struct {
+		StreamKey string     `json:"stream_key" binding:"required"`
+		Duration  float64    `json:"duration" binding:"required"` // seconds
+		Start     *time.Time `json:"start"`                       // wall-clock cue-out time
+		Delay     float64    `json:"delay"`                       // seconds from now, when start is not given
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	cue, err := h.transcoderManager.ScheduleAdCue(transcoder.AdCueRequest{
+		StreamKey: req.StreamKey,
+		Duration:  req.Duration,
+		Start:     req.Start,
+		Delay:     req.Delay,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Ad break scheduled",
+		"data":    adCueResponse(cue),
+	})
+}
+
+// ListAdCues handles requests for the ad break history of a stream or session
+func (h *Handler) ListAdCues(c *gin.Context) {
+	var sessionID *uuid.UUID
+	if raw := c.Query("session_id"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "invalid session ID",
+			})
+			return
+		}
+		sessionID = &id
+	}
+
+	streamKey := c.Query("stream_key")
+	if streamKey == "" && sessionID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "stream_key or session_id is required",
+		})
+		return
+	}
+
+	cues, err := h.repo.ListAdCues(streamKey, sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	result := make([]gin.H, len(cues))
+	for i := range cues {
+		result[i] = adCueResponse(&cues[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+		"count":   len(result),
+	})
+}
+
+// GetAdCue handles requests to get a single ad break
+func (h *Handler) GetAdCue(c *gin.Context) {
+	cue, ok := h.lookupAdCue(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    adCueResponse(cue),
+	})
+}
+
+// CancelAdCue handles requests to cancel a scheduled ad break or end a running one early
+func (h *Handler) CancelAdCue(c *gin.Context) {
+	cue, ok := h.lookupAdCue(c)
+	if !ok {
+		return
+	}
+
+	cue, err := h.transcoderManager.CancelAdCue(cue.ID)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	message := "Ad break cancelled"
+	if cue.Status != models.AdCueStatusCancelled {
+		message = "Ad break ends at the next segment boundary"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": message,
+		"data":    adCueResponse(cue),
+	})
+}
+
+// lookupAdCue resolves the :id parameter, writing the error response if it fails
+func (h *Handler) lookupAdCue(c *gin.Context) (*models.AdCue, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "invalid ad cue ID",
+		})
+		return nil, false
+	}
+
+	cue, err := h.repo.GetAdCue(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+
+	return cue, true
+}
+
+// adCueResponse reports an ad cue with its current state and end time
+func adCueResponse(cue *models.AdCue) gin.H {
+	cue.Status = transcoder.AdCueState(cue, time.Now())
+	return gin.H{
+		"cue":      cue,
+		"end_time": cue.StartTime.Add(time.Duration(cue.Duration * float64(time.Second))),
+	}
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/streamforge/platform/pkg/models"
+	"github.com/streamforge/platform/services/transcoder/internal/transcoder"
+)
+
+// CreateClip handles requests to clip part of a live stream
+func (h *Handler) CreateClip(c *gin.Context) {
+	var req struct {
+		StreamKey string     `json:"stream_key" binding:"required"`
+		Title     string     `json:"title" binding:"required"`
+		Rendition string     `json:"rendition"`
+		Offset    float64    `json:"offset"`   // seconds behind the live edge
+		Duration  float64    `json:"duration"` // seconds
+		Start     *time.Time `json:"start"`    // program date-time range
+		End       *time.Time `json:"end"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	req.Title = strings.TrimSpace(req.Title)
+	if req.Title == "" || len(req.Title) > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "title must be between 1 and 200 characters",
+		})
+		return
+	}
+
+	clip, err := h.transcoderManager.CreateClip(transcoder.ClipRequest{
+		StreamKey: req.StreamKey,
+		Rendition: req.Rendition,
+		Title:     req.Title,
+		Offset:    req.Offset,
+		Duration:  req.Duration,
+		Start:     req.Start,
+		End:       req.End,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"message": "Clip is being processed",
+		"data":    h.clipResponse(clip),
+	})
+}
+
+// ListClips handles requests to list the clips of a stream. The stream key is
+// required, since it is what proves ownership.
+func (h *Handler) ListClips(c *gin.Context) {
+	streamKey := c.Query("stream_key")
+	if streamKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "stream_key is required",
+		})
+		return
+	}
+
+	clips, err := h.repo.ListClips(streamKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	result := make([]gin.H, len(clips))
+	for i := range clips {
+		result[i] = h.clipResponse(&clips[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+		"count":   len(result),
+	})
+}
+
+// GetClip handles requests to get a single clip
+func (h *Handler) GetClip(c *gin.Context) {
+	clip, ok := h.lookupClip(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    h.clipResponse(clip),
+	})
+}
+
+// DownloadClip handles requests to download the MP4 of a clip
+func (h *Handler) DownloadClip(c *gin.Context) {
+	clip, ok := h.lookupClip(c)
+	if !ok {
+		return
+	}
+
+	if clip.Status != models.ClipStatusReady {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"error":   fmt.Sprintf("clip is %s", clip.Status),
+		})
+		return
+	}
+
+	c.FileAttachment(clip.MP4Path, fmt.Sprintf("%s-%s.mp4", h.transcoderManager.PlaybackID(clip.StreamKey), clip.ID))
+}
+
+// DeleteClip handles requests to delete a clip and its files
+func (h *Handler) DeleteClip(c *gin.Context) {
+	clip, ok := h.lookupClip(c)
+	if !ok {
+		return
+	}
+
+	if err := h.transcoderManager.DeleteClip(clip.ID); err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Clip deleted successfully",
+	})
+}
+
+// lookupClip resolves the :id parameter to a clip of a stream the caller owns,
+// writing the error response if it fails
+func (h *Handler) lookupClip(c *gin.Context) (*models.Clip, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "invalid clip ID",
+		})
+		return nil, false
+	}
+
+	clip, err := h.repo.GetClip(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+	if !h.authorizeStreamOwner(c, clip.StreamKey) {
+		return nil, false
+	}
+
+	return clip, true
+}
+
+// clipResponse adds playback and download URLs to a clip
+func (h *Handler) clipResponse(clip *models.Clip) gin.H {
+	response := gin.H{
+		"clip": clip,
+	}
+
+	if clip.Status == models.ClipStatusReady {
+		rel, err := filepath.Rel(h.transcoderManager.RecordingsDir(), clip.PlaylistPath)
+		if err == nil {
+			response["playlist_url"] = "/vod/" + filepath.ToSlash(rel)
+		}
+		response["download_url"] = fmt.Sprintf("/clips/%s/download", clip.ID)
+	}
+
+	return response
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/streamforge/platform/pkg/models"
+	"github.com/streamforge/platform/services/transcoder/internal/transcoder"
+)
+
+// GetEncoderOverrides handles requests to get the encoder overrides of a stream
+func (h *Handler) GetEncoderOverrides(c *gin.Context) {
+	settings, err := h.repo.GetStreamSettings(c.Param("streamKey"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    settings.Encoder,
+	})
+}
+
+// UpdateEncoderOverrides handles requests to replace the encoder overrides of a
+// stream. Fields left out fall back to the defaults. A live stream's encoder is
+// restarted so the change applies right away.
+func (h *Handler) UpdateEncoderOverrides(c *gin.Context) {
+	streamKey := c.Param("streamKey")
+
+	// Unknown fields are rejected so a misspelt option is not silently ignored
+	var overrides models.EncoderOverrides
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&overrides); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := transcoder.ValidateEncoderOverrides(overrides); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	settings, err := h.repo.GetStreamSettings(streamKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	settings.Encoder = overrides
+	if settings, err = h.repo.SaveStreamSettings(settings); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	restarted := h.transcoderManager.RestartEncoder(streamKey, "encoder overrides changed") == nil
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"message":   "Encoder overrides updated",
+		"data":      settings.Encoder,
+		"restarted": restarted,
+	})
+}
+
+// GetEncoderPlan handles requests for the FFmpeg command the transcoder would
+// run for a stream with its current settings, without running it
+func (h *Handler) GetEncoderPlan(c *gin.Context) {
+	streamKey := c.Param("streamKey")
+	if streamKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "stream key is required",
+		})
+		return
+	}
+
+	args, restart := h.transcoderManager.PlanFFmpegCommand(streamKey)
+	mode := "start"
+	if restart {
+		mode = "restart"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"stream_key": streamKey,
+			"mode":       mode,
+			"command":    "ffmpeg",
+			"args":       args,
+		},
+	})
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/streamforge/platform/services/transcoder/internal/transcoder"
+)
+
+// eventHeartbeat is how often an idle event stream sends a comment to keep
+// proxies from closing it
+const eventHeartbeat = 15 * time.Second
+
+// StreamEvents streams the state transitions of a stream as Server-Sent
+// Events, to its owner or to the platform's services. Clients that reconnect
+// with Last-Event-ID receive the recent events they missed.
+func (h *Handler) StreamEvents(c *gin.Context) {
+	streamKey := c.Query("stream_key")
+	if streamKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "stream_key is required",
+		})
+		return
+	}
+	if !h.internalRequest(c) && !h.authorizeStreamOwner(c, streamKey) {
+		return
+	}
+
+	var lastEventID uint64
+	if value := c.GetHeader("Last-Event-ID"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "Last-Event-ID must be an event id",
+			})
+			return
+		}
+		lastEventID = id
+	}
+
+	missed, events, unsubscribe := h.transcoderManager.Events().Subscribe(lastEventID)
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	// Tell the client how long to wait before reconnecting
+	fmt.Fprint(c.Writer, "retry: 3000\n\n")
+	for _, event := range missed {
+		if err := writeEvent(c, streamKey, event); err != nil {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return // fell too far behind; the client resumes from its last event
+			}
+			if err := writeEvent(c, streamKey, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+// writeEvent writes a stream event in the Server-Sent Events format unless it
+// belongs to a stream other than the one asked for
+func writeEvent(c *gin.Context, streamKey string, event transcoder.SequencedEvent) error {
+	if event.StreamKey != streamKey {
+		return nil
+	}
+	data, err := json.Marshal(event.Event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Event.Type, data)
+	return err
+}
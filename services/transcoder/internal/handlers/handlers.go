@@ -1,23 +1,29 @@
 package handlers
 
 import (
-	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/streamforge/platform/services/transcoder/internal/repository"
 	"github.com/streamforge/platform/services/transcoder/internal/transcoder"
 )
 
 // Handler handles HTTP requests for the transcoder service
 type Handler struct {
 	transcoderManager *transcoder.Manager
+	repo              *repository.StreamRepository
+	// Verifies the user tokens of the user management service
+	jwtSecret []byte
+	// Authenticates other services on internal routes
+	internalSecret string
 }
 
 // NewHandler creates a new handler instance
-func NewHandler(transcoderManager *transcoder.Manager) *Handler {
+func NewHandler(transcoderManager *transcoder.Manager, repo *repository.StreamRepository) *Handler {
 	return &Handler{
 		transcoderManager: transcoderManager,
+		repo:              repo,
 	}
 }
 
@@ -44,7 +50,7 @@ func (h *Handler) GetQualityProfiles(c *gin.Context) {
 			"video_bitrate": profile.VideoBitrate,
 			"max_bitrate":   profile.MaxBitrate,
 			"buffer_size":   profile.BufSize,
-			"hls_path":      fmt.Sprintf("/%d/index.m3u8", i),
+			"hls_path":      "/" + transcoder.VariantPlaylistPath(profile.Name),
 		}
 	}
 
@@ -76,13 +82,15 @@ func (h *Handler) StartTranscoder(c *gin.Context) {
 		return
 	}
 
+	playbackID := h.transcoderManager.PlaybackID(streamKey)
 	c.JSON(http.StatusOK, gin.H{
-		"success":    true,
-		"message":    "Transcoder started successfully",
-		"stream_key": streamKey,
-		"hls_url":    "/hls/" + streamKey + "/master.m3u8",
+		"success":     true,
+		"message":     "Transcoder started successfully",
+		"stream_key":  streamKey,
+		"playback_id": playbackID,
+		"hls_url":     "/hls/" + playbackID + "/master.m3u8",
 		"endpoints": gin.H{
-			"master_playlist": "/hls/" + streamKey + "/master.m3u8",
+			"master_playlist": "/hls/" + playbackID + "/master.m3u8",
 			"status":          "/transcode/status/" + streamKey,
 			"stop":            "/transcode/stop/" + streamKey,
 		},
@@ -117,6 +125,46 @@ func (h *Handler) StopTranscoder(c *gin.Context) {
 	})
 }
 
+// DisconnectPublisher handles the publisher of a stream going away. Streams with a
+// slate grace period keep running on their slate; others wait for the publisher
+// to reconnect, or are stopped when reconnects are disabled.
+func (h *Handler) DisconnectPublisher(c *gin.Context) {
+	streamKey := c.Param("streamKey")
+
+	if streamKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "stream key is required",
+		})
+		return
+	}
+
+	outcome, err := h.transcoderManager.DisconnectPublisher(streamKey)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	message := "Transcoder stopped successfully"
+	switch outcome {
+	case transcoder.DisconnectSlate:
+		message = "Showing slate until the publisher returns"
+	case transcoder.DisconnectReconnect:
+		message = "Waiting for the publisher to reconnect"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"message":     message,
+		"stream_key":  streamKey,
+		"playback_id": h.transcoderManager.PlaybackID(streamKey),
+		"outcome":     outcome,
+		"slate":       outcome == transcoder.DisconnectSlate,
+	})
+}
+
 // GetTranscoderStatus handles requests to get transcoder status for a stream
 func (h *Handler) GetTranscoderStatus(c *gin.Context) {
 	streamKey := c.Param("streamKey")
@@ -142,6 +190,7 @@ func (h *Handler) GetTranscoderStatus(c *gin.Context) {
 	uptime := time.Since(process.StartTime)
 
 	// Build quality URLs
+	playbackID := h.transcoderManager.PlaybackID(streamKey)
 	qualities := make([]gin.H, len(process.Qualities))
 	for i, quality := range process.Qualities {
 		qualities[i] = gin.H{
@@ -149,22 +198,28 @@ func (h *Handler) GetTranscoderStatus(c *gin.Context) {
 			"name":       quality.Name,
 			"resolution": quality.Resolution,
 			"bitrate":    quality.VideoBitrate,
-			"url":        "/hls/" + streamKey + "/" + fmt.Sprintf("%d", i) + "/index.m3u8",
+			"url":        "/hls/" + playbackID + "/" + transcoder.VariantPlaylistPath(quality.Name),
 		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data": gin.H{
-			"stream_key":     streamKey,
-			"status":         process.Status,
-			"start_time":     process.StartTime,
-			"uptime":         uptime.String(),
-			"uptime_seconds": int(uptime.Seconds()),
-			"output_dir":     process.OutputDir,
-			"pid":            process.PID,
-			"hls_master":     "/hls/" + streamKey + "/master.m3u8",
-			"qualities":      qualities,
+			"stream_key":      streamKey,
+			"playback_id":     playbackID,
+			"status":          process.Status,
+			"start_time":      process.StartTime,
+			"uptime":          uptime.String(),
+			"uptime_seconds":  int(uptime.Seconds()),
+			"output_dir":      process.OutputDir,
+			"pid":             process.PID,
+			"state_since":     process.StateSince,
+			"state_reason":    process.StateReason,
+			"transitions":     process.Transitions,
+			"slate_since":     process.SlateSince,
+			"reconnect_until": process.ReconnectUntil,
+			"hls_master":      "/hls/" + playbackID + "/master.m3u8",
+			"qualities":       qualities,
 		},
 	})
 }
@@ -176,16 +231,18 @@ func (h *Handler) GetActiveTranscoders(c *gin.Context) {
 	result := make([]gin.H, 0, len(activeTranscoders))
 	for streamKey, process := range activeTranscoders {
 		uptime := time.Since(process.StartTime)
+		playbackID := h.transcoderManager.PlaybackID(streamKey)
 
 		result = append(result, gin.H{
 			"stream_key":     streamKey,
+			"playback_id":    playbackID,
 			"status":         process.Status,
 			"start_time":     process.StartTime,
 			"uptime":         uptime.String(),
 			"uptime_seconds": int(uptime.Seconds()),
 			"output_dir":     process.OutputDir,
 			"pid":            process.PID,
-			"hls_master":     "/hls/" + streamKey + "/master.m3u8",
+			"hls_master":     "/hls/" + playbackID + "/master.m3u8",
 			"quality_count":  len(process.Qualities),
 		})
 	}
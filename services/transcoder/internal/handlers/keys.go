@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/streamforge/platform/pkg/playback"
+	"github.com/streamforge/platform/services/transcoder/internal/transcoder"
+)
+
+// GetKey handles requests from players for the content key of an encrypted
+// stream. Viewers authorize with the playback token the playlist was loaded
+// with, which is appended to the key URI, or as a signed-in user.
+func (h *Handler) GetKey(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("keyID"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   transcoder.ErrKeyNotFound.Error(),
+		})
+		return
+	}
+
+	var key []byte
+	if c.GetHeader("Authorization") != "" {
+		userID, ok := h.authenticatedUser(c)
+		if !ok {
+			return
+		}
+		key, err = h.transcoderManager.ReleaseKeyToUser(id, userID)
+	} else {
+		key, err = h.transcoderManager.ReleaseKey(id, c.Query(playback.TokenParam), c.ClientIP())
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, transcoder.ErrKeyNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, transcoder.ErrKeyNotReleased):
+			status = http.StatusForbidden
+		case errors.Is(err, transcoder.ErrEncryptionDisabled):
+			status = http.StatusServiceUnavailable
+		case errors.Is(err, playback.ErrTokenRequired), errors.Is(err, playback.ErrTokensDisabled),
+			errors.Is(err, playback.ErrTokenInvalid), errors.Is(err, playback.ErrTokenExpired),
+			errors.Is(err, playback.ErrTokenScope), errors.Is(err, playback.ErrTokenIP):
+			status = playback.StatusCode(err)
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	// Keys must not linger in shared caches
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "application/octet-stream", key)
+}
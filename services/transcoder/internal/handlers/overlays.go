@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/streamforge/platform/pkg/models"
+)
+
+// fontColorPattern matches FFmpeg color names and hex colors
+var fontColorPattern = regexp.MustCompile(`^(#|0x)?[A-Za-z0-9]+$`)
+
+// overlayRequest is the body of create and update overlay requests
+type overlayRequest struct {
+	StreamKey  string                  `json:"stream_key"`
+	Name       *string                 `json:"name"`
+	Type       *models.OverlayType     `json:"type"`
+	ImageURL   *string                 `json:"image_url"`
+	Text       *string                 `json:"text"`
+	Position   *models.OverlayPosition `json:"position"`
+	Margin     *int                    `json:"margin"`
+	Opacity    *float64                `json:"opacity"`
+	Scale      *float64                `json:"scale"`
+	FontSize   *int                    `json:"font_size"`
+	FontColor  *string                 `json:"font_color"`
+	Renditions *string                 `json:"renditions"`
+	Enabled    *bool                   `json:"enabled"`
+}
+
+// ListOverlays handles requests from the owner of a stream to list its overlays
+func (h *Handler) ListOverlays(c *gin.Context) {
+	streamKey := c.Query("stream_key")
+	if streamKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "stream_key is required",
+		})
+		return
+	}
+	if !h.authorizeStreamOwner(c, streamKey) {
+		return
+	}
+
+	overlays, err := h.repo.ListOverlays(streamKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    overlays,
+		"count":   len(overlays),
+	})
+}
+
+// CreateOverlay handles requests from the owner of a stream to add an overlay to it
+func (h *Handler) CreateOverlay(c *gin.Context) {
+	var req overlayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if req.StreamKey == "" || req.Name == nil || req.Type == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "stream_key, name and type are required",
+		})
+		return
+	}
+	if !h.authorizeStreamOwner(c, req.StreamKey) {
+		return
+	}
+
+	overlay := &models.StreamOverlay{
+		StreamKey: req.StreamKey,
+		Position:  models.OverlayPositionTopRight,
+		Margin:    20,
+		Opacity:   1,
+		Scale:     0.15,
+		FontSize:  48,
+		FontColor: "white",
+		Enabled:   true,
+	}
+	if err := h.applyOverlayRequest(overlay, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	overlay, err := h.repo.CreateOverlay(overlay)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success":   true,
+		"message":   "Overlay created",
+		"data":      overlay,
+		"restarted": h.restartForOverlays(overlay.StreamKey),
+	})
+}
+
+// UpdateOverlay handles requests from the owner of a stream to change one of
+// its overlays, such as toggling it on or off
+func (h *Handler) UpdateOverlay(c *gin.Context) {
+	overlay, ok := h.lookupOverlay(c)
+	if !ok || !h.authorizeStreamOwner(c, overlay.StreamKey) {
+		return
+	}
+
+	var req overlayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := h.applyOverlayRequest(overlay, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	overlay, err := h.repo.UpdateOverlay(overlay)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"message":   "Overlay updated",
+		"data":      overlay,
+		"restarted": h.restartForOverlays(overlay.StreamKey),
+	})
+}
+
+// DeleteOverlay handles requests from the owner of a stream to remove one of its overlays
+func (h *Handler) DeleteOverlay(c *gin.Context) {
+	overlay, ok := h.lookupOverlay(c)
+	if !ok || !h.authorizeStreamOwner(c, overlay.StreamKey) {
+		return
+	}
+
+	if err := h.repo.DeleteOverlay(overlay.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"message":   "Overlay deleted successfully",
+		"restarted": h.restartForOverlays(overlay.StreamKey),
+	})
+}
+
+// restartForOverlays restarts the encoder of a live stream so overlay changes show
+// up immediately. It reports whether a restart happened.
+func (h *Handler) restartForOverlays(streamKey string) bool {
+	return h.transcoderManager.RestartEncoder(streamKey, "overlays changed") == nil
+}
+
+// lookupOverlay resolves the :id parameter, writing the error response if it fails
+func (h *Handler) lookupOverlay(c *gin.Context) (*models.StreamOverlay, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "invalid overlay ID",
+		})
+		return nil, false
+	}
+
+	overlay, err := h.repo.GetOverlay(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+
+	return overlay, true
+}
+
+// applyOverlayRequest validates a request and copies its fields onto an overlay
+func (h *Handler) applyOverlayRequest(overlay *models.StreamOverlay, req *overlayRequest) error {
+	if req.Name != nil {
+		if strings.TrimSpace(*req.Name) == "" {
+			return fmt.Errorf("name must not be empty")
+		}
+		overlay.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Type != nil {
+		switch *req.Type {
+		case models.OverlayTypeImage, models.OverlayTypeText, models.OverlayTypeClock:
+			overlay.Type = *req.Type
+		default:
+			return fmt.Errorf("invalid type %q (expected image, text or clock)", *req.Type)
+		}
+	}
+	if req.ImageURL != nil {
+		overlay.ImageURL = strings.TrimSpace(*req.ImageURL)
+	}
+	if req.Text != nil {
+		overlay.Text = *req.Text
+	}
+	if req.Position != nil {
+		switch *req.Position {
+		case models.OverlayPositionTopLeft, models.OverlayPositionTopRight,
+			models.OverlayPositionBottomLeft, models.OverlayPositionBottomRight, models.OverlayPositionCenter:
+			overlay.Position = *req.Position
+		default:
+			return fmt.Errorf("invalid position %q", *req.Position)
+		}
+	}
+	if req.Margin != nil {
+		if *req.Margin < 0 || *req.Margin > 500 {
+			return fmt.Errorf("margin must be between 0 and 500")
+		}
+		overlay.Margin = *req.Margin
+	}
+	if req.Opacity != nil {
+		if *req.Opacity < 0 || *req.Opacity > 1 {
+			return fmt.Errorf("opacity must be between 0 and 1")
+		}
+		overlay.Opacity = *req.Opacity
+	}
+	if req.Scale != nil {
+		if *req.Scale <= 0 || *req.Scale > 1 {
+			return fmt.Errorf("scale must be greater than 0 and at most 1")
+		}
+		overlay.Scale = *req.Scale
+	}
+	if req.FontSize != nil {
+		if *req.FontSize < 8 || *req.FontSize > 200 {
+			return fmt.Errorf("font_size must be between 8 and 200")
+		}
+		overlay.FontSize = *req.FontSize
+	}
+	if req.FontColor != nil {
+		if !fontColorPattern.MatchString(*req.FontColor) {
+			return fmt.Errorf("invalid font_color %q", *req.FontColor)
+		}
+		overlay.FontColor = *req.FontColor
+	}
+	if req.Renditions != nil {
+		for _, name := range strings.Split(*req.Renditions, ",") {
+			if name = strings.TrimSpace(name); name != "" && !h.isRendition(name) {
+				return fmt.Errorf("unknown rendition %q", name)
+			}
+		}
+		overlay.Renditions = *req.Renditions
+	}
+	if req.Enabled != nil {
+		overlay.Enabled = *req.Enabled
+	}
+
+	// Check the combination once every field is applied
+	switch overlay.Type {
+	case models.OverlayTypeImage:
+		if overlay.ImageURL == "" {
+			return fmt.Errorf("image overlays need an image_url")
+		}
+		if err := h.transcoderManager.CheckOverlayImage(overlay.ImageURL); err != nil {
+			return err
+		}
+	case models.OverlayTypeText:
+		if strings.TrimSpace(overlay.Text) == "" {
+			return fmt.Errorf("text overlays need text")
+		}
+	}
+	return nil
+}
+
+// isRendition reports whether a name is part of the transcoder's quality ladder
+func (h *Handler) isRendition(name string) bool {
+	for _, rendition := range h.transcoderManager.Renditions() {
+		if rendition == name {
+			return true
+		}
+	}
+	return false
+}
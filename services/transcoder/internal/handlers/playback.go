@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/streamforge/platform/pkg/playback"
+	"github.com/streamforge/platform/services/transcoder/internal/transcoder"
+)
+
+// SetJWTSecret sets the secret user tokens from the user management service are signed with
+func (h *Handler) SetJWTSecret(secret string) {
+	h.jwtSecret = []byte(secret)
+}
+
+// SetInternalSecret sets the secret other services authenticate with on internal routes
+func (h *Handler) SetInternalSecret(secret string) {
+	h.internalSecret = secret
+}
+
+// IssuePlaybackToken handles requests from signed-in users for a playback token
+func (h *Handler) IssuePlaybackToken(c *gin.Context) {
+	userID, ok := h.authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		PlaybackID string `json:"playback_id" binding:"required"`
+		TTLSeconds int    `json:"ttl_seconds"` // default one hour, at most a day
+		IP         string `json:"ip"`          // binds the token to the viewer's address
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	token, err := h.transcoderManager.IssuePlaybackToken(userID, req.PlaybackID, time.Duration(req.TTLSeconds)*time.Second, req.IP)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, transcoder.ErrNotStreamOwner):
+			status = http.StatusForbidden
+		case errors.Is(err, transcoder.ErrPlaybackTokensDisabled):
+			status = http.StatusServiceUnavailable
+		case errors.Is(err, transcoder.ErrPlaybackIDNotFound):
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data": gin.H{
+			"token":        token.Token,
+			"playback_id":  token.PlaybackID,
+			"expires_at":   token.ExpiresAt,
+			"ip":           token.IP,
+			"playback_url": "/hls/" + token.PlaybackID + "/master.m3u8?" + playback.TokenQuery(token.Token),
+		},
+	})
+}
+
+// authorizeStreamOwner checks that the bearer token belongs to the owner of a
+// registered stream, writing the error response when it does not
+func (h *Handler) authorizeStreamOwner(c *gin.Context, streamKey string) bool {
+	userID, ok := h.authenticatedUser(c)
+	if !ok {
+		return false
+	}
+	if err := h.transcoderManager.CheckStreamOwner(userID, streamKey); err != nil {
+		playbackIDError(c, err)
+		return false
+	}
+	return true
+}
+
+// authenticatedUser returns the user of the bearer token issued by the user
+// management service, writing a 401 response when there is no valid one
+func (h *Handler) authenticatedUser(c *gin.Context) (uuid.UUID, bool) {
+	unauthorized := func(message string) (uuid.UUID, bool) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"error":   message,
+		})
+		return uuid.Nil, false
+	}
+
+	if len(h.jwtSecret) == 0 {
+		return unauthorized("user authentication is not configured")
+	}
+	raw, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !found {
+		return unauthorized("missing bearer token")
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(*jwt.Token) (interface{}, error) {
+		return h.jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return unauthorized("invalid bearer token")
+	}
+	subject, _ := claims["user_id"].(string)
+	userID, err := uuid.Parse(subject)
+	if err != nil {
+		return unauthorized("invalid bearer token")
+	}
+	return userID, true
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/streamforge/platform/pkg/models"
+	"github.com/streamforge/platform/services/transcoder/internal/transcoder"
+)
+
+// ListPlaybackIDs handles requests to list the playback IDs of a stream
+func (h *Handler) ListPlaybackIDs(c *gin.Context) {
+	streamKey := c.Query("stream_key")
+	if streamKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "stream_key is required",
+		})
+		return
+	}
+
+	ids, err := h.transcoderManager.ListPlaybackIDs(streamKey)
+	if err != nil {
+		playbackIDError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    ids,
+		"count":   len(ids),
+	})
+}
+
+// CreatePlaybackID handles requests from the owner of a stream to add a playback ID to it
+func (h *Handler) CreatePlaybackID(c *gin.Context) {
+	userID, ok := h.authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		StreamKey string                `json:"stream_key" binding:"required"`
+		Policy    models.PlaybackPolicy `json:"policy"` // public when empty
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if req.Policy == "" {
+		req.Policy = models.PlaybackPolicyPublic
+	}
+	if err := validatePlaybackPolicy(req.Policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	id, err := h.transcoderManager.CreatePlaybackID(userID, req.StreamKey, req.Policy)
+	if err != nil {
+		playbackIDError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Playback ID created",
+		"data":    id,
+	})
+}
+
+// GetPlaybackID handles public requests to resolve a playback ID to its
+// stream. The stream key is left out, see ResolvePlaybackID.
+func (h *Handler) GetPlaybackID(c *gin.Context) {
+	id, err := h.transcoderManager.GetPlaybackID(c.Param("playbackID"))
+	if err != nil {
+		playbackIDError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"id":          id.ID,
+			"playback_id": id.PlaybackID,
+			"policy":      id.Policy,
+			"stream_id":   id.StreamID,
+			"title":       id.Stream.Title,
+			"status":      id.Stream.Status,
+			"directory":   h.transcoderManager.PlaybackID(id.Stream.StreamKey),
+			"created_at":  id.CreatedAt,
+			"updated_at":  id.UpdatedAt,
+		},
+	})
+}
+
+// internalRequest reports whether a request carries the secret shared by the
+// platform's services
+func (h *Handler) internalRequest(c *gin.Context) bool {
+	authorization := []byte(c.GetHeader("Authorization"))
+	return len(h.internalSecret) > 0 && subtle.ConstantTimeCompare(authorization, []byte("Bearer "+h.internalSecret)) == 1
+}
+
+// ResolvePlaybackID handles requests from other services to resolve a playback
+// ID to the key of its stream. They authenticate with the internal API secret,
+// and anything else is answered as if the route did not exist.
+func (h *Handler) ResolvePlaybackID(c *gin.Context) {
+	if !h.internalRequest(c) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "not found",
+		})
+		return
+	}
+
+	id, err := h.transcoderManager.GetPlaybackID(c.Param("playbackID"))
+	if err != nil {
+		playbackIDError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"playback_id": id.PlaybackID,
+			"policy":      id.Policy,
+			"stream_id":   id.StreamID,
+			"stream_key":  id.Stream.StreamKey,
+			"directory":   h.transcoderManager.PlaybackID(id.Stream.StreamKey),
+		},
+	})
+}
+
+// UpdatePlaybackID handles requests from the owner of a stream to change the policy of one of its playback IDs
+func (h *Handler) UpdatePlaybackID(c *gin.Context) {
+	userID, ok := h.authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Policy models.PlaybackPolicy `json:"policy" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := validatePlaybackPolicy(req.Policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	id, err := h.transcoderManager.UpdatePlaybackID(userID, c.Param("playbackID"), req.Policy)
+	if err != nil {
+		playbackIDError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Playback ID updated",
+		"data":    id,
+	})
+}
+
+// DeletePlaybackID handles requests from the owner of a stream to remove one of its playback IDs
+func (h *Handler) DeletePlaybackID(c *gin.Context) {
+	userID, ok := h.authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.transcoderManager.DeletePlaybackID(userID, c.Param("playbackID")); err != nil {
+		playbackIDError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Playback ID deleted",
+	})
+}
+
+// RotateStreamKey handles requests from the owner of a stream to replace its
+// stream key. The playback IDs, and so the playback URLs, are unchanged.
+func (h *Handler) RotateStreamKey(c *gin.Context) {
+	userID, ok := h.authenticatedUser(c)
+	if !ok {
+		return
+	}
+
+	streamKey, err := h.transcoderManager.RotateStreamKey(userID, c.Param("streamKey"))
+	if err != nil {
+		playbackIDError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Stream key rotated",
+		"data": gin.H{
+			"stream_key":  streamKey,
+			"playback_id": h.transcoderManager.PlaybackID(streamKey),
+		},
+	})
+}
+
+// validatePlaybackPolicy checks that a playback policy is known
+func validatePlaybackPolicy(policy models.PlaybackPolicy) error {
+	switch policy {
+	case models.PlaybackPolicyPublic, models.PlaybackPolicySigned:
+		return nil
+	}
+	return fmt.Errorf("invalid policy %q (expected public or signed)", policy)
+}
+
+// playbackIDError writes the response for an error from a playback ID operation
+func playbackIDError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, transcoder.ErrStreamNotRegistered), errors.Is(err, transcoder.ErrPlaybackIDNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, transcoder.ErrLastPlaybackID), errors.Is(err, transcoder.ErrStreamLive):
+		status = http.StatusConflict
+	case errors.Is(err, transcoder.ErrStreamNotOwned):
+		status = http.StatusForbidden
+	}
+	c.JSON(status, gin.H{
+		"success": false,
+		"error":   err.Error(),
+	})
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/streamforge/platform/pkg/models"
+	"github.com/streamforge/platform/services/transcoder/internal/transcoder"
+)
+
+// maxBeaconBytes limits the size of a beacon request body
+const maxBeaconBytes = 256 << 10
+
+// RecordBeacon handles batches of playback events sent by players. Players may
+// send them with navigator.sendBeacon, so any content type is read as JSON.
+func (h *Handler) RecordBeacon(c *gin.Context) {
+	var req struct {
+		SessionID  string     `json:"session_id" binding:"required"`
+		PlaybackID string     `json:"playback_id" binding:"required"`
+		SentAt     *time.Time `json:"sent_at"` // player clock, corrects the times of the events
+		Events     []struct {
+			Type      models.PlaybackEventType `json:"type" binding:"required"`
+			Time      time.Time                `json:"time" binding:"required"`
+			StartupMS int                      `json:"startup_ms"` // play: from load to the first frame
+			Rendition string                   `json:"rendition"`  // play, rendition_switch
+			Bitrate   int                      `json:"bitrate"`    // play, rendition_switch: bits per second
+			Error     string                   `json:"error"`      // error
+			Fatal     bool                     `json:"fatal"`      // error: playback stopped
+		} `json:"events" binding:"required"`
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBeaconBytes)
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	beacon := transcoder.Beacon{SessionID: req.SessionID, PlaybackID: req.PlaybackID}
+	if req.SentAt != nil {
+		beacon.SentAt = *req.SentAt
+	}
+	for _, event := range req.Events {
+		beacon.Events = append(beacon.Events, transcoder.BeaconEvent{
+			Type:      event.Type,
+			Time:      event.Time,
+			StartupMS: event.StartupMS,
+			Rendition: event.Rendition,
+			Bitrate:   event.Bitrate,
+			Error:     event.Error,
+			Fatal:     event.Fatal,
+		})
+	}
+
+	accepted, err := h.transcoderManager.RecordBeacon(beacon)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, transcoder.ErrPlaybackIDNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"data": gin.H{
+			"accepted": accepted,
+		},
+	})
+}
+
+// GetQoEReport handles requests for the playback quality of a stream's viewers,
+// over the last day unless since and until (RFC 3339) are given
+func (h *Handler) GetQoEReport(c *gin.Context) {
+	until := time.Now()
+	if raw := c.Query("until"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "invalid until time (expected RFC 3339)",
+			})
+			return
+		}
+		until = parsed
+	}
+	since := until.Add(-24 * time.Hour)
+	if raw := c.Query("since"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "invalid since time (expected RFC 3339)",
+			})
+			return
+		}
+		since = parsed
+	}
+
+	report, err := h.transcoderManager.QoEReport(c.Param("streamKey"), since, until)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    report,
+	})
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/streamforge/platform/pkg/models"
+)
+
+// ListRecordings handles requests to list the recordings of a stream, optionally
+// by session. The stream key is required, since it is what proves ownership.
+func (h *Handler) ListRecordings(c *gin.Context) {
+	streamKey := c.Query("stream_key")
+	if streamKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "stream_key is required",
+		})
+		return
+	}
+
+	var sessionID *uuid.UUID
+	if raw := c.Query("session_id"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "invalid session ID",
+			})
+			return
+		}
+		sessionID = &id
+	}
+
+	recordings, err := h.repo.ListRecordings(streamKey, sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	result := make([]gin.H, len(recordings))
+	for i := range recordings {
+		result[i] = h.recordingResponse(&recordings[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+		"count":   len(result),
+	})
+}
+
+// GetRecording handles requests to get a single recording
+func (h *Handler) GetRecording(c *gin.Context) {
+	recording, ok := h.lookupRecording(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    h.recordingResponse(recording),
+	})
+}
+
+// DownloadRecording handles requests to download the MP4 of a recording
+func (h *Handler) DownloadRecording(c *gin.Context) {
+	recording, ok := h.lookupRecording(c)
+	if !ok {
+		return
+	}
+
+	if recording.Status != models.RecordingStatusReady {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"error":   fmt.Sprintf("recording is %s", recording.Status),
+		})
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s-%s.mp4", h.transcoderManager.PlaybackID(recording.StreamKey), recording.Rendition,
+		recording.StartedAt.UTC().Format("20060102-150405"))
+	c.FileAttachment(recording.MP4Path, filename)
+}
+
+// DeleteRecording handles requests to delete a recording and its files
+func (h *Handler) DeleteRecording(c *gin.Context) {
+	recording, ok := h.lookupRecording(c)
+	if !ok {
+		return
+	}
+
+	if err := h.transcoderManager.DeleteRecording(recording.ID); err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Recording deleted successfully",
+	})
+}
+
+// lookupRecording resolves the :id parameter to a recording of a stream the
+// caller owns, writing the error response if it fails
+func (h *Handler) lookupRecording(c *gin.Context) (*models.Recording, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "invalid recording ID",
+		})
+		return nil, false
+	}
+
+	recording, err := h.repo.GetRecording(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+	if !h.authorizeStreamOwner(c, recording.StreamKey) {
+		return nil, false
+	}
+
+	return recording, true
+}
+
+// recordingResponse adds playback and download URLs to a recording
+func (h *Handler) recordingResponse(recording *models.Recording) gin.H {
+	response := gin.H{
+		"recording": recording,
+	}
+
+	if recording.Status == models.RecordingStatusReady {
+		rel, err := filepath.Rel(h.transcoderManager.RecordingsDir(), recording.PlaylistPath)
+		if err == nil {
+			response["playlist_url"] = "/vod/" + filepath.ToSlash(rel)
+
+			// Thumbnails are optional, only advertise them once they exist
+			dir := filepath.Dir(recording.PlaylistPath)
+			base := "/vod/" + filepath.ToSlash(filepath.Dir(rel))
+			if _, err := os.Stat(filepath.Join(dir, "thumb.jpg")); err == nil {
+				response["thumbnail_url"] = base + "/thumb.jpg"
+			}
+			if _, err := os.Stat(filepath.Join(dir, "thumbs", "thumbnails.vtt")); err == nil {
+				response["thumbnails_url"] = base + "/thumbs/thumbnails.vtt"
+			}
+		}
+		response["download_url"] = fmt.Sprintf("/recordings/%s/download", recording.ID)
+	}
+
+	return response
+}
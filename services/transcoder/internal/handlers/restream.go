@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/streamforge/platform/pkg/models"
+	"github.com/streamforge/platform/services/transcoder/internal/transcoder"
+)
+
+// restreamTargetRequest is the body of create and update restream target requests
+type restreamTargetRequest struct {
+	StreamKey string  `json:"stream_key"`
+	Name      *string `json:"name"`
+	URL       *string `json:"url"`
+	Key       *string `json:"key"`
+	Enabled   *bool   `json:"enabled"`
+}
+
+// ListRestreamTargets handles requests to list the restream targets of a stream with their relay state
+func (h *Handler) ListRestreamTargets(c *gin.Context) {
+	streamKey := c.Query("stream_key")
+	if streamKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "stream_key is required",
+		})
+		return
+	}
+	if !h.authorizeStreamOwner(c, streamKey) {
+		return
+	}
+
+	targets, err := h.repo.ListRestreamTargets(streamKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	stats := h.transcoderManager.RelayStats(streamKey)
+	result := make([]gin.H, len(targets))
+	for i := range targets {
+		result[i] = restreamTargetResponse(&targets[i], stats)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+		"count":   len(result),
+	})
+}
+
+// CreateRestreamTarget handles requests to add a restream target to a stream
+func (h *Handler) CreateRestreamTarget(c *gin.Context) {
+	var req restreamTargetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if req.StreamKey == "" || req.Name == nil || req.URL == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "stream_key, name and url are required",
+		})
+		return
+	}
+	if !h.authorizeStreamOwner(c, req.StreamKey) {
+		return
+	}
+
+	target := &models.RestreamTarget{StreamKey: req.StreamKey, Enabled: true}
+	if err := applyRestreamTargetRequest(target, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	target, err := h.repo.CreateRestreamTarget(target)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Restream target created. It is relayed the next time the stream goes live.",
+		"data":    restreamTargetResponse(target, nil),
+	})
+}
+
+// UpdateRestreamTarget handles requests to change a restream target. A running
+// relay is restarted with the new settings.
+func (h *Handler) UpdateRestreamTarget(c *gin.Context) {
+	target, ok := h.lookupRestreamTarget(c)
+	if !ok {
+		return
+	}
+
+	var req restreamTargetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := applyRestreamTargetRequest(target, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	target, err := h.repo.UpdateRestreamTarget(target)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if _, running := h.transcoderManager.RelayStats(target.StreamKey)[target.ID]; running {
+		h.transcoderManager.StopRelay(target)
+		if target.Enabled {
+			h.transcoderManager.StartRelay(target)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Restream target updated",
+		"data":    restreamTargetResponse(target, h.transcoderManager.RelayStats(target.StreamKey)),
+	})
+}
+
+// DeleteRestreamTarget handles requests to remove a restream target, stopping its relay
+func (h *Handler) DeleteRestreamTarget(c *gin.Context) {
+	target, ok := h.lookupRestreamTarget(c)
+	if !ok {
+		return
+	}
+
+	if _, running := h.transcoderManager.RelayStats(target.StreamKey)[target.ID]; running {
+		h.transcoderManager.StopRelay(target)
+	}
+
+	if err := h.repo.DeleteRestreamTarget(target.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Restream target deleted successfully",
+	})
+}
+
+// StartRestreamTarget handles requests to start relaying a live stream to one target
+func (h *Handler) StartRestreamTarget(c *gin.Context) {
+	target, ok := h.lookupRestreamTarget(c)
+	if !ok {
+		return
+	}
+
+	if err := h.transcoderManager.StartRelay(target); err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": fmt.Sprintf("Relay to %s started", target.Name),
+		"data":    restreamTargetResponse(target, h.transcoderManager.RelayStats(target.StreamKey)),
+	})
+}
+
+// StopRestreamTarget handles requests to stop relaying to one target
+func (h *Handler) StopRestreamTarget(c *gin.Context) {
+	target, ok := h.lookupRestreamTarget(c)
+	if !ok {
+		return
+	}
+
+	if err := h.transcoderManager.StopRelay(target); err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": fmt.Sprintf("Relay to %s stopped", target.Name),
+	})
+}
+
+// lookupRestreamTarget resolves the :id parameter to a target of a stream the
+// caller owns, writing the error response if it fails
+func (h *Handler) lookupRestreamTarget(c *gin.Context) (*models.RestreamTarget, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "invalid restream target ID",
+		})
+		return nil, false
+	}
+
+	target, err := h.repo.GetRestreamTarget(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+	if !h.authorizeStreamOwner(c, target.StreamKey) {
+		return nil, false
+	}
+
+	return target, true
+}
+
+// applyRestreamTargetRequest validates a request and copies its fields onto a target
+func applyRestreamTargetRequest(target *models.RestreamTarget, req *restreamTargetRequest) error {
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return fmt.Errorf("name must not be empty")
+		}
+		target.Name = name
+	}
+
+	if req.URL != nil {
+		parsed, err := url.Parse(*req.URL)
+		if err != nil || parsed.Host == "" {
+			return fmt.Errorf("invalid url %q", *req.URL)
+		}
+		switch parsed.Scheme {
+		case "rtmp", "rtmps", "srt":
+		default:
+			return fmt.Errorf("unsupported url scheme %q (expected rtmp, rtmps or srt)", parsed.Scheme)
+		}
+		target.URL = *req.URL
+	}
+
+	if req.Key != nil {
+		target.Key = strings.TrimSpace(*req.Key)
+	}
+	if req.Enabled != nil {
+		target.Enabled = *req.Enabled
+	}
+	return nil
+}
+
+// restreamTargetResponse adds the relay state and a masked key to a target
+func restreamTargetResponse(target *models.RestreamTarget, stats map[uuid.UUID]transcoder.RelayStats) gin.H {
+	response := gin.H{
+		"target": target,
+		"relay": transcoder.RelayStats{
+			TargetID: target.ID,
+			Status:   transcoder.RelayStatusStopped,
+		},
+	}
+
+	if relayStats, exists := stats[target.ID]; exists {
+		response["relay"] = relayStats
+	}
+	if len(target.Key) > 4 {
+		response["key_hint"] = "****" + target.Key[len(target.Key)-4:]
+	} else if target.Key != "" {
+		response["key_hint"] = "****"
+	}
+
+	return response
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/streamforge/platform/pkg/models"
+	"github.com/streamforge/platform/services/transcoder/internal/transcoder"
+)
+
+const (
+	// maxDVRWindow is the longest DVR window a stream may keep, in seconds
+	maxDVRWindow = 24 * 60 * 60
+	// maxSlateGrace is the longest a stream may show its slate, in seconds
+	maxSlateGrace = 60 * 60
+	// maxKeyRotation is the most segments a content key may encrypt before it is replaced
+	maxKeyRotation = 10000
+)
+
+// tierNamePattern matches the names of the delivery tiers HLS servers rate limit by
+var tierNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// queryParamPattern matches the names of query parameters carried over to segment URIs
+var queryParamPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// maxPropagateQuery is the most query parameters carried over to segment URIs
+const maxPropagateQuery = 8
+
+// GetStreamSettings handles requests to get the settings of a stream
+func (h *Handler) GetStreamSettings(c *gin.Context) {
+	streamKey := c.Param("streamKey")
+
+	settings, err := h.repo.GetStreamSettings(streamKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    settings,
+	})
+}
+
+// UpdateStreamSettings handles requests to change the settings of a stream.
+// Changes take effect the next time the stream goes live, except for the slate,
+// which is read when the publisher drops.
+func (h *Handler) UpdateStreamSettings(c *gin.Context) {
+	streamKey := c.Param("streamKey")
+
+	var req struct {
+		RecordingMode *models.RecordingMode    `json:"recording_mode"`
+		DVRWindow     *int                     `json:"dvr_window_seconds"`
+		SlateGrace    *int                     `json:"slate_grace_seconds"`
+		SlateURL      *string                  `json:"slate_url"`
+		Encryption    *models.EncryptionMethod `json:"encryption"`
+		KeyRotation   *int                     `json:"key_rotation_segments"`
+		DeliveryTier  *string                  `json:"delivery_tier"`
+		// Rewriting of playlist URIs onto CDN base URLs
+		PlaylistBaseURL *string   `json:"playlist_base_url"`
+		SegmentBaseURL  *string   `json:"segment_base_url"`
+		PropagateQuery  *[]string `json:"propagate_query"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	settings, err := h.repo.GetStreamSettings(streamKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if req.RecordingMode != nil {
+		switch *req.RecordingMode {
+		case models.RecordingModeOff, models.RecordingModeTop, models.RecordingModeAll:
+			settings.RecordingMode = *req.RecordingMode
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   fmt.Sprintf("invalid recording mode %q (expected off, top or all)", *req.RecordingMode),
+			})
+			return
+		}
+	}
+
+	if req.DVRWindow != nil {
+		if *req.DVRWindow < 0 || *req.DVRWindow > maxDVRWindow {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   fmt.Sprintf("invalid DVR window %d (expected 0 to %d seconds)", *req.DVRWindow, maxDVRWindow),
+			})
+			return
+		}
+		settings.DVRWindow = *req.DVRWindow
+	}
+
+	if req.SlateGrace != nil {
+		if *req.SlateGrace < 0 || *req.SlateGrace > maxSlateGrace {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   fmt.Sprintf("invalid slate grace period %d (expected 0 to %d seconds)", *req.SlateGrace, maxSlateGrace),
+			})
+			return
+		}
+		settings.SlateGrace = *req.SlateGrace
+	}
+
+	if req.SlateURL != nil {
+		slateURL := strings.TrimSpace(*req.SlateURL)
+		if !isMediaLocation(slateURL) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "slate_url must be an http(s) URL or an absolute file path",
+			})
+			return
+		}
+		settings.SlateURL = slateURL
+	}
+
+	if req.Encryption != nil {
+		if err := h.transcoderManager.ValidateEncryption(*req.Encryption); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, transcoder.ErrEncryptionDisabled) {
+				status = http.StatusServiceUnavailable
+			}
+			c.JSON(status, gin.H{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
+		settings.Encryption = *req.Encryption
+		if settings.Encryption == "" {
+			settings.Encryption = models.EncryptionNone
+		}
+	}
+
+	if req.KeyRotation != nil {
+		if *req.KeyRotation < 0 || *req.KeyRotation > maxKeyRotation {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   fmt.Sprintf("invalid key rotation %d (expected 0 to %d segments)", *req.KeyRotation, maxKeyRotation),
+			})
+			return
+		}
+		settings.KeyRotation = *req.KeyRotation
+	}
+
+	if req.DeliveryTier != nil {
+		if *req.DeliveryTier != "" && !tierNamePattern.MatchString(*req.DeliveryTier) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   fmt.Sprintf("invalid delivery tier %q (expected up to 32 lowercase letters, digits, - or _)", *req.DeliveryTier),
+			})
+			return
+		}
+		settings.DeliveryTier = *req.DeliveryTier
+	}
+
+	for _, base := range []struct {
+		name  string
+		value *string
+		dst   *string
+	}{
+		{"playlist_base_url", req.PlaylistBaseURL, &settings.PlaylistBaseURL},
+		{"segment_base_url", req.SegmentBaseURL, &settings.SegmentBaseURL},
+	} {
+		if base.value == nil {
+			continue
+		}
+		baseURL := strings.TrimRight(strings.TrimSpace(*base.value), "/")
+		if !isBaseURL(baseURL) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   fmt.Sprintf("%s must be an http(s) URL without a query or fragment", base.name),
+			})
+			return
+		}
+		*base.dst = baseURL
+	}
+
+	if req.PropagateQuery != nil {
+		if len(*req.PropagateQuery) > maxPropagateQuery {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   fmt.Sprintf("propagate_query takes at most %d parameters", maxPropagateQuery),
+			})
+			return
+		}
+		for _, name := range *req.PropagateQuery {
+			if !queryParamPattern.MatchString(name) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"success": false,
+					"error":   fmt.Sprintf("invalid query parameter %q in propagate_query", name),
+				})
+				return
+			}
+		}
+		settings.PropagateQuery = *req.PropagateQuery
+	}
+
+	settings, err = h.repo.SaveStreamSettings(settings)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Stream settings updated",
+		"data":    settings,
+	})
+}
+
+// isBaseURL reports whether a value is empty or an http(s) URL without a query or fragment
+func isBaseURL(value string) bool {
+	if value == "" {
+		return true
+	}
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	return (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != "" &&
+		parsed.RawQuery == "" && parsed.Fragment == "" && !parsed.ForceQuery
+}
+
+// isMediaLocation reports whether a value is empty, an http(s) URL or an absolute file path
+func isMediaLocation(value string) bool {
+	if value == "" {
+		return true
+	}
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	if parsed.Scheme == "" {
+		return filepath.IsAbs(value)
+	}
+	return (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
@@ -0,0 +1,575 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/streamforge/platform/pkg/config"
+	"github.com/streamforge/platform/pkg/database"
+	"github.com/streamforge/platform/pkg/models"
+	"gorm.io/gorm"
+)
+
+// StreamRepository handles persistence for transcoder-managed stream data
+type StreamRepository struct {
+	db *database.Database
+}
+
+// NewStreamRepository creates a new stream repository
+func NewStreamRepository(cfg *config.Config) (*StreamRepository, error) {
+	// Create data directory first
+	if err := database.CreateDataDirectory(); err != nil {
+		return nil, err
+	}
+
+	// Initialize database
+	db, err := database.NewDatabase(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StreamRepository{db: db}, nil
+}
+
+// GetStreamSettings retrieves the settings for a stream key, falling back to defaults
+func (r *StreamRepository) GetStreamSettings(streamKey string) (*models.StreamSettings, error) {
+	var settings models.StreamSettings
+	err := r.db.GetDB().Where("stream_key = ?", streamKey).First(&settings).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &models.StreamSettings{
+				StreamKey:     streamKey,
+				RecordingMode: models.RecordingModeOff,
+			}, nil
+		}
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SaveStreamSettings creates or updates the settings for a stream key
+func (r *StreamRepository) SaveStreamSettings(settings *models.StreamSettings) (*models.StreamSettings, error) {
+	if settings.ID == uuid.Nil {
+		settings.ID = uuid.New()
+	}
+	if err := r.db.GetDB().Save(settings).Error; err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// StartSession opens a stream session for a registered stream key.
+// It returns nil without error when the key does not belong to a known stream.
+func (r *StreamRepository) StartSession(streamKey, quality string, bitrate int) (*models.StreamSession, error) {
+	var stream models.Stream
+	err := r.db.GetDB().Where("stream_key = ?", streamKey).First(&stream).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &models.StreamSession{
+		ID:        uuid.New(),
+		StreamID:  stream.ID,
+		Quality:   quality,
+		Bitrate:   bitrate,
+		StartedAt: now,
+	}
+
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+		updates := map[string]interface{}{
+			"started_at": now,
+			"ended_at":   nil,
+		}
+		// Private streams stay private while they are live
+		if stream.Status != models.StreamStatusPrivate {
+			updates["status"] = models.StreamStatusOnline
+		}
+		return tx.Model(&stream).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start session: %w", err)
+	}
+	return session, nil
+}
+
+// GetStreamID returns the ID of the registered stream with the given key, or nil
+// if the key does not belong to a registered stream
+func (r *StreamRepository) GetStreamID(streamKey string) (*uuid.UUID, error) {
+	var stream models.Stream
+	err := r.db.GetDB().Select("id").Where("stream_key = ?", streamKey).First(&stream).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &stream.ID, nil
+}
+
+// GetStream returns the registered stream with the given key, or nil if the key
+// does not belong to a registered stream
+func (r *StreamRepository) GetStream(streamKey string) (*models.Stream, error) {
+	var stream models.Stream
+	err := r.db.GetDB().Where("stream_key = ?", streamKey).First(&stream).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &stream, nil
+}
+
+// EndSession closes a stream session and marks its stream offline, unless it is private
+func (r *StreamRepository) EndSession(sessionID uuid.UUID) error {
+	now := time.Now()
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var session models.StreamSession
+		if err := tx.Where("id = ?", sessionID).First(&session).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&session).Update("ended_at", now).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Stream{}).Where("id = ?", session.StreamID).Update("ended_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Stream{}).
+			Where("id = ? AND status <> ?", session.StreamID, models.StreamStatusPrivate).
+			Update("status", models.StreamStatusOffline).Error
+	})
+}
+
+// CreateRecording creates a new recording
+func (r *StreamRepository) CreateRecording(recording *models.Recording) (*models.Recording, error) {
+	recording.ID = uuid.New()
+	if err := r.db.GetDB().Create(recording).Error; err != nil {
+		return nil, err
+	}
+	return recording, nil
+}
+
+// UpdateRecording updates an existing recording
+func (r *StreamRepository) UpdateRecording(recording *models.Recording) (*models.Recording, error) {
+	if err := r.db.GetDB().Save(recording).Error; err != nil {
+		return nil, err
+	}
+	return recording, nil
+}
+
+// GetRecording retrieves a recording by ID
+func (r *StreamRepository) GetRecording(id uuid.UUID) (*models.Recording, error) {
+	var recording models.Recording
+	err := r.db.GetDB().Preload("StreamSession").Where("id = ?", id).First(&recording).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("recording not found")
+		}
+		return nil, err
+	}
+	return &recording, nil
+}
+
+// ListRecordings retrieves the recordings of a stream, optionally filtered by session
+func (r *StreamRepository) ListRecordings(streamKey string, sessionID *uuid.UUID) ([]models.Recording, error) {
+	var recordings []models.Recording
+
+	query := r.db.GetDB().Where("stream_key = ?", streamKey).Order("started_at DESC")
+	if sessionID != nil {
+		query = query.Where("stream_session_id = ?", *sessionID)
+	}
+
+	if err := query.Find(&recordings).Error; err != nil {
+		return nil, err
+	}
+	return recordings, nil
+}
+
+// DeleteRecording deletes a recording
+func (r *StreamRepository) DeleteRecording(id uuid.UUID) error {
+	return r.db.GetDB().Where("id = ?", id).Delete(&models.Recording{}).Error
+}
+
+// CreateClip creates a new clip
+func (r *StreamRepository) CreateClip(clip *models.Clip) (*models.Clip, error) {
+	clip.ID = uuid.New()
+	if err := r.db.GetDB().Create(clip).Error; err != nil {
+		return nil, err
+	}
+	return clip, nil
+}
+
+// UpdateClip updates an existing clip
+func (r *StreamRepository) UpdateClip(clip *models.Clip) (*models.Clip, error) {
+	if err := r.db.GetDB().Save(clip).Error; err != nil {
+		return nil, err
+	}
+	return clip, nil
+}
+
+// GetClip retrieves a clip by ID
+func (r *StreamRepository) GetClip(id uuid.UUID) (*models.Clip, error) {
+	var clip models.Clip
+	if err := r.db.GetDB().Where("id = ?", id).First(&clip).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("clip not found")
+		}
+		return nil, err
+	}
+	return &clip, nil
+}
+
+// ListClips retrieves the clips of a stream
+func (r *StreamRepository) ListClips(streamKey string) ([]models.Clip, error) {
+	var clips []models.Clip
+
+	query := r.db.GetDB().Where("stream_key = ?", streamKey).Order("created_at DESC")
+	if err := query.Find(&clips).Error; err != nil {
+		return nil, err
+	}
+	return clips, nil
+}
+
+// DeleteClip deletes a clip
+func (r *StreamRepository) DeleteClip(id uuid.UUID) error {
+	return r.db.GetDB().Where("id = ?", id).Delete(&models.Clip{}).Error
+}
+
+// CreateRestreamTarget creates a new restream target
+func (r *StreamRepository) CreateRestreamTarget(target *models.RestreamTarget) (*models.RestreamTarget, error) {
+	target.ID = uuid.New()
+	if err := r.db.GetDB().Create(target).Error; err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// UpdateRestreamTarget updates an existing restream target
+func (r *StreamRepository) UpdateRestreamTarget(target *models.RestreamTarget) (*models.RestreamTarget, error) {
+	if err := r.db.GetDB().Save(target).Error; err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// GetRestreamTarget retrieves a restream target by ID
+func (r *StreamRepository) GetRestreamTarget(id uuid.UUID) (*models.RestreamTarget, error) {
+	var target models.RestreamTarget
+	if err := r.db.GetDB().Where("id = ?", id).First(&target).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("restream target not found")
+		}
+		return nil, err
+	}
+	return &target, nil
+}
+
+// ListRestreamTargets retrieves restream targets, optionally filtered by stream key
+func (r *StreamRepository) ListRestreamTargets(streamKey string) ([]models.RestreamTarget, error) {
+	var targets []models.RestreamTarget
+
+	query := r.db.GetDB().Order("created_at ASC")
+	if streamKey != "" {
+		query = query.Where("stream_key = ?", streamKey)
+	}
+
+	if err := query.Find(&targets).Error; err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// DeleteRestreamTarget deletes a restream target
+func (r *StreamRepository) DeleteRestreamTarget(id uuid.UUID) error {
+	return r.db.GetDB().Where("id = ?", id).Delete(&models.RestreamTarget{}).Error
+}
+
+// CreateOverlay creates a new stream overlay
+func (r *StreamRepository) CreateOverlay(overlay *models.StreamOverlay) (*models.StreamOverlay, error) {
+	overlay.ID = uuid.New()
+	if err := r.db.GetDB().Create(overlay).Error; err != nil {
+		return nil, err
+	}
+	return overlay, nil
+}
+
+// UpdateOverlay updates an existing stream overlay
+func (r *StreamRepository) UpdateOverlay(overlay *models.StreamOverlay) (*models.StreamOverlay, error) {
+	if err := r.db.GetDB().Save(overlay).Error; err != nil {
+		return nil, err
+	}
+	return overlay, nil
+}
+
+// GetOverlay retrieves a stream overlay by ID
+func (r *StreamRepository) GetOverlay(id uuid.UUID) (*models.StreamOverlay, error) {
+	var overlay models.StreamOverlay
+	if err := r.db.GetDB().Where("id = ?", id).First(&overlay).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("overlay not found")
+		}
+		return nil, err
+	}
+	return &overlay, nil
+}
+
+// ListOverlays retrieves the overlays of a stream in the order they are drawn
+func (r *StreamRepository) ListOverlays(streamKey string) ([]models.StreamOverlay, error) {
+	var overlays []models.StreamOverlay
+	err := r.db.GetDB().Where("stream_key = ?", streamKey).Order("created_at ASC").Find(&overlays).Error
+	if err != nil {
+		return nil, err
+	}
+	return overlays, nil
+}
+
+// DeleteOverlay deletes a stream overlay
+func (r *StreamRepository) DeleteOverlay(id uuid.UUID) error {
+	return r.db.GetDB().Where("id = ?", id).Delete(&models.StreamOverlay{}).Error
+}
+
+// CreateAdCue creates a new ad cue
+func (r *StreamRepository) CreateAdCue(cue *models.AdCue) (*models.AdCue, error) {
+	cue.ID = uuid.New()
+	if err := r.db.GetDB().Create(cue).Error; err != nil {
+		return nil, err
+	}
+	return cue, nil
+}
+
+// UpdateAdCue updates an existing ad cue
+func (r *StreamRepository) UpdateAdCue(cue *models.AdCue) (*models.AdCue, error) {
+	if err := r.db.GetDB().Save(cue).Error; err != nil {
+		return nil, err
+	}
+	return cue, nil
+}
+
+// GetAdCue retrieves an ad cue by ID
+func (r *StreamRepository) GetAdCue(id uuid.UUID) (*models.AdCue, error) {
+	var cue models.AdCue
+	if err := r.db.GetDB().Where("id = ?", id).First(&cue).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("ad cue not found")
+		}
+		return nil, err
+	}
+	return &cue, nil
+}
+
+// ListAdCues retrieves ad cues in broadcast order, optionally filtered by stream key and session
+func (r *StreamRepository) ListAdCues(streamKey string, sessionID *uuid.UUID) ([]models.AdCue, error) {
+	var cues []models.AdCue
+
+	query := r.db.GetDB().Order("start_time ASC")
+	if streamKey != "" {
+		query = query.Where("stream_key = ?", streamKey)
+	}
+	if sessionID != nil {
+		query = query.Where("stream_session_id = ?", *sessionID)
+	}
+
+	if err := query.Find(&cues).Error; err != nil {
+		return nil, err
+	}
+	return cues, nil
+}
+
+// ListPlaybackIDs retrieves the playback IDs of a stream, oldest first
+func (r *StreamRepository) ListPlaybackIDs(streamID uuid.UUID) ([]models.PlaybackID, error) {
+	var ids []models.PlaybackID
+	err := r.db.GetDB().Where("stream_id = ?", streamID).Order("created_at ASC").Find(&ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ListAllPlaybackIDs retrieves every playback ID with its stream, oldest first
+func (r *StreamRepository) ListAllPlaybackIDs() ([]models.PlaybackID, error) {
+	var ids []models.PlaybackID
+	if err := r.db.GetDB().Preload("Stream").Order("created_at ASC").Find(&ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// GetPlaybackID retrieves a playback ID with its stream, or nil if there is no such playback ID
+func (r *StreamRepository) GetPlaybackID(playbackID string) (*models.PlaybackID, error) {
+	var id models.PlaybackID
+	err := r.db.GetDB().Preload("Stream").Where("playback_id = ?", playbackID).First(&id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &id, nil
+}
+
+// CreatePlaybackID creates a new playback ID
+func (r *StreamRepository) CreatePlaybackID(id *models.PlaybackID) (*models.PlaybackID, error) {
+	id.ID = uuid.New()
+	if err := r.db.GetDB().Create(id).Error; err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// UpdatePlaybackID updates an existing playback ID
+func (r *StreamRepository) UpdatePlaybackID(id *models.PlaybackID) (*models.PlaybackID, error) {
+	if err := r.db.GetDB().Omit("Stream").Save(id).Error; err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// DeletePlaybackID deletes a playback ID
+func (r *StreamRepository) DeletePlaybackID(id uuid.UUID) error {
+	return r.db.GetDB().Where("id = ?", id).Delete(&models.PlaybackID{}).Error
+}
+
+// CreateEncryptionKey stores a new content key
+func (r *StreamRepository) CreateEncryptionKey(key *models.EncryptionKey) (*models.EncryptionKey, error) {
+	key.ID = uuid.New()
+	if err := r.db.GetDB().Create(key).Error; err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// GetEncryptionKey returns a content key by ID, or nil if it does not exist
+func (r *StreamRepository) GetEncryptionKey(id uuid.UUID) (*models.EncryptionKey, error) {
+	var key models.EncryptionKey
+	err := r.db.GetDB().Where("id = ?", id).First(&key).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetAccessRules returns the access rules of a stream key, or nil if it has none
+func (r *StreamRepository) GetAccessRules(streamKey string) (*models.StreamAccessRules, error) {
+	var rules models.StreamAccessRules
+	err := r.db.GetDB().Where("stream_key = ?", streamKey).First(&rules).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &rules, nil
+}
+
+// ListAccessRules retrieves the access rules of every stream
+func (r *StreamRepository) ListAccessRules() ([]models.StreamAccessRules, error) {
+	var rules []models.StreamAccessRules
+	if err := r.db.GetDB().Order("stream_key").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// SaveAccessRules creates or updates the access rules of a stream key
+func (r *StreamRepository) SaveAccessRules(rules *models.StreamAccessRules) (*models.StreamAccessRules, error) {
+	if rules.ID == uuid.Nil {
+		rules.ID = uuid.New()
+	}
+	if err := r.db.GetDB().Save(rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// DeleteAccessRules deletes the access rules of a stream key
+func (r *StreamRepository) DeleteAccessRules(streamKey string) error {
+	return r.db.GetDB().Where("stream_key = ?", streamKey).Delete(&models.StreamAccessRules{}).Error
+}
+
+// CreatePlaybackEvents stores a batch of player events
+func (r *StreamRepository) CreatePlaybackEvents(events []models.PlaybackEvent) error {
+	for i := range events {
+		events[i].ID = uuid.New()
+	}
+	return r.db.GetDB().CreateInBatches(events, 100).Error
+}
+
+// ListPlaybackEvents retrieves the player events of a stream in a time range,
+// grouped by session and in order within each session
+func (r *StreamRepository) ListPlaybackEvents(streamKey string, since, until time.Time) ([]models.PlaybackEvent, error) {
+	var events []models.PlaybackEvent
+	err := r.db.GetDB().
+		Where("stream_key = ? AND time >= ? AND time < ?", streamKey, since, until).
+		Order("session_id ASC, time ASC").
+		Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// RotateStreamKey replaces the key of a stream, moving everything kept by stream
+// key along with it. Playback IDs belong to the stream and are unaffected.
+func (r *StreamRepository) RotateStreamKey(oldKey, newKey string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Stream{}).Where("stream_key = ?", oldKey).Update("stream_key", newKey)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("stream not found")
+		}
+		for _, model := range []interface{}{
+			&models.StreamSettings{},
+			&models.Recording{},
+			&models.Clip{},
+			&models.RestreamTarget{},
+			&models.StreamOverlay{},
+			&models.AdCue{},
+			&models.EncryptionKey{},
+			&models.StreamAccessRules{},
+			&models.PlaybackEvent{},
+		} {
+			if err := tx.Model(model).Where("stream_key = ?", oldKey).Update("stream_key", newKey).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MoveArchivePaths points the files of recordings and clips archived under one
+// directory to another
+func (r *StreamRepository) MoveArchivePaths(from, to string) error {
+	prefix := strings.TrimSuffix(from, "/") + "/"
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.Recording{}, &models.Clip{}} {
+			for _, column := range []string{"playlist_path", "mp4_path"} {
+				err := tx.Model(model).Where("substr("+column+", 1, ?) = ?", len(prefix), prefix).
+					Update(column, gorm.Expr("? || substr("+column+", ?)", to, len(prefix))).Error
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Close closes the database connection
+func (r *StreamRepository) Close() error {
+	return r.db.Close()
+}
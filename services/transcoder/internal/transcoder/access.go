@@ -0,0 +1,126 @@
+package transcoder
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/streamforge/platform/pkg/access"
+	"github.com/streamforge/platform/pkg/models"
+)
+
+// SetGeoIP configures the database the countries of viewers are resolved with
+func (m *Manager) SetGeoIP(geo *access.GeoIP) {
+	m.accessRules = access.NewEnforcer(m.outputDir, geo)
+}
+
+// AccessRules returns the access rules of a stream, which are empty if none were set
+func (m *Manager) AccessRules(streamKey string) (*models.StreamAccessRules, error) {
+	rules, err := m.repo.GetAccessRules(streamKey)
+	if err != nil || rules != nil {
+		return rules, err
+	}
+	return &models.StreamAccessRules{
+		StreamKey:      streamKey,
+		AllowCIDRs:     []string{},
+		DenyCIDRs:      []string{},
+		AllowCountries: []string{},
+		DenyCountries:  []string{},
+	}, nil
+}
+
+// SetAccessRules validates and saves the access rules of a stream and
+// publishes them to the HLS servers, which enforce them from the next request
+func (m *Manager) SetAccessRules(rules *models.StreamAccessRules) (*models.StreamAccessRules, error) {
+	normalized := access.Rules{
+		AllowCIDRs:     rules.AllowCIDRs,
+		DenyCIDRs:      rules.DenyCIDRs,
+		AllowCountries: rules.AllowCountries,
+		DenyCountries:  rules.DenyCountries,
+	}
+	if err := normalized.Normalize(); err != nil {
+		return nil, err
+	}
+	rules.AllowCIDRs = normalized.AllowCIDRs
+	rules.DenyCIDRs = normalized.DenyCIDRs
+	rules.AllowCountries = normalized.AllowCountries
+	rules.DenyCountries = normalized.DenyCountries
+
+	saved, err := m.repo.SaveAccessRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.publishAccessRules(saved.StreamKey); err != nil {
+		return nil, err
+	}
+	log.Printf("🛡️  Updated access rules of %s", saved.StreamKey)
+	return saved, nil
+}
+
+// DeleteAccessRules removes the access rules of a stream, opening it to every viewer
+func (m *Manager) DeleteAccessRules(streamKey string) error {
+	if err := m.repo.DeleteAccessRules(streamKey); err != nil {
+		return err
+	}
+	return m.publishAccessRules(streamKey)
+}
+
+// CheckAccess decides whether a client may be served the files of a stream directory
+func (m *Manager) CheckAccess(directory, clientIP string) access.Decision {
+	return m.accessRules.Check(directory, clientIP)
+}
+
+// publishAccessRules writes the access rules sidecar of a stream, or removes
+// it when the stream has no rules. The stream directory is created if needed
+// so rules set before a stream first goes live are enforced from the start.
+func (m *Manager) publishAccessRules(streamKey string) error {
+	rules, err := m.repo.GetAccessRules(streamKey)
+	if err != nil {
+		return err
+	}
+
+	streamDir := m.streamDir(streamKey)
+	path := filepath.Join(streamDir, access.RulesFile)
+	published := access.Rules{}
+	if rules != nil {
+		published = access.Rules{
+			AllowCIDRs:     rules.AllowCIDRs,
+			DenyCIDRs:      rules.DenyCIDRs,
+			AllowCountries: rules.AllowCountries,
+			DenyCountries:  rules.DenyCountries,
+			UpdatedAt:      time.Now().UTC(),
+		}
+	}
+	if published.Empty() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(streamDir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(published, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// publishAllAccessRules republishes the access rules of every stream, in case
+// stream directories were removed while the transcoder was down
+func (m *Manager) publishAllAccessRules() {
+	rules, err := m.repo.ListAccessRules()
+	if err != nil {
+		log.Printf("⚠️  Failed to load access rules: %v", err)
+		return
+	}
+	for _, streamRules := range rules {
+		if err := m.publishAccessRules(streamRules.StreamKey); err != nil {
+			log.Printf("⚠️  Failed to publish access rules of %s: %v", streamRules.StreamKey, err)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package transcoder
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/streamforge/platform/pkg/models"
+)
+
+const (
+	// adCuesFile is the sidecar the HLS server reads to mark ad breaks in playlists
+	adCuesFile = ".ad_cues"
+	// maxAdBreakDuration is the longest ad break that can be scheduled, in seconds
+	maxAdBreakDuration = 3600
+	// maxAdCueLead is how far ahead an ad break can be scheduled
+	maxAdCueLead = 24 * time.Hour
+)
+
+// AdCueRequest schedules an ad break. It starts at Start if given, otherwise
+// Delay seconds from now.
+type AdCueRequest struct {
+	StreamKey string
+	Duration  float64
+	Start     *time.Time
+	Delay     float64
+}
+
+// adCueMarker is an ad break as written to the sidecar, with the SCTE-35
+// splice_info_sections of its cue-out and cue-in
+type adCueMarker struct {
+	ID              string    `json:"id"`
+	Start           time.Time `json:"start"`
+	PlannedDuration float64   `json:"planned_duration"`
+	Duration        float64   `json:"duration"`
+	SCTE35Out       string    `json:"scte35_out"`
+	SCTE35In        string    `json:"scte35_in"`
+}
+
+// ScheduleAdCue schedules an ad break on a live stream and publishes it to the
+// HLS server, which marks it in every variant playlist
+func (m *Manager) ScheduleAdCue(req AdCueRequest) (*models.AdCue, error) {
+	if req.Duration <= 0 || req.Duration > maxAdBreakDuration {
+		return nil, fmt.Errorf("duration must be greater than 0 and at most %d seconds", maxAdBreakDuration)
+	}
+	if req.Delay < 0 {
+		return nil, fmt.Errorf("delay must not be negative")
+	}
+
+	now := time.Now()
+	start := now.Add(time.Duration(req.Delay * float64(time.Second)))
+	if req.Start != nil {
+		if req.Delay > 0 {
+			return nil, fmt.Errorf("start and delay cannot be combined")
+		}
+		start = *req.Start
+		// A start that just passed is taken as "now" rather than rejected
+		if start.Before(now) {
+			if now.Sub(start) > time.Minute {
+				return nil, fmt.Errorf("start is in the past")
+			}
+			start = now
+		}
+	}
+	if start.Sub(now) > maxAdCueLead {
+		return nil, fmt.Errorf("ad breaks can be scheduled at most %v ahead", maxAdCueLead)
+	}
+
+	sessionID, since, live := m.adCueScope(req.StreamKey)
+	if !live {
+		return nil, fmt.Errorf("stream %s is not live", req.StreamKey)
+	}
+
+	m.adCueMutex.Lock()
+	defer m.adCueMutex.Unlock()
+
+	cues, err := m.broadcastAdCues(req.StreamKey, sessionID, since)
+	if err != nil {
+		return nil, err
+	}
+	end := start.Add(time.Duration(req.Duration * float64(time.Second)))
+	for _, cue := range cues {
+		if cue.Status == models.AdCueStatusCancelled {
+			continue
+		}
+		cueEnd := cue.StartTime.Add(time.Duration(cue.Duration * float64(time.Second)))
+		if start.Before(cueEnd) && cue.StartTime.Before(end) {
+			return nil, fmt.Errorf("ad break overlaps ad break %s", cue.ID)
+		}
+	}
+
+	cue := &models.AdCue{
+		StreamKey:       req.StreamKey,
+		StreamSessionID: sessionID,
+		StartTime:       start.UTC(),
+		PlannedDuration: req.Duration,
+		Duration:        req.Duration,
+		Status:          models.AdCueStatusScheduled,
+	}
+	if cue, err = m.repo.CreateAdCue(cue); err != nil {
+		return nil, fmt.Errorf("failed to create ad cue: %w", err)
+	}
+	// The splice event ID is derived from the cue ID so it is stable across restarts
+	cue.EventID = binary.BigEndian.Uint32(cue.ID[:4])
+	if cue, err = m.repo.UpdateAdCue(cue); err != nil {
+		return nil, fmt.Errorf("failed to create ad cue: %w", err)
+	}
+
+	m.publishAdCues(req.StreamKey)
+	log.Printf("📺 Ad break of %.1fs scheduled for %s at %s", cue.Duration, cue.StreamKey, cue.StartTime.Format(time.RFC3339))
+	return cue, nil
+}
+
+// CancelAdCue cancels a scheduled ad break, or ends a running one early so the
+// cue-in is placed on the next segment boundary
+func (m *Manager) CancelAdCue(id uuid.UUID) (*models.AdCue, error) {
+	m.adCueMutex.Lock()
+	defer m.adCueMutex.Unlock()
+
+	cue, err := m.repo.GetAdCue(id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	switch AdCueState(cue, now) {
+	case models.AdCueStatusScheduled:
+		cue.Status = models.AdCueStatusCancelled
+	case models.AdCueStatusActive:
+		// The planned duration stays as announced in the cue-out
+		cue.Duration = now.Sub(cue.StartTime).Seconds()
+	default:
+		return nil, fmt.Errorf("ad break is already %s", AdCueState(cue, now))
+	}
+
+	if cue, err = m.repo.UpdateAdCue(cue); err != nil {
+		return nil, fmt.Errorf("failed to update ad cue: %w", err)
+	}
+	m.publishAdCues(cue.StreamKey)
+	return cue, nil
+}
+
+// AdCueState returns where an ad cue is in its lifecycle at the given time
+func AdCueState(cue *models.AdCue, now time.Time) models.AdCueStatus {
+	if cue.Status == models.AdCueStatusCancelled {
+		return cue.Status
+	}
+	end := cue.StartTime.Add(time.Duration(cue.Duration * float64(time.Second)))
+	switch {
+	case now.Before(cue.StartTime):
+		return models.AdCueStatusScheduled
+	case now.Before(end):
+		return models.AdCueStatusActive
+	default:
+		return models.AdCueStatusCompleted
+	}
+}
+
+// adCueScope returns what identifies the current broadcast of a live stream:
+// its session, or for streams without one the time the broadcast started
+func (m *Manager) adCueScope(streamKey string) (*uuid.UUID, time.Time, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	process, exists := m.processes[streamKey]
+	if !exists || !process.Status.Live() {
+		return nil, time.Time{}, false
+	}
+	return process.SessionID, process.StartTime, true
+}
+
+// broadcastAdCues returns the ad cues of the current broadcast of a stream
+func (m *Manager) broadcastAdCues(streamKey string, sessionID *uuid.UUID, since time.Time) ([]models.AdCue, error) {
+	cues, err := m.repo.ListAdCues(streamKey, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ad cues: %w", err)
+	}
+	if sessionID != nil {
+		return cues, nil
+	}
+
+	var current []models.AdCue
+	for _, cue := range cues {
+		if cue.StreamSessionID == nil && !cue.CreatedAt.Before(since) {
+			current = append(current, cue)
+		}
+	}
+	return current, nil
+}
+
+// publishAdCues rewrites the ad cue sidecar of a stream if it is live
+func (m *Manager) publishAdCues(streamKey string) {
+	sessionID, since, live := m.adCueScope(streamKey)
+	if !live {
+		return
+	}
+
+	cues, err := m.broadcastAdCues(streamKey, sessionID, since)
+	if err == nil {
+		err = m.writeAdCues(streamKey, cues)
+	}
+	if err != nil {
+		log.Printf("⚠️  Failed to publish ad cues for %s: %v", streamKey, err)
+	}
+}
+
+// writeAdCues writes ad breaks to the sidecar of a stream. Only the current
+// broadcast's cues are written so earlier ones never mark a new broadcast.
+func (m *Manager) writeAdCues(streamKey string, cues []models.AdCue) error {
+	markers := []adCueMarker{}
+	for _, cue := range cues {
+		if cue.Status == models.AdCueStatusCancelled {
+			continue
+		}
+		markers = append(markers, adCueMarker{
+			ID:              cue.ID.String(),
+			Start:           cue.StartTime,
+			PlannedDuration: cue.PlannedDuration,
+			Duration:        cue.Duration,
+			SCTE35Out:       encodeSCTE35(spliceInsert(cue.EventID, true, cue.PlannedDuration)),
+			SCTE35In:        encodeSCTE35(spliceInsert(cue.EventID, false, 0)),
+		})
+	}
+
+	data, err := json.MarshalIndent(markers, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(m.streamDir(streamKey), adCuesFile), append(data, '\n'))
+}
+
+// spliceInsert builds an SCTE-35 splice_info_section carrying an immediate
+// splice_insert. A cue-out carries the break duration with auto_return set.
+func spliceInsert(eventID uint32, outOfNetwork bool, duration float64) []byte {
+	command := binary.BigEndian.AppendUint32(nil, eventID)
+	command = append(command, 0x7F) // splice_event_cancel_indicator=0, reserved
+
+	flags := byte(0x40 | 0x10 | 0x0F) // program_splice_flag, splice_immediate_flag, reserved
+	if outOfNetwork {
+		flags |= 0x80
+	}
+	if duration > 0 {
+		flags |= 0x20
+	}
+	command = append(command, flags)
+
+	if duration > 0 {
+		ticks := uint64(duration * 90000)
+		command = append(command, 0x80|0x7E|byte(ticks>>32&0x01)) // auto_return, reserved, duration bit 32
+		command = binary.BigEndian.AppendUint32(command, uint32(ticks))
+	}
+	command = append(command, 0x00, 0x01, 0x00, 0x00) // unique_program_id=1, avail_num, avails_expected
+
+	// Everything after section_length: protocol_version through descriptor_loop_length, then the CRC
+	sectionLength := 11 + len(command) + 2 + 4
+
+	section := []byte{0xFC, 0x30 | byte(sectionLength>>8&0x0F), byte(sectionLength)}
+	section = append(section, 0x00)                         // protocol_version
+	section = append(section, 0x00, 0x00, 0x00, 0x00, 0x00) // encrypted_packet, encryption_algorithm, pts_adjustment
+	section = append(section, 0x00)                         // cw_index
+	section = append(section, 0xFF, 0xF0|byte(len(command)>>8&0x0F), byte(len(command)))
+	section = append(section, 0x05) // splice_insert
+	section = append(section, command...)
+	section = append(section, 0x00, 0x00) // descriptor_loop_length
+	return binary.BigEndian.AppendUint32(section, crc32MPEG2(section))
+}
+
+// encodeSCTE35 formats a splice_info_section as the hexadecimal-sequence the
+// SCTE35-OUT and SCTE35-IN attributes of EXT-X-DATERANGE expect
+func encodeSCTE35(section []byte) string {
+	return "0x" + strings.ToUpper(fmt.Sprintf("%x", section))
+}
+
+// crc32MPEG2 computes the CRC-32/MPEG-2 checksum that ends SCTE-35 sections
+func crc32MPEG2(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
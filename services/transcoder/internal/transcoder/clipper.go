@@ -0,0 +1,252 @@
+package transcoder
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/streamforge/platform/pkg/m3u8"
+	"github.com/streamforge/platform/pkg/models"
+)
+
+const (
+	// maxClipDuration is the longest clip that can be cut, in seconds
+	maxClipDuration = 600
+	// clipsDirName is the directory under a stream's recordings that holds its clips
+	clipsDirName = "clips"
+)
+
+// ClipRequest selects the part of a stream to clip. The range is either relative
+// to the live edge (Offset and Duration) or absolute (Start and End program date-times).
+type ClipRequest struct {
+	StreamKey string
+	Rendition string
+	Title     string
+	Offset    float64 // seconds behind the live edge the clip starts, defaults to Duration
+	Duration  float64
+	Start     *time.Time
+	End       *time.Time
+}
+
+// CreateClip links the segments covering the requested range out of the live
+// window and remuxes them into an MP4 in the background. Every segment starts on
+// a keyframe, so the clip is widened to whole segments instead of re-encoding.
+func (m *Manager) CreateClip(req ClipRequest) (*models.Clip, error) {
+	if req.Rendition == "" {
+		req.Rendition = m.qualities[0].Name
+	}
+	if !m.hasRendition(req.Rendition) {
+		return nil, fmt.Errorf("unknown rendition %q", req.Rendition)
+	}
+
+	sourceDir := filepath.Join(m.streamDir(req.StreamKey), req.Rendition)
+	playlist, err := m3u8.ReadMediaFile(filepath.Join(sourceDir, variantPlaylistName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no segments on disk for stream %s", req.StreamKey)
+		}
+		return nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+
+	resolveSegmentKeys(playlist)
+	segments, err := selectClipSegments(playlist.Segments, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessionID *uuid.UUID
+	m.mutex.RLock()
+	if process, exists := m.processes[req.StreamKey]; exists {
+		sessionID = process.SessionID
+	}
+	m.mutex.RUnlock()
+
+	clip, err := m.repo.CreateClip(&models.Clip{
+		StreamKey:       req.StreamKey,
+		StreamSessionID: sessionID,
+		Title:           req.Title,
+		Rendition:       req.Rendition,
+		Status:          models.ClipStatusProcessing,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clip: %w", err)
+	}
+
+	if err := m.archiveClipSegments(clip, sourceDir, segments); err != nil {
+		// Nothing usable was produced, so leave no trace of the clip
+		os.RemoveAll(m.clipDir(clip))
+		if err := m.repo.DeleteClip(clip.ID); err != nil {
+			log.Printf("⚠️  Failed to delete clip %s: %v", clip.ID, err)
+		}
+		return nil, err
+	}
+
+	if _, err := m.repo.UpdateClip(clip); err != nil {
+		return nil, fmt.Errorf("failed to update clip: %w", err)
+	}
+
+	go m.finishClip(clip)
+	return clip, nil
+}
+
+// DeleteClip removes a clip and its files
+func (m *Manager) DeleteClip(id uuid.UUID) error {
+	clip, err := m.repo.GetClip(id)
+	if err != nil {
+		return err
+	}
+	if clip.Status == models.ClipStatusProcessing {
+		return fmt.Errorf("clip %s is still %s", id, clip.Status)
+	}
+
+	if err := os.RemoveAll(m.clipDir(clip)); err != nil {
+		return fmt.Errorf("failed to remove clip files: %w", err)
+	}
+	return m.repo.DeleteClip(id)
+}
+
+// archiveClipSegments links the selected segments into the clip directory and
+// writes its VOD playlist while they are still inside the live window
+func (m *Manager) archiveClipSegments(clip *models.Clip, sourceDir string, segments []m3u8.Segment) error {
+	dir := m.clipDir(clip)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create clip directory: %w", err)
+	}
+
+	archived := make([]m3u8.Segment, 0, len(segments))
+	for _, segment := range segments {
+		name := filepath.Base(segment.URI)
+		if err := archiveSegment(filepath.Join(sourceDir, name), filepath.Join(dir, name), segment, m.segmentKey); err != nil {
+			return fmt.Errorf("failed to archive segment %s: %w", name, err)
+		}
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			clip.SizeBytes += info.Size()
+		}
+		segment.URI = name
+		segment.Key = nil
+		archived = append(archived, segment)
+		clip.Duration += segment.Duration
+	}
+
+	clip.SegmentCount = len(archived)
+	clip.PlaylistPath = filepath.Join(dir, recordingPlaylistName)
+	clip.MP4Path = filepath.Join(dir, "clip.mp4")
+
+	first, last := archived[0], archived[len(archived)-1]
+	if !first.ProgramDateTime.IsZero() {
+		start := first.ProgramDateTime
+		clip.StartTime = &start
+	}
+	if !last.ProgramDateTime.IsZero() {
+		end := last.ProgramDateTime.Add(time.Duration(last.Duration * float64(time.Second)))
+		clip.EndTime = &end
+	}
+
+	return writeVODPlaylist(clip.PlaylistPath, archived)
+}
+
+// finishClip remuxes the archived segments of a clip into an MP4
+func (m *Manager) finishClip(clip *models.Clip) {
+	if err := remuxMP4(clip.PlaylistPath, clip.MP4Path); err != nil {
+		clip.Status = models.ClipStatusFailed
+		clip.Error = fmt.Sprintf("failed to create MP4: %v", err)
+		log.Printf("❌ Clip %s for %s failed: %v", clip.ID, clip.StreamKey, err)
+	} else {
+		if info, err := os.Stat(clip.MP4Path); err == nil {
+			clip.SizeBytes = info.Size()
+		}
+		clip.Status = models.ClipStatusReady
+		log.Printf("✂️  Clip %s for %s ready (%d segments, %.1fs)", clip.ID, clip.StreamKey, clip.SegmentCount, clip.Duration)
+	}
+
+	if _, err := m.repo.UpdateClip(clip); err != nil {
+		log.Printf("⚠️  Failed to update clip %s: %v", clip.ID, err)
+	}
+}
+
+// clipDir returns the directory a clip's files are stored in
+func (m *Manager) clipDir(clip *models.Clip) string {
+	if clip.PlaylistPath != "" {
+		return filepath.Dir(clip.PlaylistPath)
+	}
+	return filepath.Join(m.archiveDir(clip.StreamKey), clipsDirName, clip.ID.String())
+}
+
+// Renditions returns the names of the quality ladder, highest first
+func (m *Manager) Renditions() []string {
+	names := make([]string, len(m.qualities))
+	for i, quality := range m.qualities {
+		names[i] = quality.Name
+	}
+	return names
+}
+
+// hasRendition reports whether a rendition is part of the quality ladder
+func (m *Manager) hasRendition(name string) bool {
+	for _, quality := range m.qualities {
+		if quality.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// selectClipSegments returns the segments overlapping the requested range
+func selectClipSegments(segments []m3u8.Segment, req ClipRequest) ([]m3u8.Segment, error) {
+	var selected []m3u8.Segment
+
+	if req.Start != nil || req.End != nil {
+		if req.Start == nil || req.End == nil || !req.End.After(*req.Start) {
+			return nil, fmt.Errorf("start and end must both be set and end must be after start")
+		}
+		if req.End.Sub(*req.Start).Seconds() > maxClipDuration {
+			return nil, fmt.Errorf("clips are limited to %d seconds", maxClipDuration)
+		}
+
+		for _, segment := range segments {
+			if segment.ProgramDateTime.IsZero() {
+				continue
+			}
+			end := segment.ProgramDateTime.Add(time.Duration(segment.Duration * float64(time.Second)))
+			if segment.ProgramDateTime.Before(*req.End) && end.After(*req.Start) {
+				selected = append(selected, segment)
+			}
+		}
+	} else {
+		if req.Duration <= 0 || req.Duration > maxClipDuration {
+			return nil, fmt.Errorf("duration must be between 0 and %d seconds", maxClipDuration)
+		}
+		offset := req.Offset
+		if offset == 0 {
+			offset = req.Duration
+		}
+		if offset < 0 {
+			return nil, fmt.Errorf("offset must not be negative")
+		}
+
+		// Positions are measured from the start of the playlist
+		total := 0.0
+		for _, segment := range segments {
+			total += segment.Duration
+		}
+		clipStart := total - offset
+		clipEnd := clipStart + req.Duration
+
+		position := 0.0
+		for _, segment := range segments {
+			end := position + segment.Duration
+			if position < clipEnd && end > clipStart {
+				selected = append(selected, segment)
+			}
+			position = end
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("the requested range is no longer on disk")
+	}
+	return selected, nil
+}
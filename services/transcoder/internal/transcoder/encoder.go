@@ -0,0 +1,127 @@
+package transcoder
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/streamforge/platform/pkg/models"
+)
+
+const (
+	// defaultPreset is the x264 preset used unless a stream overrides it
+	defaultPreset = "veryfast"
+	// defaultTune is the x264 tune used unless a stream overrides it
+	defaultTune = "zerolatency"
+	// tuneNone disables the x264 tune
+	tuneNone = "none"
+	// gopFrameRate converts the keyframe interval to frames for -g; the forced
+	// keyframes keep the interval exact whatever the input frame rate is
+	gopFrameRate = 24
+	// maxSegmentDuration is the longest segment a stream may use, in seconds
+	maxSegmentDuration = 10
+	// minGOPSeconds is the shortest keyframe interval a stream may use
+	minGOPSeconds = 0.5
+	// minPlaylistSize and maxPlaylistSize bound the live playlist length, in segments
+	minPlaylistSize = 3
+	maxPlaylistSize = 60
+	// maxBFrames is the most consecutive B-frames a stream may use
+	maxBFrames = 16
+)
+
+var (
+	// x264Presets are the presets fast enough for live encoding, fastest first
+	x264Presets = []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow"}
+	// x264Tunes are the tunes x264 accepts
+	x264Tunes = []string{"film", "animation", "grain", "stillimage", "fastdecode", "zerolatency", "psnr", "ssim"}
+)
+
+// ValidateEncoderOverrides checks an encoder override document. The keyframe
+// interval must divide the segment duration so every segment starts on a keyframe.
+func ValidateEncoderOverrides(overrides models.EncoderOverrides) error {
+	if overrides.Preset != "" && !contains(x264Presets, overrides.Preset) {
+		return fmt.Errorf("invalid preset %q (expected one of %s)", overrides.Preset, strings.Join(x264Presets, ", "))
+	}
+	if overrides.Tune != "" && overrides.Tune != tuneNone && !contains(x264Tunes, overrides.Tune) {
+		return fmt.Errorf("invalid tune %q (expected %s or %s)", overrides.Tune, tuneNone, strings.Join(x264Tunes, ", "))
+	}
+	if overrides.SegmentDuration < 0 || overrides.SegmentDuration > maxSegmentDuration {
+		return fmt.Errorf("invalid segment duration %d (expected 1 to %d seconds)", overrides.SegmentDuration, maxSegmentDuration)
+	}
+	if overrides.PlaylistSize != 0 && (overrides.PlaylistSize < minPlaylistSize || overrides.PlaylistSize > maxPlaylistSize) {
+		return fmt.Errorf("invalid playlist size %d (expected %d to %d segments)", overrides.PlaylistSize, minPlaylistSize, maxPlaylistSize)
+	}
+	if overrides.BFrames != nil && (*overrides.BFrames < 0 || *overrides.BFrames > maxBFrames) {
+		return fmt.Errorf("invalid B-frame count %d (expected 0 to %d)", *overrides.BFrames, maxBFrames)
+	}
+
+	segmentDuration := float64(defaultSegmentDuration)
+	if overrides.SegmentDuration > 0 {
+		segmentDuration = float64(overrides.SegmentDuration)
+	}
+	if overrides.GOPSeconds != 0 {
+		if overrides.GOPSeconds < minGOPSeconds || overrides.GOPSeconds > segmentDuration {
+			return fmt.Errorf("invalid GOP %gs (expected %g to %g seconds)", overrides.GOPSeconds, minGOPSeconds, segmentDuration)
+		}
+		gops := segmentDuration / overrides.GOPSeconds
+		if math.Abs(gops-math.Round(gops)) > 1e-6 {
+			return fmt.Errorf("GOP of %gs does not divide the %gs segment duration", overrides.GOPSeconds, segmentDuration)
+		}
+	}
+	return nil
+}
+
+// applyEncoderOverrides sets the encoder options of a stream, keeping the
+// defaults for the fields the overrides leave empty
+func (h *HLSManager) applyEncoderOverrides(overrides models.EncoderOverrides) {
+	h.preset = defaultPreset
+	if overrides.Preset != "" {
+		h.preset = overrides.Preset
+	}
+	h.tune = defaultTune
+	if overrides.Tune != "" {
+		h.tune = overrides.Tune
+	}
+	h.segmentDuration = defaultSegmentDuration
+	if overrides.SegmentDuration > 0 {
+		h.segmentDuration = overrides.SegmentDuration
+	}
+	h.gopSeconds = float64(h.segmentDuration)
+	if overrides.GOPSeconds > 0 {
+		h.gopSeconds = overrides.GOPSeconds
+	}
+	h.liveWindow = defaultPlaylistSize
+	if overrides.PlaylistSize > 0 {
+		h.liveWindow = overrides.PlaylistSize
+	}
+	h.bFrames = overrides.BFrames
+}
+
+// PlanFFmpegCommand returns the FFmpeg arguments the transcoder would run for a
+// stream right now, without starting anything or touching its files. For a
+// stream that is still in progress that is the encoder restart continuing its
+// playlists, otherwise a fresh start; the second result reports which.
+func (m *Manager) PlanFFmpegCommand(streamKey string) ([]string, bool) {
+	m.mutex.RLock()
+	process, exists := m.processes[streamKey]
+	restart := exists && !process.Status.Final()
+	slate := restart && process.Status == StateSlate
+	m.mutex.RUnlock()
+
+	hlsManager, _ := m.buildHLSManager(streamKey)
+	hlsManager.resume = restart
+	if slate {
+		hlsManager.UseSlate()
+	}
+	return hlsManager.GenerateFFmpegCommand(streamKey, fmt.Sprintf("%s/%s", m.rtmpURL, streamKey)), restart
+}
+
+// contains reports whether a list holds a value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,511 @@
+package transcoder
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/streamforge/platform/pkg/m3u8"
+	"github.com/streamforge/platform/pkg/models"
+	"github.com/streamforge/platform/pkg/playback"
+)
+
+const (
+	// contentKeySize is the size of an AES-128 content key in bytes
+	contentKeySize = 16
+	// keyInfoName is the FFmpeg key info file of a stream, reread before every segment
+	keyInfoName = "key_info"
+	// keyPollInterval is how often the playlist of an encrypted stream is checked
+	// for the segments written since the last key rotation
+	keyPollInterval = time.Second
+	// defaultKeyURL is where players fetch content keys, the key server behind the NGINX API proxy
+	defaultKeyURL = "/api/keys"
+)
+
+var (
+	// ErrEncryptionDisabled is returned when encryption is used without a key encryption secret
+	ErrEncryptionDisabled = errors.New("stream encryption is not configured")
+	// ErrEncryptionMethod is returned for encryption methods the HLS muxer cannot produce
+	ErrEncryptionMethod = errors.New("SAMPLE-AES is not supported, the FFmpeg HLS muxer only encrypts whole segments with AES-128")
+	// ErrKeyNotFound is returned for content keys that do not exist
+	ErrKeyNotFound = errors.New("encryption key not found")
+	// ErrKeyNotReleased is returned when a user asks for a key of someone else's
+	// private stream, or of a stream with only signed playback IDs
+	ErrKeyNotReleased = errors.New("keys of this stream are only released to its owner or with a playback token")
+)
+
+// KeyVault seals content keys for storage with AES-256-GCM, using a key derived
+// from the key encryption secret
+type KeyVault struct {
+	aead cipher.AEAD
+}
+
+// NewKeyVault returns a vault for the secret, or nil when the secret is empty
+func NewKeyVault(secret string) *KeyVault {
+	if secret == "" {
+		return nil
+	}
+	kek := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(kek[:])
+	if err != nil {
+		panic(err) // a 32-byte key is always valid
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return &KeyVault{aead: aead}
+}
+
+// Seal encrypts a content key, prefixing the random nonce
+func (v *KeyVault) Seal(key []byte) ([]byte, error) {
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return v.aead.Seal(nonce, nonce, key, nil), nil
+}
+
+// Open decrypts a content key sealed by Seal
+func (v *KeyVault) Open(sealed []byte) ([]byte, error) {
+	if len(sealed) < v.aead.NonceSize() {
+		return nil, fmt.Errorf("sealed key too short")
+	}
+	nonce, ciphertext := sealed[:v.aead.NonceSize()], sealed[v.aead.NonceSize():]
+	key, err := v.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sealed key: %w", err)
+	}
+	return key, nil
+}
+
+// SetKeyServer configures encryption. Content keys are sealed by the vault and
+// players fetch them from keyURL followed by the key ID.
+func (m *Manager) SetKeyServer(vault *KeyVault, keyURL string) {
+	m.keyMutex.Lock()
+	defer m.keyMutex.Unlock()
+	if keyURL == "" {
+		keyURL = defaultKeyURL
+	}
+	m.keyVault = vault
+	m.keyURL = strings.TrimSuffix(keyURL, "/")
+}
+
+// ValidateEncryption checks that streams can be encrypted with a method
+func (m *Manager) ValidateEncryption(method models.EncryptionMethod) error {
+	switch method {
+	case "", models.EncryptionNone:
+		return nil
+	case models.EncryptionAES128:
+	case models.EncryptionSampleAES:
+		return ErrEncryptionMethod
+	default:
+		return fmt.Errorf("invalid encryption %q (expected none or AES-128)", method)
+	}
+
+	m.keyMutex.RLock()
+	defer m.keyMutex.RUnlock()
+	if m.keyVault == nil {
+		return ErrEncryptionDisabled
+	}
+	return nil
+}
+
+// encryptionEnabled reports whether a method encrypts segments. An empty method,
+// as read from settings saved before encryption existed, does not.
+func encryptionEnabled(method models.EncryptionMethod) bool {
+	return method != "" && method != models.EncryptionNone
+}
+
+// newContentKey generates a content key for a stream and stores it sealed
+func (m *Manager) newContentKey(streamKey string, method models.EncryptionMethod) (uuid.UUID, []byte, error) {
+	m.keyMutex.RLock()
+	vault := m.keyVault
+	m.keyMutex.RUnlock()
+	if vault == nil {
+		return uuid.Nil, nil, ErrEncryptionDisabled
+	}
+
+	key := make([]byte, contentKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return uuid.Nil, nil, fmt.Errorf("failed to generate content key: %w", err)
+	}
+	sealed, err := vault.Seal(key)
+	if err != nil {
+		return uuid.Nil, nil, fmt.Errorf("failed to seal content key: %w", err)
+	}
+	record, err := m.repo.CreateEncryptionKey(&models.EncryptionKey{
+		StreamKey: streamKey,
+		Method:    method,
+		SealedKey: sealed,
+	})
+	if err != nil {
+		return uuid.Nil, nil, fmt.Errorf("failed to store content key: %w", err)
+	}
+	m.contentKeys.Store(record.ID, key)
+	return record.ID, key, nil
+}
+
+// contentKey returns a content key, from memory while its stream is live
+func (m *Manager) contentKey(id uuid.UUID) (*models.EncryptionKey, []byte, error) {
+	record, err := m.repo.GetEncryptionKey(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if record == nil {
+		return nil, nil, ErrKeyNotFound
+	}
+	if key, ok := m.contentKeys.Load(id); ok {
+		return record, key.([]byte), nil
+	}
+
+	m.keyMutex.RLock()
+	vault := m.keyVault
+	m.keyMutex.RUnlock()
+	if vault == nil {
+		return nil, nil, ErrEncryptionDisabled
+	}
+	key, err := vault.Open(record.SealedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return record, key, nil
+}
+
+// segmentKey returns the content key a key URI of a playlist refers to
+func (m *Manager) segmentKey(uri string) ([]byte, error) {
+	if i := strings.IndexByte(uri, '?'); i >= 0 {
+		uri = uri[:i]
+	}
+	id, err := uuid.Parse(path.Base(uri))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, uri)
+	}
+	if key, ok := m.contentKeys.Load(id); ok {
+		return key.([]byte), nil
+	}
+	_, key, err := m.contentKey(id)
+	return key, err
+}
+
+// ReleaseKey returns a content key to a viewer with a playback token for one of
+// the playback IDs of the key's stream
+func (m *Manager) ReleaseKey(id uuid.UUID, token, clientIP string) ([]byte, error) {
+	record, key, err := m.contentKey(id)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, _ := m.playbackSigner()
+	switch {
+	case token == "":
+		return nil, playback.ErrTokenRequired
+	case signer == nil:
+		return nil, playback.ErrTokensDisabled
+	}
+	claims, err := signer.Parse(token, clientIP)
+	if err != nil {
+		return nil, err
+	}
+
+	// Registered streams are played by playback ID, unregistered ones by key
+	playbackID, err := m.repo.GetPlaybackID(claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if playbackID != nil && playbackID.Stream != nil {
+		if playbackID.Stream.StreamKey != record.StreamKey {
+			return nil, playback.ErrTokenScope
+		}
+		return key, nil
+	}
+	if claims.Subject != record.StreamKey {
+		return nil, playback.ErrTokenScope
+	}
+	if stream, err := m.repo.GetStream(record.StreamKey); err != nil {
+		return nil, err
+	} else if stream != nil {
+		return nil, playback.ErrTokenScope
+	}
+	return key, nil
+}
+
+// ReleaseKeyToUser returns a content key to a signed-in user. The owner of the
+// stream always gets it; other users only for streams that are not private and
+// have a public playback ID, the ones they could get a playback token for.
+func (m *Manager) ReleaseKeyToUser(id uuid.UUID, userID uuid.UUID) ([]byte, error) {
+	record, key, err := m.contentKey(id)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := m.repo.GetStream(record.StreamKey)
+	if err != nil {
+		return nil, err
+	}
+	if stream == nil || stream.UserID == userID {
+		return key, nil
+	}
+	if stream.Status == models.StreamStatusPrivate {
+		return nil, ErrKeyNotReleased
+	}
+	ids, err := m.repo.ListPlaybackIDs(stream.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, playbackID := range ids {
+		if playbackID.Policy == models.PlaybackPolicyPublic {
+			return key, nil
+		}
+	}
+	return nil, ErrKeyNotReleased
+}
+
+// keyDirOf returns the private directory of the key files of a stream
+func (m *Manager) keyDirOf(streamKey string) string {
+	return filepath.Join(m.keyDir, streamKey)
+}
+
+// KeyRotator provides FFmpeg with the content key of an encrypted stream and
+// replaces it every few segments. FFmpeg rereads the key info file before each
+// segment, so a new key applies from the next segment on.
+type KeyRotator struct {
+	manager      *Manager
+	streamKey    string
+	method       models.EncryptionMethod
+	dir          string
+	playlistPath string
+	every        int   // segments per key, 0 never rotates
+	since        int64 // media sequence of the last segment under the previous key
+	current      uuid.UUID
+	previous     uuid.UUID
+	created      []uuid.UUID
+	stopOnce     sync.Once
+	stop         chan struct{}
+	done         chan struct{} // closed once run returns, so created is no longer written
+}
+
+// startKeyRotation writes the first content key of a stream and points the HLS
+// manager at it. Streams that cannot be encrypted do not start, so premium
+// content is never delivered in the clear.
+func (m *Manager) startKeyRotation(streamKey string, settings *models.StreamSettings, hlsManager *HLSManager) (*KeyRotator, error) {
+	if err := m.ValidateEncryption(settings.Encryption); err != nil {
+		return nil, err
+	}
+
+	dir := m.keyDirOf(streamKey)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	rotator := &KeyRotator{
+		manager:      m,
+		streamKey:    streamKey,
+		method:       settings.Encryption,
+		dir:          dir,
+		playlistPath: filepath.Join(hlsManager.VariantDir(streamKey, hlsManager.qualities[0].Name), variantPlaylistName),
+		every:        settings.KeyRotation,
+		since:        -1,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	if err := rotator.rotate(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	hlsManager.keyInfoFile = rotator.keyInfoPath()
+
+	if rotator.every > 0 {
+		go rotator.run()
+	} else {
+		close(rotator.done)
+	}
+	return rotator, nil
+}
+
+// Stop ends key rotation and removes the key files once a rotation in progress
+// has finished. The keys stay in the database, sealed, for viewers still
+// playing the segments they encrypt.
+func (r *KeyRotator) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+		<-r.done
+		os.RemoveAll(r.dir)
+		for _, id := range r.created {
+			r.manager.contentKeys.Delete(id)
+		}
+	})
+}
+
+// keyInfoPath returns the FFmpeg key info file of the stream
+func (r *KeyRotator) keyInfoPath() string {
+	return filepath.Join(r.dir, keyInfoName)
+}
+
+// run rotates the key whenever enough segments were written under the current one
+func (r *KeyRotator) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(keyPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if err := r.check(); err != nil {
+				log.Printf("⚠️  Key rotation for %s failed: %v", r.streamKey, err)
+			}
+		}
+	}
+}
+
+// check counts the segments written since the last rotation
+func (r *KeyRotator) check() error {
+	playlist, err := m3u8.ReadMediaFile(r.playlistPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // FFmpeg has not written the first segment yet
+		}
+		return err
+	}
+	if len(playlist.Segments) == 0 {
+		return nil
+	}
+
+	last := playlist.MediaSequence + int64(len(playlist.Segments)) - 1
+	if r.since < 0 {
+		r.since = playlist.MediaSequence - 1
+	}
+	if last-r.since < int64(r.every) {
+		return nil
+	}
+	if err := r.rotate(); err != nil {
+		return err
+	}
+	r.since = last
+	return nil
+}
+
+// rotate generates a new content key and hands it to FFmpeg. The key file of
+// the key before the current one is removed, as FFmpeg no longer reads it.
+func (r *KeyRotator) rotate() error {
+	id, key, err := r.manager.newContentKey(r.streamKey, r.method)
+	if err != nil {
+		return err
+	}
+	r.created = append(r.created, id)
+
+	keyPath := filepath.Join(r.dir, id.String()+".key")
+	if err := os.WriteFile(keyPath, key, 0600); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+
+	r.manager.keyMutex.RLock()
+	keyURI := r.manager.keyURL + "/" + id.String()
+	r.manager.keyMutex.RUnlock()
+	info := keyURI + "\n" + keyPath + "\n"
+	if err := writeFileAtomic(r.keyInfoPath(), []byte(info)); err != nil {
+		return fmt.Errorf("failed to write key info: %w", err)
+	}
+
+	if r.previous != uuid.Nil {
+		os.Remove(filepath.Join(r.dir, r.previous.String()+".key"))
+	}
+	r.previous, r.current = r.current, id
+	log.Printf("🔐 New content key %s for %s", id, r.streamKey)
+	return nil
+}
+
+// resolveSegmentKeys gives every segment of a playlist the key it is encrypted
+// with, including the IV, so any of them can be decrypted on its own. Segments
+// in the clear are left without a key.
+func resolveSegmentKeys(playlist *m3u8.MediaPlaylist) {
+	var current *m3u8.Key
+	for i := range playlist.Segments {
+		segment := &playlist.Segments[i]
+		if segment.Key != nil {
+			current = segment.Key
+		}
+		if current == nil || current.Method == "NONE" {
+			segment.Key = nil
+			continue
+		}
+
+		key := *current
+		if key.IV == "" {
+			// Without an IV attribute the IV is the media sequence number
+			var iv [aes.BlockSize]byte
+			binary.BigEndian.PutUint64(iv[8:], uint64(playlist.MediaSequence+int64(i)))
+			key.IV = "0x" + hex.EncodeToString(iv[:])
+		}
+		segment.Key = &key
+	}
+}
+
+// decryptSegment decrypts an AES-128 segment with a PKCS#7 padded CBC stream
+func decryptSegment(data, key []byte, keyTag *m3u8.Key) ([]byte, error) {
+	if keyTag.Method != string(models.EncryptionAES128) {
+		return nil, fmt.Errorf("unsupported encryption method %s", keyTag.Method)
+	}
+	iv, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(keyTag.IV, "0x"), "0X"))
+	if err != nil || len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid IV %q", keyTag.IV)
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("encrypted segment is %d bytes, not a multiple of the block size", len(data))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(plain) {
+		return nil, fmt.Errorf("invalid padding")
+	}
+	return plain[:len(plain)-padding], nil
+}
+
+// archiveSegment links a segment into an archive, decrypting it first if it is
+// encrypted, since archives are remuxed and served without the key server
+func archiveSegment(src, dst string, segment m3u8.Segment, keys func(uri string) ([]byte, error)) error {
+	if segment.Key == nil {
+		return linkOrCopy(src, dst)
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if keys == nil {
+		return ErrEncryptionDisabled
+	}
+	key, err := keys(segment.Key.URI)
+	if err != nil {
+		return err
+	}
+	plain, err := decryptSegment(data, key, segment.Key)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dst, plain)
+}
@@ -0,0 +1,94 @@
+package transcoder
+
+import (
+	"sync"
+
+	"github.com/streamforge/platform/pkg/models"
+)
+
+const (
+	// eventBacklog is how many recent events are kept for clients catching up
+	eventBacklog = 256
+	// subscriberBuffer is how many events a subscriber may fall behind before it is dropped
+	subscriberBuffer = 64
+)
+
+// SequencedEvent is a stream event numbered in publishing order, so clients can
+// resume after the last event they saw
+type SequencedEvent struct {
+	ID        uint64
+	StreamKey string
+	Event     models.StreamEvent
+}
+
+// EventBroker fans stream events out to subscribers and keeps the most recent
+// ones for clients that reconnect
+type EventBroker struct {
+	mutex       sync.Mutex
+	lastID      uint64
+	recent      []SequencedEvent
+	subscribers map[chan SequencedEvent]struct{}
+}
+
+// NewEventBroker creates an event broker without subscribers
+func NewEventBroker() *EventBroker {
+	return &EventBroker{subscribers: make(map[chan SequencedEvent]struct{})}
+}
+
+// Publish numbers an event and delivers it to every subscriber. A subscriber
+// that cannot keep up is dropped rather than stalling the transcoder; it can
+// resubscribe from the last event it received.
+func (b *EventBroker) Publish(streamKey string, event models.StreamEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.lastID++
+	sequenced := SequencedEvent{ID: b.lastID, StreamKey: streamKey, Event: event}
+	b.recent = append(b.recent, sequenced)
+	if len(b.recent) > eventBacklog {
+		b.recent = b.recent[len(b.recent)-eventBacklog:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- sequenced:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns the kept events published after the given ID, a channel
+// for the events that follow and a function that ends the subscription. The
+// channel is closed when the subscription ends.
+func (b *EventBroker) Subscribe(afterID uint64) ([]SequencedEvent, <-chan SequencedEvent, func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var missed []SequencedEvent
+	if afterID > 0 {
+		for _, event := range b.recent {
+			if event.ID > afterID {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	ch := make(chan SequencedEvent, subscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+	unsubscribe := func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return missed, ch, unsubscribe
+}
+
+// Events returns the broker that publishes the state transitions of every stream
+func (m *Manager) Events() *EventBroker {
+	return m.events
+}
@@ -0,0 +1,392 @@
+package transcoder
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/streamforge/platform/pkg/m3u8"
+	"github.com/streamforge/platform/pkg/models"
+)
+
+const (
+	// defaultSegmentDuration is the target HLS segment length in seconds
+	defaultSegmentDuration = 2
+	// defaultPlaylistSize is the number of segments kept in a live variant playlist
+	defaultPlaylistSize = 12
+	// variantPlaylistName is the file name of every variant playlist
+	variantPlaylistName = "playlist.m3u8"
+	// streamSettingsFile is the sidecar that tells the HLS server and cleanup jobs
+	// how a stream is delivered
+	streamSettingsFile = ".stream_settings"
+	// hlsStaleAfter is how long playlists may go without an update before the
+	// output counts as stale
+	hlsStaleAfter = 30 * time.Second
+)
+
+// HLSManager generates playlists and FFmpeg arguments for a stream's HLS output
+type HLSManager struct {
+	outputDir       string
+	qualities       []Quality
+	segmentDuration int
+	liveWindow      int // segments in the live playlist
+	playlistSize    int // segments kept on disk, more than the live window with DVR
+	dvrWindow       int
+	preset          string
+	tune            string
+	gopSeconds      float64
+	bFrames         *int
+	overlays        []models.StreamOverlay
+	resume          bool
+	slate           bool   // encode the slate instead of the RTMP input
+	slateSource     string // image or clip, empty for the built-in slate
+	signedPlayback  bool   // private stream, only served with a playback token
+	deliveryTier    string // rate limit tier the HLS servers apply
+	playlistBaseURL string // base URL of rewritten variant playlist URIs
+	segmentBaseURL  string // base URL of rewritten segment URIs
+	propagateQuery  []string
+	playbackID      string // directory the output is published in
+	keyInfoFile     string // FFmpeg key info file of an encrypted stream
+	// Returns the content key of a key URI, for reading encrypted segments back
+	contentKeys func(uri string) ([]byte, error)
+}
+
+// deliverySettings is the content of a stream's settings sidecar. It is served
+// along with the stream, so it must not reveal the stream key.
+type deliverySettings struct {
+	SegmentDuration    int       `json:"segment_duration"`
+	LiveWindowSegments int       `json:"live_window_segments"`
+	DVRWindowSeconds   int       `json:"dvr_window_seconds"`
+	SignedPlayback     bool      `json:"signed_playback"`
+	DeliveryTier       string    `json:"delivery_tier,omitempty"`
+	PlaylistBaseURL    string    `json:"playlist_base_url,omitempty"`
+	SegmentBaseURL     string    `json:"segment_base_url,omitempty"`
+	PropagateQuery     []string  `json:"propagate_query,omitempty"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// HLSStats summarises the health of a stream's HLS output
+type HLSStats struct {
+	Active       bool
+	Variants     int
+	SegmentCount int
+	LastUpdate   time.Time
+}
+
+// NewHLSManager creates a new HLS manager for the given quality ladder
+func NewHLSManager(outputDir string, qualities []Quality) *HLSManager {
+	return &HLSManager{
+		outputDir:       outputDir,
+		qualities:       qualities,
+		segmentDuration: defaultSegmentDuration,
+		liveWindow:      defaultPlaylistSize,
+		playlistSize:    defaultPlaylistSize,
+		preset:          defaultPreset,
+		tune:            defaultTune,
+		gopSeconds:      defaultSegmentDuration,
+	}
+}
+
+// ApplySettings applies per-stream delivery settings. With a DVR window the
+// variant playlists keep every segment of the window, and the HLS server trims
+// them back to the live window for regular viewers.
+func (h *HLSManager) ApplySettings(settings *models.StreamSettings) {
+	h.applyEncoderOverrides(settings.Encoder)
+	h.dvrWindow = settings.DVRWindow
+	h.slateSource = settings.SlateURL
+	h.deliveryTier = settings.DeliveryTier
+	h.playlistBaseURL = settings.PlaylistBaseURL
+	h.segmentBaseURL = settings.SegmentBaseURL
+	h.propagateQuery = settings.PropagateQuery
+	h.playlistSize = h.liveWindow
+	if h.dvrWindow > 0 {
+		segments := (h.dvrWindow + h.segmentDuration - 1) / h.segmentDuration
+		if segments > h.playlistSize {
+			h.playlistSize = segments
+		}
+	}
+}
+
+// WriteStreamSettings writes the settings sidecar into the stream directory
+func (h *HLSManager) WriteStreamSettings(streamKey string) error {
+	streamDir := h.streamDir(streamKey)
+	if err := os.MkdirAll(streamDir, 0755); err != nil {
+		return fmt.Errorf("failed to create stream directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(deliverySettings{
+		SegmentDuration:    h.segmentDuration,
+		LiveWindowSegments: h.liveWindow,
+		DVRWindowSeconds:   h.dvrWindow,
+		SignedPlayback:     h.signedPlayback,
+		DeliveryTier:       h.deliveryTier,
+		PlaylistBaseURL:    h.playlistBaseURL,
+		SegmentBaseURL:     h.segmentBaseURL,
+		PropagateQuery:     h.propagateQuery,
+		UpdatedAt:          time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(streamDir, streamSettingsFile), append(data, '\n'))
+}
+
+// streamDir returns the directory of a stream's output, which is named after its
+// playback ID so the stream key never appears in playback URLs
+func (h *HLSManager) streamDir(streamKey string) string {
+	if h.playbackID != "" {
+		return filepath.Join(h.outputDir, h.playbackID)
+	}
+	return filepath.Join(h.outputDir, streamKey)
+}
+
+// GenerateMasterPlaylist writes the master playlist with CODECS for every variant
+func (h *HLSManager) GenerateMasterPlaylist(streamKey string) error {
+	streamDir := h.streamDir(streamKey)
+
+	master := &m3u8.MasterPlaylist{Version: 3, IndependentSegments: true}
+	for i, quality := range h.qualities {
+		if err := os.MkdirAll(filepath.Join(streamDir, quality.Name), 0755); err != nil {
+			return fmt.Errorf("failed to create variant directory %s: %w", quality.Name, err)
+		}
+
+		master.Variants = append(master.Variants, m3u8.Variant{
+			URI:        VariantPlaylistPath(quality.Name),
+			Bandwidth:  (parseKbps(quality.MaxBitrate) + parseKbps(h.getAudioBitrate(i))) * 1000,
+			Resolution: quality.Resolution,
+			Codecs:     h.getCodec(i) + ",mp4a.40.2",
+		})
+	}
+
+	return writeFileAtomic(filepath.Join(streamDir, "master.m3u8"), master.Encode())
+}
+
+// GenerateFFmpegCommand builds the FFmpeg arguments for a single-pass ABR ladder
+func (h *HLSManager) GenerateFFmpegCommand(streamKey, inputURL string) []string {
+	streamDir := h.streamDir(streamKey)
+	gop := int(math.Round(h.gopSeconds * gopFrameRate))
+
+	args := []string{"-hide_banner", "-loglevel", "warning"}
+	inputArgs, audio := h.inputArgs(inputURL)
+	args = append(args, inputArgs...)
+
+	for range h.qualities {
+		args = append(args, "-map", "0:v:0", "-map", audio)
+	}
+
+	args = append(args, "-c:v", "libx264", "-preset", h.preset)
+	if h.tune != tuneNone {
+		args = append(args, "-tune", h.tune)
+	}
+	if h.bFrames != nil {
+		args = append(args, "-bf", strconv.Itoa(*h.bFrames))
+	}
+	args = append(args,
+		"-g", strconv.Itoa(gop), "-keyint_min", strconv.Itoa(gop), "-sc_threshold", "0",
+		"-force_key_frames", "expr:gte(t,n_forced*"+strconv.FormatFloat(h.gopSeconds, 'f', -1, 64)+")",
+		"-c:a", "aac", "-ac", "2", "-ar", "44100",
+	)
+
+	streamMap := make([]string, len(h.qualities))
+	for i, quality := range h.qualities {
+		idx := strconv.Itoa(i)
+		args = append(args,
+			"-filter:v:"+idx, h.videoFilter(quality),
+			"-b:v:"+idx, quality.VideoBitrate,
+			"-maxrate:v:"+idx, quality.MaxBitrate,
+			"-bufsize:v:"+idx, quality.BufSize,
+			"-profile:v:"+idx, h.getProfile(i),
+			"-level:v:"+idx, h.getLevel(i),
+			"-b:a:"+idx, h.getAudioBitrate(i),
+		)
+		streamMap[i] = fmt.Sprintf("v:%d,a:%d,name:%s", i, i, quality.Name)
+	}
+
+	hlsFlags := "independent_segments+program_date_time+delete_segments+temp_file"
+	if h.resume {
+		// Continue the existing playlists, marking the encoder change as a discontinuity
+		hlsFlags += "+append_list+discont_start"
+	}
+	if h.keyInfoFile != "" {
+		// Reread the key info before every segment to pick up rotated keys
+		hlsFlags += "+periodic_rekey"
+	}
+
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(h.segmentDuration),
+		"-hls_list_size", strconv.Itoa(h.playlistSize),
+		"-hls_flags", hlsFlags,
+		"-hls_start_number_source", "epoch",
+		"-hls_segment_type", "mpegts",
+	)
+	if h.keyInfoFile != "" {
+		args = append(args, "-hls_key_info_file", h.keyInfoFile)
+	}
+	args = append(args,
+		"-hls_segment_filename", filepath.Join(streamDir, "%v", "segment%03d.ts"),
+		"-var_stream_map", strings.Join(streamMap, " "),
+		filepath.Join(streamDir, "%v", variantPlaylistName),
+	)
+
+	return args
+}
+
+// ResumePlaylists makes the next FFmpeg command append to the variant playlists
+// left by a previous encoder instead of starting new ones. The end-of-list tag
+// FFmpeg writes on exit is removed so players keep polling in between, and the
+// discontinuity the new encoder starts with is recorded for the HLS server.
+func (h *HLSManager) ResumePlaylists(streamKey string) error {
+	h.resume = true
+	if err := h.ReopenPlaylists(streamKey); err != nil {
+		return err
+	}
+	return h.recordDiscontinuity(streamKey)
+}
+
+// ReopenPlaylists removes the end-of-list tag FFmpeg writes on exit from the
+// variant playlists, so players keep polling while no encoder is running
+func (h *HLSManager) ReopenPlaylists(streamKey string) error {
+	return h.setPlaylistsEnded(streamKey, false)
+}
+
+// EndPlaylists appends EXT-X-ENDLIST to variant playlists FFmpeg left open, so
+// players stop polling once a stream is over
+func (h *HLSManager) EndPlaylists(streamKey string) error {
+	return h.setPlaylistsEnded(streamKey, true)
+}
+
+// setPlaylistsEnded adds or removes the end-of-list tag of every variant playlist
+func (h *HLSManager) setPlaylistsEnded(streamKey string, ended bool) error {
+	for _, quality := range h.qualities {
+		path := filepath.Join(h.streamDir(streamKey), quality.Name, variantPlaylistName)
+		playlist, err := m3u8.ReadMediaFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if playlist.Ended == ended {
+			continue
+		}
+		playlist.Ended = ended
+		if err := writeFileAtomic(path, playlist.Encode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MonitorHLSHealth checks whether the variant playlists of a stream are still advancing
+func (h *HLSManager) MonitorHLSHealth(streamKey string) (*HLSStats, error) {
+	streamDir := h.streamDir(streamKey)
+	if _, err := os.Stat(streamDir); err != nil {
+		return nil, fmt.Errorf("stream directory not found: %w", err)
+	}
+
+	stats := &HLSStats{}
+	for _, quality := range h.qualities {
+		playlistPath := filepath.Join(streamDir, quality.Name, variantPlaylistName)
+		info, err := os.Stat(playlistPath)
+		if err != nil {
+			continue
+		}
+
+		stats.Variants++
+		if info.ModTime().After(stats.LastUpdate) {
+			stats.LastUpdate = info.ModTime()
+		}
+		if playlist, err := m3u8.ReadMediaFile(playlistPath); err == nil {
+			stats.SegmentCount += len(playlist.Segments)
+		}
+	}
+
+	stats.Active = stats.Variants > 0 && time.Since(stats.LastUpdate) < hlsStaleAfter
+	return stats, nil
+}
+
+// VariantPlaylistPath returns the playlist of a rendition relative to the stream directory
+func VariantPlaylistPath(rendition string) string {
+	return rendition + "/" + variantPlaylistName
+}
+
+// VariantDir returns the on-disk directory of a rendition
+func (h *HLSManager) VariantDir(streamKey, rendition string) string {
+	return filepath.Join(h.streamDir(streamKey), rendition)
+}
+
+// getCodec returns the RFC 6381 codec string for a quality level
+func (h *HLSManager) getCodec(index int) string {
+	profile := "4d40" // main
+	if h.getProfile(index) == "baseline" {
+		profile = "42e0"
+	}
+
+	level := strings.Replace(h.getLevel(index), ".", "", 1)
+	levelID, _ := strconv.Atoi(level)
+	return fmt.Sprintf("avc1.%s%02x", profile, levelID)
+}
+
+// getProfile returns the H.264 profile for a quality level
+func (h *HLSManager) getProfile(index int) string {
+	if index <= 2 { // 1080p, 720p, 480p
+		return "main"
+	}
+	return "baseline" // 360p, 240p, 144p
+}
+
+// getLevel returns the H.264 level for a quality level
+func (h *HLSManager) getLevel(index int) string {
+	switch index {
+	case 0: // 1080p
+		return "4.0"
+	case 1: // 720p
+		return "3.1"
+	default:
+		return "3.0"
+	}
+}
+
+// getAudioBitrate returns the audio bitrate for a quality level
+func (h *HLSManager) getAudioBitrate(index int) string {
+	if index <= 2 { // 1080p, 720p, 480p
+		return "128k"
+	}
+	return "96k" // Lower quality levels
+}
+
+// writeFileAtomic writes a file via a temporary file so readers never see partial content
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// parseKbps converts an FFmpeg bitrate such as "2800k" to kilobits per second
+func parseKbps(bitrate string) int {
+	value := strings.TrimSuffix(strings.ToLower(bitrate), "k")
+	kbps, _ := strconv.Atoi(value)
+	return kbps
+}
+
+// splitResolution splits a "WIDTHxHEIGHT" resolution into its parts
+func splitResolution(resolution string) (string, string) {
+	parts := strings.SplitN(resolution, "x", 2)
+	if len(parts) != 2 {
+		return "-2", "-2"
+	}
+	return parts[0], parts[1]
+}
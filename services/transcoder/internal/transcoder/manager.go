@@ -12,6 +12,12 @@ import (
 	"sync"
 	"syscall"
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/streamforge/platform/pkg/access"
+	"github.com/streamforge/platform/pkg/models"
+	"github.com/streamforge/platform/pkg/playback"
+	"github.com/streamforge/platform/services/transcoder/internal/repository"
 )
 
 // Quality represents a transcoding quality profile
@@ -29,24 +35,85 @@ type TranscoderProcess struct {
 	Cmd       *exec.Cmd
 	StartTime time.Time
 	OutputDir string
-	Status    string
+	// Lifecycle state, when and why it was entered, and the latest transitions
+	Status      State
+	StateSince  time.Time
+	StateReason string
+	Transitions []StateTransition
 	// Enhanced fields for better monitoring
 	PID       int
 	Qualities []Quality
+	// Registered stream the key belongs to, zero for unregistered keys
+	StreamID uuid.UUID
+	// Session and archive state for registered streams
+	SessionID   *uuid.UUID
+	Recorder    *Recorder
+	Thumbnailer *Thumbnailer
+	KeyRotator  *KeyRotator
+	// Closed once the current encoder has exited and been reaped
+	exited <-chan struct{}
+	// Set while the slate is shown in place of a publisher that dropped
+	SlateSince *time.Time
+	slateTimer *time.Timer
+	// Set while waiting for a publisher that dropped to reconnect
+	ReconnectUntil *time.Time
+	reconnectTimer *time.Timer
 }
 
 // Manager manages multiple transcoding processes with robust concurrency control
 type Manager struct {
-	rtmpURL   string
-	outputDir string
-	lockDir   string
-	processes map[string]*TranscoderProcess
-	mutex     sync.RWMutex
-	qualities []Quality
+	rtmpURL       string
+	outputDir     string
+	recordingsDir string
+	lockDir       string
+	processes     map[string]*TranscoderProcess
+	mutex         sync.RWMutex
+	qualities     []Quality
+	repo          *repository.StreamRepository
+	// Restream relays by stream key and target ID
+	relays     map[string]map[uuid.UUID]*Relay
+	relayMutex sync.Mutex
+	// Serializes ad cue scheduling so overlap checks and the sidecar stay consistent
+	adCueMutex sync.Mutex
+	// How long a stream whose publisher dropped keeps its session for a reconnect
+	reconnectWindow time.Duration
+	// How many hours the files of an ended stream are kept
+	endedRetentionHours int
+	// Publishes state transitions to event stream subscribers
+	events *EventBroker
+	// Signs and verifies playback tokens, nil when no secret is configured.
+	// Guarded by tokenMutex, so key and playlist requests do not wait for the
+	// manager mutex while streams start.
+	playbackTokens        *playback.Signer
+	requirePlaybackTokens bool
+	tokenMutex            sync.RWMutex
+	// Cached privacy of streams by stream key
+	playbackPolicies sync.Map
+	// Playback IDs streams are published under, by stream key
+	outputNames sync.Map
+	// Resolves playback IDs in requests for HLS files
+	playbackIDs *playback.Resolver
+	// Serializes writes of the playback ID index
+	indexMutex sync.Mutex
+	// Seals content keys, nil when no key encryption secret is configured, and
+	// the base URL of the key server in EXT-X-KEY tags. Guarded by keyMutex, as
+	// keys are created while the manager mutex is held.
+	keyVault *KeyVault
+	keyURL   string
+	keyMutex sync.RWMutex
+	// Private directory of the key files FFmpeg reads
+	keyDir string
+	// Content keys of live encrypted streams by key ID
+	contentKeys sync.Map
+	// Enforces the access rules of streams on HLS file requests
+	accessRules *access.Enforcer
+	// Where overlay images may be read from, set before streams start
+	overlayDir   string
+	overlayHosts map[string]bool
 }
 
 // NewManager creates a new transcoder manager with comprehensive initialization
-func NewManager(rtmpURL, outputDir string) *Manager {
+func NewManager(rtmpURL, outputDir, recordingsDir string, repo *repository.StreamRepository) *Manager {
 	// Define quality profiles matching working configuration
 	qualities := []Quality{
 		{Name: "1080p", Resolution: "1920x1080", VideoBitrate: "5000k", MaxBitrate: "5500k", BufSize: "5000k"},
@@ -61,17 +128,39 @@ func NewManager(rtmpURL, outputDir string) *Manager {
 		log.Printf("⚠️  Failed to create lock directory: %v", err)
 	}
 
+	// Create key directory, readable by the transcoder only
+	keyDir := "/tmp/streamforge_keys"
+	if err := os.MkdirAll(keyDir, 0700); err != nil {
+		log.Printf("⚠️  Failed to create key directory: %v", err)
+	}
+
 	// Create output directory with proper permissions
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		log.Printf("⚠️  Failed to create output directory: %v", err)
 	}
 
+	// Create recordings directory for archived sessions
+	if err := os.MkdirAll(recordingsDir, 0755); err != nil {
+		log.Printf("⚠️  Failed to create recordings directory: %v", err)
+	}
+
 	manager := &Manager{
-		rtmpURL:   rtmpURL,
-		outputDir: outputDir,
-		lockDir:   lockDir,
-		processes: make(map[string]*TranscoderProcess),
-		qualities: qualities,
+		rtmpURL:       rtmpURL,
+		outputDir:     outputDir,
+		recordingsDir: recordingsDir,
+		lockDir:       lockDir,
+		keyDir:        keyDir,
+		keyURL:        defaultKeyURL,
+		processes:     make(map[string]*TranscoderProcess),
+		qualities:     qualities,
+		repo:          repo,
+		relays:        make(map[string]map[uuid.UUID]*Relay),
+
+		reconnectWindow:     defaultReconnectWindow,
+		endedRetentionHours: defaultEndedRetentionHours,
+		events:              NewEventBroker(),
+		playbackIDs:         playback.NewResolver(outputDir),
+		accessRules:         access.NewEnforcer(outputDir, nil),
 	}
 
 	// Clean up any orphaned processes and lock files from previous runs
@@ -79,9 +168,14 @@ func NewManager(rtmpURL, outputDir string) *Manager {
 		log.Printf("⚠️  Failed to cleanup orphaned processes: %v", err)
 	}
 
+	// Publish the playback IDs of registered streams to the HLS servers
+	manager.writePlaybackIndex()
+	manager.publishAllAccessRules()
+
 	log.Printf("🎬 Transcoder Manager initialized with %d quality profiles", len(qualities))
 	log.Printf("📁 Output directory: %s", outputDir)
 	log.Printf("🔒 Lock directory: %s", lockDir)
+	log.Printf("📼 Recordings directory: %s", recordingsDir)
 
 	return manager
 }
@@ -274,10 +368,18 @@ func (m *Manager) StartTranscoder(streamKey string) error {
 
 	// First check: Verify not already running in our process map
 	if proc, exists := m.processes[streamKey]; exists {
-		if proc.Status == "running" || proc.Status == "starting" {
+		// A publisher returning within the slate grace period takes over the running encoder
+		if proc.SlateSince != nil {
+			return m.leaveSlateLocked(proc)
+		}
+		// A publisher reconnecting within the window continues the session and playlists
+		if proc.ReconnectUntil != nil {
+			return m.resumeLocked(proc)
+		}
+		if !proc.Status.Final() {
 			return fmt.Errorf("transcoder for %s is already %s", streamKey, proc.Status)
 		}
-		// Clean up stale entry
+		// Clean up the entry of an ended stream
 		delete(m.processes, streamKey)
 	}
 
@@ -301,30 +403,70 @@ func (m *Manager) StartTranscoder(streamKey string) error {
 	}
 
 	// Mark as starting to prevent concurrent starts
-	m.processes[streamKey] = &TranscoderProcess{
+	process := &TranscoderProcess{
 		StreamKey: streamKey,
 		StartTime: time.Now(),
-		Status:    "starting",
+	}
+	if streamID, err := m.repo.GetStreamID(streamKey); err != nil {
+		log.Printf("⚠️  Failed to look up stream %s: %v", streamKey, err)
+	} else if streamID != nil {
+		process.StreamID = *streamID
+	}
+	m.processes[streamKey] = process
+	m.transitionLocked(process, StateStarting, "publisher connected")
+
+	// abort ends a start that failed before the encoder was running
+	abort := func(err error) error {
+		if process.KeyRotator != nil {
+			process.KeyRotator.Stop()
+		}
+		m.transitionLocked(process, StateFailed, err.Error())
+		m.releaseStreamLock(streamKey)
+		delete(m.processes, streamKey)
+		return err
 	}
 
 	log.Printf("🎬 Starting Go-based transcoding for stream: %s", streamKey)
 
-	// Create output directory structure
-	streamOutputDir := filepath.Join(m.outputDir, streamKey)
+	// Create output directory structure under the stream's current playback ID,
+	// and let the HLS servers resolve it
+	m.outputNames.Delete(streamKey)
+	streamOutputDir := m.streamDir(streamKey)
+	m.writePlaybackIndex()
 	if err := os.MkdirAll(streamOutputDir, 0755); err != nil {
-		m.releaseStreamLock(streamKey)
-		delete(m.processes, streamKey)
-		return fmt.Errorf("failed to create output directory: %w", err)
+		return abort(fmt.Errorf("failed to create output directory: %w", err))
+	}
+
+	// A new session starts with fresh playlists rather than the previous session's
+	if err := m.resetStreamDir(streamKey); err != nil {
+		return abort(fmt.Errorf("failed to reset output directory: %w", err))
+	}
+
+	// Access rules are in place before the first playlist can be served
+	if err := m.publishAccessRules(streamKey); err != nil {
+		return abort(fmt.Errorf("failed to publish access rules: %w", err))
+	}
+
+	// Keys of the previous session are not reused
+	if err := os.RemoveAll(m.keyDirOf(streamKey)); err != nil {
+		return abort(fmt.Errorf("failed to remove old key files: %w", err))
 	}
 
 	// Initialize HLS manager for this stream
-	hlsManager := NewHLSManager(m.outputDir)
+	hlsManager, settings := m.newHLSManager(streamKey)
+
+	// Encrypted streams get their first content key before the encoder starts
+	if encryptionEnabled(settings.Encryption) {
+		rotator, err := m.startKeyRotation(streamKey, settings, hlsManager)
+		if err != nil {
+			return abort(fmt.Errorf("failed to set up encryption: %w", err))
+		}
+		process.KeyRotator = rotator
+	}
 
 	// Generate master playlist with proper CODECS
 	if err := hlsManager.GenerateMasterPlaylist(streamKey); err != nil {
-		m.releaseStreamLock(streamKey)
-		delete(m.processes, streamKey)
-		return fmt.Errorf("failed to generate master playlist: %w", err)
+		return abort(fmt.Errorf("failed to generate master playlist: %w", err))
 	}
 
 	// Build FFmpeg command
@@ -339,75 +481,231 @@ func (m *Manager) StartTranscoder(streamKey string) error {
 		Setpgid: true, // Create new process group for clean termination
 	}
 
-	if err := cmd.Start(); err != nil {
-		m.releaseStreamLock(streamKey)
-		delete(m.processes, streamKey)
-		return fmt.Errorf("failed to start FFmpeg: %w", err)
-	}
-
-	// Verify process actually started
-	if cmd.Process == nil {
-		m.releaseStreamLock(streamKey)
-		delete(m.processes, streamKey)
-		return fmt.Errorf("FFmpeg process failed to initialize properly")
+	exited, err := startEncoder(cmd)
+	if err != nil {
+		return abort(fmt.Errorf("failed to start FFmpeg: %w", err))
 	}
 
 	// Update process tracking with running state
-	process := m.processes[streamKey]
 	process.Cmd = cmd
+	process.exited = exited
 	process.StartTime = time.Now()
 	process.OutputDir = streamOutputDir
-	process.Status = "running"
 	process.PID = cmd.Process.Pid
 	process.Qualities = m.qualities
 
+	// Open a session for registered streams and archive renditions if enabled
+	process.SessionID = m.startSession(streamKey)
+	m.transitionLocked(process, StateRunning, "encoder started")
+	if recorder, err := m.startRecording(streamKey, settings, process.SessionID, hlsManager); err != nil {
+		log.Printf("⚠️  Failed to start recording for %s: %v", streamKey, err)
+	} else {
+		process.Recorder = recorder
+	}
+
+	// Start the broadcast without ad breaks left over from the previous one
+	if err := m.writeAdCues(streamKey, nil); err != nil {
+		log.Printf("⚠️  Failed to reset ad cues for %s: %v", streamKey, err)
+	}
+
+	// Relay the stream to its enabled restream targets
+	m.startRelays(streamKey)
+
+	// Capture poster images and seek previews
+	process.Thumbnailer = NewThumbnailer(streamKey, hlsManager)
+	process.Thumbnailer.Start()
+
 	// Start monitoring in background
-	go m.monitorProcess(streamKey, hlsManager)
+	go m.monitorProcess(process, hlsManager)
 
 	log.Printf("✅ Go-based transcoding started for %s (PID: %d)", streamKey, cmd.Process.Pid)
 	return nil
 }
 
-// StopTranscoder stops monitoring for a stream key
-func (m *Manager) StopTranscoder(streamKey string) error {
+// newHLSManager creates the HLS manager of a stream from its current settings and
+// overlays, falling back to defaults so a database problem never prevents a stream
+// from going live
+func (m *Manager) newHLSManager(streamKey string) (*HLSManager, *models.StreamSettings) {
+	hlsManager, settings := m.buildHLSManager(streamKey)
+	if err := hlsManager.WriteStreamSettings(streamKey); err != nil {
+		log.Printf("⚠️  Failed to write stream settings for %s: %v", streamKey, err)
+	}
+	return hlsManager, settings
+}
+
+// buildHLSManager is newHLSManager without publishing the settings sidecar
+func (m *Manager) buildHLSManager(streamKey string) (*HLSManager, *models.StreamSettings) {
+	settings, err := m.repo.GetStreamSettings(streamKey)
+	if err != nil {
+		log.Printf("⚠️  Failed to load settings for %s, using defaults: %v", streamKey, err)
+		settings = &models.StreamSettings{StreamKey: streamKey, RecordingMode: models.RecordingModeOff}
+	}
+
+	hlsManager := NewHLSManager(m.outputDir, m.qualities)
+	hlsManager.ApplySettings(settings)
+	// Tell the HLS server which streams are only served with a playback token
+	hlsManager.signedPlayback = m.isPrivate(streamKey)
+	hlsManager.playbackID = m.PlaybackID(streamKey)
+	// A session that started encrypted stays encrypted across encoder restarts
+	hlsManager.contentKeys = m.segmentKey
+	keyInfo := filepath.Join(m.keyDirOf(streamKey), keyInfoName)
+	if _, err := os.Stat(keyInfo); err == nil {
+		hlsManager.keyInfoFile = keyInfo
+	}
+
+	if overlays, err := m.repo.ListOverlays(streamKey); err != nil {
+		log.Printf("⚠️  Failed to load overlays for %s: %v", streamKey, err)
+	} else {
+		hlsManager.SetOverlays(m.allowedOverlays(streamKey, overlays))
+	}
+
+	return hlsManager, settings
+}
+
+// RestartEncoder replaces the FFmpeg process of a live stream with one built from
+// its current settings and overlays. The variant playlists continue across the
+// restart with a discontinuity; the session, recordings, relays and thumbnails
+// keep running.
+func (m *Manager) RestartEncoder(streamKey, reason string) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
 	process, exists := m.processes[streamKey]
-	if !exists {
-		return fmt.Errorf("no stream monitoring found for stream key: %s", streamKey)
+	if !exists || !process.Status.Live() {
+		return fmt.Errorf("stream %s is not live", streamKey)
 	}
 
-	if process.Status != "monitoring" && process.Status != "running" {
-		return fmt.Errorf("stream monitoring for %s is not active", streamKey)
+	log.Printf("🔄 Restarting encoder for %s: %s", streamKey, reason)
+	hlsManager, _ := m.newHLSManager(streamKey)
+	if process.SlateSince != nil {
+		hlsManager.UseSlate()
 	}
+	return m.replaceEncoderLocked(process, hlsManager)
+}
 
-	log.Printf("🛑 Stopping stream monitoring for: %s", streamKey)
-	log.Printf("ℹ️  Note: NGINX-managed transcoding process will continue until stream ends")
+// replaceEncoderLocked stops the FFmpeg process of a stream and starts one built
+// by hlsManager that appends to the same playlists. The state is left to the
+// caller. Callers must hold the manager mutex.
+func (m *Manager) replaceEncoderLocked(process *TranscoderProcess, hlsManager *HLSManager) error {
+	streamKey := process.StreamKey
 
-	// Terminate FFmpeg process if it's running
+	// Stop the old encoder first so two processes never write the same playlists
 	if process.Cmd != nil && process.Cmd.Process != nil {
-		process.Cmd.Process.Signal(syscall.SIGTERM)
-		log.Printf("Sent SIGTERM to FFmpeg process PID %d", process.Cmd.Process.Pid)
+		stopEncoder(process.Cmd, process.exited)
+	}
+	if err := hlsManager.ResumePlaylists(streamKey); err != nil {
+		log.Printf("⚠️  Failed to reopen playlists for %s: %v", streamKey, err)
 	}
 
-	process.Status = "stopped"
-	delete(m.processes, streamKey)
+	inputURL := fmt.Sprintf("%s/%s", m.rtmpURL, streamKey)
+	cmd := exec.Command("ffmpeg", hlsManager.GenerateFFmpegCommand(streamKey, inputURL)...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+	exited, err := startEncoder(cmd)
+	if err != nil {
+		// Leave the exited encoder in place so the monitor handles the stream
+		return fmt.Errorf("failed to restart FFmpeg: %w", err)
+	}
 
-	// Release the lock
-	m.releaseStreamLock(streamKey)
+	process.Cmd = cmd
+	process.exited = exited
+	process.PID = cmd.Process.Pid
 
-	log.Printf("✅ Stream monitoring stopped for %s", streamKey)
+	log.Printf("✅ Encoder for %s restarted (PID: %d)", streamKey, cmd.Process.Pid)
 	return nil
 }
 
-// GetStatus returns the status of a transcoder
+// startEncoder starts an FFmpeg process and reaps it as soon as it exits. The
+// returned channel is closed once it has, so an exited encoder never lingers as
+// a zombie that still looks alive.
+func startEncoder(cmd *exec.Cmd) (<-chan struct{}, error) {
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	return exited, nil
+}
+
+// encoderExited reports whether the encoder behind an exit channel has exited
+func encoderExited(exited <-chan struct{}) bool {
+	select {
+	case <-exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// stopEncoder terminates an FFmpeg process started by startEncoder, killing it
+// if it does not exit in time
+func stopEncoder(cmd *exec.Cmd, exited <-chan struct{}) {
+	cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-exited
+	}
+}
+
+// StopTranscoder stops the encoder of a stream and ends its session
+func (m *Manager) StopTranscoder(streamKey string) error {
+	return m.stopTranscoder(streamKey, "stop requested")
+}
+
+// stopTranscoder stops a stream for the given reason
+func (m *Manager) stopTranscoder(streamKey, reason string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	process, exists := m.processes[streamKey]
+	if !exists {
+		return fmt.Errorf("no transcoder found for stream key: %s", streamKey)
+	}
+	if process.Status.Final() {
+		return fmt.Errorf("transcoder for %s is already %s", streamKey, process.Status)
+	}
+
+	log.Printf("🛑 Stopping transcoder for: %s", streamKey)
+
+	if process.slateTimer != nil {
+		process.slateTimer.Stop()
+		process.slateTimer = nil
+	}
+	process.SlateSince = nil
+	if process.reconnectTimer != nil {
+		process.reconnectTimer.Stop()
+		process.reconnectTimer = nil
+	}
+	process.ReconnectUntil = nil
+
+	// Stopped streams end their playlists and are kept for the retention period
+	// like streams whose publisher did not come back
+	m.endStreamLocked(process, reason)
+
+	log.Printf("✅ Transcoder stopped for %s", streamKey)
+	return nil
+}
+
+// GetStatus returns a snapshot of the status of a transcoder
 func (m *Manager) GetStatus(streamKey string) (*TranscoderProcess, bool) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
 	process, exists := m.processes[streamKey]
-	return process, exists
+	if !exists {
+		return nil, false
+	}
+	// A copy, since the process keeps changing after the lock is released
+	snapshot := *process
+	snapshot.Transitions = append([]StateTransition(nil), process.Transitions...)
+	return &snapshot, true
 }
 
 // GetActiveTranscoders returns all active monitoring processes
@@ -417,7 +715,7 @@ func (m *Manager) GetActiveTranscoders() map[string]*TranscoderProcess {
 
 	active := make(map[string]*TranscoderProcess)
 	for key, process := range m.processes {
-		if process.Status == "monitoring" || process.Status == "running" {
+		if process.Status.Live() {
 			active[key] = process
 		}
 	}
@@ -426,7 +724,7 @@ func (m *Manager) GetActiveTranscoders() map[string]*TranscoderProcess {
 
 // CleanupStream removes all HLS files for a specific stream
 func (m *Manager) CleanupStream(streamKey string) error {
-	streamDir := filepath.Join(m.outputDir, streamKey)
+	streamDir := m.streamDir(streamKey)
 	
 	// Stop transcoder if it's running
 	if _, exists := m.processes[streamKey]; exists {
@@ -482,33 +780,39 @@ func (m *Manager) CleanupAllStreams() error {
 	return nil
 }
 
-// StopAll stops all active monitoring
+// StopAll ends every stream when the service shuts down. Encoders are left to
+// exit with their input.
 func (m *Manager) StopAll() {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	log.Printf("🛑 Stopping all stream monitoring")
+	log.Printf("🛑 Stopping all transcoders")
 	for streamKey, process := range m.processes {
-		if process.Status == "monitoring" || process.Status == "running" {
-			log.Printf("Stopping monitoring for %s", streamKey)
-			process.Status = "stopped"
+		if !process.Status.Final() {
+			log.Printf("Stopping transcoder for %s", streamKey)
+			m.transitionLocked(process, StateStopped, "transcoder shutting down")
 		}
+		m.finishSession(process, false)
 	}
 	m.processes = make(map[string]*TranscoderProcess)
-	log.Printf("ℹ️  Note: NGINX-managed transcoding processes will continue until streams end")
 }
 
-// monitorProcess monitors Go-managed FFmpeg process and HLS health with proper synchronization
-func (m *Manager) monitorProcess(streamKey string, hlsManager *HLSManager) {
+// monitorProcess watches the encoder and HLS output of a stream until the
+// process ends or is replaced by a new start of the same stream key
+func (m *Manager) monitorProcess(process *TranscoderProcess, hlsManager *HLSManager) {
+	streamKey := process.StreamKey
 	log.Printf("📊 Starting Go process monitoring for %s", streamKey)
 	defer func() {
-		// Ensure cleanup on exit
+		// A stopped stream was already finished by whoever stopped it
 		m.mutex.Lock()
-		if process, exists := m.processes[streamKey]; exists && process.Status != "stopped" {
-			process.Status = "failed"
+		if current, exists := m.processes[streamKey]; exists && current == process {
+			if !process.Status.Final() {
+				m.transitionLocked(process, StateFailed, "monitoring stopped")
+			}
+			m.finishSession(process, true)
+			m.releaseStreamLock(streamKey)
 			log.Printf("🧹 Cleaning up monitoring for %s", streamKey)
 		}
-		m.releaseStreamLock(streamKey)
 		m.mutex.Unlock()
 		log.Printf("📊 Go process monitoring stopped for %s", streamKey)
 	}()
@@ -516,89 +820,210 @@ func (m *Manager) monitorProcess(streamKey string, hlsManager *HLSManager) {
 	for {
 		// Safely get process info with proper locking
 		m.mutex.RLock()
-		process, exists := m.processes[streamKey]
-		if !exists {
+		if current, exists := m.processes[streamKey]; !exists || current != process {
 			m.mutex.RUnlock()
 			break
 		}
-
-		// Copy critical fields while holding read lock
 		status := process.Status
-		var cmd *exec.Cmd
-		var pid int
-		if process.Cmd != nil {
-			cmd = process.Cmd
-			if process.Cmd.Process != nil {
-				pid = process.Cmd.Process.Pid
-			}
-		}
+		exited := process.exited
 		m.mutex.RUnlock()
 
-		if status == "stopped" {
+		if status.Final() {
 			break
 		}
-
-		// Check if FFmpeg process is still running (outside of lock)
-		processAlive := false
-		if cmd != nil && pid > 0 {
-			if proc, err := os.FindProcess(pid); err == nil && proc != nil {
-				// Send signal 0 to check if process exists
-				if err := proc.Signal(syscall.Signal(0)); err == nil {
-					processAlive = true
-				}
-			}
-		}
+		processAlive := exited != nil && !encoderExited(exited)
 
 		// Update process status based on health checks
 		m.mutex.Lock()
-		if process, exists := m.processes[streamKey]; exists {
-			if !processAlive && process.Status == "running" {
-				process.Status = "failed"
-				log.Printf("❌ FFmpeg process for %s has died (PID: %d)", streamKey, pid)
+		// A different exit channel means the encoder was replaced while this check ran
+		if !processAlive && status.Live() && process.Status.Live() && process.exited == exited {
+			// The input ends when the publisher drops, which is usually noticed here
+			// before on_publish_done.sh reports it
+			if process.Status != StateSlate && (m.enterSlateLocked(process, "encoder input ended") == nil ||
+				m.awaitReconnectLocked(process, "encoder input ended") == nil) {
 				m.mutex.Unlock()
-				break
+				continue
 			}
+			log.Printf("❌ FFmpeg process for %s has died (PID: %d)", streamKey, process.PID)
+			m.transitionLocked(process, StateFailed, "encoder exited")
+			m.mutex.Unlock()
+			break
+		}
 
-			// Check HLS health using Go HLS manager
+		// Check HLS health using Go HLS manager. A new encoder gets as long as a
+		// stalled one to write its first segments.
+		if processAlive {
 			if stats, err := hlsManager.MonitorHLSHealth(streamKey); err == nil {
-				if stats.Active && processAlive {
-					process.Status = "running"
-				} else if !stats.Active && processAlive {
-					process.Status = "stale"
+				if stats.Active && process.Status == StateStale {
+					m.transitionLocked(process, StateRunning, "playlists advancing again")
+				} else if !stats.Active && process.Status == StateRunning && time.Since(process.StateSince) >= hlsStaleAfter {
 					log.Printf("⚠️  HLS output for %s appears stale", streamKey)
+					m.transitionLocked(process, StateStale, "playlists stopped advancing")
 				}
 			}
 		}
 		m.mutex.Unlock()
 
-		time.Sleep(10 * time.Second)
+		// Wake up as soon as the encoder exits rather than at the next check
+		if processAlive {
+			select {
+			case <-exited:
+			case <-time.After(10 * time.Second):
+			}
+		} else {
+			time.Sleep(10 * time.Second)
+		}
 	}
 }
 
-// getProfile returns the H.264 profile for a quality level
-func (m *Manager) getProfile(index int) string {
-	if index <= 2 { // 1080p, 720p, 480p
-		return "main"
+// startSession opens a stream session if the stream key belongs to a registered stream
+func (m *Manager) startSession(streamKey string) *uuid.UUID {
+	top := m.qualities[0]
+	session, err := m.repo.StartSession(streamKey, top.Name, parseKbps(top.VideoBitrate))
+	if err != nil {
+		log.Printf("⚠️  Failed to open session for %s: %v", streamKey, err)
+		return nil
 	}
-	return "baseline" // 360p, 240p, 144p
+	if session == nil {
+		return nil
+	}
+	return &session.ID
 }
 
-// getLevel returns the H.264 level for a quality level
-func (m *Manager) getLevel(index int) string {
-	switch index {
-	case 0: // 1080p
-		return "4.0"
-	case 1: // 720p
-		return "3.1"
+// startRecording starts archiving the renditions selected by the stream's recording mode
+func (m *Manager) startRecording(streamKey string, settings *models.StreamSettings, sessionID *uuid.UUID, hlsManager *HLSManager) (*Recorder, error) {
+	var renditions []Quality
+	switch settings.RecordingMode {
+	case models.RecordingModeTop:
+		renditions = m.qualities[:1]
+	case models.RecordingModeAll:
+		renditions = m.qualities
 	default:
-		return "3.0"
+		return nil, nil
+	}
+
+	recordings := make([]*models.Recording, 0, len(renditions))
+	for _, quality := range renditions {
+		recording, err := m.repo.CreateRecording(&models.Recording{
+			StreamKey:       streamKey,
+			StreamSessionID: sessionID,
+			Rendition:       quality.Name,
+			Status:          models.RecordingStatusRecording,
+			StartedAt:       time.Now(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create recording: %w", err)
+		}
+		recordings = append(recordings, recording)
+	}
+
+	recorder, err := NewRecorder(streamKey, hlsManager, m.archiveDir(streamKey), recordings)
+	if err != nil {
+		return nil, err
+	}
+	for _, recording := range recordings {
+		if _, err := m.repo.UpdateRecording(recording); err != nil {
+			log.Printf("⚠️  Failed to update recording %s: %v", recording.ID, err)
+		}
+	}
+
+	recorder.Start()
+	log.Printf("📼 Recording %s for %s (%d renditions)", settings.RecordingMode, streamKey, len(recordings))
+	return recorder, nil
+}
+
+// finishSession closes the session of an ended transcoder, stops its thumbnails,
+// key rotation and relays, and finalises its recordings.
+// Callers must hold the manager mutex; the process is left without session state so
+// it is only finished once.
+func (m *Manager) finishSession(process *TranscoderProcess, async bool) {
+	if process.Thumbnailer != nil {
+		process.Thumbnailer.Stop()
+		process.Thumbnailer = nil
+	}
+	if process.KeyRotator != nil {
+		process.KeyRotator.Stop()
+		process.KeyRotator = nil
+	}
+	m.stopRelays(process.StreamKey)
+
+	if process.Recorder != nil {
+		if async {
+			go m.finalizeRecording(process.Recorder, process.exited)
+		} else {
+			// Shutting down: archive what is on disk without waiting for FFmpeg
+			m.finalizeRecording(process.Recorder, nil)
+		}
+		process.Recorder = nil
+	}
+
+	if process.SessionID != nil {
+		if err := m.repo.EndSession(*process.SessionID); err != nil {
+			log.Printf("⚠️  Failed to close session %s: %v", process.SessionID, err)
+		}
+		process.SessionID = nil
 	}
 }
 
-// getAudioBitrate returns the audio bitrate for a quality level
-func (m *Manager) getAudioBitrate(index int) string {
-	if index <= 2 { // 1080p, 720p, 480p
-		return "128k"
+// finalizeRecording waits for FFmpeg to flush its last segment, then turns the
+// archived segments into VOD playlists and MP4 files
+func (m *Manager) finalizeRecording(recorder *Recorder, exited <-chan struct{}) {
+	if exited != nil {
+		select {
+		case <-exited:
+		case <-time.After(10 * time.Second):
+			log.Printf("⚠️  FFmpeg for %s did not exit in time, finalising recording anyway", recorder.streamKey)
+		}
 	}
-	return "96k" // Lower quality levels
+
+	recorder.Stop()
+	for _, recording := range recorder.Recordings() {
+		recording.Status = models.RecordingStatusProcessing
+		if _, err := m.repo.UpdateRecording(recording); err != nil {
+			log.Printf("⚠️  Failed to update recording %s: %v", recording.ID, err)
+		}
+	}
+
+	recorder.Finalize()
+	for _, recording := range recorder.Recordings() {
+		if _, err := m.repo.UpdateRecording(recording); err != nil {
+			log.Printf("⚠️  Failed to update recording %s: %v", recording.ID, err)
+		}
+	}
+}
+
+// DeleteRecording removes a finished recording from disk and the database
+func (m *Manager) DeleteRecording(id uuid.UUID) error {
+	recording, err := m.repo.GetRecording(id)
+	if err != nil {
+		return err
+	}
+	if recording.Status == models.RecordingStatusRecording || recording.Status == models.RecordingStatusProcessing {
+		return fmt.Errorf("recording %s is still %s", id, recording.Status)
+	}
+
+	if err := os.RemoveAll(m.recordingDir(recording)); err != nil {
+		return fmt.Errorf("failed to remove recording files: %w", err)
+	}
+	return m.repo.DeleteRecording(id)
+}
+
+// archiveDir returns the directory the recordings and clips of a stream are
+// archived in. Like its live output it is named after the stream's playback ID,
+// so archive URLs do not reveal the stream key and survive key rotation.
+func (m *Manager) archiveDir(streamKey string) string {
+	return filepath.Join(m.recordingsDir, m.PlaybackID(streamKey))
+}
+
+// recordingDir returns the directory a recording's files are stored in
+func (m *Manager) recordingDir(recording *models.Recording) string {
+	if recording.PlaylistPath != "" {
+		return filepath.Dir(recording.PlaylistPath)
+	}
+	return filepath.Join(m.archiveDir(recording.StreamKey), recording.ID.String())
+}
+
+// RecordingsDir returns the directory recordings are archived to
+func (m *Manager) RecordingsDir() string {
+	return m.recordingsDir
 }
@@ -0,0 +1,225 @@
+package transcoder
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/streamforge/platform/pkg/models"
+)
+
+const (
+	// defaultFontFile is used for text overlays when present, otherwise FFmpeg asks fontconfig
+	defaultFontFile = "/usr/share/fonts/truetype/dejavu/DejaVuSans-Bold.ttf"
+	// defaultClockFormat is the strftime format of clock overlays without text
+	defaultClockFormat = "%H:%M:%S"
+	// overlayReferenceHeight is the video height margins and font sizes are given for
+	overlayReferenceHeight = 1080
+)
+
+// ErrOverlayImageNotAllowed is returned for overlay images outside the configured sources
+var ErrOverlayImageNotAllowed = errors.New("image_url must be a file in the overlay directory or an https URL on an allowed host")
+
+// SetOverlaySources sets where FFmpeg may read overlay images from: files in
+// dir and https URLs on hosts. Nothing is allowed when both are empty.
+func (m *Manager) SetOverlaySources(dir string, hosts []string) {
+	if dir != "" {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = resolved
+		}
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+	}
+	m.overlayDir = dir
+	m.overlayHosts = map[string]bool{}
+	for _, host := range hosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			m.overlayHosts[host] = true
+		}
+	}
+}
+
+// CheckOverlayImage checks that an overlay image is a file in the overlay
+// directory, after following symlinks, or an https URL on an allowed host
+func (m *Manager) CheckOverlayImage(location string) error {
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return ErrOverlayImageNotAllowed
+	}
+	if parsed.Scheme == "https" {
+		if parsed.User == nil && m.overlayHosts[strings.ToLower(parsed.Hostname())] {
+			return nil
+		}
+		return ErrOverlayImageNotAllowed
+	}
+	if parsed.Scheme != "" || m.overlayDir == "" || !filepath.IsAbs(location) {
+		return ErrOverlayImageNotAllowed
+	}
+
+	// Missing files are refused alike, so the check reveals nothing outside the directory
+	resolved, err := filepath.EvalSymlinks(location)
+	if err != nil {
+		return ErrOverlayImageNotAllowed
+	}
+	rel, err := filepath.Rel(m.overlayDir, resolved)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ErrOverlayImageNotAllowed
+	}
+	return nil
+}
+
+// allowedOverlays drops image overlays whose image is no longer allowed, such
+// as after the overlay sources were changed
+func (m *Manager) allowedOverlays(streamKey string, overlays []models.StreamOverlay) []models.StreamOverlay {
+	var allowed []models.StreamOverlay
+	for _, overlay := range overlays {
+		if overlay.Type == models.OverlayTypeImage {
+			if err := m.CheckOverlayImage(overlay.ImageURL); err != nil {
+				log.Printf("⚠️  Skipping overlay %s of %s: %v", overlay.ID, streamKey, err)
+				continue
+			}
+		}
+		allowed = append(allowed, overlay)
+	}
+	return allowed
+}
+
+// SetOverlays sets the overlays drawn into the renditions they select
+func (h *HLSManager) SetOverlays(overlays []models.StreamOverlay) {
+	h.overlays = nil
+	for _, overlay := range overlays {
+		if overlay.Enabled {
+			h.overlays = append(h.overlays, overlay)
+		}
+	}
+}
+
+// videoFilter returns the filter graph of a rendition: the scaler followed by
+// every overlay that applies to it
+func (h *HLSManager) videoFilter(quality Quality) string {
+	width, height := splitResolution(quality.Resolution)
+	scale := fmt.Sprintf("scale=w=%s:h=%s:force_original_aspect_ratio=decrease:force_divisible_by=2", width, height)
+
+	var overlays []models.StreamOverlay
+	for _, overlay := range h.overlays {
+		if overlayAppliesTo(overlay, quality.Name) {
+			overlays = append(overlays, overlay)
+		}
+	}
+	if len(overlays) == 0 {
+		return scale
+	}
+
+	videoWidth, _ := strconv.Atoi(width)
+	videoHeight, _ := strconv.Atoi(height)
+	ratio := float64(videoHeight) / overlayReferenceHeight
+
+	// Image overlays are read by movie sources and composited onto the main chain
+	var sources []string
+	chain := "[in]" + scale
+	for i, overlay := range overlays {
+		margin := int(float64(overlay.Margin) * ratio)
+		switch overlay.Type {
+		case models.OverlayTypeImage:
+			label := fmt.Sprintf("ov%d", i)
+			imageWidth := int(overlay.Scale*float64(videoWidth)) / 2 * 2
+			if imageWidth < 2 {
+				imageWidth = 2
+			}
+			sources = append(sources, fmt.Sprintf("movie=%s,format=rgba,colorchannelmixer=aa=%.2f,scale=%d:-2[%s]",
+				escapeFilterValue(overlay.ImageURL), overlay.Opacity, imageWidth, label))
+			x, y := overlayCoordinates(overlay.Position, margin, "W", "H", "w", "h")
+			chain += fmt.Sprintf("[base%d];[base%d][%s]overlay=x=%s:y=%s", i, i, label, x, y)
+		case models.OverlayTypeText, models.OverlayTypeClock:
+			chain += "," + drawTextFilter(overlay, margin, ratio)
+		}
+	}
+	chain += "[out]"
+
+	if len(sources) == 0 {
+		return chain
+	}
+	return strings.Join(sources, ";") + ";" + chain
+}
+
+// drawTextFilter returns the drawtext filter of a text or clock overlay
+func drawTextFilter(overlay models.StreamOverlay, margin int, ratio float64) string {
+	var text string
+	if overlay.Type == models.OverlayTypeClock {
+		format := overlay.Text
+		if format == "" {
+			format = defaultClockFormat
+		}
+		// The format is a localtime argument, so its colons need escaping for drawtext
+		format = strings.NewReplacer(`\`, `\\`, `:`, `\:`).Replace(format)
+		text = "%{localtime:" + format + "}"
+	} else {
+		text = strings.NewReplacer(`\`, `\\`, `%`, `\%`).Replace(overlay.Text)
+	}
+
+	fontSize := int(float64(overlay.FontSize) * ratio)
+	if fontSize < 8 {
+		fontSize = 8
+	}
+	x, y := overlayCoordinates(overlay.Position, margin, "w", "h", "tw", "th")
+
+	options := []string{
+		"text=" + escapeFilterValue(text),
+		fmt.Sprintf("fontsize=%d", fontSize),
+		fmt.Sprintf("fontcolor=%s@%.2f", escapeFilterValue(overlay.FontColor), overlay.Opacity),
+		"x=" + x,
+		"y=" + y,
+	}
+	if _, err := os.Stat(defaultFontFile); err == nil {
+		options = append(options, "fontfile="+escapeFilterValue(defaultFontFile))
+	}
+	return "drawtext=" + strings.Join(options, ":")
+}
+
+// overlayCoordinates returns the x and y expressions anchoring an overlay of size
+// (w, h) inside a frame of size (frameW, frameH)
+func overlayCoordinates(position models.OverlayPosition, margin int, frameW, frameH, w, h string) (string, string) {
+	left := strconv.Itoa(margin)
+	top := strconv.Itoa(margin)
+	right := fmt.Sprintf("%s-%s-%d", frameW, w, margin)
+	bottom := fmt.Sprintf("%s-%s-%d", frameH, h, margin)
+
+	switch position {
+	case models.OverlayPositionTopLeft:
+		return left, top
+	case models.OverlayPositionBottomLeft:
+		return left, bottom
+	case models.OverlayPositionBottomRight:
+		return right, bottom
+	case models.OverlayPositionCenter:
+		return fmt.Sprintf("(%s-%s)/2", frameW, w), fmt.Sprintf("(%s-%s)/2", frameH, h)
+	default: // top-right
+		return right, top
+	}
+}
+
+// overlayAppliesTo reports whether an overlay is drawn into a rendition
+func overlayAppliesTo(overlay models.StreamOverlay, rendition string) bool {
+	if strings.TrimSpace(overlay.Renditions) == "" {
+		return true
+	}
+	for _, name := range strings.Split(overlay.Renditions, ",") {
+		if strings.TrimSpace(name) == rendition {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeFilterValue escapes a filter option value for both the option parser
+// and the filter graph parser
+func escapeFilterValue(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(value)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(value)
+}
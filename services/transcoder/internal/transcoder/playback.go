@@ -0,0 +1,140 @@
+package transcoder
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/streamforge/platform/pkg/models"
+	"github.com/streamforge/platform/pkg/playback"
+)
+
+const (
+	// defaultPlaybackTokenTTL is how long a playback token lasts when the caller does not say
+	defaultPlaybackTokenTTL = time.Hour
+	// maxPlaybackTokenTTL bounds how long a playback token may last
+	maxPlaybackTokenTTL = 24 * time.Hour
+	// playbackPolicyTTL is how long the privacy of a stream is cached for file requests
+	playbackPolicyTTL = 30 * time.Second
+)
+
+var (
+	// ErrPlaybackTokensDisabled is returned when tokens are requested without a signing secret
+	ErrPlaybackTokensDisabled = errors.New("playback tokens are not configured")
+	// ErrNotStreamOwner is returned when a user asks for a token to someone else's
+	// private stream or signed playback ID
+	ErrNotStreamOwner = errors.New("only the owner of a private stream or signed playback ID can issue playback tokens")
+)
+
+// PlaybackToken is a signed token that unlocks the HLS output of a stream
+type PlaybackToken struct {
+	Token      string    `json:"token"`
+	PlaybackID string    `json:"playback_id"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	IP         string    `json:"ip,omitempty"`
+}
+
+// playbackPolicy is the cached privacy of a stream
+type playbackPolicy struct {
+	private   bool
+	checkedAt time.Time
+}
+
+// SetPlaybackTokens configures the signer for playback tokens. With requireAll
+// every stream needs a token, otherwise only private streams do.
+func (m *Manager) SetPlaybackTokens(signer *playback.Signer, requireAll bool) {
+	m.tokenMutex.Lock()
+	defer m.tokenMutex.Unlock()
+	m.playbackTokens = signer
+	m.requirePlaybackTokens = requireAll
+}
+
+// playbackSigner returns the playback token signer, and whether every stream needs a token
+func (m *Manager) playbackSigner() (*playback.Signer, bool) {
+	m.tokenMutex.RLock()
+	defer m.tokenMutex.RUnlock()
+	return m.playbackTokens, m.requirePlaybackTokens
+}
+
+// IssuePlaybackToken signs a playback token for a playback ID on behalf of a
+// user. Tokens for private streams and signed playback IDs are only issued to
+// the stream's owner; other playback IDs can be unlocked by any signed-in user.
+// Unregistered streams are played by their stream key, so it stands in for the
+// playback ID, but the key of a registered stream is never accepted.
+func (m *Manager) IssuePlaybackToken(userID uuid.UUID, playbackID string, ttl time.Duration, ip string) (*PlaybackToken, error) {
+	signer, _ := m.playbackSigner()
+	if signer == nil {
+		return nil, ErrPlaybackTokensDisabled
+	}
+
+	switch {
+	case ttl == 0:
+		ttl = defaultPlaybackTokenTTL
+	case ttl < 0 || ttl > maxPlaybackTokenTTL:
+		return nil, fmt.Errorf("invalid ttl %v (expected up to %v)", ttl, maxPlaybackTokenTTL)
+	}
+
+	id, err := m.repo.GetPlaybackID(playbackID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up playback ID: %w", err)
+	}
+	if id != nil && id.Stream != nil {
+		restricted := id.Stream.Status == models.StreamStatusPrivate || id.Policy == models.PlaybackPolicySigned
+		if restricted && id.Stream.UserID != userID {
+			return nil, ErrNotStreamOwner
+		}
+	} else if stream, err := m.repo.GetStream(playbackID); err != nil {
+		return nil, fmt.Errorf("failed to look up stream: %w", err)
+	} else if stream != nil {
+		return nil, ErrPlaybackIDNotFound
+	}
+
+	token, expiresAt, err := signer.Issue(playbackID, ttl, ip)
+	if err != nil {
+		return nil, err
+	}
+	return &PlaybackToken{Token: token, PlaybackID: playbackID, ExpiresAt: expiresAt, IP: ip}, nil
+}
+
+// AuthorizePlayback checks the token of a request for the files of a playback
+// ID, or of an unregistered stream, and returns the directory they are in
+func (m *Manager) AuthorizePlayback(name, token, clientIP string) (string, error) {
+	signer, required := m.playbackSigner()
+
+	target, indexed := m.playbackIDs.Lookup(name)
+	if !indexed {
+		var ok bool
+		if target, ok = m.playbackIDs.Resolve(name); !ok {
+			return "", ErrPlaybackIDNotFound
+		}
+		target.Signed = m.isPrivate(name)
+	}
+
+	if err := playback.Authorize(signer, token, name, clientIP, required || target.Signed); err != nil {
+		return "", err
+	}
+	return target.Directory, nil
+}
+
+// isPrivate reports whether a stream is private. The answer is cached briefly so
+// segment requests do not each hit the database, and privacy changes still take
+// effect without a restart.
+func (m *Manager) isPrivate(streamKey string) bool {
+	if cached, ok := m.playbackPolicies.Load(streamKey); ok {
+		if policy := cached.(playbackPolicy); time.Since(policy.checkedAt) < playbackPolicyTTL {
+			return policy.private
+		}
+	}
+
+	stream, err := m.repo.GetStream(streamKey)
+	if err != nil {
+		// Fail closed: a stream that may be private is not served without a token
+		log.Printf("⚠️  Failed to look up privacy of %s: %v", streamKey, err)
+		return true
+	}
+	private := stream != nil && stream.Status == models.StreamStatusPrivate
+	m.playbackPolicies.Store(streamKey, playbackPolicy{private: private, checkedAt: time.Now()})
+	return private
+}
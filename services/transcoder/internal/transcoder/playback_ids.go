@@ -0,0 +1,368 @@
+package transcoder
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"github.com/streamforge/platform/pkg/models"
+	"github.com/streamforge/platform/pkg/playback"
+)
+
+// streamKeyBytes is the number of random bytes in a rotated stream key
+const streamKeyBytes = 24
+
+var (
+	// ErrStreamNotRegistered is returned for playback ID changes to unregistered stream keys
+	ErrStreamNotRegistered = errors.New("stream is not registered")
+	// ErrPlaybackIDNotFound is returned for playback IDs that do not exist
+	ErrPlaybackIDNotFound = errors.New("playback ID not found")
+	// ErrLastPlaybackID is returned when deleting the only playback ID of a stream
+	ErrLastPlaybackID = errors.New("a stream needs at least one playback ID")
+	// ErrStreamLive is returned for changes that would move the output of a live stream
+	ErrStreamLive = errors.New("stream is live")
+	// ErrStreamNotOwned is returned when a user changes someone else's stream
+	ErrStreamNotOwned = errors.New("stream belongs to another user")
+)
+
+// PlaybackID returns the name the output of a stream is published under: the
+// oldest playback ID of a registered stream, or the stream key itself for
+// unregistered ones. Registered streams without a playback ID are given one.
+func (m *Manager) PlaybackID(streamKey string) string {
+	if name, ok := m.outputNames.Load(streamKey); ok {
+		return name.(string)
+	}
+
+	name := streamKey
+	stream, err := m.repo.GetStream(streamKey)
+	if err != nil {
+		log.Printf("⚠️  Failed to look up playback ID of %s: %v", streamKey, err)
+		return streamKey
+	}
+	if stream != nil {
+		ids, err := m.ensurePlaybackIDs(stream)
+		if err != nil {
+			log.Printf("⚠️  Failed to look up playback ID of %s: %v", streamKey, err)
+			return streamKey
+		}
+		name = ids[0].PlaybackID
+	}
+	m.outputNames.Store(streamKey, name)
+	return name
+}
+
+// streamDir returns the directory the output of a stream is published in
+func (m *Manager) streamDir(streamKey string) string {
+	return filepath.Join(m.outputDir, m.PlaybackID(streamKey))
+}
+
+// ensurePlaybackIDs returns the playback IDs of a registered stream, oldest
+// first, creating a public one for streams that have none
+func (m *Manager) ensurePlaybackIDs(stream *models.Stream) ([]models.PlaybackID, error) {
+	ids, err := m.repo.ListPlaybackIDs(stream.ID)
+	if err != nil || len(ids) > 0 {
+		return ids, err
+	}
+
+	id, err := m.newPlaybackID(stream, models.PlaybackPolicyPublic)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("🔗 Created playback ID %s for stream %s", id.PlaybackID, stream.ID)
+	return []models.PlaybackID{*id}, nil
+}
+
+// newPlaybackID creates a playback ID for a stream
+func (m *Manager) newPlaybackID(stream *models.Stream, policy models.PlaybackPolicy) (*models.PlaybackID, error) {
+	playbackID, err := playback.NewID()
+	if err != nil {
+		return nil, err
+	}
+	return m.repo.CreatePlaybackID(&models.PlaybackID{
+		StreamID:   stream.ID,
+		PlaybackID: playbackID,
+		Policy:     policy,
+	})
+}
+
+// ListPlaybackIDs returns the playback IDs of a registered stream, oldest first
+func (m *Manager) ListPlaybackIDs(streamKey string) ([]models.PlaybackID, error) {
+	stream, err := m.registeredStream(streamKey)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := m.ensurePlaybackIDs(stream)
+	if err != nil {
+		return nil, err
+	}
+	m.writePlaybackIndex()
+	return ids, nil
+}
+
+// CreatePlaybackID adds a playback ID to a registered stream of a user
+func (m *Manager) CreatePlaybackID(userID uuid.UUID, streamKey string, policy models.PlaybackPolicy) (*models.PlaybackID, error) {
+	stream, err := m.ownedStream(userID, streamKey)
+	if err != nil {
+		return nil, err
+	}
+	// A stream's first playback ID names its output directory
+	if _, err := m.ensurePlaybackIDs(stream); err != nil {
+		return nil, err
+	}
+
+	id, err := m.newPlaybackID(stream, policy)
+	if err != nil {
+		return nil, err
+	}
+	m.writePlaybackIndex()
+	return id, nil
+}
+
+// GetPlaybackID returns a playback ID with the stream it belongs to
+func (m *Manager) GetPlaybackID(playbackID string) (*models.PlaybackID, error) {
+	id, err := m.repo.GetPlaybackID(playbackID)
+	if err != nil {
+		return nil, err
+	}
+	if id == nil || id.Stream == nil {
+		return nil, ErrPlaybackIDNotFound
+	}
+	return id, nil
+}
+
+// UpdatePlaybackID changes the policy of a playback ID of a user's stream. It
+// applies to requests as soon as the HLS servers reread the index.
+func (m *Manager) UpdatePlaybackID(userID uuid.UUID, playbackID string, policy models.PlaybackPolicy) (*models.PlaybackID, error) {
+	id, err := m.ownedPlaybackID(userID, playbackID)
+	if err != nil {
+		return nil, err
+	}
+
+	id.Policy = policy
+	if _, err := m.repo.UpdatePlaybackID(id); err != nil {
+		return nil, err
+	}
+	m.writePlaybackIndex()
+	return id, nil
+}
+
+// DeletePlaybackID removes a playback ID of a user's stream, so URLs using it
+// stop working. The last playback ID of a stream cannot be removed, nor can the
+// one a live stream's output is published under.
+func (m *Manager) DeletePlaybackID(userID uuid.UUID, playbackID string) error {
+	id, err := m.ownedPlaybackID(userID, playbackID)
+	if err != nil {
+		return err
+	}
+
+	ids, err := m.repo.ListPlaybackIDs(id.StreamID)
+	if err != nil {
+		return err
+	}
+	if len(ids) <= 1 {
+		return ErrLastPlaybackID
+	}
+	streamKey := id.Stream.StreamKey
+	published := m.PlaybackID(streamKey) == playbackID
+	if published && m.isActive(streamKey) {
+		return fmt.Errorf("%w: its output is published under %s", ErrStreamLive, playbackID)
+	}
+
+	if err := m.repo.DeletePlaybackID(id.ID); err != nil {
+		return err
+	}
+	m.outputNames.Delete(streamKey)
+
+	// Files left by the last session, and the archives, move to the next
+	// playback ID, so they are no longer served under the deleted one
+	if published {
+		from, to := filepath.Join(m.outputDir, playbackID), m.streamDir(streamKey)
+		if _, err := os.Stat(to); os.IsNotExist(err) {
+			if err := os.Rename(from, to); err != nil && !os.IsNotExist(err) {
+				log.Printf("⚠️  Failed to move output of %s to %s: %v", playbackID, to, err)
+			}
+		}
+		m.moveArchives(filepath.Join(m.recordingsDir, playbackID), m.archiveDir(streamKey))
+	}
+	m.writePlaybackIndex()
+	return nil
+}
+
+// RotateStreamKey replaces the key of a user's registered stream with a new
+// random one and returns it. The old key stops working for publishing, while
+// the playback IDs, and so every playback URL, stay the same. Live streams must
+// be stopped first, since their encoder reads the old key.
+func (m *Manager) RotateStreamKey(userID uuid.UUID, streamKey string) (string, error) {
+	if _, err := m.ownedStream(userID, streamKey); err != nil {
+		return "", err
+	}
+	if m.isActive(streamKey) {
+		return "", ErrStreamLive
+	}
+
+	buf := make([]byte, streamKeyBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate stream key: %w", err)
+	}
+	newKey := hex.EncodeToString(buf)
+
+	if err := m.repo.RotateStreamKey(streamKey, newKey); err != nil {
+		return "", fmt.Errorf("failed to rotate stream key: %w", err)
+	}
+	m.outputNames.Delete(streamKey)
+	m.playbackPolicies.Delete(streamKey)
+	// Archives made before they were kept by playback ID are named after the old key
+	m.moveArchives(filepath.Join(m.recordingsDir, streamKey), m.archiveDir(newKey))
+	log.Printf("🔑 Rotated stream key of %s", m.PlaybackID(newKey))
+	return newKey, nil
+}
+
+// moveArchives moves the recordings and clips archived in one directory to
+// another, merging them with those already there, and updates their paths
+func (m *Manager) moveArchives(from, to string) {
+	if from == to {
+		return
+	}
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("⚠️  Failed to read archives in %s: %v", from, err)
+		}
+		return
+	}
+
+	// Every recording and clip has a directory of its own, named after its ID
+	var names []string
+	for _, entry := range entries {
+		if entry.Name() != clipsDirName {
+			names = append(names, entry.Name())
+			continue
+		}
+		clips, err := os.ReadDir(filepath.Join(from, clipsDirName))
+		if err != nil {
+			log.Printf("⚠️  Failed to read clips in %s: %v", from, err)
+			return
+		}
+		for _, clip := range clips {
+			names = append(names, filepath.Join(clipsDirName, clip.Name()))
+		}
+	}
+	for _, name := range names {
+		source, target := filepath.Join(from, name), filepath.Join(to, name)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			log.Printf("⚠️  Failed to move archives to %s: %v", to, err)
+			return
+		}
+		if err := os.Rename(source, target); err != nil {
+			log.Printf("⚠️  Failed to move archive %s to %s: %v", name, to, err)
+			continue
+		}
+		if err := m.repo.MoveArchivePaths(source, target); err != nil {
+			log.Printf("⚠️  Failed to update the paths of archive %s: %v", name, err)
+		}
+	}
+	// Only removed once empty
+	os.Remove(filepath.Join(from, clipsDirName))
+	os.Remove(from)
+}
+
+// ownedPlaybackID returns a playback ID of a user's stream
+func (m *Manager) ownedPlaybackID(userID uuid.UUID, playbackID string) (*models.PlaybackID, error) {
+	id, err := m.GetPlaybackID(playbackID)
+	if err != nil {
+		return nil, err
+	}
+	if id.Stream.UserID != userID {
+		return nil, ErrStreamNotOwned
+	}
+	return id, nil
+}
+
+// CheckStreamOwner checks that a stream key belongs to a registered stream of a user
+func (m *Manager) CheckStreamOwner(userID uuid.UUID, streamKey string) error {
+	_, err := m.ownedStream(userID, streamKey)
+	return err
+}
+
+// ownedStream returns the registered stream of a key if it belongs to a user
+func (m *Manager) ownedStream(userID uuid.UUID, streamKey string) (*models.Stream, error) {
+	stream, err := m.registeredStream(streamKey)
+	if err != nil {
+		return nil, err
+	}
+	if stream.UserID != userID {
+		return nil, ErrStreamNotOwned
+	}
+	return stream, nil
+}
+
+// registeredStream returns the registered stream of a key
+func (m *Manager) registeredStream(streamKey string) (*models.Stream, error) {
+	stream, err := m.repo.GetStream(streamKey)
+	if err != nil {
+		return nil, err
+	}
+	if stream == nil {
+		return nil, ErrStreamNotRegistered
+	}
+	return stream, nil
+}
+
+// isActive reports whether a stream has a transcoder process that is not over
+func (m *Manager) isActive(streamKey string) bool {
+	process, exists := m.GetStatus(streamKey)
+	return exists && !process.Status.Final()
+}
+
+// writePlaybackIndex publishes every playback ID to the HLS servers, mapped to
+// the directory of its stream. Playback IDs of private streams and signed ones
+// are only served with a playback token.
+func (m *Manager) writePlaybackIndex() {
+	m.indexMutex.Lock()
+	defer m.indexMutex.Unlock()
+
+	ids, err := m.repo.ListAllPlaybackIDs()
+	if err != nil {
+		log.Printf("⚠️  Failed to load playback IDs: %v", err)
+		return
+	}
+
+	index := playback.Index{}
+	directories := map[string]string{} // by stream key
+	for _, id := range ids {
+		if id.Stream == nil {
+			continue
+		}
+		streamKey := id.Stream.StreamKey
+		directory, seen := directories[streamKey]
+		if !seen {
+			// The oldest playback ID, unless a live stream is still published under another
+			directory = id.PlaybackID
+			if name, ok := m.outputNames.Load(streamKey); ok {
+				directory = name.(string)
+			}
+			directories[streamKey] = directory
+		}
+		index[id.PlaybackID] = playback.Target{
+			Directory: directory,
+			Signed:    id.Policy == models.PlaybackPolicySigned || id.Stream.Status == models.StreamStatusPrivate,
+		}
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		log.Printf("⚠️  Failed to encode playback IDs: %v", err)
+		return
+	}
+	if err := writeFileAtomic(filepath.Join(m.outputDir, playback.IndexFile), append(data, '\n')); err != nil {
+		log.Printf("⚠️  Failed to write playback ID index: %v", err)
+		return
+	}
+	m.playbackIDs.Invalidate()
+}
@@ -0,0 +1,303 @@
+package transcoder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/streamforge/platform/pkg/models"
+)
+
+const (
+	// maxBeaconEvents is the most events a player may send in one beacon
+	maxBeaconEvents = 200
+	// maxBeaconAge is how old events may be when they arrive
+	maxBeaconAge = 24 * time.Hour
+	// maxQoERange is the longest time range a QoE report covers
+	maxQoERange = 31 * 24 * time.Hour
+)
+
+// sessionIDPattern matches the session IDs players identify their beacons with
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{8,64}$`)
+
+// Beacon is a batch of events from one playback session. Event times are taken
+// from the player's clock and corrected by how far SentAt is from the time the
+// beacon arrived.
+type Beacon struct {
+	SessionID  string
+	PlaybackID string // or the stream key of an unregistered stream, as in the playback URL
+	SentAt     time.Time
+	Events     []BeaconEvent
+}
+
+// BeaconEvent is an event of a beacon
+type BeaconEvent struct {
+	Type      models.PlaybackEventType
+	Time      time.Time
+	StartupMS int
+	Rendition string
+	Bitrate   int
+	Error     string
+	Fatal     bool
+}
+
+// RecordBeacon validates a beacon and stores its events, returning how many were stored
+func (m *Manager) RecordBeacon(beacon Beacon) (int, error) {
+	if !sessionIDPattern.MatchString(beacon.SessionID) {
+		return 0, fmt.Errorf("session_id must be 8 to 64 letters, digits, - or _")
+	}
+	if len(beacon.Events) == 0 || len(beacon.Events) > maxBeaconEvents {
+		return 0, fmt.Errorf("a beacon carries 1 to %d events", maxBeaconEvents)
+	}
+	streamKey, err := m.streamKeyOf(beacon.PlaybackID)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	skew := time.Duration(0)
+	if !beacon.SentAt.IsZero() {
+		skew = now.Sub(beacon.SentAt)
+	}
+
+	events := make([]models.PlaybackEvent, 0, len(beacon.Events))
+	for i, event := range beacon.Events {
+		switch event.Type {
+		case models.PlaybackEventPlay, models.PlaybackEventStallStart, models.PlaybackEventStallEnd,
+			models.PlaybackEventRenditionSwitch, models.PlaybackEventError, models.PlaybackEventHeartbeat,
+			models.PlaybackEventEnd:
+		default:
+			return 0, fmt.Errorf("event %d has unknown type %q", i, event.Type)
+		}
+		if event.Time.IsZero() {
+			return 0, fmt.Errorf("event %d has no time", i)
+		}
+		if event.StartupMS < 0 || event.Bitrate < 0 {
+			return 0, fmt.Errorf("event %d has a negative startup time or bitrate", i)
+		}
+
+		at := event.Time.Add(skew)
+		if at.Before(now.Add(-maxBeaconAge)) || at.After(now) {
+			// Too old to matter, or from a clock that cannot be corrected
+			continue
+		}
+		events = append(events, models.PlaybackEvent{
+			StreamKey:  streamKey,
+			PlaybackID: beacon.PlaybackID,
+			SessionID:  beacon.SessionID,
+			Type:       event.Type,
+			Time:       at.UTC(),
+			StartupMS:  event.StartupMS,
+			Rendition:  truncate(event.Rendition, 32),
+			Bitrate:    event.Bitrate,
+			Error:      truncate(event.Error, 256),
+			Fatal:      event.Fatal,
+		})
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+	if err := m.repo.CreatePlaybackEvents(events); err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+// streamKeyOf returns the stream a playback URL name belongs to: the stream of
+// a playback ID, or an unregistered stream that has output
+func (m *Manager) streamKeyOf(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", ErrPlaybackIDNotFound
+	}
+	id, err := m.repo.GetPlaybackID(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up playback ID: %w", err)
+	}
+	if id != nil && id.Stream != nil {
+		return id.Stream.StreamKey, nil
+	}
+	if _, ok := m.playbackIDs.Resolve(name); !ok {
+		return "", ErrPlaybackIDNotFound
+	}
+	if _, err := os.Stat(filepath.Join(m.outputDir, name)); err != nil {
+		return "", ErrPlaybackIDNotFound
+	}
+	return name, nil
+}
+
+// truncate shortens a string to at most n bytes
+func truncate(value string, n int) string {
+	if len(value) > n {
+		return value[:n]
+	}
+	return value
+}
+
+// QoEReport is the quality of experience of a stream's viewers over a time
+// range, from the beacons of their players
+type QoEReport struct {
+	StreamKey         string             `json:"stream_key"`
+	Since             time.Time          `json:"since"`
+	Until             time.Time          `json:"until"`
+	Sessions          int                `json:"sessions"`
+	StartedSessions   int                `json:"started_sessions"`
+	ExitsBeforeStart  int                `json:"exits_before_start"` // sessions that never played
+	StartupP50MS      int                `json:"startup_p50_ms"`
+	StartupP95MS      int                `json:"startup_p95_ms"`
+	WatchSeconds      float64            `json:"watch_seconds"`
+	StallSeconds      float64            `json:"stall_seconds"`
+	Stalls            int                `json:"stalls"`
+	RebufferRatio     float64            `json:"rebuffer_ratio"`  // share of playback time spent stalled
+	AverageBitrate    int                `json:"average_bitrate"` // bits per second, weighted by watch time
+	RenditionSwitches int                `json:"rendition_switches"`
+	Errors            int                `json:"errors"`
+	FatalErrors       int                `json:"fatal_errors"`
+	RenditionSeconds  map[string]float64 `json:"rendition_seconds"` // watch time per rendition
+}
+
+// QoEReport aggregates the player events of a stream in a time range
+func (m *Manager) QoEReport(streamKey string, since, until time.Time) (*QoEReport, error) {
+	if !until.After(since) || until.Sub(since) > maxQoERange {
+		return nil, fmt.Errorf("the time range must be positive and at most %d days", int(maxQoERange.Hours()/24))
+	}
+	events, err := m.repo.ListPlaybackEvents(streamKey, since, until)
+	if err != nil {
+		return nil, err
+	}
+	report := summarizeQoE(events)
+	report.StreamKey = streamKey
+	report.Since = since.UTC()
+	report.Until = until.UTC()
+	return report, nil
+}
+
+// qoeSession replays the events of one playback session
+type qoeSession struct {
+	report    *QoEReport
+	started   bool
+	playing   bool // between a play event and the end of playback
+	stalled   bool
+	last      time.Time
+	bitrate   int
+	rendition string
+
+	bitrateSeconds float64 // bitrate weighted by watch time
+}
+
+// summarizeQoE aggregates events grouped by session and ordered within each
+func summarizeQoE(events []models.PlaybackEvent) *QoEReport {
+	report := &QoEReport{RenditionSeconds: map[string]float64{}}
+	var startups []int
+	var session *qoeSession
+	var bitrateSeconds float64
+	sessionID := ""
+
+	finish := func() {
+		if session == nil {
+			return
+		}
+		report.Sessions++
+		if session.started {
+			report.StartedSessions++
+		} else {
+			report.ExitsBeforeStart++
+		}
+		bitrateSeconds += session.bitrateSeconds
+	}
+
+	for _, event := range events {
+		if session == nil || event.SessionID != sessionID {
+			finish()
+			session = &qoeSession{report: report}
+			sessionID = event.SessionID
+		}
+		session.advance(event.Time)
+
+		switch event.Type {
+		case models.PlaybackEventPlay:
+			if event.StartupMS > 0 {
+				startups = append(startups, event.StartupMS)
+			}
+			session.started = true
+			session.playing = true
+			session.stalled = false
+			session.switchTo(event)
+		case models.PlaybackEventStallStart:
+			if session.playing && !session.stalled {
+				session.stalled = true
+				report.Stalls++
+			}
+		case models.PlaybackEventStallEnd:
+			session.stalled = false
+		case models.PlaybackEventRenditionSwitch:
+			if session.rendition != "" && event.Rendition != session.rendition {
+				report.RenditionSwitches++
+			}
+			session.switchTo(event)
+		case models.PlaybackEventError:
+			report.Errors++
+			if event.Fatal {
+				report.FatalErrors++
+				session.playing = false
+				session.stalled = false
+			}
+		case models.PlaybackEventEnd:
+			session.playing = false
+			session.stalled = false
+		}
+	}
+	finish()
+
+	if len(startups) > 0 {
+		sort.Ints(startups)
+		report.StartupP50MS = percentile(startups, 50)
+		report.StartupP95MS = percentile(startups, 95)
+	}
+	if total := report.WatchSeconds + report.StallSeconds; total > 0 {
+		report.RebufferRatio = report.StallSeconds / total
+	}
+	if report.WatchSeconds > 0 {
+		report.AverageBitrate = int(bitrateSeconds / report.WatchSeconds)
+	}
+	return report
+}
+
+// advance accounts for the time since the previous event of the session
+func (s *qoeSession) advance(at time.Time) {
+	if s.playing && !s.last.IsZero() && at.After(s.last) {
+		seconds := at.Sub(s.last).Seconds()
+		if s.stalled {
+			s.report.StallSeconds += seconds
+		} else {
+			s.report.WatchSeconds += seconds
+			s.bitrateSeconds += float64(s.bitrate) * seconds
+			if s.rendition != "" {
+				s.report.RenditionSeconds[s.rendition] += seconds
+			}
+		}
+	}
+	s.last = at
+}
+
+// switchTo takes the rendition an event reports as playing
+func (s *qoeSession) switchTo(event models.PlaybackEvent) {
+	if event.Rendition != "" {
+		s.rendition = event.Rendition
+	}
+	if event.Bitrate > 0 {
+		s.bitrate = event.Bitrate
+	}
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []int, p int) int {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
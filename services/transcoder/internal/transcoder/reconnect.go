@@ -0,0 +1,189 @@
+package transcoder
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/streamforge/platform/pkg/m3u8"
+)
+
+const (
+	// defaultReconnectWindow is how long a stream waits for its publisher to reconnect
+	defaultReconnectWindow = 30 * time.Second
+	// discontinuitiesFile is the sidecar the HLS server derives EXT-X-DISCONTINUITY-SEQUENCE from
+	discontinuitiesFile = ".discontinuities"
+)
+
+// variantDiscontinuities records where the encoder of a variant playlist was
+// restarted: the media sequence numbers of the segments that start with
+// EXT-X-DISCONTINUITY, and how many such segments already left the playlist
+type variantDiscontinuities struct {
+	Removed   int64   `json:"removed"`
+	Sequences []int64 `json:"sequences"`
+}
+
+// errNoReconnectWindow is returned when reconnects are disabled
+var errNoReconnectWindow = errors.New("reconnect window disabled")
+
+// SetReconnectWindow sets how long a stream whose publisher dropped waits for it
+// to reconnect before the session ends. Zero ends streams right away.
+func (m *Manager) SetReconnectWindow(window time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.reconnectWindow = window
+}
+
+// awaitReconnectLocked stops the encoder of a stream whose publisher dropped and
+// keeps its session, recordings and playlists open for the reconnect window.
+// Callers must hold the manager mutex.
+func (m *Manager) awaitReconnectLocked(process *TranscoderProcess, reason string) error {
+	if m.reconnectWindow <= 0 {
+		return errNoReconnectWindow
+	}
+
+	log.Printf("⏳ Waiting up to %v for the publisher of %s to reconnect: %s", m.reconnectWindow, process.StreamKey, reason)
+	if process.Cmd != nil && process.Cmd.Process != nil {
+		stopEncoder(process.Cmd, process.exited)
+	}
+
+	hlsManager, _ := m.newHLSManager(process.StreamKey)
+	if err := hlsManager.ReopenPlaylists(process.StreamKey); err != nil {
+		log.Printf("⚠️  Failed to reopen playlists for %s: %v", process.StreamKey, err)
+	}
+
+	until := time.Now().Add(m.reconnectWindow)
+	m.transitionLocked(process, StateReconnecting, reason)
+	process.ReconnectUntil = &until
+	process.reconnectTimer = time.AfterFunc(m.reconnectWindow, func() {
+		m.endReconnect(process.StreamKey, until)
+	})
+	return nil
+}
+
+// resumeLocked continues a stream whose publisher reconnected within the window.
+// The new encoder appends to the playlists after a discontinuity. Callers must
+// hold the manager mutex.
+func (m *Manager) resumeLocked(process *TranscoderProcess) error {
+	if process.reconnectTimer != nil {
+		process.reconnectTimer.Stop()
+		process.reconnectTimer = nil
+	}
+	process.ReconnectUntil = nil
+
+	log.Printf("🔁 Publisher of %s reconnected, continuing the session", process.StreamKey)
+	hlsManager, _ := m.newHLSManager(process.StreamKey)
+	if err := m.replaceEncoderLocked(process, hlsManager); err != nil {
+		return err
+	}
+	return m.transitionLocked(process, StateRunning, "publisher reconnected")
+}
+
+// endReconnect ends a stream whose publisher did not reconnect within the window
+func (m *Manager) endReconnect(streamKey string, until time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	process, exists := m.processes[streamKey]
+	if !exists || process.ReconnectUntil == nil || !process.ReconnectUntil.Equal(until) {
+		return // the publisher reconnected or the stream was stopped meanwhile
+	}
+	process.ReconnectUntil = nil
+	process.reconnectTimer = nil
+
+	log.Printf("🛑 Publisher of %s did not reconnect, ending stream", streamKey)
+	m.endStreamLocked(process, "publisher did not reconnect")
+}
+
+// endStreamLocked closes the playlists and session of a stream that is over and
+// marks its files for the cleanup job. Callers must hold the manager mutex.
+func (m *Manager) endStreamLocked(process *TranscoderProcess, reason string) {
+	streamKey := process.StreamKey
+	if process.Cmd != nil && process.Cmd.Process != nil && !encoderExited(process.exited) {
+		stopEncoder(process.Cmd, process.exited)
+	}
+	hlsManager, _ := m.newHLSManager(streamKey)
+	if err := hlsManager.EndPlaylists(streamKey); err != nil {
+		log.Printf("⚠️  Failed to end playlists for %s: %v", streamKey, err)
+	}
+	if err := m.writeStreamEnded(streamKey, reason); err != nil {
+		log.Printf("⚠️  Failed to mark %s as ended: %v", streamKey, err)
+	}
+
+	m.transitionLocked(process, StateStopped, reason)
+	m.finishSession(process, true)
+	delete(m.processes, streamKey)
+	m.releaseStreamLock(streamKey)
+}
+
+// resetStreamDir removes the media a previous session of a stream left behind,
+// so a new session starts with fresh playlists
+func (m *Manager) resetStreamDir(streamKey string) error {
+	streamDir := m.streamDir(streamKey)
+	for _, name := range []string{thumbnailsDirName, thumbnailName, streamEndedFile, discontinuitiesFile} {
+		if err := os.RemoveAll(filepath.Join(streamDir, name)); err != nil {
+			return err
+		}
+	}
+	for _, quality := range m.qualities {
+		variantDir := filepath.Join(streamDir, quality.Name)
+		if err := os.RemoveAll(variantDir); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(variantDir, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordDiscontinuity records that the next segment of every variant playlist
+// starts after a discontinuity. Discontinuities that slid out of a playlist are
+// only counted, which is all EXT-X-DISCONTINUITY-SEQUENCE needs.
+func (h *HLSManager) recordDiscontinuity(streamKey string) error {
+	path := filepath.Join(h.streamDir(streamKey), discontinuitiesFile)
+	variants := map[string]*variantDiscontinuities{}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &variants); err != nil {
+			log.Printf("⚠️  Ignoring unreadable %s for %s: %v", discontinuitiesFile, streamKey, err)
+			variants = map[string]*variantDiscontinuities{}
+		}
+	}
+
+	for _, quality := range h.qualities {
+		playlist, err := m3u8.ReadMediaFile(filepath.Join(h.streamDir(streamKey), quality.Name, variantPlaylistName))
+		if err != nil {
+			continue // no segments yet, so nothing to be discontinuous with
+		}
+		variant := variants[quality.Name]
+		if variant == nil {
+			variant = &variantDiscontinuities{Sequences: []int64{}}
+			variants[quality.Name] = variant
+		}
+
+		kept := variant.Sequences[:0]
+		for _, sequence := range variant.Sequences {
+			if sequence < playlist.MediaSequence {
+				variant.Removed++
+			} else {
+				kept = append(kept, sequence)
+			}
+		}
+		variant.Sequences = kept
+
+		// Several restarts before the next segment is written still mark one segment
+		next := playlist.MediaSequence + int64(len(playlist.Segments))
+		if len(kept) == 0 || kept[len(kept)-1] != next {
+			variant.Sequences = append(variant.Sequences, next)
+		}
+	}
+
+	data, err := json.MarshalIndent(variants, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
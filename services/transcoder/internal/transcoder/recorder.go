@@ -0,0 +1,280 @@
+package transcoder
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/streamforge/platform/pkg/m3u8"
+	"github.com/streamforge/platform/pkg/models"
+)
+
+const (
+	// recordingPollInterval is how often live variant playlists are checked for new segments
+	recordingPollInterval = time.Second
+	// recordingPlaylistName is the file name of a recording's VOD playlist
+	recordingPlaylistName = "playlist.m3u8"
+	// recordingMP4Name is the file name of a recording's MP4 archive
+	recordingMP4Name = "recording.mp4"
+)
+
+// Recorder archives the segments of selected renditions while a stream is live.
+// Segments are hard-linked out of the live window so the HLS muxer can keep
+// deleting them, and are turned into a VOD playlist and MP4 once the stream ends.
+// Segments of encrypted streams are archived decrypted.
+type Recorder struct {
+	streamKey string
+	tracks    []*recordingTrack
+	stopOnce  sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// recordingTrack is the archive of a single rendition
+type recordingTrack struct {
+	recording *models.Recording
+	sourceDir string
+	dir       string
+	segments  []m3u8.Segment
+	seen      map[string]bool
+	sizeBytes int64
+
+	// Content keys of encrypted segments, which are archived decrypted
+	contentKeys func(uri string) ([]byte, error)
+}
+
+// NewRecorder creates a recorder for the given recordings, one per rendition,
+// archiving them to subdirectories of archiveDir
+func NewRecorder(streamKey string, hlsManager *HLSManager, archiveDir string, recordings []*models.Recording) (*Recorder, error) {
+	recorder := &Recorder{
+		streamKey: streamKey,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	for _, recording := range recordings {
+		dir := filepath.Join(archiveDir, recording.ID.String())
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create recording directory: %w", err)
+		}
+
+		recording.PlaylistPath = filepath.Join(dir, recordingPlaylistName)
+		recording.MP4Path = filepath.Join(dir, recordingMP4Name)
+		recorder.tracks = append(recorder.tracks, &recordingTrack{
+			recording: recording,
+			sourceDir: hlsManager.VariantDir(streamKey, recording.Rendition),
+			dir:       dir,
+			seen:      make(map[string]bool),
+
+			contentKeys: hlsManager.contentKeys,
+		})
+	}
+
+	return recorder, nil
+}
+
+// Start begins archiving segments in the background
+func (r *Recorder) Start() {
+	go r.run()
+}
+
+// Stop stops archiving and waits for the last segments to be collected
+func (r *Recorder) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+	<-r.done
+}
+
+// Recordings returns the recordings managed by this recorder
+func (r *Recorder) Recordings() []*models.Recording {
+	recordings := make([]*models.Recording, len(r.tracks))
+	for i, track := range r.tracks {
+		recordings[i] = track.recording
+	}
+	return recordings
+}
+
+// Finalize writes the VOD playlist and remuxes the MP4 for every rendition.
+// It must only be called after Stop.
+func (r *Recorder) Finalize() {
+	for _, track := range r.tracks {
+		recording := track.recording
+		now := time.Now()
+		recording.EndedAt = &now
+		recording.SegmentCount = len(track.segments)
+		recording.Duration = track.duration()
+		recording.SizeBytes = track.sizeBytes
+
+		if len(track.segments) == 0 {
+			recording.Status = models.RecordingStatusFailed
+			recording.Error = "no segments were recorded"
+			continue
+		}
+
+		if err := writeVODPlaylist(recording.PlaylistPath, track.segments); err != nil {
+			recording.Status = models.RecordingStatusFailed
+			recording.Error = fmt.Sprintf("failed to write VOD playlist: %v", err)
+			continue
+		}
+
+		if err := remuxMP4(recording.PlaylistPath, recording.MP4Path); err != nil {
+			recording.Status = models.RecordingStatusFailed
+			recording.Error = fmt.Sprintf("failed to create MP4: %v", err)
+			log.Printf("❌ Recording %s for %s failed: %v", recording.ID, r.streamKey, err)
+			continue
+		}
+
+		if info, err := os.Stat(recording.MP4Path); err == nil {
+			recording.SizeBytes = info.Size()
+		}
+		if err := buildRecordingThumbnails(recording.MP4Path, track.dir, recording.Duration); err != nil {
+			// Seek previews are optional, the recording itself is fine
+			log.Printf("⚠️  Failed to build thumbnails for recording %s: %v", recording.ID, err)
+		}
+		recording.Status = models.RecordingStatusReady
+		log.Printf("📼 Recording %s for %s/%s ready (%d segments, %.1fs)",
+			recording.ID, r.streamKey, recording.Rendition, recording.SegmentCount, recording.Duration)
+	}
+}
+
+// run polls the live variant playlists until the recorder is stopped
+func (r *Recorder) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(recordingPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			// Collect whatever the muxer flushed before the stream ended
+			r.collect()
+			return
+		case <-ticker.C:
+			r.collect()
+		}
+	}
+}
+
+// collect archives any new segments of every track
+func (r *Recorder) collect() {
+	for _, track := range r.tracks {
+		if err := track.collect(); err != nil && !os.IsNotExist(err) {
+			log.Printf("⚠️  Recording %s/%s: %v", r.streamKey, track.recording.Rendition, err)
+		}
+	}
+}
+
+// collect archives segments of the live playlist that have not been seen yet
+func (t *recordingTrack) collect() error {
+	playlist, err := m3u8.ReadMediaFile(filepath.Join(t.sourceDir, variantPlaylistName))
+	if err != nil {
+		return err
+	}
+	resolveSegmentKeys(playlist)
+
+	for _, segment := range playlist.Segments {
+		if t.seen[segment.URI] {
+			continue
+		}
+
+		name := filepath.Base(segment.URI)
+		src := filepath.Join(t.sourceDir, name)
+		dst := filepath.Join(t.dir, name)
+		if err := archiveSegment(src, dst, segment, t.contentKeys); err != nil {
+			if os.IsNotExist(err) {
+				// Already rotated out of the live window, nothing left to archive
+				t.seen[segment.URI] = true
+				log.Printf("⚠️  Segment %s expired before it could be recorded", src)
+				continue
+			}
+			return fmt.Errorf("failed to archive segment %s: %w", name, err)
+		}
+
+		if info, err := os.Stat(dst); err == nil {
+			t.sizeBytes += info.Size()
+		}
+
+		t.seen[segment.URI] = true
+		segment.URI = name
+		segment.Key = nil
+		t.segments = append(t.segments, segment)
+	}
+
+	return nil
+}
+
+// duration returns the total duration of the archived segments in seconds
+func (t *recordingTrack) duration() float64 {
+	total := 0.0
+	for _, segment := range t.segments {
+		total += segment.Duration
+	}
+	return total
+}
+
+// writeVODPlaylist writes archived segments as a VOD playlist
+func writeVODPlaylist(path string, segments []m3u8.Segment) error {
+	targetDuration := 0.0
+	for _, segment := range segments {
+		targetDuration = math.Max(targetDuration, segment.Duration)
+	}
+
+	playlist := &m3u8.MediaPlaylist{
+		Version:             3,
+		TargetDuration:      int(math.Ceil(targetDuration)),
+		PlaylistType:        m3u8.PlaylistTypeVOD,
+		IndependentSegments: true,
+		Segments:            segments,
+		Ended:               true,
+	}
+	return writeFileAtomic(path, playlist.Encode())
+}
+
+// remuxMP4 copies the segments of a VOD playlist into a single MP4 without re-encoding
+func remuxMP4(playlistPath, mp4Path string) error {
+	cmd := exec.Command("ffmpeg",
+		"-hide_banner", "-loglevel", "error", "-y",
+		"-i", playlistPath,
+		"-c", "copy",
+		"-bsf:a", "aac_adtstoasc",
+		"-movflags", "+faststart",
+		mp4Path,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// linkOrCopy hard-links src to dst, falling back to a copy across filesystems
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil || os.IsExist(err) {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,389 @@
+package transcoder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/streamforge/platform/pkg/models"
+)
+
+const (
+	// relayMinBackoff and relayMaxBackoff bound the delay between reconnect attempts
+	relayMinBackoff = 2 * time.Second
+	relayMaxBackoff = 30 * time.Second
+	// relayStableAfter is how long a relay must run before its backoff is reset
+	relayStableAfter = 30 * time.Second
+)
+
+// Relay states reported by RelayStats
+const (
+	RelayStatusStarting     = "starting"
+	RelayStatusRunning      = "running"
+	RelayStatusReconnecting = "reconnecting"
+	RelayStatusStopped      = "stopped"
+)
+
+// RelayStats is a snapshot of a relay's state
+type RelayStats struct {
+	TargetID   uuid.UUID  `json:"target_id"`
+	Status     string     `json:"status"`
+	BytesSent  int64      `json:"bytes_sent"`
+	Reconnects int        `json:"reconnects"`
+	LastError  string     `json:"last_error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+}
+
+// Relay copies a live stream to a restream target with FFmpeg, restarting the
+// process with exponential backoff whenever the connection drops
+type Relay struct {
+	target   models.RestreamTarget
+	inputURL string
+
+	mu         sync.Mutex
+	status     string
+	bytesSent  int64 // bytes sent by processes that already exited
+	current    int64 // bytes sent by the running process
+	reconnects int
+	lastError  string
+	startedAt  time.Time
+	cmd        *exec.Cmd
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewRelay creates a relay from inputURL to a restream target
+func NewRelay(target models.RestreamTarget, inputURL string) *Relay {
+	return &Relay{
+		target:   target,
+		inputURL: inputURL,
+		status:   RelayStatusStopped,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start launches the relay in the background
+func (r *Relay) Start() {
+	r.mu.Lock()
+	r.status = RelayStatusStarting
+	r.startedAt = time.Now()
+	r.mu.Unlock()
+
+	go r.run()
+}
+
+// Stop terminates the relay and waits for FFmpeg to exit
+func (r *Relay) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+		r.mu.Lock()
+		if r.cmd != nil && r.cmd.Process != nil {
+			r.cmd.Process.Signal(syscall.SIGTERM)
+		}
+		r.mu.Unlock()
+	})
+
+	select {
+	case <-r.done:
+	case <-time.After(5 * time.Second):
+		r.mu.Lock()
+		if r.cmd != nil && r.cmd.Process != nil {
+			r.cmd.Process.Kill()
+		}
+		r.mu.Unlock()
+		<-r.done
+	}
+}
+
+// Stats returns a snapshot of the relay's state
+func (r *Relay) Stats() RelayStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := RelayStats{
+		TargetID:   r.target.ID,
+		Status:     r.status,
+		BytesSent:  r.bytesSent + r.current,
+		Reconnects: r.reconnects,
+		LastError:  r.lastError,
+	}
+	if !r.startedAt.IsZero() {
+		startedAt := r.startedAt
+		stats.StartedAt = &startedAt
+	}
+	return stats
+}
+
+// run supervises FFmpeg until the relay is stopped
+func (r *Relay) run() {
+	defer close(r.done)
+
+	backoff := relayMinBackoff
+	for {
+		started := time.Now()
+		err := r.runOnce()
+
+		select {
+		case <-r.stop:
+			r.setStatus(RelayStatusStopped, "")
+			return
+		default:
+		}
+
+		if time.Since(started) > relayStableAfter {
+			backoff = relayMinBackoff
+		}
+
+		reason := "relay exited"
+		if err != nil {
+			reason = r.redact(err.Error())
+		}
+		r.mu.Lock()
+		r.reconnects++
+		r.mu.Unlock()
+		r.setStatus(RelayStatusReconnecting, reason)
+		log.Printf("⚠️  Relay %s (%s) dropped: %s, reconnecting in %v", r.target.Name, r.target.StreamKey, reason, backoff)
+
+		select {
+		case <-r.stop:
+			r.setStatus(RelayStatusStopped, "")
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > relayMaxBackoff {
+			backoff = relayMaxBackoff
+		}
+	}
+}
+
+// runOnce runs a single FFmpeg relay process until it exits
+func (r *Relay) runOnce() error {
+	cmd := exec.Command("ffmpeg",
+		"-hide_banner", "-loglevel", "error", "-nostats",
+		"-i", r.inputURL,
+		"-c", "copy",
+		"-f", relayFormat(r.target.URL),
+		"-progress", "pipe:1",
+		relayOutputURL(r.target),
+	)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	r.mu.Lock()
+	select {
+	case <-r.stop:
+		r.mu.Unlock()
+		return nil
+	default:
+	}
+	if err := cmd.Start(); err != nil {
+		r.mu.Unlock()
+		return fmt.Errorf("failed to start FFmpeg: %w", err)
+	}
+	r.cmd = cmd
+	r.mu.Unlock()
+
+	r.readProgress(stdout)
+	err = cmd.Wait()
+
+	r.mu.Lock()
+	r.cmd = nil
+	r.bytesSent += r.current
+	r.current = 0
+	r.mu.Unlock()
+
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, lastLine(msg))
+		}
+		return err
+	}
+	return nil
+}
+
+// readProgress tracks bytes sent from FFmpeg's -progress output
+func (r *Relay) readProgress(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok || key != "total_size" {
+			continue
+		}
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue // "N/A" until the first packet is written
+		}
+
+		r.mu.Lock()
+		r.current = size
+		if r.status != RelayStatusRunning {
+			r.status = RelayStatusRunning
+			log.Printf("📡 Relay %s (%s) is live", r.target.Name, r.target.StreamKey)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// setStatus updates the relay status and, if given, the last error
+func (r *Relay) setStatus(status, lastError string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+	if lastError != "" {
+		r.lastError = lastError
+	}
+}
+
+// redact hides the target's stream key in a message. FFmpeg names the output
+// URL, key included, in many of its errors.
+func (r *Relay) redact(message string) string {
+	if r.target.Key == "" {
+		return message
+	}
+	for _, key := range []string{r.target.Key, url.PathEscape(r.target.Key), url.QueryEscape(r.target.Key)} {
+		message = strings.ReplaceAll(message, key, "****")
+	}
+	return message
+}
+
+// relayOutputURL adds the target's stream key to its ingest URL, as the last
+// path element for RTMP or as the streamid for SRT
+func relayOutputURL(target models.RestreamTarget) string {
+	if target.Key == "" {
+		return target.URL
+	}
+	if strings.HasPrefix(target.URL, "srt://") {
+		separator := "?"
+		if strings.Contains(target.URL, "?") {
+			separator = "&"
+		}
+		return target.URL + separator + "streamid=" + target.Key
+	}
+	return strings.TrimSuffix(target.URL, "/") + "/" + target.Key
+}
+
+// relayFormat returns the FFmpeg output format for an ingest URL
+func relayFormat(url string) string {
+	if strings.HasPrefix(url, "srt://") {
+		return "mpegts"
+	}
+	return "flv"
+}
+
+// lastLine returns the last line of FFmpeg's error output
+func lastLine(output string) string {
+	lines := strings.Split(output, "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// StartRelay starts relaying a stream to one of its targets. The stream must be live.
+func (m *Manager) StartRelay(target *models.RestreamTarget) error {
+	m.mutex.RLock()
+	process, exists := m.processes[target.StreamKey]
+	live := exists && process.Status.Live()
+	m.mutex.RUnlock()
+	if !live {
+		return fmt.Errorf("stream %s is not live", target.StreamKey)
+	}
+
+	m.relayMutex.Lock()
+	defer m.relayMutex.Unlock()
+
+	if relay, exists := m.relays[target.StreamKey][target.ID]; exists {
+		if relay.Stats().Status != RelayStatusStopped {
+			return fmt.Errorf("relay to %s is already running", target.Name)
+		}
+	}
+	m.startRelayLocked(*target)
+	return nil
+}
+
+// StopRelay stops relaying to a single target, leaving the stream and other relays running
+func (m *Manager) StopRelay(target *models.RestreamTarget) error {
+	m.relayMutex.Lock()
+	relay, exists := m.relays[target.StreamKey][target.ID]
+	if exists {
+		delete(m.relays[target.StreamKey], target.ID)
+	}
+	m.relayMutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("relay to %s is not running", target.Name)
+	}
+	relay.Stop()
+	log.Printf("🛑 Relay %s (%s) stopped", target.Name, target.StreamKey)
+	return nil
+}
+
+// RelayStats returns the state of every relay of a stream by target ID
+func (m *Manager) RelayStats(streamKey string) map[uuid.UUID]RelayStats {
+	m.relayMutex.Lock()
+	defer m.relayMutex.Unlock()
+
+	stats := make(map[uuid.UUID]RelayStats, len(m.relays[streamKey]))
+	for id, relay := range m.relays[streamKey] {
+		stats[id] = relay.Stats()
+	}
+	return stats
+}
+
+// startRelays starts a relay for every enabled restream target of a stream
+func (m *Manager) startRelays(streamKey string) {
+	targets, err := m.repo.ListRestreamTargets(streamKey)
+	if err != nil {
+		log.Printf("⚠️  Failed to load restream targets for %s: %v", streamKey, err)
+		return
+	}
+
+	m.relayMutex.Lock()
+	defer m.relayMutex.Unlock()
+	for _, target := range targets {
+		if target.Enabled {
+			m.startRelayLocked(target)
+		}
+	}
+}
+
+// startRelayLocked starts a relay. Callers must hold the relay mutex.
+func (m *Manager) startRelayLocked(target models.RestreamTarget) {
+	if m.relays[target.StreamKey] == nil {
+		m.relays[target.StreamKey] = make(map[uuid.UUID]*Relay)
+	}
+
+	relay := NewRelay(target, fmt.Sprintf("%s/%s", m.rtmpURL, target.StreamKey))
+	m.relays[target.StreamKey][target.ID] = relay
+	relay.Start()
+	log.Printf("📡 Relaying %s to %s", target.StreamKey, target.Name)
+}
+
+// stopRelays stops every relay of a stream in the background
+func (m *Manager) stopRelays(streamKey string) {
+	m.relayMutex.Lock()
+	relays := m.relays[streamKey]
+	delete(m.relays, streamKey)
+	m.relayMutex.Unlock()
+
+	for _, relay := range relays {
+		go relay.Stop()
+	}
+}
@@ -0,0 +1,202 @@
+package transcoder
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/streamforge/platform/pkg/models"
+)
+
+// testRelayKey is the destination key relays publish with, which must never show in their status
+const testRelayKey = "live_sekret_key"
+
+func TestRelayRedactsKey(t *testing.T) {
+	// FFmpeg names the output URL as given, or escaped
+	relay := NewRelay(models.RestreamTarget{URL: "rtmp://ingest.example.com/app", Key: "live_sekret/key"}, "")
+	for _, message := range []string{
+		"exit status 1: rtmp://ingest.example.com/app/live_sekret/key: Connection refused",
+		"exit status 1: srt://ingest.example.com:9000?streamid=live_sekret%2Fkey: Connection timed out",
+		"exit status 1: rtmp://ingest.example.com/app/live_sekret%2Fkey: Input/output error",
+	} {
+		if got := relay.redact(message); strings.Contains(got, "sekret") {
+			t.Errorf("redact(%q) = %q, leaks the key", message, got)
+		}
+	}
+	if got := NewRelay(models.RestreamTarget{URL: "rtmp://ingest.example.com/app"}, "").redact("relay exited"); got != "relay exited" {
+		t.Errorf("redact without a key = %q", got)
+	}
+}
+
+// requireFFmpeg skips tests that run real FFmpeg processes where there is none
+func requireFFmpeg(t *testing.T) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("relays run FFmpeg for several seconds")
+	}
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg is not installed")
+	}
+}
+
+// freePort returns a loopback port nothing listens on
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// writeTestSource writes a short FLV file for a relay to read, standing in for the live stream
+func writeTestSource(t *testing.T) string {
+	t.Helper()
+	source := filepath.Join(t.TempDir(), "source.flv")
+	output, err := exec.Command("ffmpeg", "-hide_banner", "-loglevel", "error",
+		"-f", "lavfi", "-i", "testsrc=size=320x240:rate=25:duration=2",
+		"-f", "lavfi", "-i", "sine=frequency=440:duration=2",
+		"-c:v", "flv1", "-g", "25", "-c:a", "aac", "-f", "flv", source).CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to write the test source: %v: %s", err, output)
+	}
+	return source
+}
+
+// startSink runs an FFmpeg RTMP server that takes one publisher and writes what
+// it receives to a file. The returned channel is closed once the publisher left.
+func startSink(t *testing.T, port int, key, output string) <-chan struct{} {
+	t.Helper()
+	cmd := exec.Command("ffmpeg", "-hide_banner", "-loglevel", "error", "-y",
+		"-listen", "1", "-i", fmt.Sprintf("rtmp://127.0.0.1:%d/app/%s", port, key),
+		"-c", "copy", "-f", "flv", output)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cmd.Process.Kill() })
+
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+
+	// Wait until the sink listens, without connecting to it
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port)); err == nil {
+			listener.Close()
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		return done
+	}
+	t.Fatalf("the sink did not listen on port %d", port)
+	return nil
+}
+
+// waitFor polls the relay until its state satisfies a condition
+func waitFor(t *testing.T, relay *Relay, timeout time.Duration, what string, condition func(RelayStats) bool) RelayStats {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		stats := relay.Stats()
+		if condition(stats) {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the relay to %s: %+v", what, stats)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// sinkReceived waits for a sink to see its publisher leave and checks it received media
+func sinkReceived(t *testing.T, done <-chan struct{}, output string) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(20 * time.Second):
+		t.Fatal("the relay never finished publishing to the sink")
+	}
+	info, err := os.Stat(output)
+	if err != nil || info.Size() == 0 {
+		t.Fatalf("the sink received nothing: %v", err)
+	}
+}
+
+func TestRelayPublishesAndReconnects(t *testing.T) {
+	requireFFmpeg(t)
+	source := writeTestSource(t)
+	port := freePort(t)
+	dir := t.TempDir()
+
+	first := filepath.Join(dir, "first.flv")
+	firstDone := startSink(t, port, testRelayKey, first)
+
+	relay := NewRelay(models.RestreamTarget{
+		ID:   uuid.New(),
+		Name: "local sink",
+		URL:  fmt.Sprintf("rtmp://127.0.0.1:%d/app", port),
+		Key:  testRelayKey,
+	}, source)
+	relay.Start()
+	defer relay.Stop()
+
+	// The relay connects and sends the stream; the source is a file, so it ends
+	// and the relay reconnects as it would after a dropped connection
+	sinkReceived(t, firstDone, first)
+	stats := waitFor(t, relay, 10*time.Second, "report the drop", func(stats RelayStats) bool {
+		return stats.Reconnects >= 1
+	})
+	if stats.BytesSent == 0 {
+		t.Errorf("bytes sent = 0 after publishing")
+	}
+	if stats.StartedAt == nil || stats.TargetID == uuid.Nil {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	second := filepath.Join(dir, "second.flv")
+	secondDone := startSink(t, port, testRelayKey, second)
+	sinkReceived(t, secondDone, second)
+	waitFor(t, relay, 10*time.Second, "count the second drop", func(stats RelayStats) bool {
+		return stats.Reconnects >= 2 && stats.BytesSent > 0
+	})
+
+	relay.Stop()
+	if stats := relay.Stats(); stats.Status != RelayStatusStopped {
+		t.Errorf("status after Stop = %q, want %q", stats.Status, RelayStatusStopped)
+	}
+}
+
+func TestRelayReportsErrorsWithoutKey(t *testing.T) {
+	requireFFmpeg(t)
+	source := writeTestSource(t)
+
+	// Nothing listens on the port, so every attempt fails
+	relay := NewRelay(models.RestreamTarget{
+		ID:   uuid.New(),
+		Name: "unreachable",
+		URL:  fmt.Sprintf("rtmp://127.0.0.1:%d/app", freePort(t)),
+		Key:  testRelayKey,
+	}, source)
+	relay.Start()
+	defer relay.Stop()
+
+	stats := waitFor(t, relay, 10*time.Second, "fail", func(stats RelayStats) bool {
+		return stats.Reconnects >= 1 && stats.LastError != ""
+	})
+	if stats.Status != RelayStatusReconnecting {
+		t.Errorf("status = %q, want %q", stats.Status, RelayStatusReconnecting)
+	}
+	if strings.Contains(stats.LastError, "sekret") {
+		t.Errorf("last error %q leaks the destination key", stats.LastError)
+	}
+}
@@ -0,0 +1,192 @@
+package transcoder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// slateText is drawn on the built-in slate
+	slateText = "We'll be right back"
+	// slateFrameRate is the frame rate the slate is encoded at
+	slateFrameRate = "30"
+	// streamEndedFile marks a stream directory for the cleanup job, as on_publish_done.sh does
+	streamEndedFile = ".stream_ended"
+	// defaultEndedRetentionHours is how long an ended stream's files are kept unless configured
+	defaultEndedRetentionHours = 24
+)
+
+// UseSlate makes the encoder read the slate instead of the RTMP input
+func (h *HLSManager) UseSlate() {
+	h.slate = true
+}
+
+// inputArgs returns the FFmpeg input options and the audio stream every variant
+// maps. The slate is read in real time with silent audio, so the playlists keep
+// advancing at the live rate.
+func (h *HLSManager) inputArgs(inputURL string) ([]string, string) {
+	if !h.slate {
+		return []string{"-fflags", "+genpts", "-i", inputURL}, "0:a:0"
+	}
+
+	var args []string
+	switch {
+	case h.slateSource == "":
+		width, height := splitResolution(h.qualities[0].Resolution)
+		source := fmt.Sprintf("color=c=0x101010:s=%sx%s:r=%s,drawtext=text=%s:fontsize=%d:fontcolor=white:x=(w-tw)/2:y=(h-th)/2",
+			width, height, slateFrameRate, escapeFilterValue(slateText), 72)
+		if _, err := os.Stat(defaultFontFile); err == nil {
+			source += ":fontfile=" + escapeFilterValue(defaultFontFile)
+		}
+		args = []string{"-re", "-f", "lavfi", "-i", source}
+	case isImage(h.slateSource):
+		args = []string{"-re", "-loop", "1", "-framerate", slateFrameRate, "-i", h.slateSource}
+	default:
+		args = []string{"-re", "-stream_loop", "-1", "-i", h.slateSource}
+	}
+
+	args = append(args, "-f", "lavfi", "-i", "anullsrc=channel_layout=stereo:sample_rate=44100")
+	return args, "1:a:0"
+}
+
+// isImage reports whether a slate source is a still image rather than a clip
+func isImage(source string) bool {
+	switch strings.ToLower(filepath.Ext(strings.SplitN(source, "?", 2)[0])) {
+	case ".png", ".jpg", ".jpeg", ".bmp", ".webp":
+		return true
+	}
+	return false
+}
+
+// DisconnectOutcome is what happened to a stream when its publisher went away
+type DisconnectOutcome string
+
+const (
+	// DisconnectSlate means the slate is showing until the publisher returns
+	DisconnectSlate DisconnectOutcome = "slate"
+	// DisconnectReconnect means the session stays open for the publisher to reconnect
+	DisconnectReconnect DisconnectOutcome = "reconnect"
+	// DisconnectStopped means the stream has ended
+	DisconnectStopped DisconnectOutcome = "stopped"
+)
+
+// DisconnectPublisher handles a publisher leaving a live stream. Streams with a
+// slate grace period switch to their slate and keep the playlists advancing;
+// others wait for the publisher to reconnect within the reconnect window, and
+// are stopped if there is none.
+func (m *Manager) DisconnectPublisher(streamKey string) (DisconnectOutcome, error) {
+	m.mutex.Lock()
+	process, exists := m.processes[streamKey]
+	switch {
+	case exists && process.SlateSince != nil:
+		m.mutex.Unlock()
+		return DisconnectSlate, nil
+	case exists && process.ReconnectUntil != nil:
+		m.mutex.Unlock()
+		return DisconnectReconnect, nil
+	case exists && process.Status.Live():
+		err := m.enterSlateLocked(process, "publisher disconnected")
+		if err == nil {
+			m.mutex.Unlock()
+			return DisconnectSlate, nil
+		}
+		if err != errNoSlate {
+			log.Printf("⚠️  Failed to switch %s to its slate: %v", streamKey, err)
+		}
+		if m.awaitReconnectLocked(process, "publisher disconnected") == nil {
+			m.mutex.Unlock()
+			return DisconnectReconnect, nil
+		}
+	}
+	m.mutex.Unlock()
+
+	return DisconnectStopped, m.stopTranscoder(streamKey, "publisher disconnected")
+}
+
+// errNoSlate is returned when a stream has no slate grace period
+var errNoSlate = errors.New("slate disabled")
+
+// enterSlateLocked restarts the encoder of a stream on its slate and schedules
+// the end of the stream once the grace period runs out. Callers must hold the
+// manager mutex.
+func (m *Manager) enterSlateLocked(process *TranscoderProcess, reason string) error {
+	hlsManager, settings := m.newHLSManager(process.StreamKey)
+	if settings.SlateGrace <= 0 {
+		return errNoSlate
+	}
+
+	log.Printf("🪧 Showing slate for %s for up to %ds: %s", process.StreamKey, settings.SlateGrace, reason)
+	hlsManager.UseSlate()
+	if err := m.replaceEncoderLocked(process, hlsManager); err != nil {
+		return err
+	}
+
+	m.transitionLocked(process, StateSlate, reason)
+	since := time.Now()
+	process.SlateSince = &since
+	process.slateTimer = time.AfterFunc(time.Duration(settings.SlateGrace)*time.Second, func() {
+		m.endSlate(process.StreamKey, since)
+	})
+	return nil
+}
+
+// leaveSlateLocked switches a stream on its slate back to the returning publisher.
+// Callers must hold the manager mutex.
+func (m *Manager) leaveSlateLocked(process *TranscoderProcess) error {
+	if process.slateTimer != nil {
+		process.slateTimer.Stop()
+		process.slateTimer = nil
+	}
+	process.SlateSince = nil
+
+	log.Printf("🎬 Publisher is back for %s, leaving slate", process.StreamKey)
+	hlsManager, _ := m.newHLSManager(process.StreamKey)
+	if err := m.replaceEncoderLocked(process, hlsManager); err != nil {
+		return err
+	}
+	return m.transitionLocked(process, StateRunning, "publisher returned")
+}
+
+// endSlate ends a stream whose publisher did not return within the grace period
+func (m *Manager) endSlate(streamKey string, since time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	process, exists := m.processes[streamKey]
+	if !exists || process.SlateSince == nil || !process.SlateSince.Equal(since) {
+		return // the publisher came back or the stream was stopped meanwhile
+	}
+	process.SlateSince = nil
+	process.slateTimer = nil
+
+	log.Printf("🛑 Publisher of %s did not return, ending stream", streamKey)
+	m.endStreamLocked(process, "publisher did not return")
+}
+
+// SetEndedRetention sets how many hours the files of an ended stream are kept
+// before the cleanup job removes them. Zero removes them right away.
+func (m *Manager) SetEndedRetention(hours int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.endedRetentionHours = hours
+}
+
+// writeStreamEnded writes the metadata the cleanup job uses to expire an ended stream
+func (m *Manager) writeStreamEnded(streamKey, reason string) error {
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"stream_name":     m.PlaybackID(streamKey),
+		"ended_at":        time.Now().Format(time.RFC3339),
+		"reason":          reason,
+		"retention_hours": m.endedRetentionHours,
+	}, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(m.streamDir(streamKey), streamEndedFile), append(data, '\n'))
+}
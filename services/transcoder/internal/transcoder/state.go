@@ -0,0 +1,112 @@
+package transcoder
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/streamforge/platform/pkg/models"
+)
+
+// State is a stage in the lifecycle of a transcoder process
+type State string
+
+const (
+	// StateStarting is set while the encoder is being launched
+	StateStarting State = "starting"
+	// StateRunning means the encoder is writing segments from the publisher
+	StateRunning State = "running"
+	// StateStale means the encoder is alive but its playlists stopped advancing
+	StateStale State = "stale"
+	// StateSlate means the slate is encoded while the publisher is away
+	StateSlate State = "slate"
+	// StateReconnecting means the encoder is stopped until the publisher reconnects
+	StateReconnecting State = "reconnecting"
+	// StateStopped is the end of a stream that was stopped or timed out
+	StateStopped State = "stopped"
+	// StateFailed is the end of a stream whose encoder could not be kept running
+	StateFailed State = "failed"
+)
+
+// maxTransitions is how many transitions a process keeps for its status
+const maxTransitions = 20
+
+// transitions lists the states each state may move to
+var transitions = map[State][]State{
+	"":                {StateStarting},
+	StateStarting:     {StateRunning, StateStopped, StateFailed},
+	StateRunning:      {StateStale, StateSlate, StateReconnecting, StateStopped, StateFailed},
+	StateStale:        {StateRunning, StateSlate, StateReconnecting, StateStopped, StateFailed},
+	StateSlate:        {StateRunning, StateStopped, StateFailed},
+	StateReconnecting: {StateRunning, StateStopped, StateFailed},
+}
+
+// CanBecome reports whether a process may move from s to the given state
+func (s State) CanBecome(to State) bool {
+	for _, state := range transitions[s] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Live reports whether an encoder is producing output in this state
+func (s State) Live() bool {
+	return s == StateRunning || s == StateStale || s == StateSlate
+}
+
+// Final reports whether a process in this state is over
+func (s State) Final() bool {
+	return s == StateStopped || s == StateFailed
+}
+
+// StateTransition is a change of state of a transcoder process
+type StateTransition struct {
+	From   State     `json:"from"`
+	To     State     `json:"to"`
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+}
+
+// StateChange is the data of a stream_state_changed event. Streams are named
+// by playback ID, as stream keys are secret.
+type StateChange struct {
+	PlaybackID string     `json:"playback_id"`
+	SessionID  *uuid.UUID `json:"session_id,omitempty"`
+	StateTransition
+}
+
+// transitionLocked moves a process to a new state and publishes the change.
+// Illegal transitions are refused. Callers must hold the manager mutex.
+func (m *Manager) transitionLocked(process *TranscoderProcess, to State, reason string) error {
+	from := process.Status
+	if !from.CanBecome(to) {
+		log.Printf("⚠️  Refusing to move %s from %s to %s (%s)", process.StreamKey, from, to, reason)
+		return fmt.Errorf("transcoder for %s cannot go from %s to %s", process.StreamKey, from, to)
+	}
+
+	transition := StateTransition{From: from, To: to, Reason: reason, At: time.Now()}
+	process.Status = to
+	process.StateSince = transition.At
+	process.StateReason = reason
+	process.Transitions = append(process.Transitions, transition)
+	if len(process.Transitions) > maxTransitions {
+		process.Transitions = process.Transitions[len(process.Transitions)-maxTransitions:]
+	}
+
+	m.events.Publish(process.StreamKey, models.StreamEvent{
+		Type:     models.EventStreamStateChanged,
+		StreamID: process.StreamID,
+		Data: StateChange{
+			PlaybackID:      m.PlaybackID(process.StreamKey),
+			SessionID:       process.SessionID,
+			StateTransition: transition,
+		},
+		Timestamp: transition.At,
+	})
+
+	log.Printf("🔀 %s: %s → %s (%s)", process.StreamKey, from, to, reason)
+	return nil
+}
@@ -0,0 +1,316 @@
+package transcoder
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/streamforge/platform/pkg/m3u8"
+)
+
+const (
+	// thumbnailInterval is how often a live thumbnail is captured
+	thumbnailInterval = 10 * time.Second
+	// thumbnailName is the poster image kept next to the master playlist
+	thumbnailName = "thumb.jpg"
+	// thumbnailWidth is the width of poster images
+	thumbnailWidth = 640
+	// thumbnailsDirName holds the tiles, sprite sheets and WebVTT track of a stream
+	thumbnailsDirName = "thumbs"
+	// thumbnailTrackName is the WebVTT thumbnail track for seek previews
+	thumbnailTrackName = "thumbnails.vtt"
+	// spriteColumns and spriteRows define the layout of a sprite sheet
+	spriteColumns = 5
+	spriteRows    = 5
+	// tileWidth and tileHeight are the size of a single sprite sheet tile
+	tileWidth  = 160
+	tileHeight = 90
+)
+
+// thumbnailCue is a single seek preview of a WebVTT thumbnail track
+type thumbnailCue struct {
+	Start float64
+	End   float64
+	Image string
+	Index int // position within the sprite sheet
+}
+
+// Thumbnailer captures a poster image of a live stream at a fixed interval.
+// For streams with a DVR window it also keeps sprite sheets of the window and a
+// WebVTT track whose cue times count from the start of the stream.
+type Thumbnailer struct {
+	streamKey  string
+	sourceDir  string
+	streamDir  string
+	thumbsDir  string
+	dvrWindow  time.Duration
+	startTime  time.Time
+	tiles      []thumbnailCue
+	nextTile   int
+	lastSource string
+	stopOnce   sync.Once
+	stop       chan struct{}
+
+	// Content keys of encrypted segments, which are decrypted for capturing
+	contentKeys func(uri string) ([]byte, error)
+}
+
+// NewThumbnailer creates a thumbnailer reading the top rendition of a stream
+func NewThumbnailer(streamKey string, hlsManager *HLSManager) *Thumbnailer {
+	streamDir := hlsManager.streamDir(streamKey)
+	return &Thumbnailer{
+		streamKey: streamKey,
+		sourceDir: hlsManager.VariantDir(streamKey, hlsManager.qualities[0].Name),
+		streamDir: streamDir,
+		thumbsDir: filepath.Join(streamDir, thumbnailsDirName),
+		dvrWindow: time.Duration(hlsManager.dvrWindow) * time.Second,
+		stop:      make(chan struct{}),
+
+		contentKeys: hlsManager.contentKeys,
+	}
+}
+
+// Start begins capturing thumbnails in the background
+func (t *Thumbnailer) Start() {
+	t.startTime = time.Now()
+	if t.dvrWindow > 0 {
+		// Tiles of an earlier run do not match this run's timeline
+		os.RemoveAll(t.thumbsDir)
+		if err := os.MkdirAll(t.thumbsDir, 0755); err != nil {
+			log.Printf("⚠️  Failed to create thumbnails directory for %s: %v", t.streamKey, err)
+			t.dvrWindow = 0
+		}
+	}
+	go t.run()
+}
+
+// Stop stops capturing thumbnails. The last images stay on disk.
+func (t *Thumbnailer) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
+}
+
+// run captures a thumbnail every interval until stopped
+func (t *Thumbnailer) run() {
+	ticker := time.NewTicker(thumbnailInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			if err := t.capture(); err != nil {
+				log.Printf("⚠️  Thumbnail capture for %s failed: %v", t.streamKey, err)
+			}
+		}
+	}
+}
+
+// capture grabs the first frame of the newest segment as poster and, with a
+// DVR window, as the next sprite sheet tile
+func (t *Thumbnailer) capture() error {
+	playlist, err := m3u8.ReadMediaFile(filepath.Join(t.sourceDir, variantPlaylistName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // FFmpeg has not written the first segment yet
+		}
+		return err
+	}
+	if len(playlist.Segments) == 0 {
+		return nil
+	}
+
+	resolveSegmentKeys(playlist)
+	newest := playlist.Segments[len(playlist.Segments)-1]
+	source := filepath.Join(t.sourceDir, filepath.Base(newest.URI))
+	if source == t.lastSource {
+		return nil // No new media since the last capture
+	}
+	input := source
+	if newest.Key != nil {
+		decrypted, err := t.decryptedCopy(source, newest)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(decrypted)
+		input = decrypted
+	}
+
+	posterPath := filepath.Join(t.streamDir, thumbnailName)
+	args := []string{
+		"-hide_banner", "-loglevel", "error", "-y",
+		"-i", input,
+		"-frames:v", "1", "-vf", fmt.Sprintf("scale=%d:-2", thumbnailWidth), "-q:v", "4",
+		"-f", "mjpeg", posterPath + ".tmp",
+	}
+
+	var tilePath string
+	if t.dvrWindow > 0 {
+		t.nextTile++
+		tilePath = filepath.Join(t.thumbsDir, fmt.Sprintf("tile_%05d.jpg", t.nextTile))
+		args = append(args,
+			"-frames:v", "1", "-vf", fmt.Sprintf("scale=%d:%d", tileWidth, tileHeight), "-q:v", "5",
+			"-f", "mjpeg", tilePath,
+		)
+	}
+
+	if output, err := exec.Command("ffmpeg", args...).CombinedOutput(); err != nil {
+		os.Remove(posterPath + ".tmp")
+		if tilePath != "" {
+			t.nextTile-- // Keep tile numbers contiguous for the sprite sheet input
+		}
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	if err := os.Rename(posterPath+".tmp", posterPath); err != nil {
+		return err
+	}
+	t.lastSource = source
+
+	if tilePath != "" {
+		return t.addTile(time.Since(t.startTime).Seconds())
+	}
+	return nil
+}
+
+// decryptedCopy decrypts an encrypted segment into a temporary file outside the
+// HLS directory and returns its path
+func (t *Thumbnailer) decryptedCopy(source string, segment m3u8.Segment) (string, error) {
+	file, err := os.CreateTemp("", "streamforge-thumb-*.ts")
+	if err != nil {
+		return "", err
+	}
+	path := file.Name()
+	file.Close()
+	os.Remove(path)
+	if err := archiveSegment(source, path, segment, t.contentKeys); err != nil {
+		return "", fmt.Errorf("failed to decrypt %s: %w", source, err)
+	}
+	return path, nil
+}
+
+// addTile rebuilds the sprite sheet of the newest tile, drops tiles that left
+// the DVR window and rewrites the thumbnail track
+func (t *Thumbnailer) addTile(offset float64) error {
+	tilesPerSheet := spriteColumns * spriteRows
+	sheet := (t.nextTile - 1) / tilesPerSheet
+	spriteName := fmt.Sprintf("sprite_%03d.jpg", sheet)
+
+	if len(t.tiles) > 0 {
+		t.tiles[len(t.tiles)-1].End = offset
+	}
+	t.tiles = append(t.tiles, thumbnailCue{
+		Start: offset,
+		End:   offset + thumbnailInterval.Seconds(),
+		Image: spriteName,
+		Index: (t.nextTile - 1) % tilesPerSheet,
+	})
+
+	firstTile := sheet*tilesPerSheet + 1
+	if err := buildSpriteSheet(
+		filepath.Join(t.thumbsDir, "tile_%05d.jpg"), firstTile,
+		filepath.Join(t.thumbsDir, spriteName),
+	); err != nil {
+		return err
+	}
+
+	// Expire tiles outside the window, and their sprite sheets once unused
+	windowStart := offset - t.dvrWindow.Seconds()
+	for len(t.tiles) > 0 && t.tiles[0].End < windowStart {
+		expired := t.tiles[0]
+		t.tiles = t.tiles[1:]
+		if len(t.tiles) == 0 || t.tiles[0].Image != expired.Image {
+			os.Remove(filepath.Join(t.thumbsDir, expired.Image))
+		}
+	}
+
+	// Tiles are only needed while their sheet is still being filled
+	if (t.nextTile-1)%tilesPerSheet == tilesPerSheet-1 {
+		for i := firstTile; i <= t.nextTile; i++ {
+			os.Remove(filepath.Join(t.thumbsDir, fmt.Sprintf("tile_%05d.jpg", i)))
+		}
+	}
+
+	return writeThumbnailTrack(filepath.Join(t.thumbsDir, thumbnailTrackName), t.tiles)
+}
+
+// buildSpriteSheet tiles the numbered images starting at startNumber into a sprite sheet
+func buildSpriteSheet(pattern string, startNumber int, output string) error {
+	cmd := exec.Command("ffmpeg",
+		"-hide_banner", "-loglevel", "error", "-y",
+		"-start_number", fmt.Sprintf("%d", startNumber),
+		"-i", pattern,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("tile=%dx%d", spriteColumns, spriteRows),
+		"-q:v", "5",
+		"-f", "mjpeg", output+".tmp",
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to build sprite sheet: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return os.Rename(output+".tmp", output)
+}
+
+// buildRecordingThumbnails writes a poster, sprite sheets and a WebVTT thumbnail
+// track for a finished recording
+func buildRecordingThumbnails(mp4Path, dir string, duration float64) error {
+	interval := thumbnailInterval.Seconds()
+	if err := os.MkdirAll(filepath.Join(dir, thumbnailsDirName), 0755); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("ffmpeg",
+		"-hide_banner", "-loglevel", "error", "-y",
+		"-i", mp4Path,
+		"-frames:v", "1", "-vf", fmt.Sprintf("scale=%d:-2", thumbnailWidth), "-q:v", "4",
+		"-f", "mjpeg", filepath.Join(dir, thumbnailName),
+		"-vf", fmt.Sprintf("fps=1/%d,scale=%d:%d,tile=%dx%d", int(interval), tileWidth, tileHeight, spriteColumns, spriteRows),
+		"-q:v", "5",
+		filepath.Join(dir, thumbnailsDirName, "sprite_%03d.jpg"),
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	tilesPerSheet := spriteColumns * spriteRows
+	count := int(math.Ceil(duration / interval))
+	cues := make([]thumbnailCue, count)
+	for i := range cues {
+		cues[i] = thumbnailCue{
+			Start: float64(i) * interval,
+			End:   math.Min(float64(i+1)*interval, duration),
+			// FFmpeg numbers image sequences from 1
+			Image: fmt.Sprintf("sprite_%03d.jpg", i/tilesPerSheet+1),
+			Index: i % tilesPerSheet,
+		}
+	}
+
+	return writeThumbnailTrack(filepath.Join(dir, thumbnailsDirName, thumbnailTrackName), cues)
+}
+
+// writeThumbnailTrack writes a WebVTT track pointing each cue at its sprite sheet tile
+func writeThumbnailTrack(path string, cues []thumbnailCue) error {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for _, cue := range cues {
+		x := (cue.Index % spriteColumns) * tileWidth
+		y := (cue.Index / spriteColumns) * tileHeight
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			formatVTTTimestamp(cue.Start), formatVTTTimestamp(cue.End),
+			cue.Image, x, y, tileWidth, tileHeight)
+	}
+	return writeFileAtomic(path, []byte(b.String()))
+}
+
+// formatVTTTimestamp formats seconds as a WebVTT timestamp (HH:MM:SS.mmm)
+func formatVTTTimestamp(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -2,17 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/streamforge/platform/pkg/access"
+	"github.com/streamforge/platform/pkg/config"
+	"github.com/streamforge/platform/pkg/playback"
 	"github.com/streamforge/platform/services/transcoder/internal/handlers"
+	"github.com/streamforge/platform/services/transcoder/internal/repository"
 	"github.com/streamforge/platform/services/transcoder/internal/transcoder"
 )
 
@@ -33,8 +40,11 @@ func CORSMiddleware() gin.HandlerFunc {
 	}
 }
 
-// Custom HLS file handler with CORS
-func HLSFileHandler(outputDir string) gin.HandlerFunc {
+// Custom HLS file handler with CORS. Live output and archives are requested by
+// playback ID and resolved to their stream's directory. Files of private
+// streams and signed playback IDs are only served with a valid playback token,
+// which is passed on to every URI of the playlists.
+func HLSFileHandler(outputDir string, manager *transcoder.Manager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Set CORS headers for HLS files
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
@@ -57,10 +67,51 @@ func HLSFileHandler(outputDir string) gin.HandlerFunc {
 			c.Writer.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
 		case ".ts":
 			c.Writer.Header().Set("Content-Type", "video/mp2t")
+		case ".vtt":
+			c.Writer.Header().Set("Content-Type", "text/vtt")
 		}
 
+		cleanPath := filepath.ToSlash(filepath.Clean("/" + filePath))
+		parts := strings.SplitN(strings.TrimPrefix(cleanPath, "/"), "/", 2)
+		// Sidecars and the playback ID index are not for viewers
+		if strings.Contains(cleanPath, "/.") {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		token := c.Query(playback.TokenParam)
+		directory, err := manager.AuthorizePlayback(parts[0], token, c.ClientIP())
+		if errors.Is(err, transcoder.ErrPlaybackIDNotFound) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(playback.StatusCode(err), gin.H{"error": err.Error()})
+			return
+		}
+		if decision := manager.CheckAccess(directory, c.ClientIP()); !decision.Allowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": access.ErrAccessDenied.Error(), "reason": decision.Reason})
+			return
+		}
+		parts[0] = directory
+		cleanPath = "/" + strings.Join(parts, "/")
+
 		// Serve the file
-		fullPath := filepath.Join(outputDir, filePath)
+		fullPath := filepath.Join(outputDir, filepath.FromSlash(cleanPath))
+		if token != "" && (ext == ".m3u8" || ext == ".vtt") {
+			data, err := os.ReadFile(fullPath)
+			if err != nil {
+				c.AbortWithStatus(http.StatusNotFound)
+				return
+			}
+			if ext == ".m3u8" {
+				data = playback.TokenizePlaylist(data, token)
+			} else {
+				data = playback.TokenizeThumbnails(data, token)
+			}
+			c.Data(http.StatusOK, c.Writer.Header().Get("Content-Type"), data)
+			return
+		}
 		c.File(fullPath)
 	}
 }
@@ -69,6 +120,9 @@ func main() {
 	port := flag.String("port", "8083", "HTTP server port")
 	rtmpURL := flag.String("rtmp-url", "rtmp://localhost:1935/live", "RTMP server URL")
 	outputDir := flag.String("output-dir", "/tmp/hls_shared", "HLS output directory")
+	recordingsDir := flag.String("recordings-dir", "/tmp/recordings", "Recordings archive directory")
+	reconnectWindow := flag.Duration("reconnect-window", 30*time.Second, "How long a dropped publisher can reconnect and continue its session (0 disables)")
+	retentionHours := flag.Int("retention-hours", 24, "How many hours the files of an ended stream are kept")
 	flag.Parse()
 
 	// Override with environment variables if set
@@ -81,17 +135,90 @@ func main() {
 	if envOutputDir := os.Getenv("OUTPUT_DIR"); envOutputDir != "" {
 		*outputDir = envOutputDir
 	}
+	if envRecordingsDir := os.Getenv("RECORDINGS_DIR"); envRecordingsDir != "" {
+		*recordingsDir = envRecordingsDir
+	}
+	if envReconnectWindow := os.Getenv("RECONNECT_WINDOW"); envReconnectWindow != "" {
+		window, err := time.ParseDuration(envReconnectWindow)
+		if err != nil {
+			log.Fatalf("Invalid RECONNECT_WINDOW %q: %v", envReconnectWindow, err)
+		}
+		*reconnectWindow = window
+	}
+	if envRetention := os.Getenv("HLS_RETENTION_HOURS"); envRetention != "" {
+		hours, err := strconv.Atoi(envRetention)
+		if err != nil || hours < 0 {
+			log.Fatalf("Invalid HLS_RETENTION_HOURS %q", envRetention)
+		}
+		*retentionHours = hours
+	}
 
 	log.Printf("🎬 StreamForge Transcoder Service")
 	log.Printf("Port: %s", *port)
 	log.Printf("RTMP URL: %s", *rtmpURL)
 	log.Printf("Output Directory: %s", *outputDir)
+	log.Printf("Recordings Directory: %s", *recordingsDir)
+	log.Printf("Reconnect Window: %v", *reconnectWindow)
+	log.Printf("Ended Stream Retention: %dh", *retentionHours)
+
+	// Load configuration for shared infrastructure (database, logging)
+	cfg, err := config.Load("TRANSCODER")
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Initialize database repository
+	repo, err := repository.NewStreamRepository(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize repository: %v", err)
+	}
 
 	// Initialize transcoder manager
-	transcoderManager := transcoder.NewManager(*rtmpURL, *outputDir)
+	transcoderManager := transcoder.NewManager(*rtmpURL, *outputDir, *recordingsDir, repo)
+	transcoderManager.SetReconnectWindow(*reconnectWindow)
+	transcoderManager.SetEndedRetention(*retentionHours)
+
+	// Playback tokens are signed with a secret shared with the HLS server
+	playbackSigner := playback.NewSigner(os.Getenv("PLAYBACK_TOKEN_SECRET"))
+	if playbackSigner == nil {
+		log.Printf("⚠️  PLAYBACK_TOKEN_SECRET is not set, private streams cannot be played")
+	}
+	transcoderManager.SetPlaybackTokens(playbackSigner, os.Getenv("REQUIRE_PLAYBACK_TOKENS") == "true")
+
+	// Content keys of encrypted streams are stored sealed with their own secret
+	keyVault := transcoder.NewKeyVault(os.Getenv("KEY_ENCRYPTION_SECRET"))
+	if keyVault == nil {
+		log.Printf("⚠️  KEY_ENCRYPTION_SECRET is not set, streams cannot be encrypted")
+	}
+	transcoderManager.SetKeyServer(keyVault, os.Getenv("KEY_URL"))
+
+	// Country access rules resolve viewers with a MaxMind-format database
+	geo, err := access.NewGeoIP(os.Getenv("GEOIP_DB"))
+	if err != nil {
+		log.Fatalf("Failed to open GeoIP database: %v", err)
+	}
+	if geo == nil {
+		log.Printf("⚠️  GEOIP_DB is not set, country access rules match no viewer")
+	}
+	transcoderManager.SetGeoIP(geo)
+
+	// Overlay images are read by FFmpeg, so only from trusted places
+	var overlayHosts []string
+	if hosts := os.Getenv("OVERLAY_HOSTS"); hosts != "" {
+		overlayHosts = strings.Split(hosts, ",")
+	}
+	transcoderManager.SetOverlaySources(os.Getenv("OVERLAY_DIR"), overlayHosts)
 
 	// Initialize handlers
-	handler := handlers.NewHandler(transcoderManager)
+	handler := handlers.NewHandler(transcoderManager, repo)
+	if jwtSecret := os.Getenv("JWT_SECRET"); jwtSecret != "" {
+		cfg.Auth.JWTSecret = jwtSecret
+	}
+	handler.SetJWTSecret(cfg.Auth.JWTSecret)
+	handler.SetInternalSecret(os.Getenv("INTERNAL_API_SECRET"))
+	if os.Getenv("INTERNAL_API_SECRET") == "" {
+		log.Printf("⚠️  INTERNAL_API_SECRET is not set, other services cannot resolve playback IDs to stream keys")
+	}
 
 	// Setup Gin router
 	router := gin.Default()
@@ -119,23 +246,96 @@ func main() {
 	router.GET("/transcode/stop/:streamKey", handler.StopTranscoder)
 	router.POST("/transcode/start/:streamKey", handler.StartTranscoder)
 	router.POST("/transcode/stop/:streamKey", handler.StopTranscoder)
+	// Publisher went away: shows the slate during the stream's grace period, otherwise
+	// waits for a reconnect within the reconnect window
+	router.GET("/transcode/disconnect/:streamKey", handler.DisconnectPublisher)
+	router.POST("/transcode/disconnect/:streamKey", handler.DisconnectPublisher)
 	router.GET("/transcode/status/:streamKey", handler.GetTranscoderStatus)
 	router.GET("/transcode/active", handler.GetActiveTranscoders)
+	// State transitions of every stream as Server-Sent Events
+	router.GET("/transcode/events", handler.StreamEvents)
 
 	// Legacy endpoint for web interface compatibility
 	router.GET("/streams", handler.GetActiveTranscoders)
 
 	// NGINX callback endpoints (called by NGINX on_publish/on_publish_done)
 	router.POST("/api/streams/start/:streamKey", handler.StartTranscoder)
-	router.POST("/api/streams/stop/:streamKey", handler.StopTranscoder)
+	router.POST("/api/streams/stop/:streamKey", handler.DisconnectPublisher)
 	router.GET("/api/streams/status/:streamKey", handler.GetTranscoderStatus)
 
 	// Cleanup endpoints for maintaining standardized directory structure
 	router.DELETE("/transcode/cleanup/:streamKey", handler.CleanupStream)
 	router.DELETE("/transcode/cleanup", handler.CleanupAllStreams)
 
+	// Per-stream settings (applied the next time the stream goes live)
+	router.GET("/transcode/settings/:streamKey", handler.GetStreamSettings)
+	router.PUT("/transcode/settings/:streamKey", handler.UpdateStreamSettings)
+
+	// Per-stream encoder tuning and the FFmpeg command it results in
+	router.GET("/transcode/encoder/:streamKey", handler.GetEncoderOverrides)
+	router.PUT("/transcode/encoder/:streamKey", handler.UpdateEncoderOverrides)
+	router.GET("/transcode/plan/:streamKey", handler.GetEncoderPlan)
+
+	// Per-stream access rules by network and country (enforced immediately)
+	router.GET("/transcode/access/:streamKey", handler.GetAccessRules)
+	router.PUT("/transcode/access/:streamKey", handler.UpdateAccessRules)
+	router.DELETE("/transcode/access/:streamKey", handler.DeleteAccessRules)
+
+	// Recording archive endpoints
+	router.GET("/recordings", handler.ListRecordings)
+	router.GET("/recordings/:id", handler.GetRecording)
+	router.GET("/recordings/:id/download", handler.DownloadRecording)
+	router.DELETE("/recordings/:id", handler.DeleteRecording)
+
+	// Clips cut from the segments on disk
+	router.POST("/clips", handler.CreateClip)
+	router.GET("/clips", handler.ListClips)
+	router.GET("/clips/:id", handler.GetClip)
+	router.GET("/clips/:id/download", handler.DownloadClip)
+	router.DELETE("/clips/:id", handler.DeleteClip)
+
+	// Overlays burned into selected renditions
+	router.GET("/overlays", handler.ListOverlays)
+	router.POST("/overlays", handler.CreateOverlay)
+	router.PUT("/overlays/:id", handler.UpdateOverlay)
+	router.DELETE("/overlays/:id", handler.DeleteOverlay)
+
+	// Restream targets relayed while a stream is live
+	router.GET("/restream/targets", handler.ListRestreamTargets)
+	router.POST("/restream/targets", handler.CreateRestreamTarget)
+	router.PUT("/restream/targets/:id", handler.UpdateRestreamTarget)
+	router.DELETE("/restream/targets/:id", handler.DeleteRestreamTarget)
+	router.POST("/restream/targets/:id/start", handler.StartRestreamTarget)
+	router.POST("/restream/targets/:id/stop", handler.StopRestreamTarget)
+
+	// Ad breaks signalled in the playlists
+	router.POST("/cues", handler.ScheduleAdCue)
+	router.GET("/cues", handler.ListAdCues)
+	router.GET("/cues/:id", handler.GetAdCue)
+	router.DELETE("/cues/:id", handler.CancelAdCue)
+
+	// Playback tokens for signed-in users, required for private streams
+	router.POST("/playback/tokens", handler.IssuePlaybackToken)
+
+	// Public playback IDs of streams, and rotation of their secret stream keys
+	router.GET("/playback/ids", handler.ListPlaybackIDs)
+	router.POST("/playback/ids", handler.CreatePlaybackID)
+	router.GET("/playback/ids/:playbackID", handler.GetPlaybackID)
+	router.PUT("/playback/ids/:playbackID", handler.UpdatePlaybackID)
+	router.DELETE("/playback/ids/:playbackID", handler.DeletePlaybackID)
+	router.POST("/transcode/rotate-key/:streamKey", handler.RotateStreamKey)
+	router.GET("/internal/playback/ids/:playbackID", handler.ResolvePlaybackID)
+
+	// Content keys of encrypted streams, released to authorized viewers
+	router.GET("/keys/:keyID", handler.GetKey)
+
+	// Playback quality reported by players, and its aggregates per stream
+	router.POST("/analytics/beacons", handler.RecordBeacon)
+	router.GET("/analytics/qoe/:streamKey", handler.GetQoEReport)
+
 	// HLS file serving with CORS support
-	router.GET("/hls/*filepath", HLSFileHandler(*outputDir))
+	router.GET("/hls/*filepath", HLSFileHandler(*outputDir, transcoderManager))
+	router.GET("/vod/*filepath", HLSFileHandler(*recordingsDir, transcoderManager))
 
 	// Start server
 	server := &http.Server{
@@ -153,6 +353,7 @@ func main() {
 
 		// Stop all active transcoders
 		transcoderManager.StopAll()
+		repo.Close()
 
 		// Shutdown HTTP server
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -1,6 +1,7 @@
 import React, { useState, useRef, useEffect, useCallback } from 'react';
 import Hls from 'hls.js';
 import './StreamPlayer.css';
+import { QoEReporter } from '../qoe';
 
 interface StreamInfo {
   name: string;
@@ -53,6 +54,7 @@ const SimpleReactPlayer: React.FC = () => {
 
   const videoRef = useRef<HTMLVideoElement>(null);
   const hlsRef = useRef<Hls | null>(null);
+  const qoeRef = useRef<QoEReporter | null>(null);
   const dropdownRef = useRef<HTMLSelectElement>(null);
   const autoRefreshIntervalRef = useRef<NodeJS.Timeout | null>(null);
   
@@ -239,6 +241,8 @@ const SimpleReactPlayer: React.FC = () => {
     }
 
     // Cleanup existing HLS instance
+    qoeRef.current?.destroy();
+    qoeRef.current = null;
     if (hlsRef.current) {
       hlsRef.current.destroy();
       hlsRef.current = null;
@@ -324,6 +328,9 @@ const SimpleReactPlayer: React.FC = () => {
       });
       
       hlsRef.current = hls;
+
+      // Report playback quality to the transcoder's analytics API
+      qoeRef.current = new QoEReporter(`http://${host}:${port}/analytics/beacons`, streamKey, hls, videoRef.current);
       
     } else if (videoRef.current.canPlayType('application/vnd.apple.mpegurl')) {
       addLog('🍎 Using native HLS support (Safari)');
@@ -411,6 +418,7 @@ const SimpleReactPlayer: React.FC = () => {
     
     return () => {
       // Cleanup HLS on unmount
+      qoeRef.current?.destroy();
       if (hlsRef.current) {
         hlsRef.current.destroy();
       }
@@ -0,0 +1,143 @@
+import Hls from 'hls.js';
+
+// Reference schema of the QoE beacons accepted by the transcoder at
+// POST /analytics/beacons. Times are RFC 3339 strings from the player's clock;
+// sent_at lets the server correct them for clock skew.
+
+export type PlaybackEventType =
+  | 'play'             // playback started or resumed; startup_ms on the first one
+  | 'stall_start'      // the buffer ran dry
+  | 'stall_end'        // playback continued after a stall
+  | 'rendition_switch' // another rendition is playing
+  | 'error'            // a playback error; fatal if playback stopped
+  | 'heartbeat'        // still playing, sent with every batch
+  | 'end';             // the viewer left
+
+export interface PlaybackEvent {
+  type: PlaybackEventType;
+  time: string;
+  startup_ms?: number; // play: from loading the stream to the first frame
+  rendition?: string;  // play, rendition_switch: e.g. "720p"
+  bitrate?: number;    // play, rendition_switch: bits per second
+  error?: string;      // error: what went wrong
+  fatal?: boolean;     // error: playback stopped
+}
+
+export interface Beacon {
+  session_id: string;  // 8 to 64 letters, digits, - or _
+  playback_id: string; // the name of the stream in its playback URL
+  sent_at: string;
+  events: PlaybackEvent[]; // at most 200
+}
+
+const FLUSH_INTERVAL_MS = 10000;
+const MAX_BATCH = 200;
+
+const newSessionId = (): string =>
+  Array.from(crypto.getRandomValues(new Uint8Array(16)), b => b.toString(16).padStart(2, '0')).join('');
+
+// QoEReporter collects the playback events of an hls.js player and sends them
+// to the transcoder in batches, every ten seconds and when playback ends
+export class QoEReporter {
+  private readonly sessionId = newSessionId();
+  private readonly loadStart = performance.now();
+  private events: PlaybackEvent[] = [];
+  private started = false;
+  private stalled = false;
+  private timer: ReturnType<typeof setInterval>;
+  private readonly cleanups: Array<() => void> = [];
+
+  constructor(
+    private readonly endpoint: string, // e.g. http://host:8083/analytics/beacons
+    private readonly playbackId: string,
+    private readonly hls: Hls,
+    private readonly video: HTMLVideoElement
+  ) {
+    const listen = (name: string, handler: () => void) => {
+      video.addEventListener(name, handler);
+      this.cleanups.push(() => video.removeEventListener(name, handler));
+    };
+
+    listen('playing', () => {
+      if (!this.started) {
+        this.started = true;
+        this.record({ type: 'play', startup_ms: Math.round(performance.now() - this.loadStart), ...this.rendition() });
+      } else if (this.stalled) {
+        this.stalled = false;
+        this.record({ type: 'stall_end' });
+      }
+    });
+    listen('waiting', () => {
+      if (this.started && !this.stalled) {
+        this.stalled = true;
+        this.record({ type: 'stall_start' });
+      }
+    });
+
+    hls.on(Hls.Events.LEVEL_SWITCHED, this.onLevelSwitched);
+    hls.on(Hls.Events.ERROR, this.onError);
+    this.cleanups.push(() => {
+      hls.off(Hls.Events.LEVEL_SWITCHED, this.onLevelSwitched);
+      hls.off(Hls.Events.ERROR, this.onError);
+    });
+
+    const onPageHide = () => this.flush(true);
+    window.addEventListener('pagehide', onPageHide);
+    this.cleanups.push(() => window.removeEventListener('pagehide', onPageHide));
+
+    this.timer = setInterval(() => this.flush(false), FLUSH_INTERVAL_MS);
+  }
+
+  // destroy reports the end of the session; call it before destroying hls.js
+  destroy() {
+    clearInterval(this.timer);
+    this.cleanups.forEach(cleanup => cleanup());
+    this.record({ type: 'end' });
+    this.flush(true);
+  }
+
+  private onLevelSwitched = () => {
+    if (this.started) {
+      this.record({ type: 'rendition_switch', ...this.rendition() });
+    }
+  };
+
+  private onError = (_event: string, data: { details: string; fatal: boolean }) => {
+    this.record({ type: 'error', error: data.details, fatal: data.fatal });
+  };
+
+  private rendition(): Pick<PlaybackEvent, 'rendition' | 'bitrate'> {
+    const level = this.hls.levels[this.hls.currentLevel];
+    return level ? { rendition: `${level.height}p`, bitrate: level.bitrate } : {};
+  }
+
+  private record(event: Omit<PlaybackEvent, 'time'>) {
+    this.events.push({ ...event, time: new Date().toISOString() });
+    if (this.events.length >= MAX_BATCH) {
+      this.flush(false);
+    }
+  }
+
+  private flush(final: boolean) {
+    if (this.started && !final && !this.video.paused) {
+      this.record({ type: 'heartbeat' });
+    }
+    if (this.events.length === 0) {
+      return;
+    }
+
+    const beacon: Beacon = {
+      session_id: this.sessionId,
+      playback_id: this.playbackId,
+      sent_at: new Date().toISOString(),
+      events: this.events.splice(0, MAX_BATCH),
+    };
+    const body = JSON.stringify(beacon);
+    if (final && navigator.sendBeacon?.(this.endpoint, body)) {
+      return;
+    }
+    fetch(this.endpoint, { method: 'POST', body, keepalive: true }).catch(() => {
+      // QoE reporting never interferes with playback
+    });
+  }
+}